@@ -3,12 +3,13 @@ package agent
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/cortexai/cortexai/internal/cache"
 	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
@@ -17,108 +18,147 @@ import (
 	"golang.org/x/sync/singleflight"
 )
 
-// schemaCache holds pre-built system prompts keyed by dataset ID.
-type schemaCacheEntry struct {
-	prompt    string
-	expiresAt time.Time
-}
+// cacheOpTimeout bounds every cache backend call so a slow or unreachable
+// shared backend degrades to a cache miss instead of stalling the request.
+const cacheOpTimeout = 500 * time.Millisecond
 
+// schemaCache holds pre-built schema sections keyed by dataset ID (BigQuery) or
+// "squadID:dbName" (PostgreSQL). Entries live in a pluggable cache.Cache so
+// they can be shared between replicas or persisted across restarts.
 type schemaCache struct {
-	mu    sync.RWMutex
-	store map[string]schemaCacheEntry
-	ttl   time.Duration
-	sf    singleflight.Group // deduplicate concurrent fetches for the same dataset
+	backend cache.Cache
+	ttl     time.Duration
+	sf      singleflight.Group // deduplicate concurrent fetches for the same dataset
 }
 
+// newSchemaCache returns a process-local schema cache.
 func newSchemaCache(ttl time.Duration) *schemaCache {
+	return newSchemaCacheWith(cache.NewMemoryStore().Namespace("schema"), ttl)
+}
+
+func newSchemaCacheWith(backend cache.Cache, ttl time.Duration) *schemaCache {
 	if ttl <= 0 {
 		ttl = 5 * time.Minute
 	}
-	return &schemaCache{store: make(map[string]schemaCacheEntry), ttl: ttl}
+	return &schemaCache{backend: backend, ttl: ttl}
 }
 
+// get returns the cached schema section. Backend errors are logged and
+// reported as a miss.
 func (c *schemaCache) get(datasetID string) (string, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	e, ok := c.store[datasetID]
-	if !ok || time.Now().After(e.expiresAt) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := c.backend.Get(ctx, datasetID)
+	if err != nil {
+		log.Warn().Err(err).Str("key", datasetID).Msg("schema cache read failed")
 		return "", false
 	}
-	return e.prompt, true
+	return string(v), ok
 }
 
 func (c *schemaCache) set(datasetID, prompt string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.store[datasetID] = schemaCacheEntry{
-		prompt:    prompt,
-		expiresAt: time.Now().Add(c.ttl),
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	if err := c.backend.Set(ctx, datasetID, []byte(prompt), c.ttl); err != nil {
+		log.Warn().Err(err).Str("key", datasetID).Msg("schema cache write failed")
 	}
 }
 
-func (c *schemaCache) invalidate(datasetID string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.store, datasetID)
+func (c *schemaCache) invalidate(datasetID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	return c.backend.Delete(ctx, datasetID)
 }
 
 // ── responseCache ─────────────────────────────────────────────────────────────
 
-// responseCacheEntry stores a successful AgentResponse with its expiry time.
-type responseCacheEntry struct {
-	response  *models.AgentResponse
-	expiresAt time.Time
-}
-
-// responseCache is an in-memory, TTL-based cache for exact-match AgentResponse objects.
+// responseCache is a TTL-based cache for exact-match AgentResponse objects,
+// stored JSON-encoded in a pluggable cache.Cache. Every hit decodes a fresh
+// copy, so callers may annotate the returned response freely.
 // It is defined in bigquery_handler.go so both BigQueryHandler and PostgresHandler
 // (same package) can reuse the same type without an import cycle.
 type responseCache struct {
-	mu    sync.RWMutex
-	store map[string]responseCacheEntry
-	ttl   time.Duration
+	backend  cache.Cache
+	ttl      time.Duration
+	semantic *semanticMatcher // nil = exact-match keys only (see semantic_cache.go)
 }
 
+// newResponseCache returns a process-local response cache.
 func newResponseCache(ttl time.Duration) *responseCache {
+	return newResponseCacheWith(cache.NewMemoryStore().Namespace("response"), ttl)
+}
+
+func newResponseCacheWith(backend cache.Cache, ttl time.Duration) *responseCache {
 	if ttl <= 0 {
 		ttl = 5 * time.Minute
 	}
-	return &responseCache{store: make(map[string]responseCacheEntry), ttl: ttl}
+	return &responseCache{backend: backend, ttl: ttl}
 }
 
+// get returns a decoded copy of the cached response. Backend and decode errors
+// are logged and reported as a miss.
 func (c *responseCache) get(key string) (*models.AgentResponse, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	e, ok := c.store[key]
-	if !ok || time.Now().After(e.expiresAt) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := c.backend.Get(ctx, key)
+	if err != nil {
+		log.Warn().Err(err).Msg("response cache read failed")
+		return nil, false
+	}
+	if !ok {
 		return nil, false
 	}
-	return e.response, true
+	var resp models.AgentResponse
+	if err := json.Unmarshal(v, &resp); err != nil {
+		log.Warn().Err(err).Msg("discarding undecodable response cache entry")
+		return nil, false
+	}
+	if resp.AgentMetadata == nil {
+		resp.AgentMetadata = make(map[string]interface{})
+	}
+	return &resp, true
 }
 
 func (c *responseCache) set(key string, resp *models.AgentResponse) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.store[key] = responseCacheEntry{
-		response:  resp,
-		expiresAt: time.Now().Add(c.ttl),
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Warn().Err(err).Msg("response not cacheable")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	if err := c.backend.Set(ctx, key, b, c.ttl); err != nil {
+		log.Warn().Err(err).Msg("response cache write failed")
 	}
 }
 
-func (c *responseCache) flush() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.store = make(map[string]responseCacheEntry)
+func (c *responseCache) flush() error {
+	if c.semantic != nil {
+		c.semantic.index.reset()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	return c.backend.Flush(ctx)
 }
 
 // responseCacheKey builds a deterministic SHA-256 hex key from the three fields
-// that uniquely identify an agent query: the user prompt, the dataset/database ID,
-// and the resolved persona prompt style.
-func responseCacheKey(prompt, datasetID, promptStyle string) string {
-	sum := sha256.Sum256([]byte(prompt + "|" + datasetID + "|" + promptStyle))
+// that uniquely identify an agent query: the user prompt, the cache scope (see
+// responseCacheScope), and the resolved persona prompt style.
+func responseCacheKey(prompt, scopeID, promptStyle string) string {
+	sum := sha256.Sum256([]byte(prompt + "|" + scopeID + "|" + promptStyle))
 	return fmt.Sprintf("%x", sum)
 }
 
+// responseCacheScope returns the scope cached responses are shared within:
+// the dataset or database, the caller's squad, the fingerprint of the access
+// policy (tables, columns and row filters) the answer was produced under, and
+// the versions of the glossary and few-shot examples its prompt drew on.
+// Cache hits return before the policy is checked, so callers whose policies
+// differ must never share an entry.
+func responseCacheScope(scope, squadID string, access *security.TableAccessPolicy, glossary *service.Glossary, examples *ExampleStore) string {
+	return scope + "|" + squadID + "|" + access.Fingerprint() + "|" + glossary.Version() + "|" + examples.Version()
+}
+
 // BaseSystemPrompt is the default BigQuery agent system prompt.
 // It is exported so system_prompts.go can use it as the fallback for unknown styles.
 const BaseSystemPrompt = `You are CortexAI, an expert data analyst with deep knowledge of BigQuery SQL.
@@ -143,19 +183,37 @@ SELECT ...
 // execute SQL at most once.
 const BQSchemaClosingInstruction = "\nIMPORTANT: All table schemas are already provided above. DO NOT call list_tables or get_bigquery_schema — go directly to writing and executing SQL. You should need at most 1 execute call."
 
-// getSchemaSection returns a cached schema-only section pre-loaded from BigQuery.
-// It returns only the schema portion (no base prompt) so different personas can
-// prepend their own base prompt via SystemPromptStyle(). Cache TTL is 5 minutes.
-// Concurrent requests for the same dataset share a single fetch via singleflight.
-func (h *BigQueryHandler) getSchemaSection(ctx context.Context, datasetID string) string {
+// BQPartialSchemaClosingInstruction replaces BQSchemaClosingInstruction when
+// only the tables most relevant to the prompt are shown in full.
+const BQPartialSchemaClosingInstruction = "\nIMPORTANT: Only the tables most relevant to the question are shown in full above. DO NOT call list_bigquery_tables. If the question needs a table from the other tables list, call get_bigquery_schema for that table only; otherwise go directly to writing and executing SQL. You should need at most 1 execute call."
+
+// getSchemaSection returns the schema section of the system prompt for req,
+// pre-loaded from BigQuery. It returns only the schema portion (no base
+// prompt) so different personas can prepend their own base prompt via
+// SystemPromptStyle(). The dataset's schema is cached; concurrent requests
+// for the same dataset share a single fetch via singleflight. Wide datasets
+// are cut down to the tables most relevant to the prompt (see
+// SetSchemaSelection).
+func (h *BigQueryHandler) getSchemaSection(ctx context.Context, datasetID string, req *models.AgentRequest) schemaSection {
+	snap := h.schemaSnapshot(ctx, datasetID)
+	if snap == nil {
+		return schemaSection{}
+	}
+	shown := h.schemaSel.pick(ctx, snap, schemaQuery(req))
+	return snap.render(shown, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction)
+}
+
+// schemaSnapshot returns the cached schema of datasetID, fetching it on a
+// miss, or nil when it cannot be loaded.
+func (h *BigQueryHandler) schemaSnapshot(ctx context.Context, datasetID string) *schemaSnapshot {
 	if datasetID == "" || h.bq == nil {
-		return ""
+		return nil
 	}
 
 	// Cache hit — fast path, no lock contention beyond RLock
-	if schema, ok := h.schemaCache.get(datasetID); ok {
+	if snap, ok := h.schemaCache.getSnapshot(datasetID); ok {
 		log.Debug().Str("dataset", datasetID).Msg("schema cache hit")
-		return schema
+		return snap
 	}
 
 	// Cache miss — use singleflight so concurrent requests for the same dataset
@@ -163,37 +221,38 @@ func (h *BigQueryHandler) getSchemaSection(ctx context.Context, datasetID string
 	v, err, _ := h.schemaCache.sf.Do(datasetID, func() (interface{}, error) {
 		// Double-check cache inside singleflight in case another goroutine
 		// already populated it while we were waiting to enter.
-		if schema, ok := h.schemaCache.get(datasetID); ok {
-			return schema, nil
+		if snap, ok := h.schemaCache.getSnapshot(datasetID); ok {
+			return snap, nil
 		}
 
 		log.Debug().Str("dataset", datasetID).Msg("schema cache miss, fetching from BigQuery")
 		fetchStart := time.Now()
 
-		tables, err := h.bq.ListTables(ctx, datasetID)
+		tables, err := h.bq.GetDatasetSchema(ctx, datasetID)
 		if err != nil {
-			return "", nil // soft fail — return empty, don't cache
+			log.Warn().Err(err).Str("dataset", datasetID).Msg("pre-load schema failed")
+			return nil, nil // soft fail — return empty, don't cache
 		}
 
-		var sb strings.Builder
-		sb.WriteString("\n\n## Available Dataset: " + datasetID + "\n")
-		sb.WriteString("The following tables and schemas are already available to you:\n\n")
-
+		snap := &schemaSnapshot{Title: "Dataset: " + datasetID}
 		for _, tbl := range tables {
-			schema, meta, err := h.bq.GetTableSchema(ctx, datasetID, tbl.ID)
-			if err != nil {
-				log.Warn().Err(err).Str("table", tbl.ID).Msg("pre-load schema: get schema failed")
-				continue
+			meta := tbl.Meta
+			names := make([]string, len(meta.Schema))
+			for i, f := range meta.Schema {
+				names[i] = f.Name
 			}
-			sb.WriteString(fmt.Sprintf("### %s.%s (%d rows)\n", datasetID, tbl.ID, meta.NumRows))
-			sb.WriteString(service.SchemaToString(schema))
-			sb.WriteString("\n")
+			snap.Tables = append(snap.Tables, schemaTable{
+				Name:        datasetID + "." + tbl.ID,
+				Heading:     fmt.Sprintf("%s.%s (%d rows)", datasetID, tbl.ID, meta.NumRows),
+				Details:     service.TableDetailsToString(meta),
+				Columns:     service.SchemaToString(meta.Schema),
+				ColumnNames: names,
+				Description: meta.Description,
+				Partition:   service.PartitionColumn(meta),
+			})
 		}
 
-		sb.WriteString(BQSchemaClosingInstruction)
-
-		schema := sb.String()
-		h.schemaCache.set(datasetID, schema)
+		h.schemaCache.setSnapshot(datasetID, snap)
 
 		log.Info().
 			Str("dataset", datasetID).
@@ -201,13 +260,13 @@ func (h *BigQueryHandler) getSchemaSection(ctx context.Context, datasetID string
 			Dur("fetch_ms", time.Since(fetchStart)).
 			Msg("schema cached")
 
-		return schema, nil
+		return snap, nil
 	})
 
 	if err != nil || v == nil {
-		return ""
+		return nil
 	}
-	return v.(string)
+	return v.(*schemaSnapshot)
 }
 
 // BigQueryHandler orchestrates the NL→SQL→execute pipeline
@@ -221,10 +280,16 @@ type BigQueryHandler struct {
 	dataMasker  *security.DataMasker
 	auditLogger *security.AuditLogger
 	schemaCache *schemaCache
+	schemaSel   *schemaSelector
+	glossary    *service.Glossary // nil = no glossary
+	examples    *ExampleStore     // nil = no few-shot examples
 	respCache   *responseCache
+
+	maxRepairRounds int // correction rounds for failed final SQL; see SetMaxRepairRounds
 }
 
-// NewBigQueryHandler creates a handler with all security components wired in
+// NewBigQueryHandler creates a handler with all security components wired in.
+// caches provides the schema and response cache backends; nil = in-memory.
 func NewBigQueryHandler(
 	agent LLMRunner,
 	bq *service.BigQueryService,
@@ -235,7 +300,11 @@ func NewBigQueryHandler(
 	dataMasker *security.DataMasker,
 	auditLogger *security.AuditLogger,
 	schemaCacheTTL time.Duration,
+	caches cache.Store,
 ) *BigQueryHandler {
+	if caches == nil {
+		caches = cache.NewMemoryStore()
+	}
 	return &BigQueryHandler{
 		agent:       agent,
 		bq:          bq,
@@ -244,12 +313,33 @@ func NewBigQueryHandler(
 		sqlVal:      sqlVal,
 		costTracker: costTracker,
 		dataMasker:  dataMasker,
-		schemaCache: newSchemaCache(schemaCacheTTL),
-		respCache:   newResponseCache(schemaCacheTTL),
+		schemaCache: newSchemaCacheWith(caches.Namespace("bq_schema"), schemaCacheTTL),
+		schemaSel:   newSchemaSelector(SchemaSelectionOptions{}),
+		respCache:   newResponseCacheWith(caches.Namespace("bq_response"), schemaCacheTTL),
 		auditLogger: auditLogger,
+
+		maxRepairRounds: defaultMaxRepairRounds,
 	}
 }
 
+// toolPolicy is the security pipeline applied to queries the agent's tools
+// run during the loop, matching the checks on the final SQL.
+func (h *BigQueryHandler) toolPolicy(req *models.AgentRequest, apiKey string, access *security.TableAccessPolicy) *tools.QueryPolicy {
+	p := &tools.QueryPolicy{
+		Validator: h.sqlVal,
+		Access:    access,
+		Cost:      h.costTracker,
+		Masker:    h.dataMasker,
+		Audit:     h.auditLogger,
+		Log:       tools.NewQueryLog(),
+		APIKey:    apiKey,
+	}
+	if req.ProjectID != nil {
+		p.ProjectID = *req.ProjectID
+	}
+	return p
+}
+
 // friendlyMsg returns a human-readable answer string for common pipeline errors.
 // It helps users understand what went wrong and how to reformulate their request.
 func friendlyMsg(kind, detail string) *string {
@@ -275,6 +365,12 @@ func friendlyMsg(kind, detail string) *string {
 		msg = "Maaf, saya tidak dapat mengeksekusi query tersebut karena alasan keamanan. Hanya query **SELECT** yang diizinkan — sistem tidak mengizinkan perubahan data.\n\nJika Anda ingin menganalisis data, coba: *\"tampilkan data dari tabel X\"* atau *\"hitung total Y per bulan\"*."
 	case "sql_injection":
 		msg = fmt.Sprintf("Query yang dihasilkan mengandung pola berbahaya (%s) dan tidak dapat dieksekusi. Silakan reformulasikan pertanyaan Anda.", detail)
+	case "table_access":
+		msg = fmt.Sprintf("Maaf, query yang dihasilkan mengakses data di luar hak akses squad Anda (%s). Silakan ajukan pertanyaan tentang data yang tersedia untuk tim/squad Anda.", detail)
+	case "row_level_security":
+		msg = "Maaf, kebijakan akses baris (row-level security) untuk akun Anda tidak dapat diterapkan pada query ini. Silakan hubungi administrator."
+	case "sql_execution":
+		msg = "Maaf, query yang dihasilkan gagal dijalankan oleh database dan tidak berhasil diperbaiki. Coba reformulasikan pertanyaan Anda dengan menyebutkan tabel atau kolom yang dimaksud."
 	case "cost_exceeded":
 		msg = "Maaf, query ini melebihi batas biaya yang diizinkan. Coba persempit scope data, misalnya dengan menambahkan filter tanggal atau membatasi jumlah baris."
 	default:
@@ -319,23 +415,59 @@ func sqlFriendlyMsg(validationMessage string) *string {
 }
 
 // InvalidateSchemaCache removes the cached schema prompt for the given dataset,
-// forcing the next request to re-fetch from BigQuery.
-func (h *BigQueryHandler) InvalidateSchemaCache(datasetID string) {
-	h.schemaCache.invalidate(datasetID)
+// forcing the next request to re-fetch from BigQuery. With a shared or
+// broadcasting cache backend the invalidation reaches every replica.
+func (h *BigQueryHandler) InvalidateSchemaCache(datasetID string) error {
+	return h.schemaCache.invalidate(datasetID)
 }
 
 // FlushResponseCache clears all cached agent responses.
-func (h *BigQueryHandler) FlushResponseCache() {
-	h.respCache.flush()
+func (h *BigQueryHandler) FlushResponseCache() error {
+	return h.respCache.flush()
+}
+
+// EnableSemanticCache turns on prompt normalization and, with an embedder,
+// similarity matching for the response cache. Call before serving requests.
+func (h *BigQueryHandler) EnableSemanticCache(opts SemanticCacheOptions) {
+	h.respCache.semantic = newSemanticMatcher(opts)
+}
+
+// SetSchemaSelection sets how wide datasets are cut down to the tables most
+// relevant to each prompt in the system prompt. Call before serving requests.
+func (h *BigQueryHandler) SetSchemaSelection(opts SchemaSelectionOptions) {
+	h.schemaSel = newSchemaSelector(opts)
+}
+
+// SetGlossary sets the business glossary whose terms are added to the
+// system prompt when a prompt mentions them. Call before serving requests.
+func (h *BigQueryHandler) SetGlossary(g *service.Glossary) {
+	h.glossary = g
+}
+
+// SetExamples sets the store of verified examples whose most similar ones
+// are added to the system prompt as few-shot examples. Call before serving
+// requests.
+func (h *BigQueryHandler) SetExamples(s *ExampleStore) {
+	h.examples = s
+}
+
+// SetMaxRepairRounds sets how many times final SQL that fails validation,
+// execution or the cost check is sent back to the LLM for correction; 0
+// disables repair. Call before serving requests.
+func (h *BigQueryHandler) SetMaxRepairRounds(n int) {
+	h.maxRepairRounds = max(n, 0)
 }
 
 // Handle processes an agent request for BigQuery.
-// allowedDatasets restricts which datasets this user can query (squad isolation);
-// nil means no restriction (admin or no squad configured).
+// access is the squad's table access policy (squad isolation): the datasets,
+// tables and columns generated SQL may read. nil means no restriction (admin
+// or no squad configured). squadID selects the squad's glossary terms and
+// few-shot examples (see SetGlossary and SetExamples); "" means shared ones
+// only.
 // runner is the LLMRunner resolved for the current user's persona; promptStyle
 // controls the system prompt tone ("executive", "technical", "support", or "").
 // excludedTools lists tool names to hide from the LLM; nil means all tools are available.
-func (h *BigQueryHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, allowedDatasets []string, runner LLMRunner, promptStyle string, excludedTools []string) (*models.AgentResponse, error) {
+func (h *BigQueryHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, access *security.TableAccessPolicy, runner LLMRunner, opts RunOptions, promptStyle string, excludedTools []string) (*models.AgentResponse, error) {
 	start := time.Now()
 	metadata := map[string]interface{}{
 		"data_source": "bigquery",
@@ -344,6 +476,10 @@ func (h *BigQueryHandler) Handle(ctx context.Context, req *models.AgentRequest,
 	}
 
 	// 0. Squad dataset access check
+	var allowedDatasets []string
+	if access != nil {
+		allowedDatasets = access.Datasets
+	}
 	if len(allowedDatasets) > 0 && req.DatasetID != nil && *req.DatasetID != "" {
 		if !isDatasetAllowed(*req.DatasetID, allowedDatasets) {
 			return &models.AgentResponse{
@@ -386,110 +522,65 @@ func (h *BigQueryHandler) Handle(ctx context.Context, req *models.AgentRequest,
 		datasetID = *req.DatasetID
 	}
 
-	// 2a. Response cache check (non-streaming, non-dry_run only)
-	cacheKey := responseCacheKey(req.Prompt, datasetID, promptStyle)
-	if !req.DryRun {
-		if cached, ok := h.respCache.get(cacheKey); ok {
-			cached.AgentMetadata["response_cache"] = "hit"
+	// 2a. Response cache check (non-streaming, non-dry_run only). Follow-up
+	// turns of a conversation depend on prior context and are never cached.
+	cacheable := !req.DryRun && len(req.History) == 0
+	var cacheProbe *responseCacheProbe
+	if cacheable {
+		var cached *models.AgentResponse
+		if cached, cacheProbe = h.respCache.lookup(ctx, req.Prompt, responseCacheScope(datasetID, squadID, access, h.glossary, h.examples), promptStyle); cached != nil {
 			return cached, nil
 		}
 		metadata["response_cache"] = "miss"
 	}
 
-	// 3. Build tools (BQListDatasetsTool is filtered to squad's datasets)
+	// 3. Build system prompt: persona base + schema section
+	schema := h.getSchemaSection(ctx, datasetID, req)
+	schema.record(metadata)
+	systemPrompt := SystemPromptStyle(promptStyle) + schema.text + promptGlossary(h.glossary, squadID, datasetID, req, metadata) +
+		promptExamples(ctx, h.examples, squadID, string(service.DataSourceBigQuery), datasetID, req, metadata)
+
+	// 4. Build tools (BQListDatasetsTool is filtered to squad's datasets)
 	if req.DryRun {
 		excludedTools = append(excludedTools, "execute_bigquery_sql")
 		if req.DatasetID != nil && *req.DatasetID != "" {
-			// Schema already injected into system prompt — no need for inspection tools.
-			excludedTools = append(excludedTools, "list_bigquery_tables", "get_bigquery_schema", "get_bigquery_sample_data")
+			excludedTools = append(excludedTools, dryRunSchemaTools(schema, "list_bigquery_tables", "get_bigquery_schema", "get_bigquery_sample_data")...)
 		}
 	}
+	policy := h.toolPolicy(req, apiKey, access)
 	bqTools := filterTools([]tools.Tool{
 		tools.BQListDatasetsTool(h.bq, allowedDatasets),
-		tools.BQListTablesTool(h.bq),
-		tools.BQGetSchemaTool(h.bq),
-		tools.BQSampleDataTool(h.bq),
-		tools.BQExecuteQueryTool(h.bq),
+		tools.BQListTablesTool(h.bq, policy),
+		tools.BQGetSchemaTool(h.bq, policy),
+		tools.BQSampleDataTool(h.bq, policy),
+		tools.BQExecuteQueryTool(h.bq, policy, datasetID),
 	}, excludedTools)
 
-	// 4. Build system prompt: persona base + cached schema section
-	systemPrompt := SystemPromptStyle(promptStyle) + h.getSchemaSection(ctx, datasetID)
-
-	// 5. Run agent loop
+	// 5. Run agent loop. The final SQL is validated, access-checked, cost-checked
+	// and executed; failures the LLM can fix are sent back for correction.
 	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
 	defer cancel()
 
-	llmStart := time.Now()
-	output, toolsUsed, lastSQL, err := runner.Run(agentCtx, systemPrompt, req.Prompt, bqTools)
-	llmMs := time.Since(llmStart).Milliseconds()
+	run := func(ctx context.Context, prompt string, history []models.ConversationTurn) (*RunResult, error) {
+		return runner.Run(ctx, systemPrompt, prompt, history, bqTools, opts)
+	}
+	var execute func(string) (*models.QueryResponse, *sqlFailure)
+	if !req.DryRun {
+		execute = func(sql string) (*models.QueryResponse, *sqlFailure) {
+			return h.executeSQL(agentCtx, req, sql, datasetID, apiKey, access, policy.Log, metadata)
+		}
+	}
+	resetSQLMetadata(metadata)
+	ar, err := runWithRepair(agentCtx, run, req, h.maxRepairRounds, execute, nil)
 	if err != nil {
 		return nil, fmt.Errorf("agent run: %w", err)
 	}
-
-	metadata["tools_used"] = toolsUsed
-
-	// 6. Extract SQL from output — fallback to last tool-executed SQL if not in code block
-	generatedSQL := extractSQL(output)
-	if generatedSQL == "" && lastSQL != "" {
-		generatedSQL = lastSQL
-		log.Debug().Str("sql", generatedSQL[:min(60, len(generatedSQL))]).Msg("using lastExecutedSQL as fallback")
-	}
-	metadata["sql_validation"] = "n/a"
-	metadata["cost_tracking"] = "n/a"
-	metadata["data_masking"] = "n/a"
-
-	var execResult *models.QueryResponse
-
-	if generatedSQL != "" && !req.DryRun {
-		// 6. SQL validation
-		if errMsg := h.sqlVal.Validate(generatedSQL); errMsg != "" {
-			metadata["sql_validation"] = "blocked: " + errMsg
-			return &models.AgentResponse{
-				Status:        "error",
-				Prompt:        req.Prompt,
-				AgentMetadata: metadata,
-				Answer:        sqlFriendlyMsg(errMsg),
-			}, fmt.Errorf("SQL validation failed: %s", errMsg)
-		}
-		metadata["sql_validation"] = "passed"
-
-		// 7. FIX #8: Execute SQL and populate ExecutionResult with masking + cost checks
-		projectID := ""
-		if req.ProjectID != nil {
-			projectID = *req.ProjectID
-		}
-		queryStart := time.Now()
-		result, qErr := h.bq.ExecuteQuery(agentCtx, generatedSQL, projectID, false, 60000, true, false)
-		if qErr == nil {
-			queryMs := time.Since(queryStart).Milliseconds()
-
-			// Cost check
-			if ok, costErr := h.costTracker.CheckLimits(result.TotalBytesProcessed, apiKey); !ok {
-				metadata["cost_tracking"] = "blocked: " + costErr
-			} else {
-				h.costTracker.LogQueryCost(generatedSQL, result.TotalBytesProcessed, apiKey, queryMs)
-				metadata["cost_tracking"] = "ok"
-
-				// Data masking
-				data := h.dataMasker.MaskRows(result.Data)
-				metadata["data_masking"] = "applied"
-
-				execResult = &models.QueryResponse{
-					Status:   "success",
-					Data:     data,
-					Columns:  result.Columns,
-					RowCount: len(data),
-					Metadata: models.QueryMetadata{
-						JobID:               result.JobID,
-						TotalBytesProcessed: result.TotalBytesProcessed,
-						BytesBilled:         result.BytesBilled,
-						CacheHit:            result.CacheHit,
-						ExecutionTimeMs:     queryMs,
-					},
-				}
-			}
-		}
+	metadata["tools_used"] = ar.toolsUsed
+	recordSQLAttempts(metadata, ar)
+	if ar.failure != nil {
+		return ar.failure.response(req, metadata)
 	}
+	output, generatedSQL, execResult, llmMs := ar.output, ar.generatedSQL, ar.result, ar.llmMs
 
 	execTimeMs := time.Since(start).Milliseconds()
 	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, generatedSQL, true, execTimeMs)
@@ -515,18 +606,18 @@ func (h *BigQueryHandler) Handle(ctx context.Context, req *models.AgentRequest,
 		AgentMetadata:   metadata,
 		Answer:          answerPtr,
 	}
-	if !req.DryRun {
-		h.respCache.set(cacheKey, resp)
+	if cacheable {
+		h.respCache.store(cacheProbe, resp)
 	}
 	return resp, nil
 }
 
 // HandleStream processes an agent request for BigQuery with SSE event emission.
-// allowedDatasets restricts which datasets this user can query (squad isolation).
+// access and squadID are as in Handle.
 // runner and promptStyle are resolved from the current user's persona (same as Handle).
 // excludedTools lists tool names to hide from the LLM; nil means all tools are available.
 // The final "result" or "error" event is always the last call to emitFn.
-func (h *BigQueryHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, allowedDatasets []string, runner LLMRunner, promptStyle string, emitFn func(event string, data interface{}), excludedTools []string) {
+func (h *BigQueryHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, access *security.TableAccessPolicy, runner LLMRunner, opts RunOptions, promptStyle string, emitFn func(event string, data interface{}), excludedTools []string) {
 	start := time.Now()
 	metadata := map[string]interface{}{
 		"data_source": "bigquery",
@@ -537,6 +628,10 @@ func (h *BigQueryHandler) HandleStream(ctx context.Context, req *models.AgentReq
 	emitFn("start", map[string]interface{}{"prompt": req.Prompt})
 
 	// 0. Squad dataset access check
+	var allowedDatasets []string
+	if access != nil {
+		allowedDatasets = access.Datasets
+	}
 	if len(allowedDatasets) > 0 && req.DatasetID != nil && *req.DatasetID != "" {
 		if !isDatasetAllowed(*req.DatasetID, allowedDatasets) {
 			emitFn("error", map[string]interface{}{
@@ -572,103 +667,69 @@ func (h *BigQueryHandler) HandleStream(ctx context.Context, req *models.AgentReq
 	}
 	metadata["prompt_validation"] = "passed"
 
-	// 3. Build tools (BQListDatasetsTool is filtered to squad's datasets)
+	datasetID := ""
+	if req.DatasetID != nil {
+		datasetID = *req.DatasetID
+	}
+
+	// 3. Schema pre-loading
+	emitFn("progress", map[string]interface{}{"step": "schema_loading", "dataset": datasetID})
+	schema := h.getSchemaSection(ctx, datasetID, req)
+	schema.record(metadata)
+	systemPrompt := SystemPromptStyle(promptStyle) + schema.text + promptGlossary(h.glossary, squadID, datasetID, req, metadata) +
+		promptExamples(ctx, h.examples, squadID, string(service.DataSourceBigQuery), datasetID, req, metadata)
+	emitFn("progress", schemaReadyEvent("dataset", datasetID, schema))
+
+	// 4. Build tools (BQListDatasetsTool is filtered to squad's datasets)
 	if req.DryRun {
 		excludedTools = append(excludedTools, "execute_bigquery_sql")
 		if req.DatasetID != nil && *req.DatasetID != "" {
-			// Schema already injected into system prompt — no need for inspection tools.
-			excludedTools = append(excludedTools, "list_bigquery_tables", "get_bigquery_schema", "get_bigquery_sample_data")
+			excludedTools = append(excludedTools, dryRunSchemaTools(schema, "list_bigquery_tables", "get_bigquery_schema", "get_bigquery_sample_data")...)
 		}
 	}
+	policy := h.toolPolicy(req, apiKey, access)
 	bqTools := filterTools([]tools.Tool{
 		tools.BQListDatasetsTool(h.bq, allowedDatasets),
-		tools.BQListTablesTool(h.bq),
-		tools.BQGetSchemaTool(h.bq),
-		tools.BQSampleDataTool(h.bq),
-		tools.BQExecuteQueryTool(h.bq),
+		tools.BQListTablesTool(h.bq, policy),
+		tools.BQGetSchemaTool(h.bq, policy),
+		tools.BQSampleDataTool(h.bq, policy),
+		tools.BQExecuteQueryTool(h.bq, policy, datasetID),
 	}, excludedTools)
 
-	// 4. Schema pre-loading
-	datasetID := ""
-	if req.DatasetID != nil {
-		datasetID = *req.DatasetID
-	}
-	emitFn("progress", map[string]interface{}{"step": "schema_loading", "dataset": datasetID})
-	systemPrompt := SystemPromptStyle(promptStyle) + h.getSchemaSection(ctx, datasetID)
-	emitFn("progress", map[string]interface{}{"step": "schema_ready", "dataset": datasetID})
-
-	// 5. Run agent loop with event emission
+	// 5. Run agent loop with event emission; failed final SQL is sent back
+	// for correction as in Handle.
 	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
 	defer cancel()
 
 	agentEmit := func(event string, data map[string]interface{}) {
 		emitFn(event, data)
 	}
-
-	llmStart := time.Now()
-	output, toolsUsed, lastSQL, err := runner.RunWithEmit(agentCtx, systemPrompt, req.Prompt, bqTools, agentEmit)
-	llmMs := time.Since(llmStart).Milliseconds()
+	run := func(ctx context.Context, prompt string, history []models.ConversationTurn) (*RunResult, error) {
+		return runner.RunWithEmit(ctx, systemPrompt, prompt, history, bqTools, opts, agentEmit)
+	}
+	var execute func(string) (*models.QueryResponse, *sqlFailure)
+	if !req.DryRun {
+		execute = func(sql string) (*models.QueryResponse, *sqlFailure) {
+			emitFn("progress", map[string]interface{}{"step": "executing_sql"})
+			return h.executeSQL(agentCtx, req, sql, datasetID, apiKey, access, policy.Log, metadata)
+		}
+	}
+	resetSQLMetadata(metadata)
+	ar, err := runWithRepair(agentCtx, run, req, h.maxRepairRounds, execute, streamRepairFn(emitFn))
 	if err != nil {
 		emitFn("error", map[string]interface{}{"message": "agent run: " + err.Error()})
 		return
 	}
-	metadata["tools_used"] = toolsUsed
-
-	// 6. Extract SQL
-	generatedSQL := extractSQL(output)
-	if generatedSQL == "" && lastSQL != "" {
-		generatedSQL = lastSQL
-		log.Debug().Str("sql", generatedSQL[:min(60, len(generatedSQL))]).Msg("stream: using lastExecutedSQL as fallback")
-	}
-	metadata["sql_validation"] = "n/a"
-	metadata["cost_tracking"] = "n/a"
-	metadata["data_masking"] = "n/a"
-
-	var execResult *models.QueryResponse
-
-	if generatedSQL != "" && !req.DryRun {
-		if errMsg := h.sqlVal.Validate(generatedSQL); errMsg != "" {
-			metadata["sql_validation"] = "blocked: " + errMsg
-			emitFn("error", map[string]interface{}{
-				"message": "SQL validation failed: " + errMsg,
-				"step":    "sql_validation",
-			})
-			return
-		}
-		metadata["sql_validation"] = "passed"
-
-		projectID := ""
-		if req.ProjectID != nil {
-			projectID = *req.ProjectID
-		}
-		emitFn("progress", map[string]interface{}{"step": "executing_sql"})
-		queryStart := time.Now()
-		result, qErr := h.bq.ExecuteQuery(agentCtx, generatedSQL, projectID, false, 60000, true, false)
-		if qErr == nil {
-			queryMs := time.Since(queryStart).Milliseconds()
-			if ok, costErr := h.costTracker.CheckLimits(result.TotalBytesProcessed, apiKey); !ok {
-				metadata["cost_tracking"] = "blocked: " + costErr
-			} else {
-				h.costTracker.LogQueryCost(generatedSQL, result.TotalBytesProcessed, apiKey, queryMs)
-				metadata["cost_tracking"] = "ok"
-				data := h.dataMasker.MaskRows(result.Data)
-				metadata["data_masking"] = "applied"
-				execResult = &models.QueryResponse{
-					Status:   "success",
-					Data:     data,
-					Columns:  result.Columns,
-					RowCount: len(data),
-					Metadata: models.QueryMetadata{
-						JobID:               result.JobID,
-						TotalBytesProcessed: result.TotalBytesProcessed,
-						BytesBilled:         result.BytesBilled,
-						CacheHit:            result.CacheHit,
-						ExecutionTimeMs:     queryMs,
-					},
-				}
-			}
-		}
+	metadata["tools_used"] = ar.toolsUsed
+	recordSQLAttempts(metadata, ar)
+	if ar.failure != nil {
+		emitFn("error", map[string]interface{}{
+			"message": ar.failure.err.Error(),
+			"step":    ar.failure.step,
+		})
+		return
 	}
+	output, generatedSQL, execResult, llmMs := ar.output, ar.generatedSQL, ar.result, ar.llmMs
 
 	execTimeMs := time.Since(start).Milliseconds()
 	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, generatedSQL, true, execTimeMs)
@@ -696,6 +757,127 @@ func (h *BigQueryHandler) HandleStream(ctx context.Context, req *models.AgentReq
 	})
 }
 
+// executeSQL runs the agent's final SQL through the security pipeline — SQL
+// validation, table access, row-level security and a dry-run cost check —
+// then executes it and masks the rows. The status of each step is written to
+// metadata.
+func (h *BigQueryHandler) executeSQL(ctx context.Context, req *models.AgentRequest, generatedSQL, datasetID, apiKey string, access *security.TableAccessPolicy, executed *tools.QueryLog, metadata map[string]interface{}) (*models.QueryResponse, *sqlFailure) {
+	resetSQLMetadata(metadata)
+
+	// SQL validation
+	analysis := h.sqlVal.Analyze(generatedSQL, security.DialectBigQuery)
+	metadata["referenced_tables"] = analysis.TableNames()
+	if errMsg := analysis.Message(); errMsg != "" {
+		metadata["sql_validation"] = "blocked: " + errMsg
+		metadata["sql_violations"] = analysis.Violations
+		return nil, &sqlFailure{
+			step:       "sql_validation",
+			err:        fmt.Errorf("SQL validation failed: %s", errMsg),
+			answer:     sqlFriendlyMsg(errMsg),
+			repairable: true,
+		}
+	}
+	metadata["sql_validation"] = "passed"
+
+	// Table access: every referenced table and column must be readable
+	// by the squad, whichever dataset the LLM chose to query.
+	if access != nil {
+		if violations := access.Check(analysis, datasetID); len(violations) > 0 {
+			errMsg := violations[0].Message
+			metadata["table_access"] = "blocked: " + errMsg
+			metadata["sql_violations"] = violations
+			return nil, &sqlFailure{
+				step:   "table_access",
+				err:    fmt.Errorf("table access denied: %s", errMsg),
+				answer: friendlyMsg("table_access", errMsg),
+			}
+		}
+		metadata["table_access"] = "passed"
+	}
+
+	// Row-level security: tables with row filters are read through
+	// filtered subqueries; the rewritten SQL is what runs.
+	execSQL, filtered, rlsErr := access.ApplyRowFilters(generatedSQL, analysis, datasetID)
+	if rlsErr != nil {
+		metadata["row_level_security"] = "blocked: " + rlsErr.Error()
+		return nil, &sqlFailure{
+			step:   "row_level_security",
+			err:    fmt.Errorf("row-level security: %w", rlsErr),
+			answer: friendlyMsg("row_level_security", ""),
+		}
+	}
+	if len(filtered) > 0 {
+		metadata["row_level_security"] = "applied"
+		metadata["row_filtered_tables"] = filtered
+	}
+
+	// A query the agent already ran through its tools is not run again: the
+	// recorded result is reused, subject to the per-query cost limit and
+	// masked like a fresh one. Its bytes were charged when the tool ran.
+	if prev, ok := executed.Find(execSQL, security.DialectBigQuery, datasetID); ok && prev.BigQuery != nil {
+		metadata["estimated_bytes_processed"] = prev.BigQuery.TotalBytesProcessed
+		if ok, costErr := h.costTracker.CheckQueryLimit(prev.BigQuery.TotalBytesProcessed); !ok {
+			metadata["cost_tracking"] = "blocked: " + costErr
+			return nil, costFailure(costErr)
+		}
+		metadata["sql_execution"] = "ok"
+		metadata["sql_result_reused"] = prev.Tool
+		metadata["cost_tracking"] = "ok"
+		return h.queryResponse(prev.BigQuery, metadata), nil
+	}
+
+	// FIX #8: Execute SQL and populate ExecutionResult with masking + cost checks.
+	// The cost/budget check runs on a dry-run estimate so over-budget
+	// queries are rejected before BigQuery bills them.
+	projectID := ""
+	if req.ProjectID != nil {
+		projectID = *req.ProjectID
+	}
+	dry, qErr := h.bq.ExecuteQuery(ctx, execSQL, projectID, true, 60000, true, false)
+	if qErr != nil {
+		return nil, executionFailure(metadata, qErr, service.IsQueryError(qErr))
+	}
+	metadata["estimated_bytes_processed"] = dry.TotalBytesProcessed
+	reservation, ok, costErr := h.costTracker.CheckLimits(dry.TotalBytesProcessed, apiKey)
+	if !ok {
+		metadata["cost_tracking"] = "blocked: " + costErr
+		return nil, costFailure(costErr)
+	}
+	defer reservation.Release() // no-op once settled
+	result, qErr := h.bq.ExecuteQuery(ctx, execSQL, projectID, false, 60000, true, false)
+	if qErr != nil {
+		return nil, executionFailure(metadata, qErr, service.IsQueryError(qErr))
+	}
+	metadata["sql_execution"] = "ok"
+	queryMs := result.ExecutionTimeMs
+	reservation.Settle(result.TotalBytesProcessed)
+	h.costTracker.LogQueryCost(execSQL, result.TotalBytesProcessed, apiKey, queryMs)
+	metadata["cost_tracking"] = "ok"
+
+	return h.queryResponse(result, metadata), nil
+}
+
+// queryResponse masks the rows of result and wraps it with its job
+// metadata.
+func (h *BigQueryHandler) queryResponse(result *service.QueryResult, metadata map[string]interface{}) *models.QueryResponse {
+	data := h.dataMasker.MaskRows(result.Data)
+	metadata["data_masking"] = "applied"
+
+	return &models.QueryResponse{
+		Status:   "success",
+		Data:     data,
+		Columns:  result.Columns,
+		RowCount: len(data),
+		Metadata: models.QueryMetadata{
+			JobID:               result.JobID,
+			TotalBytesProcessed: result.TotalBytesProcessed,
+			BytesBilled:         result.BytesBilled,
+			CacheHit:            result.CacheHit,
+			ExecutionTimeMs:     result.ExecutionTimeMs,
+		},
+	}
+}
+
 // extractSQL pulls SQL from model output using 4 strategies in order:
 // 1. ```sql ... ``` code block (preferred)
 // 2. ``` ... ``` generic code block containing SELECT/WITH
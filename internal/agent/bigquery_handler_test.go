@@ -1,11 +1,15 @@
 package agent
 
 import (
+	"context"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/cortexai/cortexai/internal/cache"
 	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
 	"github.com/cortexai/cortexai/internal/tools"
 )
 
@@ -182,15 +186,10 @@ func TestSchemaCache_Invalidate_NonExistentKey(t *testing.T) {
 }
 
 func TestSchemaCache_TTLExpiry(t *testing.T) {
-	c := newSchemaCache(0)
+	c := newSchemaCache(20 * time.Millisecond)
+	c.set("expired", "old prompt")
 
-	// Manually insert an already-expired entry
-	c.mu.Lock()
-	c.store["expired"] = schemaCacheEntry{
-		prompt:    "old prompt",
-		expiresAt: time.Now().Add(-1 * time.Second), // expired 1s ago
-	}
-	c.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
 
 	_, ok := c.get("expired")
 	if ok {
@@ -417,13 +416,61 @@ func TestResponseCache_HitMiss(t *testing.T) {
 
 	c.set(key, resp)
 
-	// After set: cache hit with same pointer
+	// After set: cache hit with an equal, independent copy
 	got, ok := c.get(key)
 	if !ok {
 		t.Fatal("expected cache hit after set")
 	}
-	if got != resp {
-		t.Errorf("cached response pointer mismatch: got %p, want %p", got, resp)
+	if got == resp {
+		t.Error("cache hit must return a copy, not the stored pointer")
+	}
+	if got.Status != "success" {
+		t.Errorf("cached status: got %q, want success", got.Status)
+	}
+}
+
+func TestResponseCache_HitIsIsolatedCopy(t *testing.T) {
+	c := newResponseCache(time.Minute)
+	sql := "SELECT 1"
+	key := responseCacheKey("show top 5 users", "wlt_datalake_01", "executive")
+	c.set(key, &models.AgentResponse{
+		Status:        "success",
+		GeneratedSQL:  &sql,
+		AgentMetadata: map[string]interface{}{"response_cache": "miss"},
+	})
+
+	first, _ := c.get(key)
+	first.AgentMetadata["response_cache"] = "hit"
+
+	second, ok := c.get(key)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if second.AgentMetadata["response_cache"] != "miss" {
+		t.Errorf("mutating one hit leaked into the cache: %v", second.AgentMetadata["response_cache"])
+	}
+	if second.GeneratedSQL == nil || *second.GeneratedSQL != sql {
+		t.Errorf("generated_sql not round-tripped: %v", second.GeneratedSQL)
+	}
+}
+
+func TestResponseCache_SharedBackendAcrossHandlers(t *testing.T) {
+	// Two replicas pointed at the same backend see each other's entries and
+	// flushes, which is what the shared (redis) backend provides in production.
+	store := cache.NewMemoryStore()
+	replicaA := newResponseCacheWith(store.Namespace("bq_response"), time.Minute)
+	replicaB := newResponseCacheWith(store.Namespace("bq_response"), time.Minute)
+	key := responseCacheKey("show top 5 users", "wlt_datalake_01", "executive")
+
+	replicaA.set(key, &models.AgentResponse{Status: "success"})
+	if _, ok := replicaB.get(key); !ok {
+		t.Fatal("replica B should see replica A's entry")
+	}
+	if err := replicaB.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if _, ok := replicaA.get(key); ok {
+		t.Error("flush on replica B should clear replica A's view")
 	}
 }
 
@@ -494,3 +541,154 @@ func TestBQSchemaSectionClosingInstruction_IsDirective(t *testing.T) {
 		t.Errorf("closing instruction must not use soft 'you can skip' language, got: %q", BQSchemaClosingInstruction)
 	}
 }
+
+// ── table access ─────────────────────────────────────────────────────────────
+
+// fixedOutputRunner returns the same model output for every run.
+type fixedOutputRunner struct{ output string }
+
+func (r *fixedOutputRunner) Run(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ RunOptions) (*RunResult, error) {
+	return &RunResult{Text: r.output}, nil
+}
+func (r *fixedOutputRunner) RunWithEmit(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ RunOptions, _ EmitFn) (*RunResult, error) {
+	return &RunResult{Text: r.output}, nil
+}
+func (r *fixedOutputRunner) Model() string { return "fixed" }
+
+func newTestBigQueryHandler() *BigQueryHandler {
+	return NewBigQueryHandler(nil, nil,
+		security.NewPIIDetector(nil),
+		security.NewPromptValidator(),
+		security.NewSQLValidator(),
+		security.NewCostTracker(0),
+		security.NewDataMasker(nil),
+		security.NewAuditLogger(false),
+		time.Minute, nil,
+	)
+}
+
+func TestBigQueryHandle_BlocksTableOutsideSquad(t *testing.T) {
+	h := newTestBigQueryHandler()
+	runner := &fixedOutputRunner{output: "```sql\nSELECT u.email FROM payment_ds_01.orders o JOIN user_ds_01.users u ON u.id = o.user_id\n```"}
+	ds := "payment_ds_01"
+	req := &models.AgentRequest{Prompt: "tampilkan email pelanggan dengan order terbanyak", DatasetID: &ds, Timeout: 30}
+	access := &security.TableAccessPolicy{Datasets: []string{"payment_ds_01"}}
+
+	resp, err := h.Handle(context.Background(), req, "key", "", access, runner, RunOptions{}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "table access denied") {
+		t.Fatalf("expected table access error, got %v", err)
+	}
+	if resp == nil || resp.Status != "error" || resp.Answer == nil {
+		t.Fatalf("expected friendly error response, got %+v", resp)
+	}
+	if got, _ := resp.AgentMetadata["table_access"].(string); !strings.Contains(got, "user_ds_01") {
+		t.Errorf("table_access = %q, want it to name user_ds_01", got)
+	}
+	if resp.AgentMetadata["sql_validation"] != "passed" {
+		t.Errorf("sql_validation = %v, want passed", resp.AgentMetadata["sql_validation"])
+	}
+}
+
+func TestBigQueryHandle_BlocksDeniedColumn(t *testing.T) {
+	h := newTestBigQueryHandler()
+	runner := &fixedOutputRunner{output: "```sql\nSELECT * FROM payment_ds_01.customers LIMIT 10\n```"}
+	req := &models.AgentRequest{Prompt: "tampilkan 10 data pelanggan", Timeout: 30}
+	access := &security.TableAccessPolicy{DeniedColumns: []string{"customers.phone"}}
+
+	resp, err := h.Handle(context.Background(), req, "key", "", access, runner, RunOptions{}, "", nil)
+	if err == nil {
+		t.Fatal("expected SELECT * over a denied column to be rejected")
+	}
+	violations, _ := resp.AgentMetadata["sql_violations"].([]security.SQLViolation)
+	if len(violations) != 1 || violations[0].Code != security.ViolationColumnNotAllowed {
+		t.Errorf("sql_violations = %+v, want one %s", violations, security.ViolationColumnNotAllowed)
+	}
+}
+
+func TestBigQueryHandle_BrokenRowFilterFailsClosed(t *testing.T) {
+	h := newTestBigQueryHandler() // nil BigQuery client: the query must not reach execution
+	runner := &fixedOutputRunner{output: "```sql\nSELECT id FROM payment_ds_01.orders\n```"}
+	req := &models.AgentRequest{Prompt: "tampilkan order", Timeout: 30}
+	access := &security.TableAccessPolicy{RowFilters: []security.RowFilter{
+		{Table: "orders", Predicate: "merchant_id = 1) OR (1 = 1"},
+	}}
+
+	resp, err := h.Handle(context.Background(), req, "key", "", access, runner, RunOptions{}, "", nil)
+	if err == nil {
+		t.Fatal("expected a broken row filter to block the query")
+	}
+	if got, _ := resp.AgentMetadata["row_level_security"].(string); !strings.HasPrefix(got, "blocked: ") {
+		t.Errorf("row_level_security = %q, want blocked", got)
+	}
+}
+
+func TestBigQueryHandle_ResponseCacheKeyedByAccessPolicy(t *testing.T) {
+	h := newTestBigQueryHandler()
+	runner := &fixedOutputRunner{output: "Tidak ada data."}
+	ds := "payment_ds_01"
+	req := &models.AgentRequest{Prompt: "total transaksi hari ini", DatasetID: &ds, Timeout: 30}
+	userA := &security.TableAccessPolicy{Datasets: []string{ds}, RowFilters: []security.RowFilter{{Table: "transactions", Predicate: "merchant_id = 1"}}}
+	userB := &security.TableAccessPolicy{Datasets: []string{ds}, RowFilters: []security.RowFilter{{Table: "transactions", Predicate: "merchant_id = 2"}}}
+
+	// User A's answer, computed under A's row filters, is in the cache.
+	_, probe := h.respCache.lookup(context.Background(), req.Prompt, responseCacheScope(ds, "squad-a", userA, nil, nil), "")
+	h.respCache.store(probe, &models.AgentResponse{Status: "success", Prompt: req.Prompt, AgentMetadata: map[string]interface{}{}})
+
+	resp, _ := h.Handle(context.Background(), req, "key", "squad-a", userA, runner, RunOptions{}, "", nil)
+	if resp == nil || resp.AgentMetadata["response_cache"] != "hit" {
+		t.Fatalf("same squad and policy: want a cache hit, got %+v", resp)
+	}
+	for name, call := range map[string]struct {
+		squadID string
+		access  *security.TableAccessPolicy
+	}{
+		"other row filters": {"squad-a", userB},
+		"other squad":       {"squad-b", userA},
+		"no policy":         {"squad-a", nil},
+	} {
+		resp, _ := h.Handle(context.Background(), req, "key", call.squadID, call.access, runner, RunOptions{}, "", nil)
+		if resp != nil && resp.AgentMetadata["response_cache"] == "hit" {
+			t.Errorf("%s: served user A's cached answer", name)
+		}
+	}
+}
+
+// ── result reuse ─────────────────────────────────────────────────────────────
+
+func TestBigQueryExecuteSQL_ReusesToolResult(t *testing.T) {
+	h := newTestBigQueryHandler() // nil BigQuery client: the query must not run again
+	h.costTracker = security.NewCostTracker(10_000)
+	executed := tools.NewQueryLog()
+	executed.Record(tools.ExecutedQuery{
+		Tool:  "execute_bigquery_sql",
+		SQL:   "SELECT email FROM payment_ds_01.orders LIMIT 10",
+		Scope: "payment_ds_01",
+		BigQuery: &service.QueryResult{
+			Data:                []map[string]interface{}{{"email": "john.doe@example.com"}},
+			Columns:             []string{"email"},
+			JobID:               "job-1",
+			TotalBytesProcessed: 2_000,
+		},
+	}, security.DialectBigQuery)
+
+	metadata := map[string]interface{}{}
+	req := &models.AgentRequest{Prompt: "email pelanggan"}
+	res, failure := h.executeSQL(context.Background(), req, "SELECT email\nFROM payment_ds_01.orders\nLIMIT 10;", "payment_ds_01", "key", nil, executed, metadata)
+	if failure != nil {
+		t.Fatalf("unexpected failure: %v", failure.err)
+	}
+	if res.Metadata.JobID != "job-1" || res.Metadata.TotalBytesProcessed != 2_000 {
+		t.Errorf("metadata = %+v, want the tool's job", res.Metadata)
+	}
+	if got := res.Data[0]["email"]; got == "john.doe@example.com" {
+		t.Error("reused rows must be masked")
+	}
+	if metadata["sql_result_reused"] != "execute_bigquery_sql" || metadata["sql_execution"] != "ok" {
+		t.Errorf("metadata = %v", metadata)
+	}
+
+	h.costTracker = security.NewCostTracker(1_000)
+	if _, failure := h.executeSQL(context.Background(), req, "SELECT email FROM payment_ds_01.orders LIMIT 10", "payment_ds_01", "key", nil, executed, metadata); failure == nil || failure.step != "cost_check" {
+		t.Errorf("expected the per-query limit to apply to reused results, got %+v", failure)
+	}
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+// historyAssistantText renders a prior conversation turn as the assistant
+// message replayed to the LLM. It carries the answer, the SQL that produced it
+// and a summary of the result so follow-ups like "now break that down by
+// region" can build on the previous query.
+func historyAssistantText(turn models.ConversationTurn) string {
+	var sb strings.Builder
+	if turn.Answer != "" {
+		sb.WriteString(turn.Answer)
+	}
+	if turn.GeneratedSQL != "" {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString("SQL used:\n```sql\n")
+		sb.WriteString(turn.GeneratedSQL)
+		sb.WriteString("\n```")
+	}
+	if turn.ResultSummary != "" {
+		if sb.Len() > 0 {
+			sb.WriteString("\n\n")
+		}
+		sb.WriteString("Result summary: ")
+		sb.WriteString(turn.ResultSummary)
+	}
+	if sb.Len() == 0 {
+		return "(no answer recorded)"
+	}
+	return sb.String()
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/tools"
 	"github.com/rs/zerolog/log"
 )
@@ -20,9 +22,9 @@ type ToolCall struct {
 
 // CortexAgent wraps Anthropic SDK for multi-turn tool-calling agent loop
 type CortexAgent struct {
-	client    *anthropic.Client
-	model     string
-	maxTokens int
+	client *anthropic.Client
+	model  string
+	retry  RetryPolicy
 }
 
 // NewCortexAgent creates an agent backed by Anthropic Claude or compatible provider (e.g. Z.ai)
@@ -30,89 +32,73 @@ func NewCortexAgent(apiKey, model, baseURL string) *CortexAgent {
 	if model == "" {
 		model = "claude-sonnet-4-6"
 	}
-	opts := []option.RequestOption{option.WithAPIKey(apiKey)}
+	// Retries are done by createMessage so they follow the runner's RetryPolicy.
+	opts := []option.RequestOption{option.WithAPIKey(apiKey), option.WithMaxRetries(0)}
 	if baseURL != "" {
 		opts = append(opts, option.WithBaseURL(baseURL))
 	}
 	client := anthropic.NewClient(opts...)
 	return &CortexAgent{
-		client:    client,
-		model:     model,
-		maxTokens: 4096,
+		client: client,
+		model:  model,
+		retry:  DefaultRetryPolicy,
 	}
 }
 
+// SetRetryPolicy sets how failed Messages API calls are retried.
+func (a *CortexAgent) SetRetryPolicy(p RetryPolicy) {
+	a.retry = p
+}
+
 // EmitFn is called during agent execution to emit progress events for streaming.
 // It may be nil when streaming is not needed.
 type EmitFn func(event string, data map[string]interface{})
 
 // Run executes the agent loop: LLM calls tools until stop_reason = "end_turn".
-// Returns (finalText, toolsUsed, lastExecutedSQL, error).
-// lastExecutedSQL is the last SQL passed to execute_bigquery_sql tool — used as
-// fallback when the model doesn't include a ```sql block in its final reply.
-func (a *CortexAgent) Run(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool) (string, []string, string, error) {
-	return a.run(ctx, systemPrompt, userPrompt, agentTools, nil)
+func (a *CortexAgent) Run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, nil)
 }
 
-// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call.
-func (a *CortexAgent) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool, emitFn EmitFn) (string, []string, string, error) {
-	return a.run(ctx, systemPrompt, userPrompt, agentTools, emitFn)
+// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call,
+// and streams the LLM output, emitting "text_delta" events as tokens arrive.
+func (a *CortexAgent) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, emitFn)
 }
 
 // Model returns the configured model identifier.
 func (a *CortexAgent) Model() string { return a.model }
 
-func (a *CortexAgent) run(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool, emitFn EmitFn) (string, []string, string, error) {
-	// Build Anthropic tool definitions as ToolUnionUnionParam slice
-	anthToolParams := make([]anthropic.ToolUnionUnionParam, len(agentTools))
-	for i, t := range agentTools {
-		var propsRaw interface{}
-		if props, ok := t.InputSchema["properties"]; ok {
-			propsRaw = props
-		}
+func (a *CortexAgent) run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	res := &RunResult{Model: a.model}
+	start := time.Now()
+	defer func() { res.LatencyMs = time.Since(start).Milliseconds() }()
 
-		schema := map[string]interface{}{
-			"type":       "object",
-			"properties": propsRaw,
-		}
-		if required, ok := t.InputSchema["required"]; ok {
-			schema["required"] = required
-		}
-		anthToolParams[i] = anthropic.ToolParam{
-			Name:        anthropic.String(t.Name),
-			Description: anthropic.String(t.Description),
-			InputSchema: anthropic.F[interface{}](schema),
-		}
-	}
+	anthToolParams := anthropicTools(agentTools, !opts.NoPromptCache)
 
-	messages := []anthropic.MessageParam{
-		anthropic.NewUserMessage(anthropic.NewTextBlock(userPrompt)),
+	messages := make([]anthropic.MessageParam, 0, 2*len(history)+1)
+	for _, turn := range history {
+		messages = append(messages,
+			anthropic.NewUserMessage(anthropic.NewTextBlock(turn.Prompt)),
+			anthropic.NewAssistantMessage(anthropic.NewTextBlock(historyAssistantText(turn))),
+		)
 	}
+	messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(userPrompt)))
 
-	var toolsUsed []string
-	var lastExecutedSQL string // track last SQL sent to execute_bigquery_sql tool
-	maxIter := 10
+	maxIter := opts.maxIterations()
+	forceFinalAt := opts.forceFinalAt()
 
 	for iter := 0; iter < maxIter; iter++ {
 		if emitFn != nil {
 			emitFn("llm_call", map[string]interface{}{"iteration": iter})
 		}
 
-		params := anthropic.MessageNewParams{
-			Model:     anthropic.F(anthropic.Model(a.model)),
-			MaxTokens: anthropic.F(int64(a.maxTokens)),
-			Messages:  anthropic.F(messages),
-			Tools:     anthropic.F(anthToolParams),
-		}
-		if systemPrompt != "" {
-			params.System = anthropic.F([]anthropic.TextBlockParam{
-				anthropic.NewTextBlock(systemPrompt),
-			})
-		}
+		params := a.messageParams(systemPrompt, messages, opts)
+		params.Tools = anthropic.F(anthToolParams)
 
-		resp, err := a.client.Messages.New(ctx, params)
+		callStart := time.Now()
+		resp, err := a.createMessage(ctx, params, emitFn, iter)
 		if err != nil {
-			return "", toolsUsed, lastExecutedSQL, fmt.Errorf("LLM call failed: %w", err)
+			return res, fmt.Errorf("LLM call failed: %w", err)
 		}
 
 		// Collect text and tool calls from response
@@ -149,90 +135,224 @@ func (a *CortexAgent) run(ctx context.Context, systemPrompt, userPrompt string,
 			}()).
 			Int("tool_calls", len(pendingToolCalls)).
 			Msg("agent iteration")
+		res.addIteration(iter, textContent, string(resp.StopReason), anthropicUsage(resp.Usage), time.Since(callStart))
 
 		// max_tokens: LLM was truncated — don't attempt to process partial tool calls.
 		if resp.StopReason == "max_tokens" {
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.Text = textContent
+			return res, nil
 		}
 
 		// No pending tool calls → we're done regardless of stop_reason.
 		// This handles end_turn, stop, stop_sequence from all providers.
 		if len(pendingToolCalls) == 0 {
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.Text = textContent
+			return res, nil
 		}
 		// Has pending tool calls → process them even if stop_reason is "stop".
 		// GLM quirk: returns stop_reason "stop" with tool_use blocks present.
 
-		// Force final answer after 7 iterations to avoid runaway loops
-		if iter >= 7 {
+		// Force final answer after forceFinalAt iterations to avoid runaway loops
+		if forceFinalAt >= 0 && iter >= forceFinalAt {
 			messages = append(messages, resp.ToParam())
 			messages = append(messages, anthropic.NewUserMessage(
 				anthropic.NewTextBlock("You have enough data. Please provide your final answer now without calling any more tools."),
 			))
-			params := anthropic.MessageNewParams{
-				Model:     anthropic.F(anthropic.Model(a.model)),
-				MaxTokens: anthropic.F(int64(a.maxTokens)),
-				Messages:  anthropic.F(messages),
-			}
-			if systemPrompt != "" {
-				params.System = anthropic.F([]anthropic.TextBlockParam{anthropic.NewTextBlock(systemPrompt)})
+			finalParams := a.messageParams(systemPrompt, messages, opts)
+			if !opts.NoPromptCache {
+				// Resending the tools, with tool use disabled, keeps the
+				// cached tools + system prefix valid for this last call.
+				finalParams.Tools = anthropic.F(anthToolParams)
+				finalParams.ToolChoice = anthropic.F[anthropic.ToolChoiceUnionParam](anthropic.ToolChoiceNoneParam{
+					Type: anthropic.F(anthropic.ToolChoiceNoneTypeNone),
+				})
 			}
-			finalResp, err := a.client.Messages.New(ctx, params)
+			callStart := time.Now()
+			finalResp, err := a.createMessage(ctx, finalParams, emitFn, iter+1)
 			if err != nil {
-				return textContent, toolsUsed, lastExecutedSQL, fmt.Errorf("final answer call failed: %w", err)
+				res.Text = textContent
+				return res, fmt.Errorf("final answer call failed: %w", err)
 			}
+			var finalText string
 			for _, block := range finalResp.Content {
 				if b, ok := block.AsUnion().(anthropic.TextBlock); ok {
-					textContent += b.Text
+					finalText += b.Text
 				}
 			}
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.addIteration(iter+1, finalText, string(finalResp.StopReason), anthropicUsage(finalResp.Usage), time.Since(callStart))
+			res.Text = textContent + finalText
+			return res, nil
 		}
 
 		// Add assistant message using ToParam() helper
 		messages = append(messages, resp.ToParam())
 
-		// Execute tools and build tool results
-		var toolResults []anthropic.ContentBlockParamUnion
-		for _, tc := range pendingToolCalls {
-			toolsUsed = append(toolsUsed, tc.Name)
-			// Track the last SQL executed so bigquery_handler can use it as fallback
-			if tc.Name == "execute_bigquery_sql" {
-				if sql, ok := tc.Input["sql"].(string); ok && sql != "" {
-					lastExecutedSQL = sql
-				}
-			}
-			if emitFn != nil {
-				evData := map[string]interface{}{"tool": tc.Name, "iteration": iter}
-				if tc.Name == "execute_bigquery_sql" {
-					if sql, ok := tc.Input["sql"].(string); ok && len(sql) > 0 {
-						if len(sql) > 120 {
-							evData["sql_preview"] = sql[:120] + "..."
-						} else {
-							evData["sql_preview"] = sql
-						}
-					}
-				}
-				emitFn("tool_call", evData)
-			}
-			result, execErr := executeTool(ctx, tc, agentTools)
-			if execErr != nil {
-				log.Warn().Err(execErr).Str("tool", tc.Name).Msg("tool execution error")
-				result = fmt.Sprintf("error: %v", execErr)
+		// Execute tools concurrently and build tool results in call order
+		if emitFn != nil {
+			for _, tc := range pendingToolCalls {
+				emitFn("tool_call", toolCallEventData(tc.Name, tc.Input, iter))
 			}
-			toolResults = append(toolResults, anthropic.NewToolResultBlock(tc.ID, result, execErr != nil))
+		}
+		outcomes := executeToolCalls(ctx, pendingToolCalls, agentTools, opts)
+		toolResults := make([]anthropic.ContentBlockParamUnion, len(pendingToolCalls))
+		for i, tc := range pendingToolCalls {
+			out := outcomes[i]
+			res.addToolCall(tc, out.output, out.isErr, out.latency)
+			toolResults[i] = anthropic.NewToolResultBlock(tc.ID, out.output, out.isErr)
 		}
 		messages = append(messages, anthropic.NewUserMessage(toolResults...))
 	}
 
-	return "", toolsUsed, lastExecutedSQL, fmt.Errorf("agent loop exceeded max iterations (%d)", maxIter)
+	return res, fmt.Errorf("agent loop exceeded max iterations (%d)", maxIter)
 }
 
-func executeTool(ctx context.Context, tc ToolCall, agentTools []tools.Tool) (string, error) {
-	for _, t := range agentTools {
-		if t.Name == tc.Name {
-			return t.Execute(ctx, tc.Input)
+// anthropicTools converts agentTools to Anthropic tool definitions. With
+// cache set, the last definition carries a cache_control breakpoint, so the
+// tools are cached even when the system prompt that follows them changes.
+func anthropicTools(agentTools []tools.Tool, cache bool) []anthropic.ToolUnionUnionParam {
+	params := make([]anthropic.ToolUnionUnionParam, len(agentTools))
+	for i, t := range agentTools {
+		var propsRaw interface{}
+		if props, ok := t.InputSchema["properties"]; ok {
+			propsRaw = props
+		}
+
+		schema := map[string]interface{}{
+			"type":       "object",
+			"properties": propsRaw,
+		}
+		if required, ok := t.InputSchema["required"]; ok {
+			schema["required"] = required
 		}
+		tp := anthropic.ToolParam{
+			Name:        anthropic.String(t.Name),
+			Description: anthropic.String(t.Description),
+			InputSchema: anthropic.F[interface{}](schema),
+		}
+		if cache && i == len(agentTools)-1 {
+			tp.CacheControl = anthropic.F(ephemeralCache)
+		}
+		params[i] = tp
+	}
+	return params
+}
+
+// ephemeralCache marks a prompt cache breakpoint: the request prefix up to
+// and including the marked block is cached for reuse by later calls.
+var ephemeralCache = anthropic.CacheControlEphemeralParam{
+	Type: anthropic.F(anthropic.CacheControlEphemeralTypeEphemeral),
+}
+
+// messageParams builds a Messages API request without tools. Unless
+// opts.NoPromptCache is set, the system prompt (persona prompt and schema
+// section) ends with a cache breakpoint: every iteration of the loop resends
+// it unchanged, so only the first call of a request pays for it in full.
+func (a *CortexAgent) messageParams(systemPrompt string, messages []anthropic.MessageParam, opts RunOptions) anthropic.MessageNewParams {
+	params := anthropic.MessageNewParams{
+		Model:     anthropic.F(anthropic.Model(a.model)),
+		MaxTokens: anthropic.F(int64(opts.maxTokens())),
+		Messages:  anthropic.F(messages),
+	}
+	if systemPrompt != "" {
+		block := anthropic.NewTextBlock(systemPrompt)
+		if !opts.NoPromptCache {
+			block.CacheControl = anthropic.F(ephemeralCache)
+		}
+		params.System = anthropic.F([]anthropic.TextBlockParam{block})
+	}
+	if opts.Temperature != nil {
+		params.Temperature = anthropic.F(*opts.Temperature)
+	}
+	if len(opts.StopSequences) > 0 {
+		params.StopSequences = anthropic.F(opts.StopSequences)
+	}
+	return params
+}
+
+// anthropicUsage converts the token usage of a Messages API response.
+func anthropicUsage(u anthropic.Usage) models.TokenUsage {
+	return models.TokenUsage{
+		InputTokens:      u.InputTokens,
+		OutputTokens:     u.OutputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
+}
+
+// createMessage sends one Messages API request, retried per a.retry. Without
+// emitFn it uses the non-streaming endpoint; with emitFn it streams the
+// response, emitting a "text_delta" event per text chunk as it arrives and
+// assembling the message, including tool-call arguments, from the stream
+// events.
+func (a *CortexAgent) createMessage(ctx context.Context, params anthropic.MessageNewParams, emitFn EmitFn, iter int) (*anthropic.Message, error) {
+	return retryCall(ctx, a.retry, func() (*anthropic.Message, error) {
+		if emitFn == nil {
+			return a.client.Messages.New(ctx, params)
+		}
+		return a.streamMessage(ctx, params, emitFn, iter)
+	})
+}
+
+// streamMessage is one streamed Messages API request. A stream that fails
+// after text was emitted is not retried, so clients never see text twice.
+func (a *CortexAgent) streamMessage(ctx context.Context, params anthropic.MessageNewParams, emitFn EmitFn, iter int) (*anthropic.Message, error) {
+	stream := a.client.Messages.NewStreaming(ctx, params)
+	defer stream.Close()
+
+	msg := &anthropic.Message{}
+	emitted := false
+	for stream.Next() {
+		event := stream.Current()
+		if err := msg.Accumulate(event); err != nil {
+			return nil, fmt.Errorf("stream: %w", err)
+		}
+		if ev, ok := event.AsUnion().(anthropic.ContentBlockDeltaEvent); ok {
+			if d, ok := ev.Delta.AsUnion().(anthropic.TextDelta); ok && d.Text != "" {
+				emitFn("text_delta", map[string]interface{}{"text": d.Text, "iteration": iter})
+				emitted = true
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		if emitted {
+			return nil, &noRetryError{err}
+		}
+		return nil, err
+	}
+	return msg, nil
+}
+
+// toolCallEventData builds the payload of a "tool_call" stream event.
+// SQL tools carry a truncated sql_preview (and the database, for federated
+// execute_postgres_sql); elasticsearch_search carries the
+// target index and a truncated JSON query_preview of its Query DSL.
+func toolCallEventData(name string, input map[string]interface{}, iter int) map[string]interface{} {
+	evData := map[string]interface{}{"tool": name, "iteration": iter}
+	switch name {
+	case "execute_bigquery_sql", "execute_postgres_sql":
+		if db, ok := input["database"].(string); ok && db != "" {
+			evData["database"] = db
+		}
+		if sql, ok := input["sql"].(string); ok && len(sql) > 0 {
+			evData["sql_preview"] = truncatePreview(sql, 120)
+		}
+	case "elasticsearch_search":
+		if index, ok := input["index"].(string); ok && index != "" {
+			evData["index"] = index
+		}
+		if q, ok := input["query"].(map[string]interface{}); ok && len(q) > 0 {
+			if b, err := json.Marshal(q); err == nil {
+				evData["query_preview"] = truncatePreview(string(b), 200)
+			}
+		}
+	}
+	return evData
+}
+
+// truncatePreview shortens s to at most n bytes, appending "..." when cut.
+func truncatePreview(s string, n int) string {
+	if len(s) > n {
+		return s[:n] + "..."
 	}
-	return "", fmt.Errorf("unknown tool: %s", tc.Name)
+	return s
 }
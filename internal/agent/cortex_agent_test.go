@@ -0,0 +1,181 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// ── toolCallEventData ────────────────────────────────────────────────────────
+
+func TestToolCallEventData_BigQuerySQLPreview(t *testing.T) {
+	sql := "SELECT " + strings.Repeat("col, ", 40) + "id FROM ds.t"
+	ev := toolCallEventData("execute_bigquery_sql", map[string]interface{}{"sql": sql}, 2)
+
+	if ev["tool"] != "execute_bigquery_sql" || ev["iteration"] != 2 {
+		t.Fatalf("unexpected base fields: %v", ev)
+	}
+	preview, _ := ev["sql_preview"].(string)
+	if len(preview) != 123 || !strings.HasSuffix(preview, "...") {
+		t.Errorf("sql_preview should be truncated to 120 chars + '...', got %d chars: %q", len(preview), preview)
+	}
+}
+
+func TestToolCallEventData_ElasticsearchQueryPreview(t *testing.T) {
+	input := map[string]interface{}{
+		"index": "payment-k8s-prd-*",
+		"query": map[string]interface{}{
+			"match": map[string]interface{}{"message": "timeout"},
+		},
+	}
+	ev := toolCallEventData("elasticsearch_search", input, 0)
+
+	if ev["index"] != "payment-k8s-prd-*" {
+		t.Errorf("index: got %v", ev["index"])
+	}
+	want := `{"match":{"message":"timeout"}}`
+	if ev["query_preview"] != want {
+		t.Errorf("query_preview: got %v, want %s", ev["query_preview"], want)
+	}
+	if _, ok := ev["sql_preview"]; ok {
+		t.Error("elasticsearch_search must not carry sql_preview")
+	}
+}
+
+func TestToolCallEventData_ElasticsearchNoQuery(t *testing.T) {
+	ev := toolCallEventData("elasticsearch_search", map[string]interface{}{"index": "logs-*"}, 1)
+	if _, ok := ev["query_preview"]; ok {
+		t.Error("query_preview should be omitted when the tool call has no query")
+	}
+}
+
+func TestToolCallEventData_OtherToolsNoPreview(t *testing.T) {
+	ev := toolCallEventData("list_elasticsearch_indices", map[string]interface{}{}, 0)
+	if len(ev) != 2 {
+		t.Errorf("expected only tool+iteration fields, got %v", ev)
+	}
+}
+
+// anthropicSSE writes Anthropic Messages stream events.
+func anthropicSSE(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, ev := range events {
+		typ := ev[strings.Index(ev, `"type":"`)+8:]
+		typ = typ[:strings.Index(typ, `"`)]
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, ev)
+	}
+}
+
+const anthropicMessageStart = `{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"m","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":1}}}`
+
+func TestCortexAgent_RunWithEmitStreamsTextAndAssemblesToolCalls(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { calls++ }()
+		if calls == 0 {
+			anthropicSSE(w,
+				anthropicMessageStart,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"tu_1","name":"execute_bigquery_sql","input":{}}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"sql\": \"SELECT "}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"1\"}"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"tool_use","stop_sequence":null},"usage":{"output_tokens":5}}`,
+				`{"type":"message_stop"}`,
+			)
+			return
+		}
+		anthropicSSE(w,
+			anthropicMessageStart,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Total "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"is 1."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":4}}`,
+			`{"type":"message_stop"}`,
+		)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var inputs []map[string]interface{}
+	var events, deltas []string
+	a := NewCortexAgent("key", "m", srv.URL)
+	res, err := a.RunWithEmit(context.Background(), "sys", "how many?", nil,
+		[]tools.Tool{recordingTool(&mu, &inputs)}, RunOptions{}, collectEvents(&mu, &events, &deltas))
+	if err != nil {
+		t.Fatalf("RunWithEmit: %v", err)
+	}
+	if res.Text != "Total is 1." {
+		t.Errorf("output = %q", res.Text)
+	}
+	if strings.Join(deltas, "|") != "Total |is 1." {
+		t.Errorf("text_delta chunks = %q", deltas)
+	}
+	if len(inputs) != 1 || inputs[0]["sql"] != "SELECT 1" || res.LastSQL != "SELECT 1" {
+		t.Errorf("tool inputs = %v, lastSQL = %q; want arguments assembled from fragments", inputs, res.LastSQL)
+	}
+	if used := res.ToolsUsed; len(used) != 1 {
+		t.Errorf("toolsUsed = %v", used)
+	}
+	if events[0] != "llm_call" {
+		t.Errorf("first event = %q, want llm_call", events[0])
+	}
+
+	if len(res.Iterations) != 2 {
+		t.Fatalf("iterations = %d, want 2", len(res.Iterations))
+	}
+	first := res.Iterations[0]
+	if first.StopReason != "tool_use" || len(first.ToolCalls) != 1 || first.ToolCalls[0].Output != `{"row_count":1}` {
+		t.Errorf("first iteration = %+v", first)
+	}
+	if res.StopReason != "end_turn" || res.Iterations[1].Text != "Total is 1." {
+		t.Errorf("last iteration = %+v", res.Iterations[1])
+	}
+	if want := (models.TokenUsage{InputTokens: 20, OutputTokens: 9}); res.Usage != want {
+		t.Errorf("usage = %+v, want %+v", res.Usage, want)
+	}
+}
+
+func TestCortexAgent_MessageParamsApplyRunOptions(t *testing.T) {
+	a := NewCortexAgent("key", "m", "")
+	if p := a.messageParams("", nil, RunOptions{}); p.MaxTokens.Value != DefaultMaxTokens || p.Temperature.Present || p.StopSequences.Present {
+		t.Errorf("zero options must use defaults: %+v", p)
+	}
+	temp := 0.2
+	p := a.messageParams("sys", nil, RunOptions{MaxTokens: 2048, Temperature: &temp, StopSequences: []string{"STOP"}})
+	if p.MaxTokens.Value != 2048 || p.Temperature.Value != 0.2 || len(p.StopSequences.Value) != 1 || !p.System.Present {
+		t.Errorf("params = %+v", p)
+	}
+}
+
+func TestCortexAgent_PromptCacheBreakpoints(t *testing.T) {
+	a := NewCortexAgent("key", "m", "")
+	p := a.messageParams("persona + schema", nil, RunOptions{})
+	if sys := p.System.Value; len(sys) != 1 || !sys[0].CacheControl.Present {
+		t.Errorf("system prompt must end with a cache breakpoint: %+v", sys)
+	}
+	p = a.messageParams("persona + schema", nil, RunOptions{NoPromptCache: true})
+	if p.System.Value[0].CacheControl.Present {
+		t.Error("NoPromptCache must not set cache breakpoints")
+	}
+
+	defs := anthropicTools(makeTools("list_bigquery_tables", "execute_bigquery_sql"), true)
+	first, _ := defs[0].(anthropic.ToolParam)
+	last, _ := defs[1].(anthropic.ToolParam)
+	if first.CacheControl.Present || !last.CacheControl.Present {
+		t.Errorf("only the last tool carries the breakpoint: first=%v last=%v", first.CacheControl.Present, last.CacheControl.Present)
+	}
+	for _, d := range anthropicTools(makeTools("execute_bigquery_sql"), false) {
+		if d.(anthropic.ToolParam).CacheControl.Present {
+			t.Error("tools without caching must not carry a breakpoint")
+		}
+	}
+}
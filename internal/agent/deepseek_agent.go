@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -10,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/tools"
 	"github.com/rs/zerolog/log"
 )
@@ -20,8 +22,10 @@ type DeepSeekAgent struct {
 	apiKey     string
 	model      string
 	baseURL    string
-	maxTokens  int
 	httpClient *http.Client
+	retry      RetryPolicy
+	chatURL    string              // chat completions endpoint
+	authorize  func(*http.Request) // sets the request's credentials
 }
 
 // NewDeepSeekAgent creates a DeepSeekAgent.
@@ -33,26 +37,39 @@ func NewDeepSeekAgent(apiKey, model, baseURL string) *DeepSeekAgent {
 	if baseURL == "" {
 		baseURL = "https://api.deepseek.com/v1"
 	}
-	return &DeepSeekAgent{
+	a := &DeepSeekAgent{
 		apiKey:     apiKey,
 		model:      model,
 		baseURL:    strings.TrimRight(baseURL, "/"),
-		maxTokens:  4096,
 		httpClient: &http.Client{Timeout: 120 * time.Second},
+		retry:      DefaultRetryPolicy,
 	}
+	a.chatURL = a.baseURL + "/chat/completions"
+	a.authorize = func(req *http.Request) {
+		if a.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+a.apiKey)
+		}
+	}
+	return a
+}
+
+// SetRetryPolicy sets how failed chat completion calls are retried.
+func (a *DeepSeekAgent) SetRetryPolicy(p RetryPolicy) {
+	a.retry = p
 }
 
 // Model returns the configured model identifier.
 func (a *DeepSeekAgent) Model() string { return a.model }
 
 // Run executes the agent loop (no streaming events).
-func (a *DeepSeekAgent) Run(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool) (string, []string, string, error) {
-	return a.run(ctx, systemPrompt, userPrompt, agentTools, nil)
+func (a *DeepSeekAgent) Run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, nil)
 }
 
-// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call.
-func (a *DeepSeekAgent) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool, emitFn EmitFn) (string, []string, string, error) {
-	return a.run(ctx, systemPrompt, userPrompt, agentTools, emitFn)
+// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call,
+// and streams the completion, emitting "text_delta" events as tokens arrive.
+func (a *DeepSeekAgent) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, emitFn)
 }
 
 // ── OpenAI wire types ────────────────────────────────────────────────────────
@@ -87,17 +104,57 @@ type dsFunctionDef struct {
 }
 
 type dsChatRequest struct {
-	Model     string      `json:"model"`
-	Messages  []dsMessage `json:"messages"`
-	Tools     []dsTool    `json:"tools,omitempty"`
-	MaxTokens int         `json:"max_tokens"`
+	Model       string      `json:"model"`
+	Messages    []dsMessage `json:"messages"`
+	Tools       []dsTool    `json:"tools,omitempty"`
+	ToolChoice  string      `json:"tool_choice,omitempty"` // "none" disables tool use
+	MaxTokens   int         `json:"max_tokens"`
+	Temperature *float64    `json:"temperature,omitempty"`
+	Stop        []string    `json:"stop,omitempty"`
+	Stream      bool        `json:"stream,omitempty"`
+	// StreamOptions asks for a final usage chunk when streaming.
+	StreamOptions *dsStreamOptions `json:"stream_options,omitempty"`
+}
+
+type dsStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type dsChatResponse struct {
 	Choices []dsChoice `json:"choices"`
+	Usage   *dsUsage   `json:"usage,omitempty"`
 	Error   *dsError   `json:"error,omitempty"`
 }
 
+// dsUsage is the usage block of a chat completion. Prompt tokens served from
+// the provider's context cache are reported as prompt_cache_hit_tokens by
+// DeepSeek and as prompt_tokens_details.cached_tokens by OpenAI; both are
+// included in prompt_tokens.
+type dsUsage struct {
+	PromptTokens         int64 `json:"prompt_tokens"`
+	CompletionTokens     int64 `json:"completion_tokens"`
+	PromptCacheHitTokens int64 `json:"prompt_cache_hit_tokens,omitempty"`
+	PromptTokensDetails  *struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details,omitempty"`
+}
+
+// tokenUsage converts u; a nil u counts no tokens.
+func (u *dsUsage) tokenUsage() models.TokenUsage {
+	if u == nil {
+		return models.TokenUsage{}
+	}
+	cached := u.PromptCacheHitTokens
+	if cached == 0 && u.PromptTokensDetails != nil {
+		cached = u.PromptTokensDetails.CachedTokens
+	}
+	return models.TokenUsage{
+		InputTokens:     u.PromptTokens - cached,
+		OutputTokens:    u.CompletionTokens,
+		CacheReadTokens: cached,
+	}
+}
+
 type dsChoice struct {
 	Message      dsMessage `json:"message"`
 	FinishReason string    `json:"finish_reason"`
@@ -108,6 +165,32 @@ type dsError struct {
 	Type    string `json:"type"`
 }
 
+// dsStreamChunk is one "data:" event of a streamed chat completion. Tool
+// calls arrive as fragments keyed by index: the first carries the ID and
+// function name, later ones pieces of the JSON arguments.
+type dsStreamChunk struct {
+	Choices []dsStreamChoice `json:"choices"`
+	Usage   *dsUsage         `json:"usage,omitempty"` // final chunk only
+	Error   *dsError         `json:"error,omitempty"`
+}
+
+type dsStreamChoice struct {
+	Delta        dsStreamDelta `json:"delta"`
+	FinishReason string        `json:"finish_reason"`
+}
+
+type dsStreamDelta struct {
+	Content   string            `json:"content"`
+	ToolCalls []dsToolCallDelta `json:"tool_calls"`
+}
+
+type dsToolCallDelta struct {
+	Index    int            `json:"index"`
+	ID       string         `json:"id"`
+	Type     string         `json:"type"`
+	Function dsFunctionCall `json:"function"`
+}
+
 // ── Helper functions (also used by tests) ───────────────────────────────────
 
 // buildInitialMessages creates the initial message list from system/user prompts.
@@ -120,6 +203,26 @@ func buildInitialMessages(systemPrompt, userPrompt string) []dsMessage {
 	return messages
 }
 
+// buildConversationMessages is like buildInitialMessages but replays prior
+// conversation turns as user/assistant pairs between the system prompt and
+// the new user prompt.
+func buildConversationMessages(systemPrompt, userPrompt string, history []models.ConversationTurn) []dsMessage {
+	if len(history) == 0 {
+		return buildInitialMessages(systemPrompt, userPrompt)
+	}
+	messages := make([]dsMessage, 0, 2*len(history)+2)
+	if systemPrompt != "" {
+		messages = append(messages, dsMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, turn := range history {
+		messages = append(messages,
+			dsMessage{Role: "user", Content: turn.Prompt},
+			dsMessage{Role: "assistant", Content: historyAssistantText(turn)},
+		)
+	}
+	return append(messages, dsMessage{Role: "user", Content: userPrompt})
+}
+
 // convertToOpenAITools converts tools.Tool slice to DeepSeek/OpenAI tool format.
 func convertToOpenAITools(agentTools []tools.Tool) []dsTool {
 	dsTools := make([]dsTool, len(agentTools))
@@ -147,25 +250,29 @@ func convertToOpenAITools(agentTools []tools.Tool) []dsTool {
 
 // ── Core agent loop ──────────────────────────────────────────────────────────
 
-func (a *DeepSeekAgent) run(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool, emitFn EmitFn) (string, []string, string, error) {
+func (a *DeepSeekAgent) run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	res := &RunResult{Model: a.model}
+	start := time.Now()
+	defer func() { res.LatencyMs = time.Since(start).Milliseconds() }()
+
 	dsTools := convertToOpenAITools(agentTools)
-	messages := buildInitialMessages(systemPrompt, userPrompt)
+	messages := buildConversationMessages(systemPrompt, userPrompt, history)
 
-	var toolsUsed []string
-	var lastExecutedSQL string
-	maxIter := 10
+	maxIter := opts.maxIterations()
+	forceFinalAt := opts.forceFinalAt()
 
 	for iter := 0; iter < maxIter; iter++ {
 		if emitFn != nil {
 			emitFn("llm_call", map[string]interface{}{"iteration": iter})
 		}
 
-		resp, err := a.callAPI(ctx, messages, dsTools)
+		callStart := time.Now()
+		resp, err := a.chat(ctx, messages, dsTools, "", opts, emitFn, iter)
 		if err != nil {
-			return "", toolsUsed, lastExecutedSQL, fmt.Errorf("LLM call failed: %w", err)
+			return res, fmt.Errorf("LLM call failed: %w", err)
 		}
 		if len(resp.Choices) == 0 {
-			return "", toolsUsed, lastExecutedSQL, fmt.Errorf("LLM returned no choices")
+			return res, fmt.Errorf("LLM returned no choices")
 		}
 
 		choice := resp.Choices[0]
@@ -183,41 +290,55 @@ func (a *DeepSeekAgent) run(ctx context.Context, systemPrompt, userPrompt string
 			}()).
 			Int("tool_calls", len(msg.ToolCalls)).
 			Msg("deepseek iteration")
+		res.addIteration(iter, textContent, choice.FinishReason, resp.Usage.tokenUsage(), time.Since(callStart))
 
 		// "length" finish_reason: model was truncated — return immediately
 		if choice.FinishReason == "length" {
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.Text = textContent
+			return res, nil
 		}
 
 		// No tool calls → done (handles "stop" and any other finish_reason)
 		if len(msg.ToolCalls) == 0 {
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.Text = textContent
+			return res, nil
 		}
 
-		// Force final answer after 7 iterations to avoid runaway loops
-		if iter >= 7 {
+		// Force final answer after forceFinalAt iterations to avoid runaway loops
+		if forceFinalAt >= 0 && iter >= forceFinalAt {
 			messages = append(messages, msg)
 			messages = append(messages, dsMessage{
 				Role:    "user",
 				Content: "You have enough data. Please provide your final answer now without calling any more tools.",
 			})
-			finalResp, finalErr := a.callAPI(ctx, messages, nil)
+			// Providers cache the longest previously seen request prefix, and
+			// the tool definitions precede the messages: resending them with
+			// tool use disabled keeps the whole conversation cached.
+			finalTools, toolChoice := []dsTool(nil), ""
+			if !opts.NoPromptCache && len(dsTools) > 0 {
+				finalTools, toolChoice = dsTools, "none"
+			}
+			callStart := time.Now()
+			finalResp, finalErr := a.chat(ctx, messages, finalTools, toolChoice, opts, emitFn, iter+1)
 			if finalErr != nil {
-				return textContent, toolsUsed, lastExecutedSQL, fmt.Errorf("final answer call failed: %w", finalErr)
+				res.Text = textContent
+				return res, fmt.Errorf("final answer call failed: %w", finalErr)
 			}
+			var finalText, finishReason string
 			if len(finalResp.Choices) > 0 {
-				textContent += finalResp.Choices[0].Message.Content
+				finalText, finishReason = finalResp.Choices[0].Message.Content, finalResp.Choices[0].FinishReason
 			}
-			return textContent, toolsUsed, lastExecutedSQL, nil
+			res.addIteration(iter+1, finalText, finishReason, finalResp.Usage.tokenUsage(), time.Since(callStart))
+			res.Text = textContent + finalText
+			return res, nil
 		}
 
 		// Append assistant message and execute tools
 		messages = append(messages, msg)
 
-		var toolResultMessages []dsMessage
-		for _, tc := range msg.ToolCalls {
+		calls := make([]ToolCall, len(msg.ToolCalls))
+		for i, tc := range msg.ToolCalls {
 			name := tc.Function.Name
-			toolsUsed = append(toolsUsed, name)
 
 			var input map[string]interface{}
 			if err := json.Unmarshal([]byte(tc.Function.Arguments), &input); err != nil {
@@ -225,52 +346,66 @@ func (a *DeepSeekAgent) run(ctx context.Context, systemPrompt, userPrompt string
 				input = map[string]interface{}{}
 			}
 
-			// Track last executed SQL for fallback
-			if name == "execute_bigquery_sql" {
-				if sql, ok := input["sql"].(string); ok && sql != "" {
-					lastExecutedSQL = sql
-				}
-			}
-
 			if emitFn != nil {
-				evData := map[string]interface{}{"tool": name, "iteration": iter}
-				if name == "execute_bigquery_sql" {
-					if sql, ok := input["sql"].(string); ok && len(sql) > 0 {
-						preview := sql
-						if len(preview) > 120 {
-							preview = preview[:120] + "..."
-						}
-						evData["sql_preview"] = preview
-					}
-				}
-				emitFn("tool_call", evData)
+				emitFn("tool_call", toolCallEventData(name, input, iter))
 			}
+			calls[i] = ToolCall{ID: tc.ID, Name: name, Input: input}
+		}
 
-			result, execErr := executeTool(ctx, ToolCall{ID: tc.ID, Name: name, Input: input}, agentTools)
-			if execErr != nil {
-				log.Warn().Err(execErr).Str("tool", name).Msg("tool execution error")
-				result = fmt.Sprintf("error: %v", execErr)
-			}
-			toolResultMessages = append(toolResultMessages, dsMessage{
+		// Execute tools concurrently; results keep the order of the calls
+		outcomes := executeToolCalls(ctx, calls, agentTools, opts)
+		for i, call := range calls {
+			out := outcomes[i]
+			res.addToolCall(call, out.output, out.isErr, out.latency)
+			messages = append(messages, dsMessage{
 				Role:       "tool",
-				ToolCallID: tc.ID,
-				Content:    result,
+				ToolCallID: call.ID,
+				Content:    out.output,
 			})
 		}
-		messages = append(messages, toolResultMessages...)
 	}
 
-	return "", toolsUsed, lastExecutedSQL, fmt.Errorf("agent loop exceeded max iterations (%d)", maxIter)
+	return res, fmt.Errorf("agent loop exceeded max iterations (%d)", maxIter)
 }
 
-func (a *DeepSeekAgent) callAPI(ctx context.Context, messages []dsMessage, dsTools []dsTool) (*dsChatResponse, error) {
+// chat sends one chat completion request, retried per a.retry. Without
+// emitFn it uses a plain request; with emitFn it streams the completion,
+// emitting a "text_delta" event per content chunk. A stream that fails after
+// text was emitted is not retried, so clients never see text twice.
+func (a *DeepSeekAgent) chat(ctx context.Context, messages []dsMessage, dsTools []dsTool, toolChoice string, opts RunOptions, emitFn EmitFn, iter int) (*dsChatResponse, error) {
+	return retryCall(ctx, a.retry, func() (*dsChatResponse, error) {
+		if emitFn == nil {
+			return a.callAPI(ctx, messages, dsTools, toolChoice, opts)
+		}
+		emitted := false
+		resp, err := a.callAPIStream(ctx, messages, dsTools, toolChoice, opts, func(text string) {
+			emitFn("text_delta", map[string]interface{}{"text": text, "iteration": iter})
+			emitted = true
+		})
+		if err != nil && emitted {
+			return nil, &noRetryError{err}
+		}
+		return resp, err
+	})
+}
+
+// post sends a chat completion request and returns the response once its
+// status is 200 OK.
+func (a *DeepSeekAgent) post(ctx context.Context, messages []dsMessage, dsTools []dsTool, toolChoice string, opts RunOptions, stream bool) (*http.Response, error) {
 	reqBody := dsChatRequest{
-		Model:     a.model,
-		Messages:  messages,
-		MaxTokens: a.maxTokens,
+		Model:       a.model,
+		Messages:    messages,
+		MaxTokens:   opts.maxTokens(),
+		Temperature: opts.Temperature,
+		Stop:        opts.StopSequences,
+		Stream:      stream,
+	}
+	if stream {
+		reqBody.StreamOptions = &dsStreamOptions{IncludeUsage: true}
 	}
 	if len(dsTools) > 0 {
 		reqBody.Tools = dsTools
+		reqBody.ToolChoice = toolChoice
 	}
 
 	body, err := json.Marshal(reqBody)
@@ -278,18 +413,37 @@ func (a *DeepSeekAgent) callAPI(ctx context.Context, messages []dsMessage, dsToo
 		return nil, fmt.Errorf("marshal request: %w", err)
 	}
 
-	url := a.baseURL + "/chat/completions"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.chatURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	a.authorize(req)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
 
-	httpResp, err := a.httpClient.Do(req)
+	client := a.httpClient
+	if stream {
+		client = streamingClient(client)
+	}
+	httpResp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("http request: %w", err)
 	}
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		respBytes, _ := io.ReadAll(httpResp.Body)
+		return nil, &APIError{StatusCode: httpResp.StatusCode, Body: string(respBytes)}
+	}
+	return httpResp, nil
+}
+
+func (a *DeepSeekAgent) callAPI(ctx context.Context, messages []dsMessage, dsTools []dsTool, toolChoice string, opts RunOptions) (*dsChatResponse, error) {
+	httpResp, err := a.post(ctx, messages, dsTools, toolChoice, opts, false)
+	if err != nil {
+		return nil, err
+	}
 	defer httpResp.Body.Close()
 
 	respBytes, err := io.ReadAll(httpResp.Body)
@@ -297,10 +451,6 @@ func (a *DeepSeekAgent) callAPI(ctx context.Context, messages []dsMessage, dsToo
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
-	if httpResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API error %d: %s", httpResp.StatusCode, string(respBytes))
-	}
-
 	var resp dsChatResponse
 	if err := json.Unmarshal(respBytes, &resp); err != nil {
 		return nil, fmt.Errorf("unmarshal response: %w", err)
@@ -311,3 +461,83 @@ func (a *DeepSeekAgent) callAPI(ctx context.Context, messages []dsMessage, dsToo
 
 	return &resp, nil
 }
+
+// callAPIStream requests a streamed completion and assembles the chunks into
+// a dsChatResponse: content is concatenated (and passed to onText as it
+// arrives) and tool-call fragments are merged by index. A stream that ends
+// without [DONE] or a finish_reason fails with errStreamTruncated.
+func (a *DeepSeekAgent) callAPIStream(ctx context.Context, messages []dsMessage, dsTools []dsTool, toolChoice string, opts RunOptions, onText func(string)) (*dsChatResponse, error) {
+	httpResp, err := a.post(ctx, messages, dsTools, toolChoice, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var content strings.Builder
+	var toolCalls []dsToolCall
+	var finishReason string
+	var usage *dsUsage
+	done := false
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // blank separators, comments and keep-alives
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+		var chunk dsStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, fmt.Errorf("API error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		choice := chunk.Choices[0]
+		if choice.Delta.Content != "" {
+			content.WriteString(choice.Delta.Content)
+			onText(choice.Delta.Content)
+		}
+		for _, d := range choice.Delta.ToolCalls {
+			for len(toolCalls) <= d.Index {
+				toolCalls = append(toolCalls, dsToolCall{Type: "function"})
+			}
+			tc := &toolCalls[d.Index]
+			if d.ID != "" {
+				tc.ID = d.ID
+			}
+			if d.Type != "" {
+				tc.Type = d.Type
+			}
+			if d.Function.Name != "" {
+				tc.Function.Name = d.Function.Name
+			}
+			tc.Function.Arguments += d.Function.Arguments
+		}
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read stream: %w", err)
+	}
+	if !done && finishReason == "" {
+		return nil, fmt.Errorf("read stream: %w", errStreamTruncated)
+	}
+
+	return &dsChatResponse{Choices: []dsChoice{{
+		Message:      dsMessage{Role: "assistant", Content: content.String(), ToolCalls: toolCalls},
+		FinishReason: finishReason,
+	}}, Usage: usage}, nil
+}
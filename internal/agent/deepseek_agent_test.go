@@ -2,8 +2,18 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/tools"
 )
 
@@ -81,6 +91,41 @@ func TestDeepSeekAgent_BuildMessages_SystemAndUser(t *testing.T) {
 	})
 }
 
+func TestDeepSeekAgent_BuildConversationMessages(t *testing.T) {
+	history := []models.ConversationTurn{
+		{Prompt: "revenue by month", Answer: "Revenue grew 5%", GeneratedSQL: "SELECT month, SUM(x) FROM t GROUP BY month"},
+		{Prompt: "only 2025"},
+	}
+	msgs := buildConversationMessages("sys", "now by region", history)
+
+	wantRoles := []string{"system", "user", "assistant", "user", "assistant", "user"}
+	if len(msgs) != len(wantRoles) {
+		t.Fatalf("expected %d messages, got %d", len(wantRoles), len(msgs))
+	}
+	for i, role := range wantRoles {
+		if msgs[i].Role != role {
+			t.Errorf("msgs[%d].Role = %q, want %q", i, msgs[i].Role, role)
+		}
+	}
+	if msgs[1].Content != "revenue by month" {
+		t.Errorf("msgs[1].Content = %q, want first prompt", msgs[1].Content)
+	}
+	if !strings.Contains(msgs[2].Content, "Revenue grew 5%") || !strings.Contains(msgs[2].Content, "GROUP BY month") {
+		t.Errorf("assistant turn should carry answer and SQL, got %q", msgs[2].Content)
+	}
+	if msgs[4].Content != "(no answer recorded)" {
+		t.Errorf("empty turn should render placeholder, got %q", msgs[4].Content)
+	}
+	if msgs[5].Content != "now by region" {
+		t.Errorf("last message should be the new prompt, got %q", msgs[5].Content)
+	}
+
+	// No history is equivalent to buildInitialMessages
+	if got := buildConversationMessages("sys", "hello", nil); len(got) != 2 {
+		t.Errorf("empty history: expected 2 messages, got %d", len(got))
+	}
+}
+
 func TestDeepSeekAgent_BuildTools(t *testing.T) {
 	agentTools := []tools.Tool{
 		{
@@ -160,3 +205,274 @@ func TestDeepSeekAgent_BuildTools(t *testing.T) {
 		t.Errorf("dsTools[1].Function.Name = %q, want %q", dsTools[1].Function.Name, "list_tables")
 	}
 }
+
+// recordingTool returns an execute_bigquery_sql tool that records its inputs.
+func recordingTool(mu *sync.Mutex, inputs *[]map[string]interface{}) tools.Tool {
+	return tools.Tool{
+		Name:        "execute_bigquery_sql",
+		InputSchema: map[string]interface{}{"type": "object"},
+		Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			*inputs = append(*inputs, input)
+			return `{"row_count":1}`, nil
+		},
+	}
+}
+
+// collectEvents returns an EmitFn that records events and their payloads.
+func collectEvents(mu *sync.Mutex, events *[]string, deltas *[]string) EmitFn {
+	return func(event string, data map[string]interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		*events = append(*events, event)
+		if event == "text_delta" {
+			*deltas = append(*deltas, data["text"].(string))
+		}
+	}
+}
+
+func TestDeepSeekAgent_RunWithEmitStreamsTextAndAssemblesToolCalls(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req dsChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream || req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+			t.Errorf("call %d: expected stream=true with usage", calls)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		var chunks []string
+		if calls == 0 {
+			chunks = []string{
+				`{"choices":[{"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"execute_bigquery_sql","arguments":""}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"sql\": \"SELECT "}}]}}]}`,
+				`{"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			}
+		} else {
+			chunks = []string{
+				`{"choices":[{"delta":{"content":"Total "}}]}`,
+				`{"choices":[{"delta":{"content":"is 1."},"finish_reason":"stop"}]}`,
+				`{"choices":[],"usage":{"prompt_tokens":30,"completion_tokens":4}}`,
+			}
+		}
+		calls++
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var inputs []map[string]interface{}
+	var events, deltas []string
+	a := NewDeepSeekAgent("key", "", srv.URL)
+	res, err := a.RunWithEmit(context.Background(), "sys", "how many?", nil,
+		[]tools.Tool{recordingTool(&mu, &inputs)}, RunOptions{}, collectEvents(&mu, &events, &deltas))
+	if err != nil {
+		t.Fatalf("RunWithEmit: %v", err)
+	}
+	if res.Text != "Total is 1." {
+		t.Errorf("output = %q", res.Text)
+	}
+	if strings.Join(deltas, "|") != "Total |is 1." {
+		t.Errorf("text_delta chunks = %q", deltas)
+	}
+	if len(inputs) != 1 || inputs[0]["sql"] != "SELECT 1" || res.LastSQL != "SELECT 1" {
+		t.Errorf("tool inputs = %v, lastSQL = %q; want arguments assembled from fragments", inputs, res.LastSQL)
+	}
+	if used := res.ToolsUsed; len(used) != 1 || used[0] != "execute_bigquery_sql" {
+		t.Errorf("toolsUsed = %v", used)
+	}
+	if len(res.Iterations) != 2 || len(res.Iterations[0].ToolCalls) != 1 || res.Iterations[0].StopReason != "tool_calls" {
+		t.Fatalf("iterations = %+v", res.Iterations)
+	}
+	if got := res.Iterations[1].Usage; got.InputTokens != 30 || got.OutputTokens != 4 {
+		t.Errorf("usage of final iteration = %+v, want the usage chunk", got)
+	}
+}
+
+func TestDeepSeekAgent_TruncatedStreamFails(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"execute_bigquery_sql","arguments":"{\"sql\": \"SEL"}}]}}]}`+"\n\n")
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var inputs []map[string]interface{}
+	var events, deltas []string
+	a := NewDeepSeekAgent("key", "", srv.URL)
+	a.SetRetryPolicy(RetryPolicy{MaxRetries: 1})
+	_, err := a.RunWithEmit(context.Background(), "sys", "how many?", nil,
+		[]tools.Tool{recordingTool(&mu, &inputs)}, RunOptions{}, collectEvents(&mu, &events, &deltas))
+	if !errors.Is(err, errStreamTruncated) {
+		t.Fatalf("err = %v, want errStreamTruncated", err)
+	}
+	if len(inputs) != 0 {
+		t.Errorf("tool ran with %v from a truncated stream", inputs)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want the truncated stream retried once", calls)
+	}
+}
+
+func TestDeepSeekAgent_StreamOutlivesClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range []string{"Total ", "is ", "1."} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", c)
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	a := NewDeepSeekAgent("key", "", srv.URL)
+	a.httpClient.Timeout = 50 * time.Millisecond
+	var mu sync.Mutex
+	var events, deltas []string
+	res, err := a.RunWithEmit(context.Background(), "", "how many?", nil, nil, RunOptions{}, collectEvents(&mu, &events, &deltas))
+	if err != nil || res.Text != "Total is 1." {
+		t.Errorf("RunWithEmit = %v, %v; the client timeout must not cut the stream", res, err)
+	}
+}
+
+func TestRunResult_TruncatesToolOutput(t *testing.T) {
+	res := &RunResult{}
+	res.addIteration(0, "", "tool_calls", models.TokenUsage{}, 0)
+	long := strings.Repeat("é", maxTraceToolOutput) // 2 bytes per rune
+	res.addToolCall(ToolCall{Name: "execute_postgres_sql", Input: map[string]interface{}{"sql": "SELECT 1"}}, long, false, 0)
+
+	call := res.Iterations[0].ToolCalls[0]
+	if !call.OutputTruncated || len(call.Output) > maxTraceToolOutput || !utf8.ValidString(call.Output) {
+		t.Errorf("output not truncated on a rune boundary: %d bytes, truncated=%v", len(call.Output), call.OutputTruncated)
+	}
+	if res.LastSQL != "SELECT 1" {
+		t.Errorf("LastSQL = %q; execute_postgres_sql must be tracked too", res.LastSQL)
+	}
+}
+
+func TestDeepSeekAgent_RunDoesNotStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req dsChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if req.Stream {
+			t.Error("Run must not request a streamed completion")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	res, err := NewDeepSeekAgent("key", "", srv.URL).Run(context.Background(), "", "hi", nil, nil, RunOptions{})
+	if err != nil || res.Text != "done" {
+		t.Errorf("Run = %q, %v", res.Text, err)
+	}
+}
+
+func TestDSUsage_SeparatesCachedPromptTokens(t *testing.T) {
+	var deepseek, openai dsUsage
+	_ = json.Unmarshal([]byte(`{"prompt_tokens":100,"completion_tokens":10,"prompt_cache_hit_tokens":60}`), &deepseek)
+	_ = json.Unmarshal([]byte(`{"prompt_tokens":100,"completion_tokens":10,"prompt_tokens_details":{"cached_tokens":40}}`), &openai)
+
+	if got := deepseek.tokenUsage(); got.InputTokens != 40 || got.CacheReadTokens != 60 || got.OutputTokens != 10 {
+		t.Errorf("deepseek usage = %+v", got)
+	}
+	if got := openai.tokenUsage(); got.InputTokens != 60 || got.CacheReadTokens != 40 {
+		t.Errorf("openai usage = %+v", got)
+	}
+}
+
+func TestRunOptions_ForceFinalAt(t *testing.T) {
+	tests := []struct {
+		opts RunOptions
+		want int
+	}{
+		{RunOptions{}, 7},
+		{RunOptions{MaxIterations: 4}, 3}, // capped so the last iteration still answers
+		{RunOptions{MaxIterations: 20, ForceFinalAfter: 12}, 12},
+		{RunOptions{ForceFinalAfter: -1}, -1},
+	}
+	for _, tt := range tests {
+		if got := tt.opts.forceFinalAt(); got != tt.want {
+			t.Errorf("%+v.forceFinalAt() = %d, want %d", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestDeepSeekAgent_RunAppliesRunOptions(t *testing.T) {
+	var reqs []dsChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req dsChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reqs = append(reqs, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(req.Tools) == 0 || req.ToolChoice == "none" {
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"final"},"finish_reason":"stop"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","tool_calls":[{"id":"c","type":"function","function":{"name":"execute_bigquery_sql","arguments":"{\"sql\":\"SELECT 1\"}"}}]},"finish_reason":"tool_calls"}]}`)
+	}))
+	defer srv.Close()
+
+	temp := 0.1
+	opts := RunOptions{MaxTokens: 1024, MaxIterations: 2, Temperature: &temp, StopSequences: []string{"</answer>"}}
+	var mu sync.Mutex
+	var inputs []map[string]interface{}
+	res, err := NewDeepSeekAgent("key", "", srv.URL).Run(context.Background(), "", "q", nil,
+		[]tools.Tool{recordingTool(&mu, &inputs)}, opts)
+	if err != nil || res.Text != "final" {
+		t.Fatalf("Run = %q, %v", res.Text, err)
+	}
+	// Iteration 0 calls a tool, iteration 1 is forced to answer without tools.
+	if len(reqs) != 3 || reqs[2].ToolChoice != "none" {
+		t.Fatalf("got %d requests; want 2 tool-calling iterations and a forced final answer", len(reqs))
+	}
+	r := reqs[0]
+	if r.MaxTokens != 1024 || r.Temperature == nil || *r.Temperature != 0.1 || len(r.Stop) != 1 {
+		t.Errorf("request = max_tokens %d, temperature %v, stop %v", r.MaxTokens, r.Temperature, r.Stop)
+	}
+}
+
+func TestDeepSeekAgent_ForcedFinalKeepsCachedPrefix(t *testing.T) {
+	for _, noCache := range []bool{false, true} {
+		var final dsChatRequest
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req dsChatRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			if len(req.Tools) == 0 || req.ToolChoice == "none" {
+				final = req
+				fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"final"},"finish_reason":"stop"}]}`)
+				return
+			}
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","tool_calls":[{"id":"c","type":"function","function":{"name":"execute_bigquery_sql","arguments":"{\"sql\":\"SELECT 1\"}"}}]},"finish_reason":"tool_calls"}]}`)
+		}))
+
+		var mu sync.Mutex
+		var inputs []map[string]interface{}
+		opts := RunOptions{MaxIterations: 1, NoPromptCache: noCache}
+		_, err := NewDeepSeekAgent("key", "", srv.URL).Run(context.Background(), "system", "q", nil,
+			[]tools.Tool{recordingTool(&mu, &inputs)}, opts)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("noCache=%v: %v", noCache, err)
+		}
+		if noCache {
+			if len(final.Tools) != 0 || final.ToolChoice != "" {
+				t.Errorf("without prompt caching the final call drops the tools, got %d tools, tool_choice %q", len(final.Tools), final.ToolChoice)
+			}
+			continue
+		}
+		if len(final.Tools) != 1 || final.ToolChoice != "none" {
+			t.Errorf("final call must resend the tools with tool_choice none, got %d tools, tool_choice %q", len(final.Tools), final.ToolChoice)
+		}
+		if final.Messages[0].Role != "system" || final.Messages[0].Content != "system" {
+			t.Errorf("final call must keep the system prompt first, got %+v", final.Messages[0])
+		}
+	}
+}
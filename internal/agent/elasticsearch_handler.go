@@ -65,7 +65,7 @@ func NewElasticsearchHandler(
 // allowedPatterns overrides the global ES index patterns for squad isolation;
 // nil means use the global patterns configured in the service.
 // runner and promptStyle are resolved from the current user's persona.
-func (h *ElasticsearchHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, allowedPatterns []string, runner LLMRunner, promptStyle string) (*models.AgentResponse, error) {
+func (h *ElasticsearchHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, allowedPatterns []string, runner LLMRunner, opts RunOptions, promptStyle string) (*models.AgentResponse, error) {
 	start := time.Now()
 	metadata := map[string]interface{}{
 		"data_source": "elasticsearch",
@@ -123,13 +123,15 @@ func (h *ElasticsearchHandler) Handle(ctx context.Context, req *models.AgentRequ
 	defer cancel()
 
 	llmStart := time.Now()
-	output, toolsUsed, _, err := runner.Run(agentCtx, ESSystemPromptStyle(promptStyle), req.Prompt, esTools)
+	res, err := runner.Run(agentCtx, ESSystemPromptStyle(promptStyle), req.Prompt, req.History, esTools, opts)
 	llmMs := time.Since(llmStart).Milliseconds()
+	recordRun(ctx, req.Prompt, res, err)
 	if err != nil {
 		return nil, fmt.Errorf("agent run: %w", err)
 	}
+	output := res.Text
 
-	metadata["tools_used"] = toolsUsed
+	metadata["tools_used"] = res.ToolsUsed
 
 	execTimeMs := time.Since(start).Milliseconds()
 	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, "", true, execTimeMs)
@@ -150,3 +152,105 @@ func (h *ElasticsearchHandler) Handle(ctx context.Context, req *models.AgentRequ
 		Answer:        answerPtr,
 	}, nil
 }
+
+// HandleStream processes an agent request for Elasticsearch with SSE event emission.
+// allowedPatterns and runner/promptStyle are resolved the same way as for Handle.
+// tool_call events for elasticsearch_search include the target index and a
+// query_preview of the Query DSL the model built.
+// The final "result" or "error" event is always the last call to emitFn.
+func (h *ElasticsearchHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, allowedPatterns []string, runner LLMRunner, opts RunOptions, promptStyle string, emitFn func(event string, data interface{})) {
+	start := time.Now()
+	metadata := map[string]interface{}{
+		"data_source": "elasticsearch",
+		"model":       runner.Model(),
+		"method":      "agent_stream",
+	}
+
+	emitFn("start", map[string]interface{}{"prompt": req.Prompt})
+
+	// 1. PII detection
+	emitFn("progress", map[string]interface{}{"step": "pii_check"})
+	if found, kw := h.piiDetector.Detect(req.Prompt); found {
+		metadata["pii_check"] = "blocked: " + kw
+		emitFn("error", map[string]interface{}{
+			"message": "PII detected in prompt: " + kw,
+			"step":    "pii_check",
+		})
+		return
+	}
+	metadata["pii_check"] = "passed"
+
+	// 2. General prompt validation
+	emitFn("progress", map[string]interface{}{"step": "prompt_validation"})
+	vr := h.promptVal.Validate(req.Prompt)
+	if !vr.Valid {
+		metadata["prompt_validation"] = "blocked: " + vr.Message
+		emitFn("error", map[string]interface{}{
+			"message": "prompt validation failed: " + vr.Message,
+			"step":    "prompt_validation",
+		})
+		return
+	}
+	metadata["prompt_validation"] = "passed"
+
+	// 3. ES-specific identifier validation
+	emitFn("progress", map[string]interface{}{"step": "es_validation"})
+	valid, identType, errMsg := h.esPromptVal.Validate(req.Prompt)
+	if !valid {
+		metadata["es_validation"] = "blocked: " + errMsg
+		emitFn("error", map[string]interface{}{
+			"message": "ES prompt validation failed: " + errMsg,
+			"step":    "es_validation",
+		})
+		return
+	}
+	metadata["es_validation"] = "passed: " + identType
+
+	// 4. Build ES tools — use squad-scoped ES service if patterns are restricted
+	esSvc := h.es
+	if len(allowedPatterns) > 0 {
+		esSvc = h.es.WithPatterns(allowedPatterns)
+	}
+	esTools := []tools.Tool{
+		tools.ESListIndicesTool(esSvc),
+		tools.ESSearchTool(esSvc),
+	}
+
+	// 5. Run agent loop with event emission
+	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+	defer cancel()
+
+	agentEmit := func(event string, data map[string]interface{}) {
+		emitFn(event, data)
+	}
+
+	llmStart := time.Now()
+	res, err := runner.RunWithEmit(agentCtx, ESSystemPromptStyle(promptStyle), req.Prompt, req.History, esTools, opts, agentEmit)
+	llmMs := time.Since(llmStart).Milliseconds()
+	recordRun(ctx, req.Prompt, res, err)
+	if err != nil {
+		emitFn("error", map[string]interface{}{"message": "agent run: " + err.Error()})
+		return
+	}
+	output := res.Text
+	metadata["tools_used"] = res.ToolsUsed
+
+	execTimeMs := time.Since(start).Milliseconds()
+	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, "", true, execTimeMs)
+
+	answerText := cleanAnswer(output)
+	var answerPtr *string
+	if answerText != "" {
+		answerPtr = &answerText
+	}
+
+	metadata["total_time_ms"] = execTimeMs
+	metadata["llm_time_ms"] = llmMs
+
+	emitFn("result", &models.AgentResponse{
+		Status:        "success",
+		Prompt:        req.Prompt,
+		AgentMetadata: metadata,
+		Answer:        answerPtr,
+	})
+}
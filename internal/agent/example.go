@@ -0,0 +1,403 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrExampleNotFound is returned when an example does not exist.
+var ErrExampleNotFound = errors.New("example not found")
+
+const (
+	// DefaultFewShotExamples is how many examples are put in a prompt when
+	// ExampleOptions.MaxPerPrompt is zero.
+	DefaultFewShotExamples = 3
+
+	// exampleTTL keeps examples until they are deleted; the store backends
+	// require an expiry.
+	exampleTTL = 10 * 365 * 24 * time.Hour
+
+	// An example is relevant to a prompt when this share of the prompt's
+	// words match its prompt, or, with an embedder, when their embeddings
+	// reach this cosine similarity.
+	minExampleOverlap    = 0.3
+	minExampleSimilarity = 0.75
+
+	exampleSharedKey  = "shared"
+	exampleIndexKey   = "squads"  // squad IDs that have an entry
+	exampleVersionKey = "version" // changes on every write; see Version
+)
+
+// ExamplesInstruction introduces the few-shot examples of the system prompt.
+const ExamplesInstruction = "These similar questions were answered correctly on this data before. Follow their tables, joins, filters and metric definitions where they apply, and adapt the SQL to the question asked now instead of copying it."
+
+// ExampleOptions configures how examples are picked for a prompt.
+type ExampleOptions struct {
+	MaxPerPrompt int              // 0 = DefaultFewShotExamples, -1 = none
+	Embedder     service.Embedder // nil = word overlap only
+}
+
+// ExampleStore keeps verified prompt→SQL examples, JSON-encoded in a
+// cache.Cache. Examples are curated, so the backend must be durable: the
+// server uses a cache.FileStore under examples_dir, never the response
+// cache backend, and replicas share it through a shared volume. Each
+// squad's examples are one entry, shared examples another. Writes are
+// serialized on this replica only: concurrent writes from two replicas to
+// the same squad keep the last one.
+type ExampleStore struct {
+	backend  cache.Cache
+	max      int
+	embedder service.Embedder
+	vectors  *vectorCache
+	mu       sync.Mutex
+	now      func() time.Time
+}
+
+// NewExampleStore creates an example store.
+func NewExampleStore(backend cache.Cache, opts ExampleOptions) *ExampleStore {
+	if opts.MaxPerPrompt == 0 {
+		opts.MaxPerPrompt = DefaultFewShotExamples
+	}
+	s := &ExampleStore{backend: backend, max: opts.MaxPerPrompt, embedder: opts.Embedder, now: time.Now}
+	if opts.Embedder != nil {
+		s.vectors = &vectorCache{m: make(map[[32]byte][]float32)}
+	}
+	return s
+}
+
+func exampleKey(squadID string) string {
+	if squadID == "" {
+		return exampleSharedKey
+	}
+	return "squad:" + squadID
+}
+
+// load returns the examples stored under key.
+func (s *ExampleStore) load(key string) ([]models.QueryExample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := s.backend.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var examples []models.QueryExample
+	if err := json.Unmarshal(v, &examples); err != nil {
+		return nil, fmt.Errorf("decode examples %s: %w", key, err)
+	}
+	return examples, nil
+}
+
+// save stores the examples under key and moves the store to a new version.
+func (s *ExampleStore) save(key string, examples []models.QueryExample) error {
+	b, err := json.Marshal(examples)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	if err := s.backend.Set(ctx, key, b, exampleTTL); err != nil {
+		return err
+	}
+	return s.backend.Set(ctx, exampleVersionKey, []byte(uuid.New().String()), exampleTTL)
+}
+
+// Version returns an ID that changes whenever an example is created,
+// updated or deleted, so answers built on earlier examples can be told
+// apart. It is empty for a nil store or one never written to.
+func (s *ExampleStore) Version() string {
+	if s == nil {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := s.backend.Get(ctx, exampleVersionKey)
+	if err != nil || !ok {
+		return ""
+	}
+	return string(v)
+}
+
+// squads returns the IDs of the squads that have examples.
+func (s *ExampleStore) squads() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := s.backend.Get(ctx, exampleIndexKey)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(v, &ids); err != nil {
+		return nil, fmt.Errorf("decode example index: %w", err)
+	}
+	return ids, nil
+}
+
+// List returns squadID's examples followed by the shared ones.
+func (s *ExampleStore) List(squadID string) ([]models.QueryExample, error) {
+	var out []models.QueryExample
+	if squadID != "" {
+		own, err := s.load(exampleKey(squadID))
+		if err != nil {
+			return nil, err
+		}
+		out = own
+	}
+	shared, err := s.load(exampleSharedKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, shared...), nil
+}
+
+// ListAll returns the examples of every squad, then the shared ones.
+func (s *ExampleStore) ListAll() ([]models.QueryExample, error) {
+	ids, err := s.squads()
+	if err != nil {
+		return nil, err
+	}
+	var out []models.QueryExample
+	for _, id := range ids {
+		examples, err := s.load(exampleKey(id))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, examples...)
+	}
+	shared, err := s.load(exampleSharedKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, shared...), nil
+}
+
+// Get returns the example with the given ID.
+func (s *ExampleStore) Get(id string) (*models.QueryExample, error) {
+	all, err := s.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	for i := range all {
+		if all[i].ID == id {
+			return &all[i], nil
+		}
+	}
+	return nil, ErrExampleNotFound
+}
+
+// Create stores ex under a new ID and sets its timestamps.
+func (s *ExampleStore) Create(ex *models.QueryExample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ex.SquadID != "" {
+		ids, err := s.squads()
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(ids, ex.SquadID) {
+			b, err := json.Marshal(append(ids, ex.SquadID))
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+			err = s.backend.Set(ctx, exampleIndexKey, b, exampleTTL)
+			cancel()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	key := exampleKey(ex.SquadID)
+	examples, err := s.load(key)
+	if err != nil {
+		return err
+	}
+	ex.ID = uuid.New().String()
+	ex.CreatedAt = s.now().UTC()
+	ex.UpdatedAt = ex.CreatedAt
+	return s.save(key, append(examples, *ex))
+}
+
+// Update replaces the stored example with ex's ID and sets its UpdatedAt.
+// The example stays in its squad: ex.SquadID must match the stored one.
+func (s *ExampleStore) Update(ex *models.QueryExample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := exampleKey(ex.SquadID)
+	examples, err := s.load(key)
+	if err != nil {
+		return err
+	}
+	for i := range examples {
+		if examples[i].ID == ex.ID {
+			ex.UpdatedAt = s.now().UTC()
+			examples[i] = *ex
+			return s.save(key, examples)
+		}
+	}
+	return ErrExampleNotFound
+}
+
+// Delete removes the example with the given ID from squadID's examples.
+func (s *ExampleStore) Delete(squadID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := exampleKey(squadID)
+	examples, err := s.load(key)
+	if err != nil {
+		return err
+	}
+	for i := range examples {
+		if examples[i].ID == id {
+			return s.save(key, append(examples[:i], examples[i+1:]...))
+		}
+	}
+	return ErrExampleNotFound
+}
+
+// Similar returns up to the configured number of verified examples of
+// squadID (its own and the shared ones) for dataset on dataSource whose
+// prompts are most similar to prompt, most similar first.
+func (s *ExampleStore) Similar(ctx context.Context, squadID, dataSource, dataset, prompt string) []models.QueryExample {
+	if s == nil || s.max < 0 || dataset == "" {
+		return nil
+	}
+	all, err := s.List(squadID)
+	if err != nil {
+		log.Warn().Err(err).Str("squad", squadID).Msg("few-shot examples: load failed")
+		return nil
+	}
+	var candidates []models.QueryExample
+	for _, ex := range all {
+		if ex.Verified && ex.DataSource == dataSource && ex.Dataset == dataset {
+			candidates = append(candidates, ex)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	overlap := exampleOverlaps(candidates, prompt)
+	sims := s.similarities(ctx, candidates, prompt)
+	type scored struct {
+		ex    models.QueryExample
+		score float64
+	}
+	var relevant []scored
+	for i, ex := range candidates {
+		sim := 0.0
+		if sims != nil {
+			sim = sims[i]
+		}
+		if overlap[i] >= minExampleOverlap || sim >= minExampleSimilarity {
+			relevant = append(relevant, scored{ex, overlap[i] + max(sim, 0)})
+		}
+	}
+	sort.SliceStable(relevant, func(a, b int) bool { return relevant[a].score > relevant[b].score })
+
+	out := make([]models.QueryExample, 0, min(len(relevant), s.max))
+	for _, r := range relevant[:min(len(relevant), s.max)] {
+		out = append(out, r.ex)
+	}
+	return out
+}
+
+// exampleOverlaps returns, for each example, the share of prompt's words
+// that its prompt matches (see wordMatch).
+func exampleOverlaps(examples []models.QueryExample, prompt string) []float64 {
+	words := schemaTokens(prompt)
+	out := make([]float64, len(examples))
+	if len(words) == 0 {
+		return out
+	}
+	for i, ex := range examples {
+		exWords := schemaTokens(ex.Prompt)
+		var score float64
+		for _, p := range words {
+			best := 0.0
+			for _, w := range exWords {
+				best = max(best, wordMatch(p, w))
+			}
+			score += best
+		}
+		out[i] = score / float64(len(words))
+	}
+	return out
+}
+
+// similarities returns the cosine similarity of each example's prompt to
+// prompt, or nil without an embedder or when embedding fails.
+func (s *ExampleStore) similarities(ctx context.Context, examples []models.QueryExample, prompt string) []float64 {
+	if s.embedder == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	q, err := s.embedder.Embed(ctx, prompt)
+	if err != nil {
+		log.Warn().Err(err).Msg("few-shot examples: prompt embedding failed, using word overlap only")
+		return nil
+	}
+	sims := make([]float64, len(examples))
+	for i, ex := range examples {
+		v, ok := s.vectors.get(ex.Prompt)
+		if !ok {
+			if v, err = s.embedder.Embed(ctx, ex.Prompt); err != nil {
+				log.Warn().Err(err).Msg("few-shot examples: example embedding failed, using word overlap only")
+				return nil
+			}
+			s.vectors.put(ex.Prompt, v)
+		}
+		sims[i] = cosineSimilarity(q, v)
+	}
+	return sims
+}
+
+// promptExamples returns the few-shot section of the system prompt for req
+// on dataset, and records the IDs of the examples used in metadata.
+func promptExamples(ctx context.Context, s *ExampleStore, squadID, dataSource, dataset string, req *models.AgentRequest, metadata map[string]interface{}) string {
+	examples := s.Similar(ctx, squadID, dataSource, dataset, req.Prompt)
+	if len(examples) == 0 {
+		return ""
+	}
+	ids := make([]string, len(examples))
+	for i, ex := range examples {
+		ids[i] = ex.ID
+	}
+	metadata["few_shot_examples"] = ids
+	return examplesSection(examples)
+}
+
+// examplesSection renders examples for the system prompt.
+func examplesSection(examples []models.QueryExample) string {
+	var sb strings.Builder
+	sb.WriteString("\n\n## Verified Examples\n")
+	sb.WriteString(ExamplesInstruction + "\n")
+	for i, ex := range examples {
+		sb.WriteString("\n### Example " + strconv.Itoa(i+1) + "\n")
+		sb.WriteString("Question: " + ex.Prompt + "\n")
+		if ex.Notes != "" {
+			sb.WriteString("Note: " + ex.Notes + "\n")
+		}
+		sb.WriteString("```sql\n" + strings.TrimSpace(ex.SQL) + "\n```\n")
+	}
+	return sb.String()
+}
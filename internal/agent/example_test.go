@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+func addExample(t *testing.T, s *ExampleStore, squadID, dataset, prompt string, verified bool) *models.QueryExample {
+	t.Helper()
+	ex := &models.QueryExample{SquadID: squadID, DataSource: "bigquery", Dataset: dataset, Prompt: prompt, SQL: "SELECT 1", Verified: verified}
+	if err := s.Create(ex); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return ex
+}
+
+func examplePrompts(examples []models.QueryExample) []string {
+	out := make([]string, len(examples))
+	for i, ex := range examples {
+		out[i] = ex.Prompt
+	}
+	return out
+}
+
+func TestExampleStore_CRUDAndSquadScoping(t *testing.T) {
+	s := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{})
+	a := addExample(t, s, "squad-a", "sales", "total revenue per month", true)
+	addExample(t, s, "squad-b", "sales", "orders per city", true)
+	shared := addExample(t, s, "", "sales", "daily active users", true)
+
+	got, err := s.List("squad-a")
+	if err != nil || !reflect.DeepEqual(examplePrompts(got), []string{"total revenue per month", "daily active users"}) {
+		t.Fatalf("List(squad-a) = %v, %v", examplePrompts(got), err)
+	}
+	if all, _ := s.ListAll(); len(all) != 3 {
+		t.Errorf("ListAll returned %d examples, want 3", len(all))
+	}
+
+	got1, err := s.Get(shared.ID)
+	if err != nil || got1.Prompt != "daily active users" || got1.CreatedAt.IsZero() {
+		t.Errorf("Get = %+v, %v", got1, err)
+	}
+
+	a.SQL = "SELECT month, SUM(revenue) FROM sales.orders GROUP BY month"
+	if err := s.Update(a); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := s.Get(a.ID); got.SQL != a.SQL {
+		t.Errorf("updated SQL = %q", got.SQL)
+	}
+
+	if err := s.Delete("squad-a", a.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(a.ID); !errors.Is(err, ErrExampleNotFound) {
+		t.Errorf("Get after delete: %v", err)
+	}
+	if err := s.Delete("squad-a", a.ID); !errors.Is(err, ErrExampleNotFound) {
+		t.Errorf("second Delete: %v", err)
+	}
+}
+
+func TestExampleStoreSimilar(t *testing.T) {
+	s := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{MaxPerPrompt: 2})
+	addExample(t, s, "squad-a", "sales", "total revenue per month by city", true)
+	addExample(t, s, "squad-a", "sales", "revenue per month", true)
+	addExample(t, s, "squad-a", "sales", "monthly revenue trend", false)    // unverified
+	addExample(t, s, "squad-a", "ops", "revenue per month in ops", true)    // other dataset
+	addExample(t, s, "squad-b", "sales", "revenue per month squad b", true) // other squad
+	addExample(t, s, "", "sales", "number of drivers", true)
+
+	got := s.Similar(context.Background(), "squad-a", "bigquery", "sales", "berapa revenue per month per city tahun ini")
+	want := []string{"total revenue per month by city", "revenue per month"}
+	if !reflect.DeepEqual(examplePrompts(got), want) {
+		t.Errorf("Similar = %v, want %v", examplePrompts(got), want)
+	}
+	if got := s.Similar(context.Background(), "squad-a", "postgres", "sales", "revenue per month"); len(got) != 0 {
+		t.Errorf("other data source matched %v", examplePrompts(got))
+	}
+
+	off := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{MaxPerPrompt: -1})
+	addExample(t, off, "", "sales", "revenue per month", true)
+	if got := off.Similar(context.Background(), "", "bigquery", "sales", "revenue per month"); got != nil {
+		t.Errorf("MaxPerPrompt -1 returned %v", examplePrompts(got))
+	}
+}
+
+func TestExampleStoreSimilar_Embeddings(t *testing.T) {
+	query := "pendapatan bulanan"
+	emb := &fakeEmbedder{vectors: map[string][]float32{
+		query:                   {1, 0},
+		"monthly revenue":       {0.9, 0.1},
+		"number of new drivers": {0, 1},
+	}}
+	s := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{Embedder: emb})
+	addExample(t, s, "", "sales", "monthly revenue", true)
+	addExample(t, s, "", "sales", "number of new drivers", true)
+
+	got := s.Similar(context.Background(), "", "bigquery", "sales", query)
+	if !reflect.DeepEqual(examplePrompts(got), []string{"monthly revenue"}) {
+		t.Errorf("Similar = %v, want [monthly revenue]", examplePrompts(got))
+	}
+}
+
+func TestPromptExamples_Section(t *testing.T) {
+	s := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{})
+	ex := &models.QueryExample{DataSource: "postgres", Dataset: "payment_db", Prompt: "refund rate per merchant", SQL: "SELECT merchant_id, AVG(refunded::int) FROM payments GROUP BY 1\n", Notes: "refunded is a boolean", Verified: true}
+	if err := s.Create(ex); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := map[string]interface{}{}
+	got := promptExamples(context.Background(), s, "squad-a", "postgres", "payment_db", &models.AgentRequest{Prompt: "refund rate per merchant last week"}, metadata)
+	want := "\n\n## Verified Examples\n" + ExamplesInstruction + "\n\n### Example 1\nQuestion: refund rate per merchant\nNote: refunded is a boolean\n```sql\nSELECT merchant_id, AVG(refunded::int) FROM payments GROUP BY 1\n```\n"
+	if got != want {
+		t.Errorf("section =\n%q\nwant\n%q", got, want)
+	}
+	if !reflect.DeepEqual(metadata["few_shot_examples"], []string{ex.ID}) {
+		t.Errorf("few_shot_examples = %v", metadata["few_shot_examples"])
+	}
+
+	metadata = map[string]interface{}{}
+	if got := promptExamples(context.Background(), s, "squad-a", "postgres", "payment_db", &models.AgentRequest{Prompt: "list all tables"}, metadata); got != "" || len(metadata) != 0 {
+		t.Errorf("unrelated prompt: section %q, metadata %v", got, metadata)
+	}
+	if got := promptExamples(context.Background(), nil, "", "postgres", "payment_db", &models.AgentRequest{Prompt: "refund rate"}, metadata); strings.TrimSpace(got) != "" {
+		t.Errorf("nil store: section %q", got)
+	}
+}
+
+func TestExampleStoreVersion_ChangesOnWrite(t *testing.T) {
+	s := NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), ExampleOptions{})
+	if v := s.Version(); v != "" {
+		t.Errorf("empty store version = %q", v)
+	}
+	ex := addExample(t, s, "squad-a", "sales", "revenue per month", false)
+	created := s.Version()
+	scope := responseCacheScope("sales", "squad-a", nil, nil, s)
+
+	ex.Verified = true
+	if err := s.Update(ex); err != nil {
+		t.Fatal(err)
+	}
+	if s.Version() == created {
+		t.Error("verifying an example must change the version")
+	}
+	if responseCacheScope("sales", "squad-a", nil, nil, s) == scope {
+		t.Error("answers cached before the example was verified must not be served after")
+	}
+	var none *ExampleStore
+	if none.Version() != "" {
+		t.Error("nil store must have an empty version")
+	}
+}
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/tools"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrNoHealthyRunner is returned by FailoverRunner when every runner of its
+// chain is removed by an open circuit breaker.
+var ErrNoHealthyRunner = errors.New("no healthy LLM runner available")
+
+// circuitBreaker takes a runner out of failover chains after threshold
+// consecutive provider failures. Once cooldown has passed one request is let
+// through again: success closes the breaker, failure reopens it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	now       func() time.Time // overridable in tests
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether the runner may be called. A half-open breaker lets
+// one caller through and stays closed to others until that call reports.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.now().Before(b.openUntil) {
+		return false
+	}
+	b.openUntil = b.now().Add(b.cooldown) // half-open: one trial call
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// FailoverRunner is an LLMRunner over an ordered chain of runners, built by
+// LLMPool.Get for keys configured with SetChain. Each run goes to the first
+// runner whose circuit breaker is closed; when it fails with a provider error
+// (rate limit, server or transport error, after the runner's own retries)
+// the run starts over on the next runner. Other errors, such as the request
+// deadline, are returned as-is, and so is any error after the run executed a
+// tool: starting over would run its queries again and, when streaming, send
+// the client a second set of events.
+type FailoverRunner struct {
+	keys     []string
+	runners  []LLMRunner
+	breakers []*circuitBreaker
+}
+
+// Model returns the model of the first runner of the chain.
+func (f *FailoverRunner) Model() string { return f.runners[0].Model() }
+
+// Run executes the agent loop on the first healthy runner of the chain.
+func (f *FailoverRunner) Run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions) (*RunResult, error) {
+	return f.run(ctx, nil, func(r LLMRunner) (*RunResult, error) {
+		return r.Run(ctx, systemPrompt, userPrompt, history, agentTools, opts)
+	})
+}
+
+// RunWithEmit is like Run; it also emits an "llm_failover" event
+// ({"runner", "next", "error"}) each time the run moves to the next runner.
+func (f *FailoverRunner) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	return f.run(ctx, emitFn, func(r LLMRunner) (*RunResult, error) {
+		return r.RunWithEmit(ctx, systemPrompt, userPrompt, history, agentTools, opts, emitFn)
+	})
+}
+
+func (f *FailoverRunner) run(ctx context.Context, emitFn EmitFn, call func(LLMRunner) (*RunResult, error)) (*RunResult, error) {
+	var failovers []models.RunnerFailure
+	var lastRes *RunResult
+	var lastErr error
+	for i, r := range f.runners {
+		key, breaker := f.keys[i], f.breakers[i]
+		if !breaker.allow() {
+			failovers = append(failovers, models.RunnerFailure{Runner: key, Error: "circuit open"})
+			continue
+		}
+		res, err := call(r)
+		if res == nil {
+			res = &RunResult{}
+		}
+		res.Runner = key
+		res.Failovers = failovers
+		if err == nil {
+			breaker.success()
+			return res, nil
+		}
+		if !isRetryable(err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		breaker.failure()
+		if len(res.ToolsUsed) > 0 {
+			log.Warn().Err(err).Str("runner", key).Msg("LLM runner failed after running tools, not failing over")
+			return res, &noRetryError{err}
+		}
+		failovers = append(failovers, models.RunnerFailure{Runner: key, Error: err.Error()})
+		log.Warn().Err(err).Str("runner", key).Msg("LLM runner failed, failing over")
+		if emitFn != nil && i+1 < len(f.runners) {
+			emitFn("llm_failover", map[string]interface{}{"runner": key, "next": f.keys[i+1], "error": err.Error()})
+		}
+		lastRes, lastErr = res, err
+	}
+
+	if lastErr == nil {
+		return &RunResult{Failovers: failovers}, ErrNoHealthyRunner
+	}
+	lastRes.Failovers = failovers
+	return lastRes, fmt.Errorf("all LLM runners failed: %w", lastErr)
+}
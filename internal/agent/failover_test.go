@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// flakyRunner fails with err for its first failures runs, then answers.
+// Failed runs report tools as already executed.
+type flakyRunner struct {
+	model    string
+	err      error
+	tools    []string
+	failures int
+	calls    int
+}
+
+func (r *flakyRunner) Run(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ RunOptions) (*RunResult, error) {
+	r.calls++
+	if r.calls <= r.failures {
+		return &RunResult{Model: r.model, ToolsUsed: r.tools}, fmt.Errorf("LLM call failed: %w", r.err)
+	}
+	return &RunResult{Text: "answer from " + r.model, Model: r.model}, nil
+}
+func (r *flakyRunner) RunWithEmit(ctx context.Context, system, prompt string, history []models.ConversationTurn, ts []tools.Tool, _ RunOptions, _ EmitFn) (*RunResult, error) {
+	return r.Run(ctx, system, prompt, history, ts, RunOptions{})
+}
+func (r *flakyRunner) Model() string { return r.model }
+
+func TestLLMPool_ChainFailsOverOnProviderErrors(t *testing.T) {
+	primary := &flakyRunner{model: "glm-4.5-air", err: &APIError{StatusCode: 503}, failures: 100}
+	backup := &flakyRunner{model: "deepseek-chat"}
+	pool := NewLLMPool()
+	pool.Register("anthropic:glm-4.5-air", primary)
+	pool.Register("deepseek:deepseek-chat", backup)
+	pool.SetCircuitBreaker(2, time.Hour)
+	pool.SetChain("anthropic:glm-4.5-air", "deepseek:deepseek-chat")
+
+	runner := pool.Get("anthropic:glm-4.5-air")
+	if runner.Model() != "glm-4.5-air" {
+		t.Errorf("chain Model() = %q, want the primary's", runner.Model())
+	}
+	for i := 0; i < 3; i++ {
+		res, err := runner.Run(context.Background(), "", "q", nil, nil, RunOptions{})
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if res.Runner != "deepseek:deepseek-chat" || res.Text != "answer from deepseek-chat" || len(res.Failovers) != 1 {
+			t.Fatalf("run %d: runner = %q, failovers = %+v", i, res.Runner, res.Failovers)
+		}
+	}
+	if primary.calls != 2 {
+		t.Errorf("primary called %d times; the breaker should open after 2 failures", primary.calls)
+	}
+}
+
+func TestFailoverRunner_DoesNotFailOverRequestErrors(t *testing.T) {
+	primary := &flakyRunner{model: "a", err: &APIError{StatusCode: http.StatusBadRequest}, failures: 1}
+	backup := &flakyRunner{model: "b"}
+	pool := NewLLMPool()
+	pool.Register("x:a", primary)
+	pool.Register("x:b", backup)
+	pool.SetChain("x:a", "x:b")
+
+	_, err := pool.Get("x:a").Run(context.Background(), "", "q", nil, nil, RunOptions{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || backup.calls != 0 {
+		t.Errorf("a 400 must be returned, not failed over: err=%v backup calls=%d", err, backup.calls)
+	}
+}
+
+func TestFailoverRunner_DoesNotFailOverAfterTools(t *testing.T) {
+	primary := &flakyRunner{model: "a", err: &APIError{StatusCode: 503}, tools: []string{"execute_bigquery_sql"}, failures: 1}
+	backup := &flakyRunner{model: "b"}
+	pool := NewLLMPool()
+	pool.Register("x:a", primary)
+	pool.Register("x:b", backup)
+	pool.SetChain("x:a", "x:b")
+
+	var events []string
+	emit := func(event string, _ map[string]interface{}) { events = append(events, event) }
+	res, err := pool.Get("x:a").RunWithEmit(context.Background(), "", "q", nil, nil, RunOptions{}, emit)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || isRetryable(err) || backup.calls != 0 {
+		t.Fatalf("a failure after tools ran must not fail over: err=%v backup calls=%d", err, backup.calls)
+	}
+	if res.Runner != "x:a" || len(res.ToolsUsed) != 1 || len(events) != 0 {
+		t.Errorf("got runner %q tools %v events %v", res.Runner, res.ToolsUsed, events)
+	}
+}
+
+func TestFailoverRunner_AllRunnersDown(t *testing.T) {
+	pool := NewLLMPool()
+	pool.Register("x:a", &flakyRunner{model: "a", err: &APIError{StatusCode: 429}, failures: 100})
+	pool.SetCircuitBreaker(1, time.Hour)
+	pool.SetChain("x:a")
+	runner := pool.Get("x:a")
+
+	if _, err := runner.Run(context.Background(), "", "q", nil, nil, RunOptions{}); err == nil {
+		t.Fatal("expected error")
+	}
+	res, err := runner.Run(context.Background(), "", "q", nil, nil, RunOptions{})
+	if !errors.Is(err, ErrNoHealthyRunner) || len(res.Failovers) != 1 || res.Failovers[0].Error != "circuit open" {
+		t.Errorf("open breaker: err=%v failovers=%+v", err, res.Failovers)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenAfterCooldown(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(1, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.failure()
+	if b.allow() {
+		t.Fatal("breaker should be open")
+	}
+	now = now.Add(time.Minute)
+	if !b.allow() || b.allow() {
+		t.Fatal("after cooldown exactly one trial call should be allowed")
+	}
+	b.success()
+	if !b.allow() {
+		t.Error("a successful trial should close the breaker")
+	}
+}
+
+func TestDeepSeekAgent_RetriesRateLimit(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			http.Error(w, `{"error":{"message":"rate limited"}}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	a := NewDeepSeekAgent("key", "", srv.URL)
+	a.SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+	res, err := a.Run(context.Background(), "", "hi", nil, nil, RunOptions{})
+	if err != nil || res.Text != "done" || calls != 2 {
+		t.Errorf("Run = %q, %v after %d calls; want one retry", res.Text, err, calls)
+	}
+
+	a.SetRetryPolicy(RetryPolicy{})
+	calls = 0
+	if _, err := a.Run(context.Background(), "", "hi", nil, nil, RunOptions{}); err == nil || calls != 1 {
+		t.Errorf("without retries: err=%v calls=%d", err, calls)
+	}
+}
@@ -0,0 +1,382 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// FederatedScope limits what a federated request may touch. Sources comes from
+// the persona's AllowedDataSources; the rest from the user's squad.
+type FederatedScope struct {
+	Sources         []service.DataSource // persona-permitted sources; nil = all
+	SquadID         string
+	Project         string   // BigQuery project of Datasets
+	Datasets        []string // allowed BigQuery datasets; nil = no restriction
+	ESIndexPatterns []string // allowed ES index patterns; nil = global patterns
+	PGDatabases     []string // allowed PostgreSQL databases; nil = no restriction
+	AllowedTables   []string // squad table/column lists, see security.TableAccessPolicy
+	DeniedTables    []string
+	DeniedColumns   []string
+	RowFilters      []security.RowFilter // squad and user row-level security
+}
+
+// tableAccess returns the policy enforced on the scope's SQL, or nil when
+// the scope restricts nothing.
+func (s FederatedScope) tableAccess() *security.TableAccessPolicy {
+	p := &security.TableAccessPolicy{
+		Project:       s.Project,
+		Datasets:      s.Datasets,
+		Databases:     s.PGDatabases,
+		AllowedTables: s.AllowedTables,
+		DeniedTables:  s.DeniedTables,
+		DeniedColumns: s.DeniedColumns,
+		RowFilters:    s.RowFilters,
+	}
+	if len(p.Datasets)+len(p.Databases)+len(p.AllowedTables)+len(p.DeniedTables)+len(p.DeniedColumns)+len(p.RowFilters) == 0 {
+		return nil
+	}
+	return p
+}
+
+// FederatedHandler answers questions that span BigQuery, PostgreSQL and
+// Elasticsearch. The agent gets the tools of every source available to the
+// request; execute/search results are masked and staged so they can be fed
+// into the next query or joined in memory with join_staged_results.
+type FederatedHandler struct {
+	bq            *service.BigQueryService      // nil = BigQuery not configured
+	pgRegistry    *service.PGPoolRegistry       // nil = PostgreSQL not configured
+	es            *service.ElasticsearchService // nil = Elasticsearch not configured
+	piiDetector   *security.PIIDetector
+	promptVal     *security.PromptValidator
+	esPromptVal   *security.ESPromptValidator
+	sqlVal        *security.SQLValidator
+	costTracker   *security.CostTracker
+	pgCostTracker *security.PGCostTracker
+	dataMasker    *security.DataMasker
+	auditLogger   *security.AuditLogger
+}
+
+// NewFederatedHandler creates a handler over whichever of bq, pgRegistry and es
+// are configured (nil services are skipped).
+func NewFederatedHandler(
+	bq *service.BigQueryService,
+	pgRegistry *service.PGPoolRegistry,
+	es *service.ElasticsearchService,
+	piiDetector *security.PIIDetector,
+	promptVal *security.PromptValidator,
+	esPromptVal *security.ESPromptValidator,
+	sqlVal *security.SQLValidator,
+	costTracker *security.CostTracker,
+	pgCostTracker *security.PGCostTracker,
+	dataMasker *security.DataMasker,
+	auditLogger *security.AuditLogger,
+) *FederatedHandler {
+	return &FederatedHandler{
+		bq:            bq,
+		pgRegistry:    pgRegistry,
+		es:            es,
+		piiDetector:   piiDetector,
+		promptVal:     promptVal,
+		esPromptVal:   esPromptVal,
+		sqlVal:        sqlVal,
+		costTracker:   costTracker,
+		pgCostTracker: pgCostTracker,
+		dataMasker:    dataMasker,
+		auditLogger:   auditLogger,
+	}
+}
+
+// AvailableSources returns the sources permitted by scope that are configured
+// on this server (PostgreSQL also needs a pool for the squad), in
+// BigQuery, PostgreSQL, Elasticsearch order.
+func (h *FederatedHandler) AvailableSources(scope FederatedScope) []service.DataSource {
+	permitted := func(src service.DataSource) bool {
+		if len(scope.Sources) == 0 {
+			return true
+		}
+		for _, s := range scope.Sources {
+			if s == src {
+				return true
+			}
+		}
+		return false
+	}
+	var out []service.DataSource
+	if h.bq != nil && permitted(service.DataSourceBigQuery) {
+		out = append(out, service.DataSourceBigQuery)
+	}
+	if h.pgRegistry != nil && h.pgRegistry.Get(scope.SquadID) != nil && permitted(service.DataSourcePostgres) {
+		out = append(out, service.DataSourcePostgres)
+	}
+	if h.es != nil && permitted(service.DataSourceElasticsearch) {
+		out = append(out, service.DataSourceElasticsearch)
+	}
+	return out
+}
+
+// federatedStepError is a pipeline failure before the agent loop runs.
+type federatedStepError struct {
+	step   string
+	err    error
+	answer *string
+}
+
+// prepare runs the prompt checks and builds the tools and system prompt.
+// It fills metadata with the check results and the sources in use.
+func (h *FederatedHandler) prepare(req *models.AgentRequest, apiKey string, scope FederatedScope, promptStyle string, excludedTools []string, run *federatedRun, metadata map[string]interface{}) ([]tools.Tool, string, *federatedStepError) {
+	// 1. PII detection
+	if found, kw := h.piiDetector.Detect(req.Prompt); found {
+		metadata["pii_check"] = "blocked: " + kw
+		return nil, "", &federatedStepError{"pii_check", fmt.Errorf("PII detected in prompt: %s", kw), friendlyMsg("pii", kw)}
+	}
+	metadata["pii_check"] = "passed"
+
+	// 2. Prompt validation
+	vr := h.promptVal.Validate(req.Prompt)
+	if !vr.Valid {
+		metadata["prompt_validation"] = "blocked: " + vr.Message
+		return nil, "", &federatedStepError{"prompt_validation", fmt.Errorf("prompt validation failed: %s", vr.Message), promptFriendlyMsg(vr.Message)}
+	}
+	metadata["prompt_validation"] = "passed"
+
+	// 3. Resolve sources. Elasticsearch keeps its identifier/time-range
+	// requirement: if the prompt has none, ES tools are dropped rather than
+	// failing the whole request.
+	sources := h.AvailableSources(scope)
+	for i, src := range sources {
+		if src != service.DataSourceElasticsearch {
+			continue
+		}
+		valid, identType, errMsg := h.esPromptVal.Validate(req.Prompt)
+		if valid {
+			metadata["es_validation"] = "passed: " + identType
+		} else {
+			metadata["es_validation"] = "blocked: " + errMsg + " (elasticsearch tools disabled)"
+			sources = append(sources[:i], sources[i+1:]...)
+		}
+		break
+	}
+	if len(sources) == 0 {
+		msg := "Tidak ada sumber data yang tersedia untuk query lintas sumber. Silakan hubungi administrator."
+		return nil, "", &federatedStepError{"source_check", fmt.Errorf("no data sources available for federated query"), &msg}
+	}
+	names := make([]string, len(sources))
+	for i, src := range sources {
+		names[i] = string(src)
+	}
+	metadata["federated_sources"] = names
+
+	// 4. Build tools
+	if req.DryRun {
+		excludedTools = append(excludedTools, "execute_bigquery_sql", "execute_postgres_sql", "elasticsearch_search")
+	}
+	projectID := ""
+	if req.ProjectID != nil {
+		projectID = *req.ProjectID
+	}
+	datasetID := "" // unqualified BigQuery tables resolve to the request's dataset
+	if req.DatasetID != nil {
+		datasetID = *req.DatasetID
+	}
+	// Every tool query runs through the same pipeline; the metadata tools are
+	// filtered by its table access policy.
+	policy := &tools.QueryPolicy{
+		Validator: h.sqlVal,
+		Access:    scope.tableAccess(),
+		Cost:      h.costTracker,
+		PGCost:    h.pgCostTracker,
+		Masker:    h.dataMasker,
+		Audit:     h.auditLogger,
+		APIKey:    apiKey,
+		ProjectID: projectID,
+	}
+	var ts []tools.Tool
+	for _, src := range sources {
+		switch src {
+		case service.DataSourceBigQuery:
+			ts = append(ts,
+				tools.BQListDatasetsTool(h.bq, scope.Datasets),
+				tools.BQListTablesTool(h.bq, policy),
+				tools.BQGetSchemaTool(h.bq, policy),
+				tools.BQSampleDataTool(h.bq, policy),
+				h.bqExecuteTool(run, policy, datasetID),
+			)
+		case service.DataSourcePostgres:
+			pgSvc := h.pgRegistry.Get(scope.SquadID)
+			ts = append(ts,
+				tools.PGListDatabasesTool(scope.PGDatabases),
+				withDatabaseParam(func(db string) tools.Tool { return tools.PGListTablesTool(pgSvc, db, policy) }, scope.PGDatabases),
+				withDatabaseParam(func(db string) tools.Tool { return tools.PGGetSchemaTool(pgSvc, db, policy) }, scope.PGDatabases),
+				withDatabaseParam(func(db string) tools.Tool { return tools.PGSampleDataTool(pgSvc, db, policy) }, scope.PGDatabases),
+				h.pgExecuteTool(run, pgSvc, scope.PGDatabases, policy),
+			)
+		case service.DataSourceElasticsearch:
+			esSvc := h.es
+			if len(scope.ESIndexPatterns) > 0 {
+				esSvc = h.es.WithPatterns(scope.ESIndexPatterns)
+			}
+			ts = append(ts,
+				tools.ESListIndicesTool(esSvc),
+				h.esSearchTool(run, esSvc),
+			)
+		}
+	}
+	ts = append(ts, stagingTools(run)...)
+	ts = filterTools(ts, excludedTools)
+
+	// 5. System prompt: persona base + the sources in scope
+	systemPrompt := FederatedSystemPromptStyle(promptStyle) + federatedSourcesSection(sources, scope)
+	return ts, systemPrompt, nil
+}
+
+// federatedSourcesSection tells the model which sources (and which squad
+// datasets, databases and index patterns) it may use.
+func federatedSourcesSection(sources []service.DataSource, scope FederatedScope) string {
+	var sb strings.Builder
+	sb.WriteString("\n\n## Available Sources\n")
+	for _, src := range sources {
+		switch src {
+		case service.DataSourceBigQuery:
+			sb.WriteString("- BigQuery (BigQuery SQL, `dataset.table` names)")
+			if len(scope.Datasets) > 0 {
+				sb.WriteString(" — datasets: " + strings.Join(scope.Datasets, ", "))
+			}
+		case service.DataSourcePostgres:
+			sb.WriteString(`- PostgreSQL (PostgreSQL SQL, "schema"."table" names; pass database to every postgres tool)`)
+			if len(scope.PGDatabases) > 0 {
+				sb.WriteString(" — databases: " + strings.Join(scope.PGDatabases, ", "))
+			}
+		case service.DataSourceElasticsearch:
+			sb.WriteString("- Elasticsearch (Query DSL)")
+			if len(scope.ESIndexPatterns) > 0 {
+				sb.WriteString(" — index patterns: " + strings.Join(scope.ESIndexPatterns, ", "))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// finish builds the success response from the agent output and run state.
+// ExecutionResult is the most recently staged result (typically the final join).
+func (h *FederatedHandler) finish(req *models.AgentRequest, apiKey, output string, toolsUsed []string, run *federatedRun, metadata map[string]interface{}, start time.Time, llmMs int64) *models.AgentResponse {
+	metadata["tools_used"] = toolsUsed
+	metadata["sources"] = run.snapshot()
+	metadata["staged_results"] = run.stage.names()
+	metadata["data_masking"] = "applied"
+
+	var execResult *models.QueryResponse
+	if latest := run.stage.latest(); latest != nil {
+		execResult = &models.QueryResponse{
+			Status:   "success",
+			Data:     latest.Rows,
+			Columns:  latest.Columns,
+			RowCount: len(latest.Rows),
+		}
+	}
+
+	execTimeMs := time.Since(start).Milliseconds()
+	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, strings.Join(run.sqlQueries(), ";\n"), true, execTimeMs)
+
+	metadata["total_time_ms"] = execTimeMs
+	metadata["llm_time_ms"] = llmMs
+
+	answerText := cleanAnswer(output)
+	var answerPtr *string
+	if answerText != "" {
+		answerPtr = &answerText
+	}
+	return &models.AgentResponse{
+		Status:          "success",
+		Prompt:          req.Prompt,
+		ExecutionResult: execResult,
+		AgentMetadata:   metadata,
+		Answer:          answerPtr,
+	}
+}
+
+// Handle processes a federated agent request. Responses are never cached:
+// they combine sources with different freshness.
+// excludedTools lists tool names to hide from the LLM; nil means all tools are available.
+func (h *FederatedHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, scope FederatedScope, runner LLMRunner, opts RunOptions, promptStyle string, excludedTools []string) (*models.AgentResponse, error) {
+	start := time.Now()
+	metadata := map[string]interface{}{
+		"data_source": string(service.DataSourceFederated),
+		"model":       runner.Model(),
+		"method":      "agent",
+	}
+
+	run := newFederatedRun()
+	fedTools, systemPrompt, stepErr := h.prepare(req, apiKey, scope, promptStyle, excludedTools, run, metadata)
+	if stepErr != nil {
+		return &models.AgentResponse{
+			Status:        "error",
+			Prompt:        req.Prompt,
+			AgentMetadata: metadata,
+			Answer:        stepErr.answer,
+		}, stepErr.err
+	}
+
+	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+	defer cancel()
+
+	llmStart := time.Now()
+	res, err := runner.Run(agentCtx, systemPrompt, req.Prompt, req.History, fedTools, opts)
+	llmMs := time.Since(llmStart).Milliseconds()
+	recordRun(ctx, req.Prompt, res, err)
+	if err != nil {
+		return nil, fmt.Errorf("agent run: %w", err)
+	}
+
+	return h.finish(req, apiKey, res.Text, res.ToolsUsed, run, metadata, start, llmMs), nil
+}
+
+// HandleStream processes a federated agent request with SSE event emission.
+// The final "result" or "error" event is always the last call to emitFn.
+func (h *FederatedHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, scope FederatedScope, runner LLMRunner, opts RunOptions, promptStyle string, emitFn func(event string, data interface{}), excludedTools []string) {
+	start := time.Now()
+	metadata := map[string]interface{}{
+		"data_source": string(service.DataSourceFederated),
+		"model":       runner.Model(),
+		"method":      "agent_stream",
+	}
+
+	emitFn("start", map[string]interface{}{"prompt": req.Prompt})
+	emitFn("progress", map[string]interface{}{"step": "prompt_validation"})
+
+	run := newFederatedRun()
+	fedTools, systemPrompt, stepErr := h.prepare(req, apiKey, scope, promptStyle, excludedTools, run, metadata)
+	if stepErr != nil {
+		emitFn("error", map[string]interface{}{
+			"message": stepErr.err.Error(),
+			"step":    stepErr.step,
+		})
+		return
+	}
+	emitFn("progress", map[string]interface{}{"step": "sources_ready", "sources": metadata["federated_sources"]})
+
+	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
+	defer cancel()
+
+	agentEmit := func(event string, data map[string]interface{}) {
+		emitFn(event, data)
+	}
+
+	llmStart := time.Now()
+	res, err := runner.RunWithEmit(agentCtx, systemPrompt, req.Prompt, req.History, fedTools, opts, agentEmit)
+	llmMs := time.Since(llmStart).Milliseconds()
+	recordRun(ctx, req.Prompt, res, err)
+	if err != nil {
+		emitFn("error", map[string]interface{}{"message": "agent run: " + err.Error()})
+		return
+	}
+
+	emitFn("result", h.finish(req, apiKey, res.Text, res.ToolsUsed, run, metadata, start, llmMs))
+}
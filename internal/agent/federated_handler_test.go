@@ -0,0 +1,373 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+func rowsOf(kv ...map[string]interface{}) []map[string]interface{} { return kv }
+
+func TestStagingArea_AutoNameAndLatest(t *testing.T) {
+	s := newStagingArea()
+	a, _ := s.put("", "bigquery", []string{"id"}, rowsOf(map[string]interface{}{"id": 1}))
+	b, _ := s.put("", "bigquery", []string{"id"}, nil)
+	c, _ := s.put("merchants", "postgres", []string{"id"}, nil)
+
+	if a.Name != "bigquery_1" || b.Name != "bigquery_2" || c.Name != "merchants" {
+		t.Errorf("unexpected names: %q %q %q", a.Name, b.Name, c.Name)
+	}
+	if got := s.latest(); got.Name != "merchants" {
+		t.Errorf("latest = %q, want merchants", got.Name)
+	}
+
+	// Re-staging an existing name replaces it and makes it the latest
+	s.put("bigquery_1", "bigquery", []string{"id"}, nil)
+	names := s.names()
+	if names[len(names)-1] != "bigquery_1" || len(names) != 3 {
+		t.Errorf("unexpected order after replace: %v", names)
+	}
+}
+
+func TestStagingArea_TruncatesAndLimitsCount(t *testing.T) {
+	s := newStagingArea()
+	rows := make([]map[string]interface{}, maxStagedRows+10)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"n": i}
+	}
+	res, err := s.put("big", "bigquery", []string{"n"}, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != maxStagedRows || !res.Truncated {
+		t.Errorf("expected %d rows and truncated, got %d (truncated=%v)", maxStagedRows, len(res.Rows), res.Truncated)
+	}
+	if p := res.preview(); len(p["data"].([]map[string]interface{})) != stagePreviewRows || p["note"] == nil {
+		t.Error("preview should be capped and carry a note")
+	}
+
+	for i := 1; i < maxStagedResults; i++ {
+		if _, err := s.put("", "postgres", nil, nil); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+	if _, err := s.put("", "postgres", nil, nil); err == nil {
+		t.Error("expected error beyond maxStagedResults")
+	}
+}
+
+func TestJoinStaged_InnerMatchesAcrossTypes(t *testing.T) {
+	// BigQuery returns INT64, Elasticsearch a keyword string: keys must still match.
+	left := &stagedResult{Name: "revenue", Columns: []string{"merchant_id", "revenue"}, Rows: rowsOf(
+		map[string]interface{}{"merchant_id": int64(1), "revenue": 100.0},
+		map[string]interface{}{"merchant_id": int64(2), "revenue": 50.0},
+	)}
+	right := &stagedResult{Name: "errors", Columns: []string{"merchant_id", "message"}, Rows: rowsOf(
+		map[string]interface{}{"merchant_id": "1", "message": "timeout"},
+		map[string]interface{}{"merchant_id": "1", "message": "5xx"},
+		map[string]interface{}{"merchant_id": "3", "message": "other"},
+	)}
+
+	cols, rows, err := joinStaged(left, right, "merchant_id", "merchant_id", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 joined rows, got %d", len(rows))
+	}
+	wantCols := []string{"merchant_id", "revenue", "errors.merchant_id", "message"}
+	if strings.Join(cols, ",") != strings.Join(wantCols, ",") {
+		t.Errorf("columns = %v, want %v", cols, wantCols)
+	}
+	if rows[0]["revenue"] != 100.0 || rows[0]["errors.merchant_id"] != "1" {
+		t.Errorf("unexpected row: %v", rows[0])
+	}
+}
+
+func TestJoinStaged_LeftKeepsUnmatched(t *testing.T) {
+	left := &stagedResult{Name: "l", Columns: []string{"id"}, Rows: rowsOf(
+		map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2},
+	)}
+	right := &stagedResult{Name: "r", Columns: []string{"rid", "v"}, Rows: rowsOf(
+		map[string]interface{}{"rid": 1, "v": "x"},
+	)}
+	_, rows, err := joinStaged(left, right, "id", "rid", "left")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if v, ok := rows[1]["v"]; !ok || v != nil {
+		t.Errorf("unmatched row should have nil right columns, got %v", rows[1])
+	}
+}
+
+func TestJoinStaged_Errors(t *testing.T) {
+	res := &stagedResult{Name: "a", Columns: []string{"id"}}
+	if _, _, err := joinStaged(res, res, "missing", "id", ""); err == nil {
+		t.Error("expected error for unknown left key")
+	}
+	if _, _, err := joinStaged(res, res, "id", "missing", ""); err == nil {
+		t.Error("expected error for unknown right key")
+	}
+	if _, _, err := joinStaged(res, res, "id", "id", "full"); err == nil {
+		t.Error("expected error for unsupported join type")
+	}
+}
+
+func TestDistinctValues(t *testing.T) {
+	res := &stagedResult{Name: "a", Columns: []string{"id"}, Rows: rowsOf(
+		map[string]interface{}{"id": "m1"}, map[string]interface{}{"id": "m2"},
+		map[string]interface{}{"id": "m1"}, map[string]interface{}{"id": nil},
+	)}
+	vals, err := distinctValues(res, "id", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 2 || vals[0] != "m1" || vals[1] != "m2" {
+		t.Errorf("unexpected values: %v", vals)
+	}
+	if vals, _ := distinctValues(res, "id", 1); len(vals) != 1 {
+		t.Errorf("limit not applied: %v", vals)
+	}
+	if _, err := distinctValues(res, "nope", 10); err == nil {
+		t.Error("expected error for unknown column")
+	}
+}
+
+func TestESHitsToRows_FlattensSource(t *testing.T) {
+	rows := esHitsToRows([]map[string]interface{}{{
+		"_id":    "doc1",
+		"_index": "logs-2026",
+		"_source": map[string]interface{}{
+			"level":    "ERROR",
+			"merchant": map[string]interface{}{"id": "m1", "email": "a@b.com"},
+		},
+	}})
+	if len(rows) != 1 {
+		t.Fatalf("expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	if row["_id"] != "doc1" || row["_index"] != "logs-2026" || row["level"] != "ERROR" || row["merchant.id"] != "m1" {
+		t.Errorf("unexpected row: %v", row)
+	}
+
+	// Dotted keys are masked like SQL columns.
+	masked := security.NewDataMasker([]string{"email"}).MaskRows(rows)
+	if masked[0]["merchant.email"] == "a@b.com" {
+		t.Error("nested email should be masked after flattening")
+	}
+}
+
+func TestWithDatabaseParam(t *testing.T) {
+	tool := withDatabaseParam(func(db string) tools.Tool { return tools.PGGetSchemaTool(nil, db, nil) }, []string{"payment_db"})
+
+	if tool.Name != "get_postgres_schema" {
+		t.Errorf("name = %q", tool.Name)
+	}
+	props := tool.InputSchema["properties"].(map[string]interface{})
+	for _, p := range []string{"database", "schema", "table"} {
+		if _, ok := props[p]; !ok {
+			t.Errorf("schema missing property %q", p)
+		}
+	}
+	req := tool.InputSchema["required"].([]string)
+	if req[0] != "database" || len(req) != 3 {
+		t.Errorf("required = %v", req)
+	}
+
+	_, err := tool.Execute(context.Background(), map[string]interface{}{"database": "hr_db", "schema": "public", "table": "x"})
+	if err == nil || !strings.Contains(err.Error(), "not accessible") {
+		t.Errorf("expected access error for hr_db, got %v", err)
+	}
+	if _, err := tool.Execute(context.Background(), map[string]interface{}{"schema": "public"}); err == nil {
+		t.Error("expected error when database is missing")
+	}
+}
+
+func TestStagingTools_JoinAndValues(t *testing.T) {
+	run := newFederatedRun()
+	run.stage.put("pg", "postgres", []string{"merchant_id", "name"}, rowsOf(
+		map[string]interface{}{"merchant_id": 7, "name": "Toko A"},
+	))
+	run.stage.put("es", "elasticsearch", []string{"merchant_id", "level"}, rowsOf(
+		map[string]interface{}{"merchant_id": "7", "level": "ERROR"},
+	))
+
+	byName := map[string]tools.Tool{}
+	for _, tl := range stagingTools(run) {
+		byName[tl.Name] = tl
+	}
+
+	out, err := byName["join_staged_results"].Execute(context.Background(), map[string]interface{}{
+		"left": "pg", "right": "es", "left_key": "merchant_id", "stage_as": "joined",
+	})
+	if err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	var joined map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &joined); err != nil {
+		t.Fatal(err)
+	}
+	if joined["staged_as"] != "joined" || joined["row_count"].(float64) != 1 {
+		t.Errorf("unexpected join output: %s", out)
+	}
+	if run.stage.latest().Name != "joined" {
+		t.Error("join result should be the latest staged result")
+	}
+
+	out, err = byName["get_staged_values"].Execute(context.Background(), map[string]interface{}{"name": "pg", "column": "name"})
+	if err != nil || !strings.Contains(out, "Toko A") {
+		t.Errorf("get_staged_values: %s (err %v)", out, err)
+	}
+
+	out, _ = byName["list_staged_results"].Execute(context.Background(), nil)
+	for _, n := range []string{"pg", "es", "joined"} {
+		if !strings.Contains(out, `"`+n+`"`) {
+			t.Errorf("list_staged_results missing %q: %s", n, out)
+		}
+	}
+}
+
+func TestFederatedRun_RecordStats(t *testing.T) {
+	run := newFederatedRun()
+	run.record(sourceCall{source: service.DataSourcePostgres, target: "payment_db", query: "SELECT 1", rows: 3, ms: 10, stagedAs: "postgres_1"})
+	run.record(sourceCall{source: service.DataSourcePostgres, target: "payment_db", query: "SELECT 2", err: context.Canceled})
+	run.record(sourceCall{source: service.DataSourceBigQuery, query: "SELECT 3", rows: 1, bytes: 1024})
+
+	snap := run.snapshot()
+	pg := snap["postgres"]
+	if pg.Calls != 2 || pg.Errors != 1 || pg.Rows != 3 || len(pg.Targets) != 1 || len(pg.Queries) != 2 {
+		t.Errorf("unexpected postgres stats: %+v", pg)
+	}
+	if snap["bigquery"].BytesProcessed != 1024 {
+		t.Errorf("unexpected bigquery stats: %+v", snap["bigquery"])
+	}
+	if got := run.sqlQueries(); len(got) != 3 || got[0] != "SELECT 3" {
+		t.Errorf("sqlQueries = %v", got)
+	}
+}
+
+func TestFederatedExecuteTools_RunThroughQueryPolicy(t *testing.T) {
+	h := newTestFederatedHandler()
+	run := newFederatedRun()
+	policy := &tools.QueryPolicy{
+		Validator: h.sqlVal,
+		Access: &security.TableAccessPolicy{
+			Datasets:     []string{"payment_ds"},
+			Databases:    []string{"payment_db"},
+			DeniedTables: []string{"public.api_tokens"},
+		},
+	}
+	// nil services: every call must fail in the policy before reaching them
+	bq := h.bqExecuteTool(run, policy, "user_ds")
+	_, err := bq.Execute(context.Background(), map[string]interface{}{"sql": "SELECT id FROM users"})
+	if err == nil || !strings.Contains(err.Error(), "dataset user_ds") {
+		t.Errorf("unqualified table must resolve to the request dataset, got %v", err)
+	}
+	pg := h.pgExecuteTool(run, nil, []string{"payment_db"}, policy)
+	_, err = pg.Execute(context.Background(), map[string]interface{}{"database": "payment_db", "sql": "SELECT token FROM api_tokens"})
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Errorf("expected access denied, got %v", err)
+	}
+	_, err = pg.Execute(context.Background(), map[string]interface{}{"database": "payment_db", "sql": "DELETE FROM orders"})
+	if err == nil || !strings.Contains(err.Error(), "SQL validation failed") {
+		t.Errorf("expected validation error, got %v", err)
+	}
+
+	snap := run.snapshot()
+	if snap["bigquery"].Errors != 1 || snap["postgres"].Errors != 2 {
+		t.Errorf("failed calls must be recorded: %+v", snap)
+	}
+}
+
+func newTestFederatedHandler() *FederatedHandler {
+	return NewFederatedHandler(nil, nil, nil,
+		security.NewPIIDetector([]string{"password"}),
+		security.NewPromptValidator(),
+		security.NewESPromptValidator(),
+		security.NewSQLValidator(),
+		security.NewCostTracker(0),
+		security.NewPGCostTracker(0),
+		security.NewDataMasker(nil),
+		security.NewAuditLogger(false),
+	)
+}
+
+func TestFederatedHandler_AvailableSources_NoneConfigured(t *testing.T) {
+	h := newTestFederatedHandler()
+	if got := h.AvailableSources(FederatedScope{}); len(got) != 0 {
+		t.Errorf("expected no sources, got %v", got)
+	}
+}
+
+func TestFederatedHandler_AvailableSources_PersonaFilter(t *testing.T) {
+	h := newTestFederatedHandler()
+	h.pgRegistry = service.NewPGPoolRegistry()
+	h.pgRegistry.Register("payment", &service.PostgresService{})
+
+	if got := h.AvailableSources(FederatedScope{SquadID: "payment"}); len(got) != 1 || got[0] != service.DataSourcePostgres {
+		t.Errorf("expected [postgres], got %v", got)
+	}
+	if got := h.AvailableSources(FederatedScope{SquadID: "other"}); len(got) != 0 {
+		t.Errorf("squad without a PG pool should get no sources, got %v", got)
+	}
+	scope := FederatedScope{SquadID: "payment", Sources: []service.DataSource{service.DataSourceBigQuery}}
+	if got := h.AvailableSources(scope); len(got) != 0 {
+		t.Errorf("persona without postgres should get no sources, got %v", got)
+	}
+}
+
+func TestFederatedHandler_Handle_NoSources(t *testing.T) {
+	h := newTestFederatedHandler()
+	req := &models.AgentRequest{Prompt: "tampilkan merchant dengan error log kemarin dan revenue mereka", Timeout: 30}
+	resp, err := h.Handle(context.Background(), req, "key", FederatedScope{}, &mockRunner{model: "m"}, RunOptions{}, "", nil)
+	if err == nil {
+		t.Fatal("expected error with no sources configured")
+	}
+	if resp == nil || resp.Status != "error" || resp.Answer == nil {
+		t.Fatalf("expected friendly error response, got %+v", resp)
+	}
+	if resp.AgentMetadata["pii_check"] != "passed" || resp.AgentMetadata["data_source"] != "federated" {
+		t.Errorf("unexpected metadata: %v", resp.AgentMetadata)
+	}
+}
+
+func TestFederatedHandler_Handle_PIIBlocked(t *testing.T) {
+	h := newTestFederatedHandler()
+	req := &models.AgentRequest{Prompt: "show merchant password revenue", Timeout: 30}
+	_, err := h.Handle(context.Background(), req, "key", FederatedScope{}, &mockRunner{model: "m"}, RunOptions{}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "PII") {
+		t.Errorf("expected PII error, got %v", err)
+	}
+}
+
+func TestFederatedSourcesSection(t *testing.T) {
+	s := federatedSourcesSection(
+		[]service.DataSource{service.DataSourceBigQuery, service.DataSourcePostgres, service.DataSourceElasticsearch},
+		FederatedScope{Datasets: []string{"sales"}, PGDatabases: []string{"payment_db"}, ESIndexPatterns: []string{"logs-*"}},
+	)
+	for _, want := range []string{"BigQuery", "sales", "PostgreSQL", "payment_db", "Elasticsearch", "logs-*"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("sources section missing %q:\n%s", want, s)
+		}
+	}
+}
+
+func TestFederatedSystemPromptStyle(t *testing.T) {
+	if FederatedSystemPromptStyle("") != FederatedBaseSystemPrompt {
+		t.Error("empty style should return the base prompt")
+	}
+	for style, want := range map[string]string{"executive": "EXECUTIVE", "technical": "TECHNICAL", "support": "SUPPORT"} {
+		if !strings.Contains(FederatedSystemPromptStyle(style), want) {
+			t.Errorf("style %q should contain %q", style, want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	maxStagedRows    = 5000 // rows kept per staged result
+	stagePreviewRows = 50   // rows returned to the LLM per tool call
+	maxStagedValues  = 500  // distinct values returned by get_staged_values
+	maxStagedResults = 20   // staged results kept per request
+	stagedJoinSource = "join"
+)
+
+// stagedResult is an intermediate result set kept for the lifetime of one
+// federated request so later tool calls can reuse or join it. Rows are
+// already masked when they are staged.
+type stagedResult struct {
+	Name      string
+	Source    string // "bigquery", "postgres", "elasticsearch" or "join"
+	Columns   []string
+	Rows      []map[string]interface{}
+	Truncated bool // more than maxStagedRows rows were produced
+}
+
+// summary is the compact description returned by list_staged_results.
+func (r *stagedResult) summary() map[string]interface{} {
+	return map[string]interface{}{
+		"name":      r.Name,
+		"source":    r.Source,
+		"columns":   r.Columns,
+		"row_count": len(r.Rows),
+		"truncated": r.Truncated,
+	}
+}
+
+// preview is the tool output for a freshly staged result: the summary plus the
+// first stagePreviewRows rows.
+func (r *stagedResult) preview() map[string]interface{} {
+	out := r.summary()
+	out["staged_as"] = r.Name
+	out["data"] = r.Rows[:min(stagePreviewRows, len(r.Rows))]
+	if len(r.Rows) > stagePreviewRows {
+		out["note"] = fmt.Sprintf("Only the first %d rows are shown. Use get_staged_values or join_staged_results to work with the full result.", stagePreviewRows)
+	}
+	return out
+}
+
+// stagingArea holds the staged results of one federated request.
+// It is safe for concurrent use.
+type stagingArea struct {
+	mu      sync.Mutex
+	results map[string]*stagedResult
+	order   []string // insertion order; last element is the latest result
+	seq     map[string]int
+}
+
+func newStagingArea() *stagingArea {
+	return &stagingArea{
+		results: make(map[string]*stagedResult),
+		seq:     make(map[string]int),
+	}
+}
+
+// put stages rows under name, generating "<source>_<n>" when name is empty.
+// An existing result with the same name is replaced. Rows beyond maxStagedRows
+// are dropped and the result is marked truncated.
+func (s *stagingArea) put(name, source string, columns []string, rows []map[string]interface{}) (*stagedResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name == "" {
+		s.seq[source]++
+		name = fmt.Sprintf("%s_%d", source, s.seq[source])
+	}
+	if _, exists := s.results[name]; !exists && len(s.results) >= maxStagedResults {
+		return nil, fmt.Errorf("too many staged results (max %d); reuse an existing name", maxStagedResults)
+	}
+	if columns == nil {
+		columns = columnsOf(rows)
+	}
+	res := &stagedResult{Name: name, Source: source, Columns: columns, Rows: rows}
+	if len(rows) > maxStagedRows {
+		res.Rows = rows[:maxStagedRows]
+		res.Truncated = true
+	}
+
+	if _, exists := s.results[name]; exists {
+		for i, n := range s.order {
+			if n == name {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+	s.results[name] = res
+	s.order = append(s.order, name)
+	return res, nil
+}
+
+func (s *stagingArea) get(name string) (*stagedResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.results[name]
+	if !ok {
+		return nil, fmt.Errorf("no staged result named %q", name)
+	}
+	return res, nil
+}
+
+// list returns summaries of all staged results in staging order.
+func (s *stagingArea) list() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]map[string]interface{}, 0, len(s.order))
+	for _, n := range s.order {
+		out = append(out, s.results[n].summary())
+	}
+	return out
+}
+
+// latest returns the most recently staged result, or nil.
+func (s *stagingArea) latest() *stagedResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.order) == 0 {
+		return nil
+	}
+	return s.results[s.order[len(s.order)-1]]
+}
+
+// names returns the staged result names in staging order.
+func (s *stagingArea) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.order...)
+}
+
+// distinctValues returns up to limit distinct non-null values of column in
+// first-seen order.
+func distinctValues(res *stagedResult, column string, limit int) ([]interface{}, error) {
+	if !hasColumn(res, column) {
+		return nil, fmt.Errorf("staged result %q has no column %q (columns: %v)", res.Name, column, res.Columns)
+	}
+	seen := make(map[string]struct{})
+	var out []interface{}
+	for _, row := range res.Rows {
+		v, ok := row[column]
+		if !ok || v == nil {
+			continue
+		}
+		k := joinKey(v)
+		if _, dup := seen[k]; dup {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, v)
+		if len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// joinStaged hash-joins two staged results on leftKey = rightKey. Keys are
+// compared by their string form so values from different sources (e.g. a
+// BigQuery INT64 and an Elasticsearch keyword) still match. joinType is
+// "inner" (default) or "left". Right-hand columns that collide with a
+// left-hand column are renamed "<right name>.<column>".
+func joinStaged(left, right *stagedResult, leftKey, rightKey, joinType string) ([]string, []map[string]interface{}, error) {
+	if !hasColumn(left, leftKey) {
+		return nil, nil, fmt.Errorf("staged result %q has no column %q (columns: %v)", left.Name, leftKey, left.Columns)
+	}
+	if !hasColumn(right, rightKey) {
+		return nil, nil, fmt.Errorf("staged result %q has no column %q (columns: %v)", right.Name, rightKey, right.Columns)
+	}
+	switch joinType {
+	case "", "inner", "left":
+	default:
+		return nil, nil, fmt.Errorf("unsupported join_type %q (use inner or left)", joinType)
+	}
+
+	leftCols := make(map[string]struct{}, len(left.Columns))
+	for _, c := range left.Columns {
+		leftCols[c] = struct{}{}
+	}
+	columns := append([]string(nil), left.Columns...)
+	rename := make(map[string]string, len(right.Columns))
+	for _, c := range right.Columns {
+		out := c
+		if _, clash := leftCols[c]; clash {
+			out = right.Name + "." + c
+		}
+		rename[c] = out
+		columns = append(columns, out)
+	}
+
+	index := make(map[string][]map[string]interface{}, len(right.Rows))
+	for _, row := range right.Rows {
+		if v, ok := row[rightKey]; ok && v != nil {
+			k := joinKey(v)
+			index[k] = append(index[k], row)
+		}
+	}
+
+	var rows []map[string]interface{}
+	for _, l := range left.Rows {
+		var matches []map[string]interface{}
+		if v, ok := l[leftKey]; ok && v != nil {
+			matches = index[joinKey(v)]
+		}
+		if len(matches) == 0 {
+			if joinType == "left" {
+				rows = append(rows, mergeRow(l, nil, rename))
+			}
+			continue
+		}
+		for _, r := range matches {
+			rows = append(rows, mergeRow(l, r, rename))
+			if len(rows) > maxStagedRows {
+				return columns, rows, nil // put() marks the result truncated
+			}
+		}
+	}
+	return columns, rows, nil
+}
+
+func mergeRow(left, right map[string]interface{}, rename map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(left)+len(rename))
+	for k, v := range left {
+		out[k] = v
+	}
+	for orig, renamed := range rename {
+		if right == nil {
+			out[renamed] = nil
+			continue
+		}
+		out[renamed] = right[orig]
+	}
+	return out
+}
+
+func joinKey(v interface{}) string {
+	return fmt.Sprint(v)
+}
+
+func hasColumn(res *stagedResult, column string) bool {
+	for _, c := range res.Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// columnsOf derives a sorted column list from the keys of all rows, for
+// sources (Elasticsearch) whose results carry no column metadata.
+func columnsOf(rows []map[string]interface{}) []string {
+	set := make(map[string]struct{})
+	for _, row := range rows {
+		for k := range row {
+			set[k] = struct{}{}
+		}
+	}
+	cols := make([]string, 0, len(set))
+	for k := range set {
+		cols = append(cols, k)
+	}
+	sort.Strings(cols)
+	return cols
+}
@@ -0,0 +1,480 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// sourceStats is the per-source execution metadata reported in
+// agent_metadata["sources"] for federated requests.
+type sourceStats struct {
+	Calls           int      `json:"calls"`
+	Errors          int      `json:"errors,omitempty"`
+	Rows            int      `json:"rows"`
+	ExecutionTimeMs int64    `json:"execution_time_ms"`
+	BytesProcessed  int64    `json:"bytes_processed,omitempty"` // BigQuery only
+	Targets         []string `json:"targets,omitempty"`         // PG databases / ES indices queried
+	Queries         []string `json:"queries,omitempty"`         // SQL or "index: query" per call
+	StagedAs        []string `json:"staged_as,omitempty"`
+}
+
+// sourceCall describes one execute/search tool call for federatedRun.record.
+type sourceCall struct {
+	source   service.DataSource
+	target   string
+	query    string
+	rows     int
+	ms       int64
+	bytes    int64
+	stagedAs string
+	err      error
+}
+
+// federatedRun is the per-request state shared by the federated tools:
+// the staging area and per-source execution stats.
+type federatedRun struct {
+	stage *stagingArea
+
+	mu    sync.Mutex
+	stats map[service.DataSource]*sourceStats
+}
+
+func newFederatedRun() *federatedRun {
+	return &federatedRun{
+		stage: newStagingArea(),
+		stats: make(map[service.DataSource]*sourceStats),
+	}
+}
+
+func (r *federatedRun) record(c sourceCall) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.stats[c.source]
+	if !ok {
+		st = &sourceStats{}
+		r.stats[c.source] = st
+	}
+	st.Calls++
+	if c.query != "" {
+		st.Queries = append(st.Queries, c.query)
+	}
+	if c.target != "" && !containsString(st.Targets, c.target) {
+		st.Targets = append(st.Targets, c.target)
+	}
+	if c.err != nil {
+		st.Errors++
+		return
+	}
+	st.Rows += c.rows
+	st.ExecutionTimeMs += c.ms
+	st.BytesProcessed += c.bytes
+	if c.stagedAs != "" {
+		st.StagedAs = append(st.StagedAs, c.stagedAs)
+	}
+}
+
+// snapshot returns a copy of the stats keyed by data source name.
+func (r *federatedRun) snapshot() map[string]sourceStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]sourceStats, len(r.stats))
+	for src, st := range r.stats {
+		out[string(src)] = *st
+	}
+	return out
+}
+
+// sqlQueries returns every SQL statement executed, for the audit log.
+func (r *federatedRun) sqlQueries() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []string
+	for _, src := range []service.DataSource{service.DataSourceBigQuery, service.DataSourcePostgres} {
+		if st, ok := r.stats[src]; ok {
+			out = append(out, st.Queries...)
+		}
+	}
+	return out
+}
+
+var stageAsProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "Optional name to stage the result under for get_staged_values / join_staged_results (default: <source>_<n>)",
+}
+
+// stagedToolOutput marshals the preview of a freshly staged result, adding extra fields.
+func stagedToolOutput(res *stagedResult, extra map[string]interface{}) (string, error) {
+	out := res.preview()
+	for k, v := range extra {
+		out[k] = v
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return "", fmt.Errorf("marshal staged result: %w", err)
+	}
+	return string(b), nil
+}
+
+// bqExecuteTool is the federated execute_bigquery_sql: the SQL runs through
+// policy like the single-source tool, with unqualified tables resolved
+// against datasetID, and the masked rows are staged.
+func (h *FederatedHandler) bqExecuteTool(run *federatedRun, policy *tools.QueryPolicy, datasetID string) tools.Tool {
+	return tools.Tool{
+		Name:        "execute_bigquery_sql",
+		Description: "Execute a SQL SELECT query on BigQuery. The result is staged for later joins; the first rows are returned.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"sql": map[string]interface{}{
+					"type":        "string",
+					"description": "The SQL SELECT query to execute",
+				},
+				"stage_as": stageAsProperty,
+			},
+			"required": []string{"sql"},
+		},
+		Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+			sql, _ := input["sql"].(string)
+			stageAs, _ := input["stage_as"].(string)
+			if sql == "" {
+				return "", fmt.Errorf("sql is required")
+			}
+			call := sourceCall{source: service.DataSourceBigQuery, query: sql}
+			fail := func(err error) (string, error) {
+				call.err = err
+				run.record(call)
+				return "", err
+			}
+
+			result, err := policy.RunBigQuery(ctx, h.bq, "execute_bigquery_sql", sql, datasetID, 60000)
+			if err != nil {
+				return fail(err)
+			}
+			staged, err := run.stage.put(stageAs, string(service.DataSourceBigQuery), result.Columns, result.Data)
+			if err != nil {
+				return fail(err)
+			}
+			call.rows, call.ms, call.bytes, call.stagedAs = len(staged.Rows), result.ExecutionTimeMs, result.TotalBytesProcessed, staged.Name
+			run.record(call)
+			return stagedToolOutput(staged, map[string]interface{}{"bytes_processed": result.TotalBytesProcessed})
+		},
+	}
+}
+
+// pgExecuteTool is the federated execute_postgres_sql: like the single-source
+// tool, running the SQL through policy, but with a database parameter, and
+// the masked rows are staged.
+func (h *FederatedHandler) pgExecuteTool(run *federatedRun, pgSvc *service.PostgresService, allowedDatabases []string, policy *tools.QueryPolicy) tools.Tool {
+	return tools.Tool{
+		Name:        "execute_postgres_sql",
+		Description: "Execute a read-only SELECT query against a PostgreSQL database. The result is staged for later joins; the first rows are returned.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"database": databaseProperty,
+				"sql": map[string]interface{}{
+					"type":        "string",
+					"description": "The SQL SELECT query to execute",
+				},
+				"stage_as": stageAsProperty,
+			},
+			"required": []string{"database", "sql"},
+		},
+		Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+			dbName, _ := input["database"].(string)
+			sql, _ := input["sql"].(string)
+			stageAs, _ := input["stage_as"].(string)
+			if dbName == "" || sql == "" {
+				return "", fmt.Errorf("database and sql are required")
+			}
+			if len(allowedDatabases) > 0 && !isDatabaseAllowed(dbName, allowedDatabases) {
+				return "", fmt.Errorf("database '%s' is not accessible for your squad", dbName)
+			}
+			call := sourceCall{source: service.DataSourcePostgres, target: dbName, query: sql}
+			fail := func(err error) (string, error) {
+				call.err = err
+				run.record(call)
+				return "", err
+			}
+
+			queryStart := time.Now()
+			result, err := policy.RunPostgres(ctx, pgSvc, "execute_postgres_sql", dbName, sql, 60000)
+			if err != nil {
+				return fail(err)
+			}
+			call.ms = time.Since(queryStart).Milliseconds()
+			staged, err := run.stage.put(stageAs, string(service.DataSourcePostgres), result.Columns, result.Data)
+			if err != nil {
+				return fail(err)
+			}
+			call.rows, call.stagedAs = len(staged.Rows), staged.Name
+			run.record(call)
+			return stagedToolOutput(staged, nil)
+		},
+	}
+}
+
+// esSearchTool is the federated elasticsearch_search: hits are flattened into
+// rows (_id, _index and dotted _source fields), masked and staged.
+func (h *FederatedHandler) esSearchTool(run *federatedRun, esSvc *service.ElasticsearchService) tools.Tool {
+	return tools.Tool{
+		Name:        "elasticsearch_search",
+		Description: "Search documents in Elasticsearch using Query DSL. Hits are flattened into rows (_id, _index and dotted _source fields) and staged for later joins.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"index": map[string]interface{}{
+					"type":        "string",
+					"description": "Index pattern to search (e.g., 'logs-*', 'hc-upg-k8s-prd-*')",
+				},
+				"query": map[string]interface{}{
+					"type":        "object",
+					"description": "Elasticsearch Query DSL object",
+				},
+				"size": map[string]interface{}{
+					"type":        "integer",
+					"description": "Number of results to return (default: 10, max: 100)",
+				},
+				"stage_as": stageAsProperty,
+			},
+			"required": []string{"index"},
+		},
+		Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+			index, _ := input["index"].(string)
+			stageAs, _ := input["stage_as"].(string)
+			if index == "" {
+				return "", fmt.Errorf("index is required")
+			}
+			size := 10
+			if s, ok := input["size"].(float64); ok {
+				size = int(s)
+			}
+			if size > 100 {
+				size = 100
+			}
+			req := &models.SearchRequest{Index: index, Size: size}
+			if q, ok := input["query"].(map[string]interface{}); ok {
+				req.Query = q
+			}
+
+			call := sourceCall{source: service.DataSourceElasticsearch, target: index, query: index}
+			if b, err := json.Marshal(req.Query); err == nil && req.Query != nil {
+				call.query = index + ": " + string(b)
+			}
+
+			searchStart := time.Now()
+			resp, err := esSvc.Search(ctx, req)
+			if err != nil {
+				call.err = err
+				run.record(call)
+				return "", fmt.Errorf("es search: %w", err)
+			}
+			call.ms = time.Since(searchStart).Milliseconds()
+
+			staged, err := run.stage.put(stageAs, string(service.DataSourceElasticsearch), nil, h.dataMasker.MaskRows(esHitsToRows(resp.Hits)))
+			if err != nil {
+				call.err = err
+				run.record(call)
+				return "", err
+			}
+			call.rows, call.stagedAs = len(staged.Rows), staged.Name
+			run.record(call)
+			return stagedToolOutput(staged, map[string]interface{}{"total_hits": resp.TotalHits})
+		},
+	}
+}
+
+// esHitsToRows flattens search hits into rows: _id, _index and the _source
+// fields, with nested objects flattened to dotted keys ("customer.email") so
+// they can be joined on and masked like SQL columns.
+func esHitsToRows(hits []map[string]interface{}) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		row := make(map[string]interface{})
+		if id, ok := hit["_id"]; ok {
+			row["_id"] = id
+		}
+		if idx, ok := hit["_index"]; ok {
+			row["_index"] = idx
+		}
+		if src, ok := hit["_source"].(map[string]interface{}); ok {
+			flattenInto(row, "", src)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func flattenInto(dst map[string]interface{}, prefix string, src map[string]interface{}) {
+	for k, v := range src {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenInto(dst, key, nested)
+			continue
+		}
+		dst[key] = v
+	}
+}
+
+var databaseProperty = map[string]interface{}{
+	"type":        "string",
+	"description": "The PostgreSQL database name (see list_postgres_databases)",
+}
+
+// withDatabaseParam adapts a single-database PostgreSQL tool (bound to one
+// dbName at construction) into a tool that takes the database as an input
+// parameter, enforcing the squad's database allow-list.
+func withDatabaseParam(build func(dbName string) tools.Tool, allowedDatabases []string) tools.Tool {
+	t := build("")
+	props := map[string]interface{}{"database": databaseProperty}
+	if orig, ok := t.InputSchema["properties"].(map[string]interface{}); ok {
+		for k, v := range orig {
+			props[k] = v
+		}
+	}
+	required := []string{"database"}
+	if orig, ok := t.InputSchema["required"].([]string); ok {
+		required = append(required, orig...)
+	}
+	t.InputSchema = map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   required,
+	}
+	t.Execute = func(ctx context.Context, input map[string]interface{}) (string, error) {
+		dbName, _ := input["database"].(string)
+		if dbName == "" {
+			return "", fmt.Errorf("database is required")
+		}
+		if len(allowedDatabases) > 0 && !isDatabaseAllowed(dbName, allowedDatabases) {
+			return "", fmt.Errorf("database '%s' is not accessible for your squad", dbName)
+		}
+		return build(dbName).Execute(ctx, input)
+	}
+	return t
+}
+
+// stagingTools returns the tools that inspect and combine staged results.
+func stagingTools(run *federatedRun) []tools.Tool {
+	return []tools.Tool{
+		{
+			Name:        "list_staged_results",
+			Description: "List the intermediate results staged so far in this request (name, source, columns, row count).",
+			InputSchema: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{},
+				"required":   []string{},
+			},
+			Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+				b, err := json.Marshal(run.stage.list())
+				if err != nil {
+					return "", err
+				}
+				return string(b), nil
+			},
+		},
+		{
+			Name:        "get_staged_values",
+			Description: fmt.Sprintf("Get the distinct values of one column of a staged result (max %d), e.g. to filter the next query with IN (...).", maxStagedValues),
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"name": map[string]interface{}{
+						"type":        "string",
+						"description": "Staged result name",
+					},
+					"column": map[string]interface{}{
+						"type":        "string",
+						"description": "Column to read",
+					},
+				},
+				"required": []string{"name", "column"},
+			},
+			Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+				name, _ := input["name"].(string)
+				column, _ := input["column"].(string)
+				res, err := run.stage.get(name)
+				if err != nil {
+					return "", err
+				}
+				values, err := distinctValues(res, column, maxStagedValues)
+				if err != nil {
+					return "", err
+				}
+				b, err := json.Marshal(map[string]interface{}{
+					"name":   name,
+					"column": column,
+					"count":  len(values),
+					"values": values,
+				})
+				if err != nil {
+					return "", err
+				}
+				return string(b), nil
+			},
+		},
+		{
+			Name:        "join_staged_results",
+			Description: "Join two staged results on a key column (values compared as text, so IDs from different sources match). The joined result is staged too.",
+			InputSchema: map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"left":      map[string]interface{}{"type": "string", "description": "Left staged result name"},
+					"right":     map[string]interface{}{"type": "string", "description": "Right staged result name"},
+					"left_key":  map[string]interface{}{"type": "string", "description": "Join column in the left result"},
+					"right_key": map[string]interface{}{"type": "string", "description": "Join column in the right result (default: same as left_key)"},
+					"join_type": map[string]interface{}{"type": "string", "description": "inner (default) or left"},
+					"stage_as":  stageAsProperty,
+				},
+				"required": []string{"left", "right", "left_key"},
+			},
+			Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+				leftName, _ := input["left"].(string)
+				rightName, _ := input["right"].(string)
+				leftKey, _ := input["left_key"].(string)
+				rightKey, _ := input["right_key"].(string)
+				joinType, _ := input["join_type"].(string)
+				stageAs, _ := input["stage_as"].(string)
+				if rightKey == "" {
+					rightKey = leftKey
+				}
+				left, err := run.stage.get(leftName)
+				if err != nil {
+					return "", err
+				}
+				right, err := run.stage.get(rightName)
+				if err != nil {
+					return "", err
+				}
+				columns, rows, err := joinStaged(left, right, leftKey, rightKey, joinType)
+				if err != nil {
+					return "", err
+				}
+				staged, err := run.stage.put(stageAs, stagedJoinSource, columns, rows)
+				if err != nil {
+					return "", err
+				}
+				return stagedToolOutput(staged, nil)
+			},
+		},
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+// maxGlossaryTerms caps the glossary terms injected into one system prompt.
+const maxGlossaryTerms = 10
+
+// GlossaryInstruction introduces the glossary section of the system prompt.
+const GlossaryInstruction = "The question uses these business terms. Use the definitions below instead of your own: when a term has SQL, use that expression as written, and join tables as the join hints say."
+
+// promptGlossary returns the glossary section of the system prompt for req:
+// the terms of squadID's glossary in scope that the prompt or its history
+// mention. The names of the injected terms are recorded in metadata.
+func promptGlossary(g *service.Glossary, squadID, scope string, req *models.AgentRequest, metadata map[string]interface{}) string {
+	terms := g.Match(squadID, scope, schemaQuery(req))
+	if len(terms) == 0 {
+		return ""
+	}
+	terms = terms[:min(len(terms), maxGlossaryTerms)]
+	names := make([]string, len(terms))
+	for i, t := range terms {
+		names[i] = t.Term
+	}
+	metadata["glossary_terms"] = names
+	return glossarySection(terms)
+}
+
+// glossarySection renders glossary terms for the system prompt.
+func glossarySection(terms []service.GlossaryTerm) string {
+	var sb strings.Builder
+	sb.WriteString("\n\n## Business Glossary\n")
+	sb.WriteString(GlossaryInstruction + "\n")
+	for _, t := range terms {
+		sb.WriteString("\n### " + t.Term)
+		if len(t.Synonyms) > 0 {
+			sb.WriteString(" (also: " + strings.Join(t.Synonyms, ", ") + ")")
+		}
+		sb.WriteString("\n")
+		if t.Definition != "" {
+			sb.WriteString("Definition: " + t.Definition + "\n")
+		}
+		if t.SQL != "" {
+			sb.WriteString("SQL: " + t.SQL + "\n")
+		}
+		for _, hint := range t.JoinHints {
+			sb.WriteString("Join: " + hint + "\n")
+		}
+	}
+	return sb.String()
+}
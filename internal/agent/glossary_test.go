@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+func TestPromptGlossary_InjectsMentionedTerms(t *testing.T) {
+	g := service.NewGlossary([]service.GlossaryTerm{
+		{Term: "GMV", Synonyms: []string{"nilai transaksi"}, SQL: "SUM(o.amount)", JoinHints: []string{"orders.driver_id = drivers.id"}},
+		{Term: "Churn", Definition: "Customers without an order in 60 days"},
+	}, nil)
+
+	metadata := map[string]interface{}{}
+	got := promptGlossary(g, "", "sales", &models.AgentRequest{Prompt: "nilai transaksi per driver"}, metadata)
+	for _, want := range []string{
+		"\n\n## Business Glossary\n" + GlossaryInstruction + "\n",
+		"### GMV (also: nilai transaksi)\nSQL: SUM(o.amount)\nJoin: orders.driver_id = drivers.id\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("section missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Churn") {
+		t.Errorf("unmentioned term injected:\n%s", got)
+	}
+	if !reflect.DeepEqual(metadata["glossary_terms"], []string{"GMV"}) {
+		t.Errorf("glossary_terms = %v", metadata["glossary_terms"])
+	}
+
+	metadata = map[string]interface{}{}
+	if got := promptGlossary(g, "", "sales", &models.AgentRequest{Prompt: "jumlah order hari ini"}, metadata); got != "" || len(metadata) != 0 {
+		t.Errorf("no term mentioned: section %q, metadata %v", got, metadata)
+	}
+	if got := promptGlossary(nil, "", "sales", &models.AgentRequest{Prompt: "GMV"}, metadata); got != "" {
+		t.Errorf("nil glossary: section %q", got)
+	}
+}
+
+func TestPromptGlossary_CapsTerms(t *testing.T) {
+	var terms []service.GlossaryTerm
+	var words []string
+	for i := 0; i < maxGlossaryTerms+5; i++ {
+		terms = append(terms, service.GlossaryTerm{Term: fmt.Sprintf("metric%d", i), Definition: "d"})
+		words = append(words, fmt.Sprintf("metric%d", i))
+	}
+	metadata := map[string]interface{}{}
+	got := promptGlossary(service.NewGlossary(terms, nil), "", "", &models.AgentRequest{Prompt: strings.Join(words, " ")}, metadata)
+	if n := strings.Count(got, "\n### "); n != maxGlossaryTerms {
+		t.Errorf("injected %d terms, want %d", n, maxGlossaryTerms)
+	}
+	if names := metadata["glossary_terms"].([]string); len(names) != maxGlossaryTerms {
+		t.Errorf("glossary_terms has %d names", len(names))
+	}
+}
+
+func TestResponseCacheScope_GlossaryAndSquad(t *testing.T) {
+	g1 := service.NewGlossary([]service.GlossaryTerm{{Term: "GMV", SQL: "SUM(o.amount)"}}, nil)
+	g2 := service.NewGlossary([]service.GlossaryTerm{{Term: "GMV", SQL: "SUM(o.amount) - SUM(o.discount)"}}, nil)
+
+	base := responseCacheScope("sales", "squad-a", nil, g1, nil)
+	if base != responseCacheScope("sales", "squad-a", nil, g1, nil) {
+		t.Error("scope must be deterministic")
+	}
+	if base == responseCacheScope("sales", "squad-a", nil, g2, nil) {
+		t.Error("answers built on another glossary must not share a scope")
+	}
+	if base == responseCacheScope("sales", "squad-b", nil, g1, nil) {
+		t.Error("squads with their own glossary terms must not share a scope")
+	}
+}
@@ -2,7 +2,10 @@ package agent
 
 import (
 	"context"
+	"time"
+	"unicode/utf8"
 
+	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/tools"
 )
 
@@ -11,12 +14,169 @@ import (
 // ElasticsearchHandler without coupling them to a specific provider SDK.
 type LLMRunner interface {
 	// Run executes the agent loop.
-	// Returns (finalText, toolsUsed, lastExecutedSQL, error).
-	Run(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool) (string, []string, string, error)
+	// history holds prior turns of the same conversation (oldest first) and is
+	// replayed as alternating user/assistant messages before userPrompt; nil
+	// starts a fresh conversation.
+	// opts tunes the loop for the caller's persona; the zero value uses the
+	// runner defaults.
+	// The result is non-nil even when err is set, holding the iterations
+	// completed before the failure.
+	Run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions) (*RunResult, error)
 
 	// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call.
-	RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, agentTools []tools.Tool, emitFn EmitFn) (string, []string, string, error)
+	// Runners that support it stream the LLM output and emit a "text_delta"
+	// event ({"text", "iteration"}) per chunk as it arrives.
+	RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error)
 
 	// Model returns the model identifier used by this runner.
 	Model() string
 }
+
+// Defaults of the agent loop, used for zero RunOptions fields.
+const (
+	DefaultMaxTokens       = 4096
+	DefaultMaxIterations   = 10
+	DefaultForceFinalAfter = 7
+)
+
+// RunOptions tunes one agent run. Runners are shared by every persona using
+// the same provider and model, so per-persona settings travel with each run
+// instead of living on the runner. Zero fields use the defaults above or the
+// provider's.
+type RunOptions struct {
+	MaxTokens     int // output tokens per LLM call
+	MaxIterations int // LLM calls that may request tools
+	// ForceFinalAfter is the iteration after whose tool calls the model is
+	// told to answer without tools. It is capped at MaxIterations-1 so a run
+	// always ends with an answer; -1 never forces one.
+	ForceFinalAfter int
+	Temperature     *float64 // nil = provider default
+	StopSequences   []string
+	// ToolConcurrency bounds the tool calls of one iteration that run at
+	// once; 1 runs them one after another.
+	ToolConcurrency int
+	// ToolTimeout bounds each tool call without its own tools.Tool.Timeout;
+	// negative means no limit.
+	ToolTimeout time.Duration
+	// NoPromptCache turns off prompt caching: Anthropic cache_control
+	// breakpoints on the system prompt and tools, and keeping the tools in
+	// the forced final call so the cached prefix stays valid.
+	NoPromptCache bool
+}
+
+func (o RunOptions) toolConcurrency() int {
+	if o.ToolConcurrency > 0 {
+		return o.ToolConcurrency
+	}
+	return DefaultToolConcurrency
+}
+
+func (o RunOptions) toolTimeout() time.Duration {
+	if o.ToolTimeout == 0 {
+		return DefaultToolTimeout
+	}
+	return o.ToolTimeout
+}
+
+func (o RunOptions) maxTokens() int {
+	if o.MaxTokens > 0 {
+		return o.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+func (o RunOptions) maxIterations() int {
+	if o.MaxIterations > 0 {
+		return o.MaxIterations
+	}
+	return DefaultMaxIterations
+}
+
+// forceFinalAt returns the iteration at which the final answer is forced, or
+// -1 for none.
+func (o RunOptions) forceFinalAt() int {
+	at := o.ForceFinalAfter
+	switch {
+	case at < 0:
+		return -1
+	case at == 0:
+		at = DefaultForceFinalAfter
+	}
+	if last := o.maxIterations() - 1; at > last {
+		at = last
+	}
+	return at
+}
+
+// RunResult is the outcome of one agent loop.
+type RunResult struct {
+	Text      string   // final answer text
+	ToolsUsed []string // tool names in call order
+	// LastSQL is the last SQL passed to execute_bigquery_sql or
+	// execute_postgres_sql — used as fallback when the model doesn't include
+	// a ```sql block in its final reply.
+	LastSQL string
+	Model   string // model that produced the result
+	// Runner and Failovers are set by FailoverRunner: the pool key of the
+	// runner that produced the result and the runners that failed before it.
+	Runner     string
+	Failovers  []models.RunnerFailure
+	Iterations []models.AgentIteration
+	StopReason string // of the last LLM call
+	Usage      models.TokenUsage
+	LatencyMs  int64
+}
+
+// maxTraceToolOutput bounds the tool output kept per call in a RunResult.
+const maxTraceToolOutput = 2000
+
+// addIteration records an LLM call.
+func (r *RunResult) addIteration(iter int, text, stopReason string, usage models.TokenUsage, latency time.Duration) {
+	r.Iterations = append(r.Iterations, models.AgentIteration{
+		Iteration:  iter,
+		Text:       text,
+		StopReason: stopReason,
+		Usage:      usage,
+		LatencyMs:  latency.Milliseconds(),
+	})
+	r.StopReason = stopReason
+	r.Usage.Add(usage)
+}
+
+// addToolCall records a tool call of the last iteration, with its output
+// truncated to maxTraceToolOutput.
+func (r *RunResult) addToolCall(tc ToolCall, output string, isErr bool, latency time.Duration) {
+	r.ToolsUsed = append(r.ToolsUsed, tc.Name)
+	if tc.Name == "execute_bigquery_sql" || tc.Name == "execute_postgres_sql" {
+		if sql, ok := tc.Input["sql"].(string); ok && sql != "" {
+			r.LastSQL = sql
+		}
+	}
+	if len(r.Iterations) == 0 {
+		return
+	}
+	call := models.AgentToolCall{
+		ID:        tc.ID,
+		Name:      tc.Name,
+		Input:     tc.Input,
+		Output:    output,
+		IsError:   isErr,
+		LatencyMs: latency.Milliseconds(),
+	}
+	if len(output) > maxTraceToolOutput {
+		call.Output, call.OutputTruncated = truncateUTF8(output, maxTraceToolOutput), true
+	}
+	it := &r.Iterations[len(r.Iterations)-1]
+	it.ToolCalls = append(it.ToolCalls, call)
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
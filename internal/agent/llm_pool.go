@@ -1,20 +1,61 @@
 package agent
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // LLMPool manages multiple LLMRunner instances keyed by "provider:model".
 // Multiple personas can share the same LLMRunner if they use identical provider+model.
+// A key can be given a failover chain (SetChain); its runners then share one
+// circuit breaker per key across all chains.
 // The pool is written once at startup and read-only during request handling,
 // so no mutex is required.
 type LLMPool struct {
 	runners  map[string]LLMRunner
 	fallback LLMRunner // used when a key is not found
+
+	chains           map[string][]string // key → fallback keys, in order
+	breakers         map[string]*circuitBreaker
+	breakerThreshold int
+	breakerCooldown  time.Duration
 }
 
 // NewLLMPool creates an empty pool.
 func NewLLMPool() *LLMPool {
 	return &LLMPool{
-		runners: make(map[string]LLMRunner),
+		runners:          make(map[string]LLMRunner),
+		chains:           make(map[string][]string),
+		breakers:         make(map[string]*circuitBreaker),
+		breakerThreshold: defaultBreakerThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+	}
+}
+
+// SetChain makes Get(key) return a FailoverRunner that tries key's runner
+// and then the runners of fallbacks, in order. Keys must be registered
+// before Get is called; unregistered keys are skipped.
+func (p *LLMPool) SetChain(key string, fallbacks ...string) {
+	p.chains[key] = fallbacks
+	for _, k := range append([]string{key}, fallbacks...) {
+		if _, ok := p.breakers[k]; !ok {
+			p.breakers[k] = newCircuitBreaker(p.breakerThreshold, p.breakerCooldown)
+		}
+	}
+}
+
+// SetCircuitBreaker configures the breakers of failover chains: a runner is
+// skipped for cooldown after threshold consecutive provider failures.
+// Values <= 0 keep the defaults (3 failures, 30s).
+func (p *LLMPool) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	if threshold > 0 {
+		p.breakerThreshold = threshold
+	}
+	if cooldown > 0 {
+		p.breakerCooldown = cooldown
+	}
+	for _, b := range p.breakers {
+		b.threshold, b.cooldown = p.breakerThreshold, p.breakerCooldown
 	}
 }
 
@@ -29,16 +70,47 @@ func (p *LLMPool) SetFallback(runner LLMRunner) {
 	p.fallback = runner
 }
 
-// Get returns the runner for the given key.
+// Get returns the runner for the given key, or a FailoverRunner when a chain
+// is configured for it.
 // If the key is not registered, it returns the fallback runner.
 // Returns nil only when the key is not found AND no fallback is set.
 func (p *LLMPool) Get(key string) LLMRunner {
+	if fallbacks, ok := p.chains[key]; ok {
+		if f := p.failoverRunner(append([]string{key}, fallbacks...)); f != nil {
+			return f
+		}
+	}
 	if r, ok := p.runners[key]; ok {
 		return r
 	}
 	return p.fallback
 }
 
+// failoverRunner builds the FailoverRunner over the registered keys of chain,
+// or returns nil when none is registered.
+func (p *LLMPool) failoverRunner(chain []string) LLMRunner {
+	f := &FailoverRunner{}
+	for _, k := range chain {
+		r, ok := p.runners[k]
+		if !ok {
+			continue
+		}
+		f.keys = append(f.keys, k)
+		f.runners = append(f.runners, r)
+		f.breakers = append(f.breakers, p.breakers[k])
+	}
+	if len(f.runners) == 0 {
+		return nil
+	}
+	return f
+}
+
+// Has reports whether a runner is registered under key.
+func (p *LLMPool) Has(key string) bool {
+	_, ok := p.runners[key]
+	return ok
+}
+
 // HasRunners returns true if at least one runner is registered or a fallback is set.
 func (p *LLMPool) HasRunners() bool {
 	return len(p.runners) > 0 || p.fallback != nil
@@ -4,17 +4,18 @@ import (
 	"context"
 	"testing"
 
+	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/tools"
 )
 
 // mockRunner is a minimal LLMRunner for testing the pool.
 type mockRunner struct{ model string }
 
-func (m *mockRunner) Run(_ context.Context, _, _ string, _ []tools.Tool) (string, []string, string, error) {
-	return "", nil, "", nil
+func (m *mockRunner) Run(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ RunOptions) (*RunResult, error) {
+	return &RunResult{}, nil
 }
-func (m *mockRunner) RunWithEmit(_ context.Context, _, _ string, _ []tools.Tool, _ EmitFn) (string, []string, string, error) {
-	return "", nil, "", nil
+func (m *mockRunner) RunWithEmit(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ RunOptions, _ EmitFn) (*RunResult, error) {
+	return &RunResult{}, nil
 }
 func (m *mockRunner) Model() string { return m.model }
 
@@ -0,0 +1,337 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/tools"
+	"github.com/rs/zerolog/log"
+)
+
+// OllamaAgent implements LLMRunner for Ollama's native /api/chat endpoint, for
+// self-hosted and air-gapped models. Ollama has no API key; apiKey is sent as
+// a bearer token for deployments behind an authenticating proxy.
+type OllamaAgent struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// NewOllamaAgent creates an OllamaAgent. baseURL defaults to
+// "http://localhost:11434".
+func NewOllamaAgent(apiKey, model, baseURL string) *OllamaAgent {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &OllamaAgent{
+		apiKey:  apiKey,
+		model:   model,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		// Local models are much slower than hosted APIs.
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+		retry:      DefaultRetryPolicy,
+	}
+}
+
+// SetRetryPolicy sets how failed chat calls are retried.
+func (a *OllamaAgent) SetRetryPolicy(p RetryPolicy) {
+	a.retry = p
+}
+
+// Model returns the configured model identifier.
+func (a *OllamaAgent) Model() string { return a.model }
+
+// Run executes the agent loop (no streaming events).
+func (a *OllamaAgent) Run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, nil)
+}
+
+// RunWithEmit is like Run but calls emitFn at each LLM iteration and tool call,
+// and streams the reply, emitting "text_delta" events as tokens arrive.
+func (a *OllamaAgent) RunWithEmit(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	return a.run(ctx, systemPrompt, userPrompt, history, agentTools, opts, emitFn)
+}
+
+// ── Ollama wire types ────────────────────────────────────────────────────────
+
+// ollamaMessage differs from the OpenAI format in its tool calls: they have
+// no IDs and their arguments are a JSON object rather than a string. Tool
+// results name the tool instead of referencing a call ID.
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function ollamaFunctionCall `json:"function"`
+}
+
+type ollamaFunctionCall struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []dsTool        `json:"tools,omitempty"` // same format as OpenAI
+	Stream   bool            `json:"stream"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+type ollamaOptions struct {
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+}
+
+// ollamaChatResponse is a complete reply, or one line of a streamed reply;
+// the last line has Done set and carries the token counts.
+type ollamaChatResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int64         `json:"prompt_eval_count"`
+	EvalCount       int64         `json:"eval_count"`
+	Error           string        `json:"error,omitempty"`
+}
+
+func (r *ollamaChatResponse) tokenUsage() models.TokenUsage {
+	return models.TokenUsage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount}
+}
+
+// buildOllamaMessages replays history like buildConversationMessages.
+func buildOllamaMessages(systemPrompt, userPrompt string, history []models.ConversationTurn) []ollamaMessage {
+	messages := make([]ollamaMessage, 0, 2*len(history)+2)
+	if systemPrompt != "" {
+		messages = append(messages, ollamaMessage{Role: "system", Content: systemPrompt})
+	}
+	for _, turn := range history {
+		messages = append(messages,
+			ollamaMessage{Role: "user", Content: turn.Prompt},
+			ollamaMessage{Role: "assistant", Content: historyAssistantText(turn)},
+		)
+	}
+	return append(messages, ollamaMessage{Role: "user", Content: userPrompt})
+}
+
+// ── Core agent loop ──────────────────────────────────────────────────────────
+
+func (a *OllamaAgent) run(ctx context.Context, systemPrompt, userPrompt string, history []models.ConversationTurn, agentTools []tools.Tool, opts RunOptions, emitFn EmitFn) (*RunResult, error) {
+	res := &RunResult{Model: a.model}
+	start := time.Now()
+	defer func() { res.LatencyMs = time.Since(start).Milliseconds() }()
+
+	ollamaTools := convertToOpenAITools(agentTools)
+	messages := buildOllamaMessages(systemPrompt, userPrompt, history)
+
+	maxIter := opts.maxIterations()
+	forceFinalAt := opts.forceFinalAt()
+
+	for iter := 0; iter < maxIter; iter++ {
+		if emitFn != nil {
+			emitFn("llm_call", map[string]interface{}{"iteration": iter})
+		}
+
+		callStart := time.Now()
+		resp, err := a.chat(ctx, messages, ollamaTools, opts, emitFn, iter)
+		if err != nil {
+			return res, fmt.Errorf("LLM call failed: %w", err)
+		}
+		msg := resp.Message
+		stopReason := resp.DoneReason
+		if len(msg.ToolCalls) > 0 {
+			stopReason = "tool_calls"
+		}
+		log.Debug().
+			Int("iter", iter).
+			Str("done_reason", resp.DoneReason).
+			Int("tool_calls", len(msg.ToolCalls)).
+			Msg("ollama iteration")
+		res.addIteration(iter, msg.Content, stopReason, resp.tokenUsage(), time.Since(callStart))
+
+		if resp.DoneReason == "length" || len(msg.ToolCalls) == 0 {
+			res.Text = msg.Content
+			return res, nil
+		}
+
+		// Force final answer after forceFinalAt iterations to avoid runaway loops
+		if forceFinalAt >= 0 && iter >= forceFinalAt {
+			messages = append(messages, msg, ollamaMessage{
+				Role:    "user",
+				Content: "You have enough data. Please provide your final answer now without calling any more tools.",
+			})
+			callStart := time.Now()
+			finalResp, finalErr := a.chat(ctx, messages, nil, opts, emitFn, iter+1)
+			if finalErr != nil {
+				res.Text = msg.Content
+				return res, fmt.Errorf("final answer call failed: %w", finalErr)
+			}
+			res.addIteration(iter+1, finalResp.Message.Content, finalResp.DoneReason, finalResp.tokenUsage(), time.Since(callStart))
+			res.Text = msg.Content + finalResp.Message.Content
+			return res, nil
+		}
+
+		messages = append(messages, msg)
+		calls := make([]ToolCall, len(msg.ToolCalls))
+		for i, tc := range msg.ToolCalls {
+			input := tc.Function.Arguments
+			if input == nil {
+				input = map[string]interface{}{}
+			}
+			if emitFn != nil {
+				emitFn("tool_call", toolCallEventData(tc.Function.Name, input, iter))
+			}
+			calls[i] = ToolCall{ID: fmt.Sprintf("call_%d_%d", iter, i), Name: tc.Function.Name, Input: input}
+		}
+		outcomes := executeToolCalls(ctx, calls, agentTools, opts)
+		for i, call := range calls {
+			out := outcomes[i]
+			res.addToolCall(call, out.output, out.isErr, out.latency)
+			messages = append(messages, ollamaMessage{Role: "tool", Content: out.output, ToolName: call.Name})
+		}
+	}
+
+	return res, fmt.Errorf("agent loop exceeded max iterations (%d)", maxIter)
+}
+
+// chat sends one chat request, retried per a.retry. With emitFn the reply is
+// streamed and a "text_delta" event is emitted per content chunk; a stream
+// that fails after text was emitted is not retried.
+func (a *OllamaAgent) chat(ctx context.Context, messages []ollamaMessage, ollamaTools []dsTool, opts RunOptions, emitFn EmitFn, iter int) (*ollamaChatResponse, error) {
+	return retryCall(ctx, a.retry, func() (*ollamaChatResponse, error) {
+		if emitFn == nil {
+			return a.callAPI(ctx, messages, ollamaTools, opts)
+		}
+		emitted := false
+		resp, err := a.callAPIStream(ctx, messages, ollamaTools, opts, func(text string) {
+			emitFn("text_delta", map[string]interface{}{"text": text, "iteration": iter})
+			emitted = true
+		})
+		if err != nil && emitted {
+			return nil, &noRetryError{err}
+		}
+		return resp, err
+	})
+}
+
+// post sends a chat request and returns the response once its status is
+// 200 OK.
+func (a *OllamaAgent) post(ctx context.Context, messages []ollamaMessage, ollamaTools []dsTool, opts RunOptions, stream bool) (*http.Response, error) {
+	body, err := json.Marshal(ollamaChatRequest{
+		Model:    a.model,
+		Messages: messages,
+		Tools:    ollamaTools,
+		Stream:   stream,
+		Options: ollamaOptions{
+			NumPredict:  opts.maxTokens(),
+			Temperature: opts.Temperature,
+			Stop:        opts.StopSequences,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiKey)
+	}
+
+	client := a.httpClient
+	if stream {
+		client = streamingClient(client)
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http request: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		respBytes, _ := io.ReadAll(httpResp.Body)
+		return nil, &APIError{StatusCode: httpResp.StatusCode, Body: string(respBytes)}
+	}
+	return httpResp, nil
+}
+
+func (a *OllamaAgent) callAPI(ctx context.Context, messages []ollamaMessage, ollamaTools []dsTool, opts RunOptions) (*ollamaChatResponse, error) {
+	httpResp, err := a.post(ctx, messages, ollamaTools, opts, false)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var resp ollamaChatResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("API error: %s", resp.Error)
+	}
+	return &resp, nil
+}
+
+// callAPIStream requests a streamed reply (one JSON object per line) and
+// assembles it into one response: content is concatenated and passed to
+// onText as it arrives, tool calls are collected, and the token counts are
+// taken from the final line. A stream that ends without its "done": true
+// line fails with errStreamTruncated.
+func (a *OllamaAgent) callAPIStream(ctx context.Context, messages []ollamaMessage, ollamaTools []dsTool, opts RunOptions, onText func(string)) (*ollamaChatResponse, error) {
+	httpResp, err := a.post(ctx, messages, ollamaTools, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	var content strings.Builder
+	out := &ollamaChatResponse{Message: ollamaMessage{Role: "assistant"}}
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("unmarshal stream chunk: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("API error: %s", chunk.Error)
+		}
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			onText(chunk.Message.Content)
+		}
+		out.Message.ToolCalls = append(out.Message.ToolCalls, chunk.Message.ToolCalls...)
+		if chunk.Done {
+			out.Done, out.DoneReason = true, chunk.DoneReason
+			out.PromptEvalCount, out.EvalCount = chunk.PromptEvalCount, chunk.EvalCount
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read stream: %w", err)
+	}
+	if !out.Done {
+		return nil, fmt.Errorf("read stream: %w", errStreamTruncated)
+	}
+	out.Message.Content = content.String()
+	return out, nil
+}
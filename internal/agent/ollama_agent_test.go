@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+func TestOllamaAgent_ImplementsLLMRunner(t *testing.T) {
+	var _ LLMRunner = NewOllamaAgent("", "llama3.1", "")
+}
+
+func TestOllamaAgent_RunExecutesToolCalls(t *testing.T) {
+	var reqs []ollamaChatRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("request to %s", r.URL.Path)
+		}
+		var req ollamaChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		reqs = append(reqs, req)
+		w.Header().Set("Content-Type", "application/json")
+		if len(reqs) == 1 {
+			fmt.Fprint(w, `{"message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"execute_bigquery_sql","arguments":{"sql":"SELECT 1"}}}]},"done":true,"done_reason":"stop","prompt_eval_count":50,"eval_count":12}`)
+			return
+		}
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"Total is 1."},"done":true,"done_reason":"stop","prompt_eval_count":80,"eval_count":5}`)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var inputs []map[string]interface{}
+	res, err := NewOllamaAgent("", "qwen2.5", srv.URL).Run(context.Background(), "sys", "how many?", nil,
+		[]tools.Tool{recordingTool(&mu, &inputs)}, RunOptions{})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Text != "Total is 1." || res.LastSQL != "SELECT 1" || len(inputs) != 1 {
+		t.Errorf("text = %q, lastSQL = %q, inputs = %v", res.Text, res.LastSQL, inputs)
+	}
+	if reqs[0].Stream || len(reqs[0].Tools) != 1 || reqs[0].Tools[0].Function.Name != "execute_bigquery_sql" {
+		t.Errorf("first request = %+v", reqs[0])
+	}
+	last := reqs[1].Messages[len(reqs[1].Messages)-1]
+	if last.Role != "tool" || last.ToolName != "execute_bigquery_sql" || last.Content != `{"row_count":1}` {
+		t.Errorf("tool result message = %+v", last)
+	}
+	if len(res.Iterations) != 2 || res.Iterations[0].StopReason != "tool_calls" || res.Usage.InputTokens != 130 || res.Usage.OutputTokens != 17 {
+		t.Errorf("iterations = %+v, usage = %+v", res.Iterations, res.Usage)
+	}
+}
+
+func TestOllamaAgent_RunWithEmitStreams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaChatRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Error("RunWithEmit must stream")
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Total "},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"is 1."},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":20,"eval_count":4}`)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var events, deltas []string
+	res, err := NewOllamaAgent("", "qwen2.5", srv.URL).RunWithEmit(context.Background(), "", "how many?", nil, nil, RunOptions{},
+		collectEvents(&mu, &events, &deltas))
+	if err != nil {
+		t.Fatalf("RunWithEmit: %v", err)
+	}
+	if res.Text != "Total is 1." || strings.Join(deltas, "|") != "Total |is 1." {
+		t.Errorf("text = %q, deltas = %q", res.Text, deltas)
+	}
+	if res.Usage.InputTokens != 20 || res.Usage.OutputTokens != 4 {
+		t.Errorf("usage = %+v", res.Usage)
+	}
+}
+
+func TestOllamaAgent_TruncatedStreamFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Total "},"done":false}`)
+	}))
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var events, deltas []string
+	a := NewOllamaAgent("", "qwen2.5", srv.URL)
+	a.SetRetryPolicy(RetryPolicy{})
+	_, err := a.RunWithEmit(context.Background(), "", "how many?", nil, nil, RunOptions{}, collectEvents(&mu, &events, &deltas))
+	if !errors.Is(err, errStreamTruncated) {
+		t.Errorf("err = %v, want errStreamTruncated", err)
+	}
+}
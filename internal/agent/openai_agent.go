@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// OpenAIAgent implements LLMRunner for any OpenAI-compatible chat completions
+// API: OpenAI itself, Azure OpenAI, and self-hosted servers such as vLLM and
+// LM Studio. It runs the same agent loop and tool-calling translation as
+// DeepSeekAgent, which speaks the same protocol.
+type OpenAIAgent struct {
+	*DeepSeekAgent
+}
+
+// OpenAIOptions configures an OpenAIAgent.
+type OpenAIOptions struct {
+	APIKey  string // optional for self-hosted servers
+	Model   string // model ID; the deployment name on Azure
+	BaseURL string // API root; defaults to "https://api.openai.com/v1"
+	// APIVersion selects Azure OpenAI: requests go to
+	// {BaseURL}/openai/deployments/{Model}/chat/completions?api-version=APIVersion
+	// and authenticate with the api-key header.
+	APIVersion string
+}
+
+// NewOpenAIAgent creates an OpenAIAgent.
+func NewOpenAIAgent(opts OpenAIOptions) *OpenAIAgent {
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	a := NewDeepSeekAgent(opts.APIKey, opts.Model, baseURL)
+	a.model = opts.Model
+	if opts.APIVersion != "" {
+		a.chatURL = a.baseURL + "/openai/deployments/" + url.PathEscape(opts.Model) +
+			"/chat/completions?api-version=" + url.QueryEscape(opts.APIVersion)
+		a.authorize = func(req *http.Request) { req.Header.Set("api-key", a.apiKey) }
+	}
+	return &OpenAIAgent{DeepSeekAgent: a}
+}
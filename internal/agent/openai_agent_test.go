@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIAgent_AzureDeploymentURLAndKeyHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt-4o/chat/completions" || r.URL.Query().Get("api-version") != "2024-10-21" {
+			t.Errorf("request to %s", r.URL)
+		}
+		if r.Header.Get("api-key") != "azure-key" || r.Header.Get("Authorization") != "" {
+			t.Errorf("Azure must authenticate with api-key only, got headers %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	a := NewOpenAIAgent(OpenAIOptions{APIKey: "azure-key", Model: "gpt-4o", BaseURL: srv.URL + "/", APIVersion: "2024-10-21"})
+	res, err := a.Run(context.Background(), "", "hi", nil, nil, RunOptions{})
+	if err != nil || res.Text != "ok" || res.Model != "gpt-4o" {
+		t.Errorf("Run = %+v, %v", res, err)
+	}
+}
+
+func TestOpenAIAgent_SelfHostedWithoutKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "" {
+			t.Errorf("request to %s with Authorization %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer srv.Close()
+
+	a := NewOpenAIAgent(OpenAIOptions{Model: "Qwen/Qwen2.5-32B-Instruct", BaseURL: srv.URL + "/v1"})
+	if a.Model() != "Qwen/Qwen2.5-32B-Instruct" {
+		t.Errorf("Model() = %q", a.Model())
+	}
+	if _, err := a.Run(context.Background(), "", "hi", nil, nil, RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
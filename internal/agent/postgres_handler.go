@@ -3,9 +3,9 @@ package agent
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
+	"github.com/cortexai/cortexai/internal/cache"
 	"github.com/cortexai/cortexai/internal/models"
 	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
@@ -28,9 +28,15 @@ type PostgresHandler struct {
 	auditLogger *security.AuditLogger
 	schemaCache *schemaCache  // reuse existing type from bigquery_handler.go (same package)
 	respCache   *responseCache // reuse existing type from bigquery_handler.go (same package)
+	schemaSel   *schemaSelector
+	glossary    *service.Glossary // nil = no glossary
+	examples    *ExampleStore     // nil = no few-shot examples
+
+	maxRepairRounds int // correction rounds for failed final SQL; see SetMaxRepairRounds
 }
 
 // NewPostgresHandler creates a handler with all security components wired in.
+// caches provides the schema and response cache backends; nil = in-memory.
 func NewPostgresHandler(
 	agent LLMRunner,
 	pgRegistry *service.PGPoolRegistry,
@@ -41,7 +47,11 @@ func NewPostgresHandler(
 	dataMasker *security.DataMasker,
 	auditLogger *security.AuditLogger,
 	schemaCacheTTL time.Duration,
+	caches cache.Store,
 ) *PostgresHandler {
+	if caches == nil {
+		caches = cache.NewMemoryStore()
+	}
 	return &PostgresHandler{
 		agent:       agent,
 		pgRegistry:  pgRegistry,
@@ -51,19 +61,68 @@ func NewPostgresHandler(
 		costTracker: costTracker,
 		dataMasker:  dataMasker,
 		auditLogger: auditLogger,
-		schemaCache: newSchemaCache(schemaCacheTTL),
-		respCache:   newResponseCache(schemaCacheTTL),
+		schemaCache: newSchemaCacheWith(caches.Namespace("pg_schema"), schemaCacheTTL),
+		respCache:   newResponseCacheWith(caches.Namespace("pg_response"), schemaCacheTTL),
+		schemaSel:   newSchemaSelector(SchemaSelectionOptions{}),
+
+		maxRepairRounds: defaultMaxRepairRounds,
+	}
+}
+
+// toolPolicy is the security pipeline applied to queries the agent's tools
+// run during the loop, matching the checks on the final SQL.
+func (h *PostgresHandler) toolPolicy(apiKey string, access *security.TableAccessPolicy) *tools.QueryPolicy {
+	return &tools.QueryPolicy{
+		Validator: h.sqlVal,
+		Access:    access,
+		PGCost:    h.costTracker,
+		Masker:    h.dataMasker,
+		Audit:     h.auditLogger,
+		Log:       tools.NewQueryLog(),
+		APIKey:    apiKey,
 	}
 }
 
 // InvalidateSchemaCache removes the cached schema for the given cache key (squadID:dbName).
-func (h *PostgresHandler) InvalidateSchemaCache(cacheKey string) {
-	h.schemaCache.invalidate(cacheKey)
+func (h *PostgresHandler) InvalidateSchemaCache(cacheKey string) error {
+	return h.schemaCache.invalidate(cacheKey)
 }
 
 // FlushResponseCache clears all cached agent responses.
-func (h *PostgresHandler) FlushResponseCache() {
-	h.respCache.flush()
+func (h *PostgresHandler) FlushResponseCache() error {
+	return h.respCache.flush()
+}
+
+// EnableSemanticCache turns on prompt normalization and, with an embedder,
+// similarity matching for the response cache. Call before serving requests.
+func (h *PostgresHandler) EnableSemanticCache(opts SemanticCacheOptions) {
+	h.respCache.semantic = newSemanticMatcher(opts)
+}
+
+// SetSchemaSelection sets how wide databases are cut down to the tables most
+// relevant to each prompt in the system prompt. Call before serving requests.
+func (h *PostgresHandler) SetSchemaSelection(opts SchemaSelectionOptions) {
+	h.schemaSel = newSchemaSelector(opts)
+}
+
+// SetGlossary sets the business glossary whose terms are added to the
+// system prompt when a prompt mentions them. Call before serving requests.
+func (h *PostgresHandler) SetGlossary(g *service.Glossary) {
+	h.glossary = g
+}
+
+// SetExamples sets the store of verified examples whose most similar ones
+// are added to the system prompt as few-shot examples. Call before serving
+// requests.
+func (h *PostgresHandler) SetExamples(s *ExampleStore) {
+	h.examples = s
+}
+
+// SetMaxRepairRounds sets how many times final SQL that fails validation,
+// execution or the cost check is sent back to the LLM for correction; 0
+// disables repair. Call before serving requests.
+func (h *PostgresHandler) SetMaxRepairRounds(n int) {
+	h.maxRepairRounds = max(n, 0)
 }
 
 // PGSchemaClosingInstruction is the directive appended to the pre-injected schema
@@ -71,52 +130,68 @@ func (h *PostgresHandler) FlushResponseCache() {
 // execute SQL at most once.
 const PGSchemaClosingInstruction = "\nIMPORTANT: All table schemas are already provided above. DO NOT call list_postgres_tables or get_postgres_schema — go directly to writing and executing SQL. You should need at most 1 execute call."
 
-// getPGSchemaSection returns a cached schema section for the given squad+database.
+// PGPartialSchemaClosingInstruction replaces PGSchemaClosingInstruction when
+// only the tables most relevant to the prompt are shown in full.
+const PGPartialSchemaClosingInstruction = "\nIMPORTANT: Only the tables most relevant to the question are shown in full above. DO NOT call list_postgres_tables. If the question needs a table from the other tables list, call get_postgres_schema for that table only; otherwise go directly to writing and executing SQL. You should need at most 1 execute call."
+
+// getPGSchemaSection returns the schema section of the system prompt for req
+// from the cached schema of the given squad+database. Wide databases are cut
+// down to the tables most relevant to the prompt (see SetSchemaSelection).
+func (h *PostgresHandler) getPGSchemaSection(ctx context.Context, squadID, dbName string, pgSvc *service.PostgresService, req *models.AgentRequest) schemaSection {
+	snap := h.pgSchemaSnapshot(ctx, squadID, dbName, pgSvc)
+	if snap == nil {
+		return schemaSection{}
+	}
+	shown := h.schemaSel.pick(ctx, snap, schemaQuery(req))
+	return snap.render(shown, PGSchemaClosingInstruction, PGPartialSchemaClosingInstruction)
+}
+
+// pgSchemaSnapshot returns the cached schema of the squad's database,
+// fetching it on a miss, or nil when it cannot be loaded.
 // Cache key is "squadID:dbName".
-func (h *PostgresHandler) getPGSchemaSection(ctx context.Context, squadID, dbName string, pgSvc *service.PostgresService) string {
+func (h *PostgresHandler) pgSchemaSnapshot(ctx context.Context, squadID, dbName string, pgSvc *service.PostgresService) *schemaSnapshot {
 	if dbName == "" || pgSvc == nil {
-		return ""
+		return nil
 	}
 
 	cacheKey := squadID + ":" + dbName
 
-	if schema, ok := h.schemaCache.get(cacheKey); ok {
+	if snap, ok := h.schemaCache.getSnapshot(cacheKey); ok {
 		log.Debug().Str("cache_key", cacheKey).Msg("pg schema cache hit")
-		return schema
+		return snap
 	}
 
 	v, err, _ := h.schemaCache.sf.Do(cacheKey, func() (interface{}, error) {
-		if schema, ok := h.schemaCache.get(cacheKey); ok {
-			return schema, nil
+		if snap, ok := h.schemaCache.getSnapshot(cacheKey); ok {
+			return snap, nil
 		}
 
 		log.Debug().Str("database", dbName).Str("squad", squadID).Msg("pg schema cache miss, fetching")
 		fetchStart := time.Now()
 
-		tables, err := pgSvc.ListTables(ctx, dbName)
+		tables, err := pgSvc.GetDatabaseSchema(ctx, dbName)
 		if err != nil {
-			return "", nil
+			log.Warn().Err(err).Str("database", dbName).Msg("pg pre-load schema failed")
+			return nil, nil
 		}
 
-		var sb strings.Builder
-		sb.WriteString("\n\n## Available Database: " + dbName + "\n")
-		sb.WriteString("The following tables and schemas are already available to you:\n\n")
-
+		snap := &schemaSnapshot{Title: "Database: " + dbName}
 		for _, tbl := range tables {
-			cols, err := pgSvc.GetTableSchema(ctx, dbName, tbl.Schema, tbl.Name)
-			if err != nil {
-				log.Warn().Err(err).Str("table", tbl.Schema+"."+tbl.Name).Msg("pg pre-load schema: get schema failed")
-				continue
+			names := make([]string, len(tbl.Columns))
+			for i, c := range tbl.Columns {
+				names[i] = c.Name
 			}
-			sb.WriteString(fmt.Sprintf("### %s.%s (%s)\n", tbl.Schema, tbl.Name, tbl.Type))
-			sb.WriteString(service.PGSchemaToString(cols))
-			sb.WriteString("\n")
+			snap.Tables = append(snap.Tables, schemaTable{
+				Name:        tbl.Schema + "." + tbl.Name,
+				Heading:     fmt.Sprintf("%s.%s (%s)", tbl.Schema, tbl.Name, tbl.Type),
+				Details:     service.PGTableDetailsToString(&tbl.Details),
+				Columns:     service.PGSchemaToString(tbl.Columns),
+				ColumnNames: names,
+				Description: tbl.Details.Comment,
+			})
 		}
 
-		sb.WriteString(PGSchemaClosingInstruction)
-
-		schema := sb.String()
-		h.schemaCache.set(cacheKey, schema)
+		h.schemaCache.setSnapshot(cacheKey, snap)
 
 		log.Info().
 			Str("database", dbName).
@@ -125,17 +200,19 @@ func (h *PostgresHandler) getPGSchemaSection(ctx context.Context, squadID, dbNam
 			Dur("fetch_ms", time.Since(fetchStart)).
 			Msg("pg schema cached")
 
-		return schema, nil
+		return snap, nil
 	})
 
 	if err != nil || v == nil {
-		return ""
+		return nil
 	}
-	return v.(string)
+	return v.(*schemaSnapshot)
 }
 
 // Handle processes an agent request for PostgreSQL.
-func (h *PostgresHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, allowedDatabases []string, runner LLMRunner, promptStyle string, excludedTools []string) (*models.AgentResponse, error) {
+// access is the squad's table access policy (squad isolation): the databases,
+// tables and columns generated SQL may read. nil means no restriction.
+func (h *PostgresHandler) Handle(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, access *security.TableAccessPolicy, runner LLMRunner, opts RunOptions, promptStyle string, excludedTools []string) (*models.AgentResponse, error) {
 	start := time.Now()
 	metadata := map[string]interface{}{
 		"data_source": "postgres",
@@ -169,6 +246,10 @@ func (h *PostgresHandler) Handle(ctx context.Context, req *models.AgentRequest,
 			Answer:        &msg,
 		}, fmt.Errorf("database name is required (use dataset_id field)")
 	}
+	var allowedDatabases []string
+	if access != nil {
+		allowedDatabases = access.Databases
+	}
 	if len(allowedDatabases) > 0 && !isDatabaseAllowed(dbName, allowedDatabases) {
 		return &models.AgentResponse{
 			Status:        "error",
@@ -203,113 +284,65 @@ func (h *PostgresHandler) Handle(ctx context.Context, req *models.AgentRequest,
 	}
 	metadata["prompt_validation"] = "passed"
 
-	// 2a. Response cache check (non-streaming, non-dry_run only)
-	pgCacheKey := responseCacheKey(req.Prompt, dbName, promptStyle)
-	if !req.DryRun {
-		if cached, ok := h.respCache.get(pgCacheKey); ok {
-			cached.AgentMetadata["response_cache"] = "hit"
+	// 2a. Response cache check (non-streaming, non-dry_run only). Follow-up
+	// turns of a conversation depend on prior context and are never cached.
+	cacheable := !req.DryRun && len(req.History) == 0
+	var cacheProbe *responseCacheProbe
+	if cacheable {
+		var cached *models.AgentResponse
+		if cached, cacheProbe = h.respCache.lookup(ctx, req.Prompt, responseCacheScope(dbName, squadID, access, h.glossary, h.examples), promptStyle); cached != nil {
 			return cached, nil
 		}
 		metadata["response_cache"] = "miss"
 	}
 
-	// 3. Build PG tools
+	// 3. Build system prompt: persona base + cached schema section
+	schema := h.getPGSchemaSection(ctx, squadID, dbName, pgSvc, req)
+	schema.record(metadata)
+	systemPrompt := PGSystemPromptStyle(promptStyle) + schema.text + promptGlossary(h.glossary, squadID, dbName, req, metadata) +
+		promptExamples(ctx, h.examples, squadID, string(service.DataSourcePostgres), dbName, req, metadata)
+
+	// 4. Build PG tools
 	if req.DryRun {
 		excludedTools = append(excludedTools, "execute_postgres_sql")
 		if dbName != "" {
-			// Schema already injected into system prompt — no need for inspection tools.
-			excludedTools = append(excludedTools, "list_postgres_tables", "get_postgres_schema", "get_postgres_sample_data")
+			excludedTools = append(excludedTools, dryRunSchemaTools(schema, "list_postgres_tables", "get_postgres_schema", "get_postgres_sample_data")...)
 		}
 	}
+	policy := h.toolPolicy(apiKey, access)
 	pgTools := filterTools([]tools.Tool{
 		tools.PGListDatabasesTool(allowedDatabases),
-		tools.PGListTablesTool(pgSvc, dbName),
-		tools.PGGetSchemaTool(pgSvc, dbName),
-		tools.PGSampleDataTool(pgSvc, dbName),
-		tools.PGExecuteQueryTool(pgSvc, dbName),
+		tools.PGListTablesTool(pgSvc, dbName, policy),
+		tools.PGGetSchemaTool(pgSvc, dbName, policy),
+		tools.PGSampleDataTool(pgSvc, dbName, policy),
+		tools.PGExecuteQueryTool(pgSvc, dbName, policy),
 	}, excludedTools)
 
-	// 4. Build system prompt: persona base + cached schema section
-	systemPrompt := PGSystemPromptStyle(promptStyle) + h.getPGSchemaSection(ctx, squadID, dbName, pgSvc)
-
-	// 5. Run agent loop
+	// 5. Run agent loop. The final SQL is validated, access-checked, cost-checked
+	// and executed; failures the LLM can fix are sent back for correction.
 	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
 	defer cancel()
 
-	llmStart := time.Now()
-	output, toolsUsed, lastSQL, err := runner.Run(agentCtx, systemPrompt, req.Prompt, pgTools)
-	llmMs := time.Since(llmStart).Milliseconds()
+	run := func(ctx context.Context, prompt string, history []models.ConversationTurn) (*RunResult, error) {
+		return runner.Run(ctx, systemPrompt, prompt, history, pgTools, opts)
+	}
+	var execute func(string) (*models.QueryResponse, *sqlFailure)
+	if !req.DryRun {
+		execute = func(sql string) (*models.QueryResponse, *sqlFailure) {
+			return h.executeSQL(agentCtx, pgSvc, sql, dbName, apiKey, access, policy.Log, metadata)
+		}
+	}
+	resetSQLMetadata(metadata)
+	ar, err := runWithRepair(agentCtx, run, req, h.maxRepairRounds, execute, nil)
 	if err != nil {
 		return nil, fmt.Errorf("agent run: %w", err)
 	}
-
-	metadata["tools_used"] = toolsUsed
-
-	// 6. Extract SQL (dialect-agnostic)
-	generatedSQL := extractSQL(output)
-	if generatedSQL == "" && lastSQL != "" {
-		generatedSQL = lastSQL
-		log.Debug().Str("sql", generatedSQL[:min(60, len(generatedSQL))]).Msg("pg: using lastExecutedSQL as fallback")
-	}
-	metadata["sql_validation"] = "n/a"
-	metadata["cost_tracking"] = "n/a"
-	metadata["data_masking"] = "n/a"
-
-	var execResult *models.QueryResponse
-
-	if generatedSQL != "" && !req.DryRun {
-		// 7. SQL validation (PG-specific)
-		if errMsg := h.sqlVal.ValidatePG(generatedSQL); errMsg != "" {
-			metadata["sql_validation"] = "blocked: " + errMsg
-			return &models.AgentResponse{
-				Status:        "error",
-				Prompt:        req.Prompt,
-				AgentMetadata: metadata,
-				Answer:        sqlFriendlyMsg(errMsg),
-			}, fmt.Errorf("SQL validation failed: %s", errMsg)
-		}
-		metadata["sql_validation"] = "passed"
-
-		// 8. EXPLAIN cost check
-		explainCost, explainErr := pgSvc.ExplainCost(agentCtx, dbName, generatedSQL)
-		if explainErr == nil && explainCost != nil {
-			if ok, costErr := h.costTracker.CheckCost(explainCost.TotalCost); !ok {
-				metadata["cost_tracking"] = "blocked: " + costErr
-				return &models.AgentResponse{
-					Status:        "error",
-					Prompt:        req.Prompt,
-					AgentMetadata: metadata,
-					Answer:        friendlyMsg("cost_exceeded", ""),
-				}, fmt.Errorf("query cost check failed: %s", costErr)
-			}
-		}
-
-		// 9. Execute query (read-only tx)
-		queryStart := time.Now()
-		result, qErr := pgSvc.ExecuteQuery(agentCtx, dbName, generatedSQL, 60000)
-		if qErr == nil {
-			queryMs := time.Since(queryStart).Milliseconds()
-
-			if explainCost != nil {
-				h.costTracker.LogQueryCost(generatedSQL, explainCost.TotalCost, apiKey, queryMs)
-			}
-			metadata["cost_tracking"] = "ok"
-
-			// 10. Data masking
-			data := h.dataMasker.MaskRows(result.Data)
-			metadata["data_masking"] = "applied"
-
-			execResult = &models.QueryResponse{
-				Status:   "success",
-				Data:     data,
-				Columns:  result.Columns,
-				RowCount: len(data),
-				Metadata: models.QueryMetadata{
-					ExecutionTimeMs: queryMs,
-				},
-			}
-		}
+	metadata["tools_used"] = ar.toolsUsed
+	recordSQLAttempts(metadata, ar)
+	if ar.failure != nil {
+		return ar.failure.response(req, metadata)
 	}
+	output, generatedSQL, execResult, llmMs := ar.output, ar.generatedSQL, ar.result, ar.llmMs
 
 	// 11. Audit logging
 	execTimeMs := time.Since(start).Milliseconds()
@@ -336,14 +369,15 @@ func (h *PostgresHandler) Handle(ctx context.Context, req *models.AgentRequest,
 		AgentMetadata:   metadata,
 		Answer:          answerPtr,
 	}
-	if !req.DryRun {
-		h.respCache.set(pgCacheKey, pgResp)
+	if cacheable {
+		h.respCache.store(cacheProbe, pgResp)
 	}
 	return pgResp, nil
 }
 
 // HandleStream processes an agent request for PostgreSQL with SSE event emission.
-func (h *PostgresHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, allowedDatabases []string, runner LLMRunner, promptStyle string, emitFn func(event string, data interface{}), excludedTools []string) {
+// access is the squad's table access policy (same as Handle).
+func (h *PostgresHandler) HandleStream(ctx context.Context, req *models.AgentRequest, apiKey string, squadID string, access *security.TableAccessPolicy, runner LLMRunner, opts RunOptions, promptStyle string, emitFn func(event string, data interface{}), excludedTools []string) {
 	start := time.Now()
 	metadata := map[string]interface{}{
 		"data_source": "postgres",
@@ -375,6 +409,10 @@ func (h *PostgresHandler) HandleStream(ctx context.Context, req *models.AgentReq
 		})
 		return
 	}
+	var allowedDatabases []string
+	if access != nil {
+		allowedDatabases = access.Databases
+	}
 	if len(allowedDatabases) > 0 && !isDatabaseAllowed(dbName, allowedDatabases) {
 		emitFn("error", map[string]interface{}{
 			"message": fmt.Sprintf("database '%s' is not accessible for your squad", dbName),
@@ -408,104 +446,64 @@ func (h *PostgresHandler) HandleStream(ctx context.Context, req *models.AgentReq
 	}
 	metadata["prompt_validation"] = "passed"
 
-	// 3. Build PG tools
+	// 3. Schema pre-loading
+	emitFn("progress", map[string]interface{}{"step": "schema_loading", "database": dbName})
+	schema := h.getPGSchemaSection(ctx, squadID, dbName, pgSvc, req)
+	schema.record(metadata)
+	systemPrompt := PGSystemPromptStyle(promptStyle) + schema.text + promptGlossary(h.glossary, squadID, dbName, req, metadata) +
+		promptExamples(ctx, h.examples, squadID, string(service.DataSourcePostgres), dbName, req, metadata)
+	emitFn("progress", schemaReadyEvent("database", dbName, schema))
+
+	// 4. Build PG tools
 	if req.DryRun {
 		excludedTools = append(excludedTools, "execute_postgres_sql")
 		if dbName != "" {
-			// Schema already injected into system prompt — no need for inspection tools.
-			excludedTools = append(excludedTools, "list_postgres_tables", "get_postgres_schema", "get_postgres_sample_data")
+			excludedTools = append(excludedTools, dryRunSchemaTools(schema, "list_postgres_tables", "get_postgres_schema", "get_postgres_sample_data")...)
 		}
 	}
+	policy := h.toolPolicy(apiKey, access)
 	pgTools := filterTools([]tools.Tool{
 		tools.PGListDatabasesTool(allowedDatabases),
-		tools.PGListTablesTool(pgSvc, dbName),
-		tools.PGGetSchemaTool(pgSvc, dbName),
-		tools.PGSampleDataTool(pgSvc, dbName),
-		tools.PGExecuteQueryTool(pgSvc, dbName),
+		tools.PGListTablesTool(pgSvc, dbName, policy),
+		tools.PGGetSchemaTool(pgSvc, dbName, policy),
+		tools.PGSampleDataTool(pgSvc, dbName, policy),
+		tools.PGExecuteQueryTool(pgSvc, dbName, policy),
 	}, excludedTools)
 
-	// 4. Schema pre-loading
-	emitFn("progress", map[string]interface{}{"step": "schema_loading", "database": dbName})
-	systemPrompt := PGSystemPromptStyle(promptStyle) + h.getPGSchemaSection(ctx, squadID, dbName, pgSvc)
-	emitFn("progress", map[string]interface{}{"step": "schema_ready", "database": dbName})
-
-	// 5. Run agent loop with event emission
+	// 5. Run agent loop with event emission; failed final SQL is sent back
+	// for correction as in Handle.
 	agentCtx, cancel := context.WithTimeout(ctx, time.Duration(req.Timeout)*time.Second)
 	defer cancel()
 
 	agentEmit := func(event string, data map[string]interface{}) {
 		emitFn(event, data)
 	}
-
-	llmStart := time.Now()
-	output, toolsUsed, lastSQL, err := runner.RunWithEmit(agentCtx, systemPrompt, req.Prompt, pgTools, agentEmit)
-	llmMs := time.Since(llmStart).Milliseconds()
+	run := func(ctx context.Context, prompt string, history []models.ConversationTurn) (*RunResult, error) {
+		return runner.RunWithEmit(ctx, systemPrompt, prompt, history, pgTools, opts, agentEmit)
+	}
+	var execute func(string) (*models.QueryResponse, *sqlFailure)
+	if !req.DryRun {
+		execute = func(sql string) (*models.QueryResponse, *sqlFailure) {
+			emitFn("progress", map[string]interface{}{"step": "executing_sql"})
+			return h.executeSQL(agentCtx, pgSvc, sql, dbName, apiKey, access, policy.Log, metadata)
+		}
+	}
+	resetSQLMetadata(metadata)
+	ar, err := runWithRepair(agentCtx, run, req, h.maxRepairRounds, execute, streamRepairFn(emitFn))
 	if err != nil {
 		emitFn("error", map[string]interface{}{"message": "agent run: " + err.Error()})
 		return
 	}
-	metadata["tools_used"] = toolsUsed
-
-	// 6. Extract SQL
-	generatedSQL := extractSQL(output)
-	if generatedSQL == "" && lastSQL != "" {
-		generatedSQL = lastSQL
-		log.Debug().Str("sql", generatedSQL[:min(60, len(generatedSQL))]).Msg("pg stream: using lastExecutedSQL as fallback")
-	}
-	metadata["sql_validation"] = "n/a"
-	metadata["cost_tracking"] = "n/a"
-	metadata["data_masking"] = "n/a"
-
-	var execResult *models.QueryResponse
-
-	if generatedSQL != "" && !req.DryRun {
-		if errMsg := h.sqlVal.ValidatePG(generatedSQL); errMsg != "" {
-			metadata["sql_validation"] = "blocked: " + errMsg
-			emitFn("error", map[string]interface{}{
-				"message": "SQL validation failed: " + errMsg,
-				"step":    "sql_validation",
-			})
-			return
-		}
-		metadata["sql_validation"] = "passed"
-
-		// EXPLAIN cost check
-		explainCost, explainErr := pgSvc.ExplainCost(agentCtx, dbName, generatedSQL)
-		if explainErr == nil && explainCost != nil {
-			if ok, costErr := h.costTracker.CheckCost(explainCost.TotalCost); !ok {
-				metadata["cost_tracking"] = "blocked: " + costErr
-				emitFn("error", map[string]interface{}{
-					"message": "Query cost check failed: " + costErr,
-					"step":    "cost_check",
-				})
-				return
-			}
-		}
-
-		emitFn("progress", map[string]interface{}{"step": "executing_sql"})
-		queryStart := time.Now()
-		result, qErr := pgSvc.ExecuteQuery(agentCtx, dbName, generatedSQL, 60000)
-		if qErr == nil {
-			queryMs := time.Since(queryStart).Milliseconds()
-
-			if explainCost != nil {
-				h.costTracker.LogQueryCost(generatedSQL, explainCost.TotalCost, apiKey, queryMs)
-			}
-			metadata["cost_tracking"] = "ok"
-
-			data := h.dataMasker.MaskRows(result.Data)
-			metadata["data_masking"] = "applied"
-			execResult = &models.QueryResponse{
-				Status:   "success",
-				Data:     data,
-				Columns:  result.Columns,
-				RowCount: len(data),
-				Metadata: models.QueryMetadata{
-					ExecutionTimeMs: queryMs,
-				},
-			}
-		}
+	metadata["tools_used"] = ar.toolsUsed
+	recordSQLAttempts(metadata, ar)
+	if ar.failure != nil {
+		emitFn("error", map[string]interface{}{
+			"message": ar.failure.err.Error(),
+			"step":    ar.failure.step,
+		})
+		return
 	}
+	output, generatedSQL, execResult, llmMs := ar.output, ar.generatedSQL, ar.result, ar.llmMs
 
 	execTimeMs := time.Since(start).Milliseconds()
 	h.auditLogger.LogAIAgentRequest(req.Prompt, apiKey, generatedSQL, true, execTimeMs)
@@ -533,6 +531,125 @@ func (h *PostgresHandler) HandleStream(ctx context.Context, req *models.AgentReq
 	})
 }
 
+// executeSQL runs the agent's final SQL through the security pipeline — SQL
+// validation, table access, row-level security and an EXPLAIN cost check —
+// then executes it in a read-only transaction and masks the rows. The status
+// of each step is written to metadata.
+func (h *PostgresHandler) executeSQL(ctx context.Context, pgSvc *service.PostgresService, generatedSQL, dbName, apiKey string, access *security.TableAccessPolicy, executed *tools.QueryLog, metadata map[string]interface{}) (*models.QueryResponse, *sqlFailure) {
+	resetSQLMetadata(metadata)
+
+	// SQL validation (PG-specific)
+	analysis := h.sqlVal.Analyze(generatedSQL, security.DialectPostgres)
+	metadata["referenced_tables"] = analysis.TableNames()
+	if errMsg := analysis.Message(); errMsg != "" {
+		metadata["sql_validation"] = "blocked: " + errMsg
+		metadata["sql_violations"] = analysis.Violations
+		return nil, &sqlFailure{
+			step:       "sql_validation",
+			err:        fmt.Errorf("SQL validation failed: %s", errMsg),
+			answer:     sqlFriendlyMsg(errMsg),
+			repairable: true,
+		}
+	}
+	metadata["sql_validation"] = "passed"
+
+	// Table access
+	if access != nil {
+		if violations := access.Check(analysis, dbName); len(violations) > 0 {
+			errMsg := violations[0].Message
+			metadata["table_access"] = "blocked: " + errMsg
+			metadata["sql_violations"] = violations
+			return nil, &sqlFailure{
+				step:   "table_access",
+				err:    fmt.Errorf("table access denied: %s", errMsg),
+				answer: friendlyMsg("table_access", errMsg),
+			}
+		}
+		metadata["table_access"] = "passed"
+	}
+
+	// Row-level security: tables with row filters are read through
+	// filtered subqueries; the rewritten SQL is what runs.
+	execSQL, filtered, rlsErr := access.ApplyRowFilters(generatedSQL, analysis, dbName)
+	if rlsErr != nil {
+		metadata["row_level_security"] = "blocked: " + rlsErr.Error()
+		return nil, &sqlFailure{
+			step:   "row_level_security",
+			err:    fmt.Errorf("row-level security: %w", rlsErr),
+			answer: friendlyMsg("row_level_security", ""),
+		}
+	}
+	if len(filtered) > 0 {
+		metadata["row_level_security"] = "applied"
+		metadata["row_filtered_tables"] = filtered
+	}
+
+	// A query the agent already ran through its tools is not run again: the
+	// recorded result is reused after the same cost check, using the EXPLAIN
+	// estimate taken when the tool ran when there is one.
+	prev, reuse := executed.Find(execSQL, security.DialectPostgres, dbName)
+	reuse = reuse && prev.Postgres != nil
+
+	// EXPLAIN cost check. A query PostgreSQL cannot plan (unknown column,
+	// type mismatch) fails here already.
+	explainCost := prev.PGCost
+	if explainCost == nil {
+		var explainErr error
+		explainCost, explainErr = pgSvc.ExplainCost(ctx, dbName, execSQL)
+		if explainErr != nil && service.IsPGQueryError(explainErr) {
+			return nil, executionFailure(metadata, explainErr, true)
+		}
+		if explainErr != nil {
+			explainCost = nil
+		}
+	}
+	if explainCost != nil {
+		if ok, costErr := h.costTracker.CheckCost(explainCost.TotalCost); !ok {
+			metadata["cost_tracking"] = "blocked: " + costErr
+			return nil, costFailure(costErr)
+		}
+	}
+
+	if reuse {
+		metadata["sql_execution"] = "ok"
+		metadata["sql_result_reused"] = prev.Tool
+		metadata["cost_tracking"] = "ok"
+		return h.queryResponse(prev.Postgres, prev.LatencyMs, metadata), nil
+	}
+
+	// Execute query (read-only tx)
+	queryStart := time.Now()
+	result, qErr := pgSvc.ExecuteQuery(ctx, dbName, execSQL, 60000)
+	if qErr != nil {
+		return nil, executionFailure(metadata, qErr, service.IsPGQueryError(qErr))
+	}
+	metadata["sql_execution"] = "ok"
+	queryMs := time.Since(queryStart).Milliseconds()
+
+	if explainCost != nil {
+		h.costTracker.LogQueryCost(execSQL, explainCost.TotalCost, apiKey, queryMs)
+	}
+	metadata["cost_tracking"] = "ok"
+
+	return h.queryResponse(result, queryMs, metadata), nil
+}
+
+// queryResponse masks the rows of result, which took queryMs to run.
+func (h *PostgresHandler) queryResponse(result *service.PGQueryResult, queryMs int64, metadata map[string]interface{}) *models.QueryResponse {
+	data := h.dataMasker.MaskRows(result.Data)
+	metadata["data_masking"] = "applied"
+
+	return &models.QueryResponse{
+		Status:   "success",
+		Data:     data,
+		Columns:  result.Columns,
+		RowCount: len(data),
+		Metadata: models.QueryMetadata{
+			ExecutionTimeMs: queryMs,
+		},
+	}
+}
+
 // isDatabaseAllowed returns true if the dbName is in the allowed list.
 func isDatabaseAllowed(dbName string, allowedDatabases []string) bool {
 	for _, d := range allowedDatabases {
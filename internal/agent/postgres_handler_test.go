@@ -1,8 +1,14 @@
 package agent
 
 import (
+	"context"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/cortexai/cortexai/internal/tools"
 )
 
 func TestIsDatabaseAllowed_InList(t *testing.T) {
@@ -98,3 +104,53 @@ func TestPGSchemaSectionClosingInstruction_IsDirective(t *testing.T) {
 		t.Errorf("closing instruction must not use soft 'you can skip' language, got: %q", PGSchemaClosingInstruction)
 	}
 }
+
+func newTestPostgresHandler(maxCost float64) *PostgresHandler {
+	return NewPostgresHandler(nil, nil,
+		security.NewPIIDetector(nil),
+		security.NewPromptValidator(),
+		security.NewSQLValidator(),
+		security.NewPGCostTracker(maxCost),
+		security.NewDataMasker(nil),
+		security.NewAuditLogger(false),
+		time.Minute, nil,
+	)
+}
+
+func TestPostgresExecuteSQL_ReusesToolResult(t *testing.T) {
+	executed := tools.NewQueryLog()
+	executed.Record(tools.ExecutedQuery{
+		Tool:  "execute_postgres_sql",
+		SQL:   "SELECT email FROM public.users LIMIT 5",
+		Scope: "appdb",
+		Postgres: &service.PGQueryResult{
+			Columns:  []string{"email"},
+			Data:     []map[string]interface{}{{"email": "john.doe@example.com"}},
+			RowCount: 1,
+		},
+		PGCost:    &service.PGExplainCost{TotalCost: 500},
+		LatencyMs: 42,
+	}, security.DialectPostgres)
+
+	// nil PostgresService: neither EXPLAIN nor the query may run again
+	h := newTestPostgresHandler(1_000)
+	metadata := map[string]interface{}{}
+	res, failure := h.executeSQL(context.Background(), nil, "SELECT email\nFROM public.users\nLIMIT 5;", "appdb", "key", nil, executed, metadata)
+	if failure != nil {
+		t.Fatalf("unexpected failure: %v", failure.err)
+	}
+	if res.RowCount != 1 || res.Metadata.ExecutionTimeMs != 42 {
+		t.Errorf("result = %+v, want the tool's result", res)
+	}
+	if got := res.Data[0]["email"]; got == "john.doe@example.com" {
+		t.Error("reused rows must be masked")
+	}
+	if metadata["sql_result_reused"] != "execute_postgres_sql" {
+		t.Errorf("sql_result_reused = %v", metadata["sql_result_reused"])
+	}
+
+	h = newTestPostgresHandler(100)
+	if _, failure := h.executeSQL(context.Background(), nil, "SELECT email FROM public.users LIMIT 5", "appdb", "key", nil, executed, metadata); failure == nil || failure.step != "cost_check" {
+		t.Errorf("expected the EXPLAIN cost limit to apply to reused results, got %+v", failure)
+	}
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultMaxRepairRounds is how many times a failed final SQL is sent back to
+// the LLM for correction when the handler is not configured otherwise.
+const defaultMaxRepairRounds = 2
+
+// sqlFailure describes why the agent's final SQL was not executed. step names
+// the pipeline step that rejected it, as reported in agent_metadata and in
+// the "step" of streamed error events. Only repairable failures are sent
+// back to the LLM; access and row-level security denials are final.
+type sqlFailure struct {
+	step       string
+	err        error   // returned by Handle
+	answer     *string // friendly message shown to the user
+	repairable bool
+}
+
+// response is the error AgentResponse returned by Handle when the SQL could
+// not be run and no correction rounds are left.
+func (f *sqlFailure) response(req *models.AgentRequest, metadata map[string]interface{}) (*models.AgentResponse, error) {
+	return &models.AgentResponse{
+		Status:        "error",
+		Prompt:        req.Prompt,
+		AgentMetadata: metadata,
+		Answer:        f.answer,
+	}, f.err
+}
+
+// executionFailure is the failure for a query the database rejected or
+// could not run. repairable reports whether the error is about the query
+// itself rather than the connection or a timeout.
+func executionFailure(metadata map[string]interface{}, err error, repairable bool) *sqlFailure {
+	metadata["sql_execution"] = "failed: " + err.Error()
+	return &sqlFailure{
+		step:       "sql_execution",
+		err:        fmt.Errorf("query execution failed: %w", err),
+		answer:     friendlyMsg("sql_execution", ""),
+		repairable: repairable,
+	}
+}
+
+// costFailure is the failure for a query rejected by the cost limit or
+// budget, which a narrower query may pass.
+func costFailure(costErr string) *sqlFailure {
+	return &sqlFailure{
+		step:       "cost_check",
+		err:        fmt.Errorf("query cost check failed: %s", costErr),
+		answer:     friendlyMsg("cost_exceeded", ""),
+		repairable: true,
+	}
+}
+
+// streamRepairFn returns the onRepair callback of runWithRepair that emits a
+// "sql_repair" progress event per correction round.
+func streamRepairFn(emitFn func(event string, data interface{})) func(round int, f *sqlFailure) {
+	return func(round int, f *sqlFailure) {
+		emitFn("progress", map[string]interface{}{
+			"step":   "sql_repair",
+			"round":  round,
+			"failed": f.step,
+			"error":  f.err.Error(),
+		})
+	}
+}
+
+// sqlAttempt is one SQL the agent produced for a request, recorded in
+// agent_metadata["sql_attempts"].
+type sqlAttempt struct {
+	Round  int    `json:"round"`
+	SQL    string `json:"sql"`
+	Status string `json:"status"` // "ok" or "failed"
+	Step   string `json:"step,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// repairRun is the outcome of runWithRepair.
+type repairRun struct {
+	output       string // LLM output of the last round
+	generatedSQL string // SQL of the last round; "" if the LLM wrote none
+	toolsUsed    []string
+	result       *models.QueryResponse // nil when the SQL was not executed
+	failure      *sqlFailure           // set when the last round's SQL failed
+	attempts     []sqlAttempt
+	llmMs        int64
+}
+
+// agentRunFunc runs one agent loop; it is LLMRunner.Run or RunWithEmit bound
+// to the request's system prompt and tools.
+type agentRunFunc func(ctx context.Context, prompt string, history []models.ConversationTurn) (*RunResult, error)
+
+// runWithRepair runs the agent, extracts its final SQL and passes it to
+// execute. When execute reports a repairable failure — a validation error,
+// a database error such as an unknown column or type mismatch, or a cost
+// limit rejection — the failed answer is appended to the conversation and
+// the LLM is asked to correct it, for at most maxRounds further rounds.
+// execute may be nil (dry run), in which case the SQL is only extracted.
+// onRepair, when non-nil, is called before each correction round.
+func runWithRepair(ctx context.Context, run agentRunFunc, req *models.AgentRequest, maxRounds int, execute func(sql string) (*models.QueryResponse, *sqlFailure), onRepair func(round int, f *sqlFailure)) (*repairRun, error) {
+	out := &repairRun{}
+	prompt, history := req.Prompt, req.History
+	for round := 0; ; round++ {
+		llmStart := time.Now()
+		res, err := run(ctx, prompt, history)
+		out.llmMs += time.Since(llmStart).Milliseconds()
+		recordRun(ctx, prompt, res, err)
+		if err != nil {
+			return out, err
+		}
+		out.toolsUsed = append(out.toolsUsed, res.ToolsUsed...)
+		output := res.Text
+
+		// Extract SQL from output — fallback to last tool-executed SQL if not in code block
+		generatedSQL := extractSQL(output)
+		if generatedSQL == "" && res.LastSQL != "" {
+			generatedSQL = res.LastSQL
+			log.Debug().Str("sql", generatedSQL[:min(60, len(generatedSQL))]).Msg("using lastExecutedSQL as fallback")
+		}
+		out.output, out.generatedSQL = output, generatedSQL
+		out.result, out.failure = nil, nil
+		if generatedSQL == "" || execute == nil {
+			return out, nil
+		}
+
+		out.result, out.failure = execute(generatedSQL)
+		attempt := sqlAttempt{Round: round, SQL: generatedSQL, Status: "ok"}
+		if f := out.failure; f != nil {
+			attempt.Status, attempt.Step, attempt.Error = "failed", f.step, f.err.Error()
+		}
+		out.attempts = append(out.attempts, attempt)
+		if out.failure == nil || !out.failure.repairable || round >= maxRounds || ctx.Err() != nil {
+			return out, nil
+		}
+
+		log.Debug().Int("round", round+1).Str("step", out.failure.step).Err(out.failure.err).Msg("asking LLM to repair failed SQL")
+		if onRepair != nil {
+			onRepair(round+1, out.failure)
+		}
+		turn := models.ConversationTurn{Prompt: prompt, Answer: output}
+		if extractSQL(output) == "" {
+			turn.GeneratedSQL = generatedSQL
+		}
+		history = append(history[:len(history):len(history)], turn)
+		prompt = repairPrompt(generatedSQL, out.failure)
+	}
+}
+
+// repairPrompt is the user message asking the LLM to correct sql after f.
+func repairPrompt(sql string, f *sqlFailure) string {
+	var sb strings.Builder
+	sb.WriteString("The SQL from your previous answer could not be run.\n\n")
+	fmt.Fprintf(&sb, "Failed step: %s\nError: %s\n\n", f.step, f.err.Error())
+	sb.WriteString("SQL:\n```sql\n")
+	sb.WriteString(sql)
+	sb.WriteString("\n```\n\n")
+	switch f.step {
+	case "cost_check":
+		sb.WriteString("Rewrite the query so it reads less data: select only the columns you need and filter on the partition or date column.")
+	case "sql_validation":
+		sb.WriteString("Rewrite it as a single read-only SELECT statement.")
+	default:
+		sb.WriteString("Check the table and column names against the schema and fix the query.")
+	}
+	sb.WriteString(" Keep answering the original question, and reply with the corrected SQL in a ```sql code block.")
+	return sb.String()
+}
+
+// recordSQLAttempts adds the attempts of run to metadata.
+func recordSQLAttempts(metadata map[string]interface{}, run *repairRun) {
+	if len(run.attempts) == 0 {
+		return
+	}
+	metadata["sql_attempts"] = run.attempts
+	metadata["sql_repair_rounds"] = len(run.attempts) - 1
+}
+
+// resetSQLMetadata sets the per-attempt pipeline metadata to its initial
+// state, so the keys describe the last SQL attempt only.
+func resetSQLMetadata(metadata map[string]interface{}) {
+	metadata["sql_validation"] = "n/a"
+	metadata["table_access"] = "n/a"
+	metadata["row_level_security"] = "n/a"
+	metadata["cost_tracking"] = "n/a"
+	metadata["data_masking"] = "n/a"
+	for _, k := range []string{"referenced_tables", "sql_violations", "row_filtered_tables", "estimated_bytes_processed", "sql_execution", "sql_result_reused"} {
+		delete(metadata, k)
+	}
+}
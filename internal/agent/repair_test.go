@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// scriptedRunner returns outputs in turn (repeating the last) and records the
+// prompt and history of every run.
+type scriptedRunner struct {
+	outputs   []string
+	prompts   []string
+	histories [][]models.ConversationTurn
+}
+
+func (r *scriptedRunner) Run(_ context.Context, _, prompt string, history []models.ConversationTurn, _ []tools.Tool, _ RunOptions) (*RunResult, error) {
+	r.prompts = append(r.prompts, prompt)
+	r.histories = append(r.histories, history)
+	return &RunResult{Text: r.outputs[min(len(r.prompts), len(r.outputs))-1]}, nil
+}
+func (r *scriptedRunner) RunWithEmit(ctx context.Context, system, prompt string, history []models.ConversationTurn, ts []tools.Tool, _ RunOptions, _ EmitFn) (*RunResult, error) {
+	return r.Run(ctx, system, prompt, history, ts, RunOptions{})
+}
+func (r *scriptedRunner) Model() string { return "scripted" }
+
+func TestBigQueryHandle_FeedsValidationErrorBackToLLM(t *testing.T) {
+	h := newTestBigQueryHandler()
+	first := "```sql\nDELETE FROM payment_ds.orders WHERE id = 1\n```"
+	runner := &scriptedRunner{outputs: []string{
+		first,
+		"```sql\nSELECT email FROM user_ds.users LIMIT 10\n```", // still wrong, but not repairable
+	}}
+	req := &models.AgentRequest{Prompt: "tampilkan order terbaru", Timeout: 30}
+	access := &security.TableAccessPolicy{Datasets: []string{"payment_ds"}}
+
+	resp, err := h.Handle(context.Background(), req, "key", "", access, runner, RunOptions{}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "table access denied") {
+		t.Fatalf("expected the corrected SQL to reach the table access check, got %v", err)
+	}
+	if len(runner.prompts) != 2 {
+		t.Fatalf("runs = %d, want 2 (one repair round, none after an access denial)", len(runner.prompts))
+	}
+	if !strings.Contains(runner.prompts[1], "Failed step: sql_validation") || !strings.Contains(runner.prompts[1], "DELETE FROM payment_ds.orders") {
+		t.Errorf("repair prompt does not describe the failure:\n%s", runner.prompts[1])
+	}
+	if h := runner.histories[1]; len(h) != 1 || h[0].Prompt != req.Prompt || h[0].Answer != first {
+		t.Errorf("repair history = %+v, want the failed turn", h)
+	}
+
+	attempts, _ := resp.AgentMetadata["sql_attempts"].([]sqlAttempt)
+	if len(attempts) != 2 || attempts[0].Step != "sql_validation" || attempts[1].Step != "table_access" {
+		t.Fatalf("sql_attempts = %+v", attempts)
+	}
+	if resp.AgentMetadata["sql_repair_rounds"] != 1 {
+		t.Errorf("sql_repair_rounds = %v, want 1", resp.AgentMetadata["sql_repair_rounds"])
+	}
+	if resp.AgentMetadata["sql_validation"] != "passed" {
+		t.Errorf("sql_validation = %v, want the last attempt's status", resp.AgentMetadata["sql_validation"])
+	}
+}
+
+func TestBigQueryHandle_RepairRoundsAreBounded(t *testing.T) {
+	for _, rounds := range []int{0, 1, 3} {
+		h := newTestBigQueryHandler()
+		h.SetMaxRepairRounds(rounds)
+		runner := &scriptedRunner{outputs: []string{"```sql\nSELECT * FROM ds.t; DROP TABLE ds.t\n```"}}
+		req := &models.AgentRequest{Prompt: "tampilkan data", Timeout: 30}
+
+		resp, err := h.Handle(context.Background(), req, "key", "", nil, runner, RunOptions{}, "", nil)
+		if err == nil || !strings.Contains(err.Error(), "SQL validation failed") {
+			t.Fatalf("rounds=%d: expected validation error, got %v", rounds, err)
+		}
+		if len(runner.prompts) != rounds+1 {
+			t.Errorf("rounds=%d: runs = %d, want %d", rounds, len(runner.prompts), rounds+1)
+		}
+		if resp.Status != "error" || resp.Answer == nil {
+			t.Errorf("rounds=%d: expected friendly error response, got %+v", rounds, resp)
+		}
+	}
+}
+
+func TestPostgresHandle_ReportsExecutionFailure(t *testing.T) {
+	registry := service.NewPGPoolRegistry()
+	registry.Register("payment", service.NewPostgresService("127.0.0.1", 1, "u", "p", "disable", 1))
+	h := NewPostgresHandler(nil, registry,
+		security.NewPIIDetector(nil),
+		security.NewPromptValidator(),
+		security.NewSQLValidator(),
+		security.NewPGCostTracker(0),
+		security.NewDataMasker(nil),
+		security.NewAuditLogger(false),
+		time.Minute, nil,
+	)
+	runner := &scriptedRunner{outputs: []string{"```sql\nSELECT id FROM orders LIMIT 5\n```"}}
+	db := "payment_db"
+	req := &models.AgentRequest{Prompt: "tampilkan 5 order", DatasetID: &db, Timeout: 30}
+
+	resp, err := h.Handle(context.Background(), req, "key", "payment", nil, runner, RunOptions{}, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "query execution failed") {
+		t.Fatalf("expected execution error instead of an empty success, got %v", err)
+	}
+	if len(runner.prompts) != 1 {
+		t.Errorf("runs = %d; a connection error must not be sent back to the LLM", len(runner.prompts))
+	}
+	if got, _ := resp.AgentMetadata["sql_execution"].(string); !strings.HasPrefix(got, "failed: ") {
+		t.Errorf("sql_execution = %q, want failed", got)
+	}
+}
@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy controls how a runner retries an LLM call that failed with a
+// rate limit, a server error or a transport error. The delay before retry n
+// (0-based) is BaseDelay·2ⁿ capped at MaxDelay, with jitter.
+type RetryPolicy struct {
+	MaxRetries int // retries after the first attempt; 0 = no retries
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// DefaultRetryPolicy is used by runners unless SetRetryPolicy is called.
+var DefaultRetryPolicy = RetryPolicy{MaxRetries: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: 8 * time.Second}
+
+// delay returns the backoff before retry n.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay << n
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	// Full jitter in the upper half spreads retries of concurrent requests.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// APIError is an error response from an LLM provider's HTTP API.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d: %s", e.StatusCode, e.Body)
+}
+
+// errStreamTruncated is returned when a streamed reply ends without its
+// terminator, e.g. because the connection was closed mid-answer: what was
+// received, tool-call arguments included, may be incomplete.
+var errStreamTruncated = errors.New("stream ended before the reply was complete")
+
+// streamingClient returns c without its overall timeout, which covers
+// reading the whole body and would cut long streamed answers off. Streamed
+// calls are bounded by their request context instead.
+func streamingClient(c *http.Client) *http.Client {
+	sc := *c
+	sc.Timeout = 0
+	return &sc
+}
+
+// noRetryError marks an error that must not be retried or failed over even
+// though its cause would be, e.g. a stream that broke after output was
+// already sent to the client.
+type noRetryError struct{ err error }
+
+func (e *noRetryError) Error() string { return e.err.Error() }
+func (e *noRetryError) Unwrap() error { return e.err }
+
+// isRetryable reports whether an LLM call failing with err may succeed when
+// repeated or sent to another provider: HTTP 429 and 5xx responses and
+// transport errors, truncated streams included. Cancellation and the
+// request's own deadline are not.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var noRetry *noRetryError
+	if errors.As(err, &noRetry) {
+		return false
+	}
+	if errors.Is(err, errStreamTruncated) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return retryableStatus(apiErr.StatusCode)
+	}
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return retryableStatus(anthropicErr.StatusCode)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryCall calls fn until it succeeds, fails with an error isRetryable
+// rejects, or policy's retries are used up, sleeping between attempts.
+func retryCall[T any](ctx context.Context, policy RetryPolicy, fn func() (T, error)) (T, error) {
+	for n := 0; ; n++ {
+		v, err := fn()
+		if err == nil || n >= policy.MaxRetries || !isRetryable(err) {
+			return v, err
+		}
+		d := policy.delay(n)
+		log.Warn().Err(err).Int("retry", n+1).Dur("backoff", d).Msg("LLM call failed, retrying")
+		select {
+		case <-ctx.Done():
+			return v, err
+		case <-time.After(d):
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// DefaultSchemaMaxTables is how many tables are described in full in the
+	// system prompt when SchemaSelectionOptions.MaxTables is zero.
+	DefaultSchemaMaxTables = 25
+
+	schemaEmbedTimeout     = 10 * time.Second // embedding the tables of a schema not seen before
+	schemaEmbedConcurrency = 4
+	maxSchemaVectors       = 20000 // cached table embeddings; the cache is cleared beyond this
+)
+
+// SchemaSelectionOptions controls how much of a dataset's or database's
+// schema is injected into the system prompt. Wide schemas are cut down to
+// the MaxTables tables scoring best against the prompt, plus a compact index
+// naming the others; the schema tools stay available for those.
+type SchemaSelectionOptions struct {
+	MaxTables int              // tables described in full; 0 = DefaultSchemaMaxTables, -1 = all
+	Embedder  service.Embedder // nil = lexical scoring only
+}
+
+// schemaTable is one table of a schemaSnapshot.
+type schemaTable struct {
+	Name        string   `json:"name"`              // as written in SQL: dataset.table or schema.table
+	Heading     string   `json:"heading"`           // "### " line, e.g. "ds.orders (1200 rows)"
+	Details     string   `json:"details,omitempty"` // rendered table metadata: keys, partitioning, ...
+	Columns     string   `json:"columns"`           // rendered column list
+	ColumnNames []string `json:"column_names"`
+	Description string   `json:"description,omitempty"`
+	Partition   string   `json:"partition,omitempty"` // column queries should filter on
+}
+
+// dryRunSchemaTools returns the schema inspection tools to hide from a dry
+// run, given as list, get-schema and sample-data tool names. The schema is
+// already in the system prompt, so none are needed, except the get-schema
+// tool for tables a cut-down schema leaves out.
+func dryRunSchemaTools(schema schemaSection, list, getSchema, sample string) []string {
+	if schema.complete() {
+		return []string{list, getSchema, sample}
+	}
+	return []string{list, sample}
+}
+
+// schemaReadyEvent is the "schema_ready" progress event for the dataset or
+// database (scope) name.
+func schemaReadyEvent(scope, name string, schema schemaSection) map[string]interface{} {
+	ev := map[string]interface{}{"step": "schema_ready", scope: name}
+	if !schema.complete() {
+		ev["tables_shown"] = schema.shown
+		ev["tables_total"] = schema.total
+	}
+	return ev
+}
+
+// schemaSnapshot is the schema of a dataset or database as kept in
+// schemaCache. The schema section of each request is rendered from it.
+type schemaSnapshot struct {
+	Title  string        `json:"title"` // "Dataset: ds" or "Database: db"
+	Tables []schemaTable `json:"tables"`
+}
+
+// getSnapshot returns the cached schema under key. Entries that do not
+// decode, such as those written by older versions, are reported as a miss.
+func (c *schemaCache) getSnapshot(key string) (*schemaSnapshot, bool) {
+	v, ok := c.get(key)
+	if !ok {
+		return nil, false
+	}
+	var snap schemaSnapshot
+	if err := json.Unmarshal([]byte(v), &snap); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("discarding undecodable schema cache entry")
+		return nil, false
+	}
+	return &snap, true
+}
+
+func (c *schemaCache) setSnapshot(key string, snap *schemaSnapshot) {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("schema cache encode failed")
+		return
+	}
+	c.set(key, string(b))
+}
+
+// schemaSection is the schema part of one request's system prompt.
+type schemaSection struct {
+	text  string
+	shown int // tables described in full
+	total int
+}
+
+// complete reports whether every table is described in full.
+func (s schemaSection) complete() bool { return s.shown == s.total }
+
+// record writes the table counts to metadata when the schema was cut down.
+func (s schemaSection) record(metadata map[string]interface{}) {
+	if s.complete() {
+		return
+	}
+	metadata["schema_tables_shown"] = s.shown
+	metadata["schema_tables_total"] = s.total
+}
+
+// render builds the schema section from the tables at indexes shown (in
+// snapshot order). complete closes a full schema; partial closes one cut
+// down by selection, whose remaining tables are listed by name only.
+func (s *schemaSnapshot) render(shown []int, complete, partial string) schemaSection {
+	var sb strings.Builder
+	sb.WriteString("\n\n## Available " + s.Title + "\n")
+	full := len(shown) == len(s.Tables)
+	if full {
+		sb.WriteString("The following tables and schemas are already available to you:\n\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("%d tables. The schemas of the %d most relevant to the question are below:\n\n", len(s.Tables), len(shown)))
+	}
+	inFull := make(map[int]bool, len(shown))
+	for _, i := range shown {
+		inFull[i] = true
+		t := s.Tables[i]
+		sb.WriteString("### " + t.Heading + "\n")
+		sb.WriteString(t.Details)
+		sb.WriteString(t.Columns)
+		sb.WriteString("\n")
+	}
+	s.writePartitionRule(&sb, shown)
+	if full {
+		sb.WriteString(complete)
+		return schemaSection{text: sb.String(), shown: len(shown), total: len(s.Tables)}
+	}
+
+	sb.WriteString("### Other tables (schema not shown)\n")
+	for i, t := range s.Tables {
+		if !inFull[i] {
+			sb.WriteString("- " + t.Name + "\n")
+		}
+	}
+	sb.WriteString(partial)
+	return schemaSection{text: sb.String(), shown: len(shown), total: len(s.Tables)}
+}
+
+// writePartitionRule tells the model to filter partitioned tables among
+// shown on their partition column, so queries scan only the partitions they
+// need.
+func (s *schemaSnapshot) writePartitionRule(sb *strings.Builder, shown []int) {
+	var parts []string
+	for _, i := range shown {
+		if t := s.Tables[i]; t.Partition != "" {
+			parts = append(parts, "- "+t.Name+": "+t.Partition+"\n")
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	sb.WriteString("PARTITIONED TABLES: every query on these tables MUST filter on the partition column in WHERE (e.g. a date range), or it scans the whole table:\n")
+	for _, p := range parts {
+		sb.WriteString(p)
+	}
+}
+
+// schemaSelector picks the tables of a schemaSnapshot to describe in full.
+type schemaSelector struct {
+	maxTables int
+	embedder  service.Embedder
+	vectors   *vectorCache
+}
+
+func newSchemaSelector(opts SchemaSelectionOptions) *schemaSelector {
+	if opts.MaxTables == 0 {
+		opts.MaxTables = DefaultSchemaMaxTables
+	}
+	sel := &schemaSelector{maxTables: opts.MaxTables, embedder: opts.Embedder}
+	if opts.Embedder != nil {
+		sel.vectors = &vectorCache{m: make(map[[32]byte][]float32)}
+	}
+	return sel
+}
+
+// schemaQuery is the text tables are scored against: the prompt and, for
+// follow-up turns, the prompts before it, which often name the tables.
+func schemaQuery(req *models.AgentRequest) string {
+	var sb strings.Builder
+	sb.WriteString(req.Prompt)
+	for _, turn := range req.History {
+		sb.WriteString("\n" + turn.Prompt)
+	}
+	return sb.String()
+}
+
+// pick returns the indexes, in snapshot order, of the tables of snap to
+// describe in full for query: all of them when there are at most maxTables,
+// else the maxTables best-scoring ones.
+func (sel *schemaSelector) pick(ctx context.Context, snap *schemaSnapshot, query string) []int {
+	n := len(snap.Tables)
+	if sel.maxTables < 0 || n <= sel.maxTables {
+		all := make([]int, n)
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+
+	scores := lexicalScores(snap.Tables, query)
+	if sim := sel.similarities(ctx, snap.Tables, query); sim != nil {
+		maxLex := 0.0
+		for _, s := range scores {
+			maxLex = max(maxLex, s)
+		}
+		for i := range scores {
+			if maxLex > 0 {
+				scores[i] /= maxLex
+			}
+			scores[i] += max(sim[i], 0)
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	picked := order[:sel.maxTables]
+	sort.Ints(picked)
+	return picked
+}
+
+// similarities returns the cosine similarity of each table to query, or
+// nil without an embedder or when embedding fails.
+func (sel *schemaSelector) similarities(ctx context.Context, tables []schemaTable, query string) []float64 {
+	if sel.embedder == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, schemaEmbedTimeout)
+	defer cancel()
+
+	q, err := sel.embedder.Embed(ctx, query)
+	if err != nil {
+		log.Warn().Err(err).Msg("schema selection: prompt embedding failed, using lexical scores only")
+		return nil
+	}
+	vecs := make([][]float32, len(tables))
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(schemaEmbedConcurrency)
+	for i, t := range tables {
+		text := tableEmbeddingText(t)
+		if v, ok := sel.vectors.get(text); ok {
+			vecs[i] = v
+			continue
+		}
+		g.Go(func() error {
+			v, err := sel.embedder.Embed(gctx, text)
+			if err != nil {
+				return err
+			}
+			sel.vectors.put(text, v)
+			vecs[i] = v
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		log.Warn().Err(err).Msg("schema selection: table embedding failed, using lexical scores only")
+		return nil
+	}
+	sims := make([]float64, len(tables))
+	for i, v := range vecs {
+		sims[i] = cosineSimilarity(q, v)
+	}
+	return sims
+}
+
+// tableEmbeddingText is the text a table is embedded as.
+func tableEmbeddingText(t schemaTable) string {
+	text := t.Name + ": " + strings.Join(t.ColumnNames, ", ")
+	if t.Description != "" {
+		text += "\n" + t.Description
+	}
+	return text
+}
+
+// vectorCache keeps embeddings by text, so each table is embedded once
+// until its schema changes and each example prompt once.
+type vectorCache struct {
+	mu sync.Mutex
+	m  map[[32]byte][]float32
+}
+
+func (c *vectorCache) get(text string) ([]float32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.m[sha256.Sum256([]byte(text))]
+	return v, ok
+}
+
+func (c *vectorCache) put(text string, v []float32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.m) >= maxSchemaVectors {
+		c.m = make(map[[32]byte][]float32)
+	}
+	c.m[sha256.Sum256([]byte(text))] = v
+}
+
+// ── Lexical scoring ──────────────────────────────────────────────────────────
+
+// Weights of a prompt word matching a table's name, one of its columns, or
+// its description. Each prompt word counts once, at its best match, so wide
+// tables do not win on column count alone.
+const (
+	tableNameWeight   = 3.0
+	columnNameWeight  = 1.0
+	descriptionWeight = 0.5
+)
+
+// schemaStopwords are English and Indonesian words too common in questions
+// to say anything about the tables they need.
+var schemaStopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "in": true, "on": true, "at": true,
+	"for": true, "to": true, "by": true, "and": true, "or": true, "with": true,
+	"from": true, "what": true, "which": true, "who": true, "show": true, "list": true,
+	"give": true, "get": true, "me": true, "how": true, "many": true, "much": true,
+	"is": true, "are": true, "was": true, "were": true, "per": true, "each": true,
+	"all": true, "top": true, "this": true, "that": true, "data": true, "table": true,
+	"yang": true, "dan": true, "di": true, "ke": true, "dari": true, "untuk": true,
+	"dengan": true, "berapa": true, "apa": true, "siapa": true, "tampilkan": true,
+	"daftar": true, "semua": true, "ini": true, "itu": true, "pada": true, "oleh": true,
+	"atau": true, "tabel": true, "saya": true, "tolong": true, "berikan": true,
+}
+
+// schemaTokens splits s into lower-case words on anything but letters and
+// digits (so snake_case names split too), dropping stopwords and reducing
+// English plurals.
+func schemaTokens(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := words[:0]
+	for _, w := range words {
+		if len(w) < 2 || schemaStopwords[w] {
+			continue
+		}
+		out = append(out, singular(w))
+	}
+	return out
+}
+
+func singular(w string) string {
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 3 && strings.HasSuffix(w, "s") && !strings.HasSuffix(w, "ss"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// wordMatch scores how well prompt word p matches schema word w: 1 when
+// equal, 0.6 when they share a prefix of 5+ letters ("transaksi" and
+// "transaction"), else 0.
+func wordMatch(p, w string) float64 {
+	if p == w {
+		return 1
+	}
+	n := 0
+	for n < len(p) && n < len(w) && p[n] == w[n] {
+		n++
+	}
+	if n >= 5 {
+		return 0.6
+	}
+	return 0
+}
+
+// lexicalScores scores each table against query.
+func lexicalScores(tables []schemaTable, query string) []float64 {
+	words := schemaTokens(query)
+	lowerQuery := strings.ToLower(query)
+	scores := make([]float64, len(tables))
+	for i, t := range tables {
+		nameWords := schemaTokens(t.Name)
+		var colWords []string
+		for _, c := range t.ColumnNames {
+			colWords = append(colWords, schemaTokens(c)...)
+		}
+		descWords := schemaTokens(t.Description)
+
+		var score float64
+		for _, p := range words {
+			best := 0.0
+			for _, w := range nameWords {
+				best = max(best, tableNameWeight*wordMatch(p, w))
+			}
+			for _, w := range colWords {
+				best = max(best, columnNameWeight*wordMatch(p, w))
+			}
+			for _, w := range descWords {
+				best = max(best, descriptionWeight*wordMatch(p, w))
+			}
+			score += best
+		}
+		// A table named outright ("orders_daily") is almost certainly needed.
+		short := t.Name[strings.LastIndex(t.Name, ".")+1:]
+		if len(short) >= 4 && strings.Contains(lowerQuery, strings.ToLower(short)) {
+			score += 2 * tableNameWeight
+		}
+		scores[i] = score
+	}
+	return scores
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wideSnapshot is a dataset of filler tables with a few real ones mixed in.
+func wideSnapshot(fillers int) *schemaSnapshot {
+	snap := &schemaSnapshot{Title: "Dataset: ds"}
+	add := func(name string, cols ...string) {
+		snap.Tables = append(snap.Tables, schemaTable{
+			Name:        "ds." + name,
+			Heading:     "ds." + name + " (10 rows)",
+			Columns:     "  - " + strings.Join(cols, "\n  - ") + "\n",
+			ColumnNames: cols,
+		})
+	}
+	for i := 0; i < fillers; i++ {
+		add(fmt.Sprintf("audit_log_%02d", i), "id", "created_at", "actor")
+	}
+	add("orders", "order_id", "customer_id", "amount", "created_at")
+	add("payments", "payment_id", "order_id", "method", "paid_amount")
+	add("drivers", "driver_id", "name", "rating")
+	return snap
+}
+
+func tableNames(snap *schemaSnapshot, idx []int) []string {
+	names := make([]string, len(idx))
+	for i, j := range idx {
+		names[i] = snap.Tables[j].Name
+	}
+	return names
+}
+
+func TestSchemaSelectorPick_AllTablesWhenNarrow(t *testing.T) {
+	snap := wideSnapshot(2)
+	sel := newSchemaSelector(SchemaSelectionOptions{})
+	got := sel.pick(context.Background(), snap, "anything")
+	if len(got) != len(snap.Tables) {
+		t.Fatalf("picked %d of %d tables, want all", len(got), len(snap.Tables))
+	}
+
+	wide := wideSnapshot(40)
+	all := newSchemaSelector(SchemaSelectionOptions{MaxTables: -1})
+	if got := all.pick(context.Background(), wide, "anything"); len(got) != len(wide.Tables) {
+		t.Errorf("MaxTables -1: picked %d of %d tables, want all", len(got), len(wide.Tables))
+	}
+}
+
+func TestSchemaSelectorPick_LexicalRanking(t *testing.T) {
+	snap := wideSnapshot(30)
+	sel := newSchemaSelector(SchemaSelectionOptions{MaxTables: 2})
+
+	got := tableNames(snap, sel.pick(context.Background(), snap, "total paid amount per payment method for each order"))
+	want := []string{"ds.orders", "ds.payments"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("picked %v, want %v", got, want)
+	}
+
+	// Indonesian prompt naming the table outright.
+	got = tableNames(snap, sel.pick(context.Background(), snap, "rata-rata rating drivers bulan ini"))
+	if !slices.Contains(got, "ds.drivers") {
+		t.Errorf("picked %v, want ds.drivers among them", got)
+	}
+}
+
+func TestSchemaSelectorPick_EmbeddingsRankUnmatchedWords(t *testing.T) {
+	snap := wideSnapshot(3)
+	query := "pendapatan mitra bulan lalu" // no word matches any table
+	vectors := map[string][]float32{query: {0, 1}}
+	for _, tbl := range snap.Tables {
+		v := []float32{1, 0}
+		if tbl.Name == "ds.drivers" {
+			v = []float32{0.1, 1}
+		}
+		vectors[tableEmbeddingText(tbl)] = v
+	}
+	sel := newSchemaSelector(SchemaSelectionOptions{MaxTables: 1, Embedder: &fakeEmbedder{vectors: vectors}})
+
+	if got := tableNames(snap, sel.pick(context.Background(), snap, query)); !reflect.DeepEqual(got, []string{"ds.drivers"}) {
+		t.Errorf("picked %v, want [ds.drivers]", got)
+	}
+
+	// Without a vector for the prompt, selection falls back to lexical scores.
+	got := tableNames(snap, sel.pick(context.Background(), snap, "payment method"))
+	if !reflect.DeepEqual(got, []string{"ds.payments"}) {
+		t.Errorf("fallback picked %v, want [ds.payments]", got)
+	}
+}
+
+func TestSchemaSnapshotRender(t *testing.T) {
+	snap := wideSnapshot(2)
+
+	full := snap.render([]int{0, 1, 2, 3, 4}, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction)
+	if !full.complete() {
+		t.Error("all tables shown: want a complete section")
+	}
+	if !strings.Contains(full.text, "## Available Dataset: ds\nThe following tables and schemas are already available to you:") ||
+		!strings.HasSuffix(full.text, BQSchemaClosingInstruction) ||
+		strings.Contains(full.text, "Other tables") {
+		t.Errorf("unexpected full section:\n%s", full.text)
+	}
+	metadata := map[string]interface{}{}
+	full.record(metadata)
+	if len(metadata) != 0 {
+		t.Errorf("complete section recorded %v, want nothing", metadata)
+	}
+
+	part := snap.render([]int{2, 3}, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction)
+	if part.complete() || part.shown != 2 || part.total != 5 {
+		t.Errorf("partial section counts = %d/%d", part.shown, part.total)
+	}
+	for _, want := range []string{
+		"5 tables. The schemas of the 2 most relevant",
+		"### ds.orders (10 rows)\n",
+		"### ds.payments (10 rows)\n",
+		"### Other tables (schema not shown)\n- ds.audit_log_00\n- ds.audit_log_01\n- ds.drivers\n",
+	} {
+		if !strings.Contains(part.text, want) {
+			t.Errorf("partial section missing %q:\n%s", want, part.text)
+		}
+	}
+	if !strings.HasSuffix(part.text, BQPartialSchemaClosingInstruction) {
+		t.Errorf("partial section must end with the partial closing instruction:\n%s", part.text)
+	}
+	part.record(metadata)
+	if metadata["schema_tables_shown"] != 2 || metadata["schema_tables_total"] != 5 {
+		t.Errorf("metadata = %v", metadata)
+	}
+
+	if got := dryRunSchemaTools(part, "list", "schema", "sample"); !reflect.DeepEqual(got, []string{"list", "sample"}) {
+		t.Errorf("partial dry run excludes %v, want get-schema kept", got)
+	}
+	if got := dryRunSchemaTools(full, "list", "schema", "sample"); len(got) != 3 {
+		t.Errorf("complete dry run excludes %v, want all three", got)
+	}
+}
+
+func TestPartialSchemaClosingInstructions_KeepGetSchema(t *testing.T) {
+	for name, s := range map[string]string{
+		"bq": BQPartialSchemaClosingInstruction,
+		"pg": PGPartialSchemaClosingInstruction,
+	} {
+		if !strings.Contains(s, "IMPORTANT:") || !strings.Contains(s, "at most 1 execute call") {
+			t.Errorf("%s: partial closing instruction must be directive, got %q", name, s)
+		}
+	}
+	if !strings.Contains(BQPartialSchemaClosingInstruction, "DO NOT call list_bigquery_tables") ||
+		!strings.Contains(BQPartialSchemaClosingInstruction, "call get_bigquery_schema for that table only") {
+		t.Errorf("bq partial closing instruction: %q", BQPartialSchemaClosingInstruction)
+	}
+	if !strings.Contains(PGPartialSchemaClosingInstruction, "DO NOT call list_postgres_tables") ||
+		!strings.Contains(PGPartialSchemaClosingInstruction, "call get_postgres_schema for that table only") {
+		t.Errorf("pg partial closing instruction: %q", PGPartialSchemaClosingInstruction)
+	}
+}
+
+func TestSchemaCacheSnapshot_RoundTripAndStaleEntry(t *testing.T) {
+	c := newSchemaCache(time.Minute)
+	snap := wideSnapshot(1)
+	c.setSnapshot("ds", snap)
+	got, ok := c.getSnapshot("ds")
+	if !ok || !reflect.DeepEqual(got, snap) {
+		t.Errorf("round trip = %+v, %v", got, ok)
+	}
+
+	// A pre-rendered section from an older version is a miss.
+	c.set("old", "\n\n## Available Dataset: old\n")
+	if _, ok := c.getSnapshot("old"); ok {
+		t.Error("undecodable entry should be a miss")
+	}
+}
+
+func TestSchemaSnapshotRender_DetailsAndPartitionRule(t *testing.T) {
+	snap := wideSnapshot(1)
+	snap.Tables[1].Details = "Partitioned by: created_at (DAY)\nClustered by: customer_id\n"
+	snap.Tables[1].Partition = "created_at"
+	snap.Tables[2].Partition = "_PARTITIONTIME"
+
+	got := snap.render([]int{0, 1, 2, 3}, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction).text
+	for _, want := range []string{
+		"### ds.orders (10 rows)\nPartitioned by: created_at (DAY)\nClustered by: customer_id\n  - order_id\n",
+		"MUST filter on the partition column in WHERE",
+		"- ds.orders: created_at\n- ds.payments: _PARTITIONTIME\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("section missing %q:\n%s", want, got)
+		}
+	}
+
+	// Only shown tables are listed.
+	got = snap.render([]int{0, 1}, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction).text
+	if strings.Contains(got, "ds.payments: _PARTITIONTIME") {
+		t.Errorf("partition rule lists a table that is not shown:\n%s", got)
+	}
+	got = snap.render([]int{0, 3}, BQSchemaClosingInstruction, BQPartialSchemaClosingInstruction).text
+	if strings.Contains(got, "PARTITIONED TABLES") {
+		t.Errorf("partition rule without partitioned tables:\n%s", got)
+	}
+}
@@ -0,0 +1,408 @@
+package agent
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultSemanticCacheThreshold is the minimum cosine similarity for an
+	// embedding match when SemanticCacheOptions.Threshold is zero.
+	DefaultSemanticCacheThreshold = 0.92
+
+	maxSemanticEntriesPerScope = 1000 // oldest entries are evicted beyond this
+	embedTimeout               = 3 * time.Second
+)
+
+// SemanticCacheOptions enables near-duplicate prompt matching on the response
+// cache. Prompts are always normalized (case, whitespace, number words, date
+// phrases) before keying; with an Embedder, prompts whose embeddings reach
+// Threshold cosine similarity also share an entry.
+type SemanticCacheOptions struct {
+	Threshold float64          // 0 = DefaultSemanticCacheThreshold
+	Embedder  service.Embedder // nil = normalized exact match only
+}
+
+// ── Prompt normalization ─────────────────────────────────────────────────────
+
+// Number words in English and Indonesian. Indonesian compounds are built from
+// units plus the "belas" (+10), "puluh" (×10), "ratus" (×100) and "ribu"
+// (×1000) suffix words; "se-" forms are listed directly.
+var (
+	numberUnits = map[string]int{
+		"zero": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+		"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+		"thirteen": 13, "fourteen": 14, "fifteen": 15, "sixteen": 16,
+		"seventeen": 17, "eighteen": 18, "nineteen": 19,
+		"nol": 0, "satu": 1, "dua": 2, "tiga": 3, "empat": 4, "lima": 5, "enam": 6,
+		"tujuh": 7, "delapan": 8, "sembilan": 9,
+		"sepuluh": 10, "sebelas": 11,
+	}
+	numberTens = map[string]int{
+		"twenty": 20, "thirty": 30, "forty": 40, "fifty": 50,
+		"sixty": 60, "seventy": 70, "eighty": 80, "ninety": 90,
+	}
+	numberHundreds  = map[string]bool{"hundred": true, "ratus": true}
+	numberThousands = map[string]bool{"thousand": true, "ribu": true}
+)
+
+// datePhrases maps relative date expressions to canonical tokens. Patterns are
+// applied in order to the lower-cased, number-normalized prompt, so longer
+// phrases ("minggu kemarin") must come before their substrings ("kemarin").
+var datePhrases = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`\b(?:(?:in the )?(?:last|past|previous) (\d+) days?|(\d+) hari (?:terakhir|ke ?belakang))\b`), "@last_${1}${2}_days"},
+	{regexp.MustCompile(`\b(?:(?:in the )?(?:last|past|previous) (\d+) weeks?|(\d+) (?:minggu|pekan) (?:terakhir|ke ?belakang))\b`), "@last_${1}${2}_weeks"},
+	{regexp.MustCompile(`\b(?:(?:in the )?(?:last|past|previous) (\d+) months?|(\d+) bulan (?:terakhir|ke ?belakang))\b`), "@last_${1}${2}_months"},
+	{regexp.MustCompile(`\b(?:(?:last|previous) week|(?:minggu|pekan) (?:lalu|kemarin|sebelumnya))\b`), "@last_week"},
+	{regexp.MustCompile(`\b(?:this week|(?:minggu|pekan) ini)\b`), "@this_week"},
+	{regexp.MustCompile(`\b(?:(?:last|previous) month|bulan (?:lalu|kemarin|sebelumnya))\b`), "@last_month"},
+	{regexp.MustCompile(`\b(?:this month|bulan ini)\b`), "@this_month"},
+	{regexp.MustCompile(`\b(?:(?:last|previous) year|tahun (?:lalu|kemarin|sebelumnya))\b`), "@last_year"},
+	{regexp.MustCompile(`\b(?:this year|tahun ini)\b`), "@this_year"},
+	{regexp.MustCompile(`\b(?:yesterday|kemarin)\b`), "@yesterday"},
+	{regexp.MustCompile(`\b(?:today|hari ini)\b`), "@today"},
+}
+
+// normalizePrompt canonicalizes a prompt so trivially different phrasings map
+// to the same cache key: lower case, collapsed whitespace, surrounding
+// punctuation stripped, English/Indonesian number words converted to digits
+// ("sepuluh", "ten" → "10"), and relative date phrases converted to tokens
+// ("7 hari terakhir", "last seven days" → "@last_7_days").
+func normalizePrompt(prompt string) string {
+	var tokens []string
+	for _, f := range strings.Fields(strings.ToLower(prompt)) {
+		f = strings.Trim(f, `?!.,;:"'()`)
+		if f == "" {
+			continue
+		}
+		// Split hyphenated number words ("twenty-five") but keep other
+		// hyphenated terms ("e-wallet") intact.
+		if parts := strings.Split(f, "-"); len(parts) > 1 && allNumberWords(parts) {
+			tokens = append(tokens, parts...)
+			continue
+		}
+		tokens = append(tokens, f)
+	}
+	s := strings.Join(replaceNumberWords(tokens), " ")
+	for _, dp := range datePhrases {
+		s = dp.re.ReplaceAllString(s, dp.repl)
+	}
+	return s
+}
+
+// promptAnchors returns the tokens of a normalized prompt that hold a digit
+// or are date tokens, sorted: the limits, years and periods that change the
+// answer however close two prompts' embeddings are.
+func promptAnchors(normalized string) string {
+	var anchors []string
+	for _, f := range strings.Fields(normalized) {
+		if strings.HasPrefix(f, "@") || strings.ContainsAny(f, "0123456789") {
+			anchors = append(anchors, f)
+		}
+	}
+	sort.Strings(anchors)
+	return strings.Join(anchors, " ")
+}
+
+func isNumberWord(w string) bool {
+	_, unit := numberUnits[w]
+	_, tens := numberTens[w]
+	return unit || tens || numberHundreds[w] || numberThousands[w] ||
+		w == "belas" || w == "puluh" || w == "seratus" || w == "seribu"
+}
+
+func allNumberWords(ws []string) bool {
+	for _, w := range ws {
+		if !isNumberWord(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceNumberWords collapses each run of number words into its digits.
+// Modifier words ("belas", "puluh", "hundred", ...) on their own are left
+// untouched, so "ratusan transaksi" or "hundreds" are not rewritten.
+func replaceNumberWords(tokens []string) []string {
+	out := make([]string, 0, len(tokens))
+	for i := 0; i < len(tokens); {
+		j := i
+		for j < len(tokens) && isNumberWord(tokens[j]) {
+			j++
+		}
+		if j == i {
+			out = append(out, tokens[i])
+			i++
+			continue
+		}
+		if n, ok := parseNumberWords(tokens[i:j]); ok {
+			out = append(out, strconv.Itoa(n))
+		} else {
+			out = append(out, tokens[i:j]...)
+		}
+		i = j
+	}
+	return out
+}
+
+// parseNumberWords evaluates a run of number words. It reports false when the
+// run does not start with a value word (e.g. a lone "puluh").
+func parseNumberWords(words []string) (int, bool) {
+	total, current := 0, 0
+	seenValue := false
+	for _, w := range words {
+		switch {
+		case w == "seratus":
+			current += 100
+			seenValue = true
+		case w == "seribu":
+			total += 1000
+			seenValue = true
+		case w == "belas":
+			if !seenValue {
+				return 0, false
+			}
+			current += 10
+		case w == "puluh":
+			if !seenValue {
+				return 0, false
+			}
+			current = current/10*10 + current%10*10
+		case numberHundreds[w]:
+			if !seenValue {
+				return 0, false
+			}
+			current = current/100*100 + current%100*100
+		case numberThousands[w]:
+			if !seenValue {
+				return 0, false
+			}
+			total += current * 1000
+			current = 0
+		default:
+			if v, ok := numberUnits[w]; ok {
+				current += v
+			} else {
+				current += numberTens[w]
+			}
+			seenValue = true
+		}
+	}
+	return total + current, seenValue
+}
+
+// ── Embedding index ──────────────────────────────────────────────────────────
+
+// semanticEntry points an embedded prompt at its response cache key.
+type semanticEntry struct {
+	prompt    string // original prompt of the cached response
+	anchors   string // promptAnchors of the normalized prompt
+	key       string
+	vector    []float32
+	expiresAt time.Time
+}
+
+// semanticIndex is a per-replica, per-scope list of embedded prompts. The
+// responses themselves live in the response cache backend, so entries whose
+// response was flushed elsewhere simply miss on lookup.
+type semanticIndex struct {
+	mu     sync.Mutex
+	scopes map[string][]semanticEntry
+}
+
+func newSemanticIndex() *semanticIndex {
+	return &semanticIndex{scopes: make(map[string][]semanticEntry)}
+}
+
+// add records e under scope, replacing an entry with the same key and
+// evicting the oldest entries beyond maxSemanticEntriesPerScope.
+func (ix *semanticIndex) add(scope string, e semanticEntry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	entries := ix.scopes[scope]
+	for i := range entries {
+		if entries[i].key == e.key {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	entries = append(entries, e)
+	if len(entries) > maxSemanticEntriesPerScope {
+		entries = entries[len(entries)-maxSemanticEntriesPerScope:]
+	}
+	ix.scopes[scope] = entries
+}
+
+// best returns the unexpired entry in scope with the given anchors that is
+// most similar to vec. Expired entries are dropped as a side effect.
+func (ix *semanticIndex) best(scope, anchors string, vec []float32, now time.Time) (semanticEntry, float64, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	entries := ix.scopes[scope]
+	live := entries[:0]
+	var best semanticEntry
+	bestSim, found := -1.0, false
+	for _, e := range entries {
+		if now.After(e.expiresAt) {
+			continue
+		}
+		live = append(live, e)
+		if e.anchors != anchors {
+			continue
+		}
+		if sim := cosineSimilarity(vec, e.vector); sim > bestSim {
+			best, bestSim, found = e, sim, true
+		}
+	}
+	ix.scopes[scope] = live
+	return best, bestSim, found
+}
+
+func (ix *semanticIndex) reset() {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.scopes = make(map[string][]semanticEntry)
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0 when
+// the lengths differ or either vector is zero.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// ── Response cache integration ───────────────────────────────────────────────
+
+// semanticMatcher is the optional semantic layer of a responseCache.
+type semanticMatcher struct {
+	embedder  service.Embedder
+	threshold float64
+	index     *semanticIndex
+}
+
+func newSemanticMatcher(opts SemanticCacheOptions) *semanticMatcher {
+	if opts.Threshold <= 0 || opts.Threshold > 1 {
+		opts.Threshold = DefaultSemanticCacheThreshold
+	}
+	return &semanticMatcher{embedder: opts.Embedder, threshold: opts.Threshold, index: newSemanticIndex()}
+}
+
+// responseCacheProbe carries what lookup computed so store can reuse it.
+type responseCacheProbe struct {
+	key     string
+	scope   string
+	prompt  string
+	anchors string
+	vector  []float32 // nil unless an embedder is configured and succeeded
+}
+
+// lookup finds a cached response for prompt within (scopeID, promptStyle).
+// Without a semantic layer this is the exact-match lookup. With one, the
+// normalized prompt is tried first and then, if an embedder is configured,
+// the most similar cached prompt at or above the threshold that has the same
+// numbers and date tokens, so "top 100 drivers last week" never gets the
+// answer to "top 10 drivers last week" or "top 10 drivers yesterday".
+// Semantic hits report the matched prompt, similarity and match method in
+// agent_metadata.
+// The returned probe must be passed to store on a miss.
+func (c *responseCache) lookup(ctx context.Context, prompt, scopeID, promptStyle string) (*models.AgentResponse, *responseCacheProbe) {
+	if c.semantic == nil {
+		probe := &responseCacheProbe{key: responseCacheKey(prompt, scopeID, promptStyle), prompt: prompt}
+		if cached, ok := c.get(probe.key); ok {
+			cached.AgentMetadata["response_cache"] = "hit"
+			return cached, probe
+		}
+		return nil, probe
+	}
+
+	normalized := normalizePrompt(prompt)
+	probe := &responseCacheProbe{
+		key:     responseCacheKey(normalized, scopeID, promptStyle),
+		scope:   scopeID + "|" + promptStyle,
+		prompt:  prompt,
+		anchors: promptAnchors(normalized),
+	}
+	if cached, ok := c.get(probe.key); ok {
+		method := "exact"
+		if cached.Prompt != prompt {
+			method = "normalized"
+		}
+		return annotateSemanticHit(cached, prompt, method, 1), probe
+	}
+	if c.semantic.embedder == nil {
+		return nil, probe
+	}
+
+	embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+	vec, err := c.semantic.embedder.Embed(embedCtx, normalized)
+	if err != nil {
+		log.Warn().Err(err).Msg("semantic cache embedding failed, using exact match only")
+		return nil, probe
+	}
+	probe.vector = vec
+
+	entry, sim, ok := c.semantic.index.best(probe.scope, probe.anchors, vec, time.Now())
+	if !ok || sim < c.semantic.threshold {
+		return nil, probe
+	}
+	cached, ok := c.get(entry.key)
+	if !ok {
+		return nil, probe
+	}
+	return annotateSemanticHit(cached, prompt, "embedding", sim), probe
+}
+
+// store caches resp under the probe's key and, with an embedder, indexes the
+// prompt embedding for later similarity matches.
+func (c *responseCache) store(probe *responseCacheProbe, resp *models.AgentResponse) {
+	if probe == nil {
+		return
+	}
+	c.set(probe.key, resp)
+	if c.semantic != nil && probe.vector != nil {
+		c.semantic.index.add(probe.scope, semanticEntry{
+			prompt:    probe.prompt,
+			anchors:   probe.anchors,
+			key:       probe.key,
+			vector:    probe.vector,
+			expiresAt: time.Now().Add(c.ttl),
+		})
+	}
+}
+
+// annotateSemanticHit rewrites a cached response for the current prompt and
+// records which cached prompt it matched and how closely.
+func annotateSemanticHit(cached *models.AgentResponse, prompt, method string, similarity float64) *models.AgentResponse {
+	matched := cached.Prompt
+	cached.Prompt = prompt
+	cached.AgentMetadata["response_cache"] = "hit"
+	cached.AgentMetadata["response_cache_match"] = method
+	cached.AgentMetadata["response_cache_matched_prompt"] = matched
+	cached.AgentMetadata["response_cache_similarity"] = math.Round(similarity*10000) / 10000
+	return cached
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+// ── normalizePrompt ──────────────────────────────────────────────────────────
+
+func TestNormalizePrompt(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"  Top 10   Drivers by Rating? ", "top 10 drivers by rating"},
+		{"top ten drivers by rating", "top 10 drivers by rating"},
+		{"tampilkan sepuluh driver teratas", "tampilkan 10 driver teratas"},
+		{"dua puluh lima transaksi terakhir", "25 transaksi terakhir"},
+		{"seratus dua belas order", "112 order"},
+		{"twenty-five orders", "25 orders"},
+		{"tiga ratus ribu rupiah", "300000 rupiah"},
+		{"e-wallet top up hari ini", "e-wallet top up @today"},
+		{"orders today", "orders @today"},
+		{"total order 7 hari terakhir", "total order @last_7_days"},
+		{"total orders in the last seven days", "total orders @last_7_days"},
+		{"revenue bulan lalu vs bulan ini", "revenue @last_month vs @this_month"},
+		{"transaksi minggu kemarin", "transaksi @last_week"},
+		{"transaksi kemarin", "transaksi @yesterday"},
+		{"ratusan transaksi", "ratusan transaksi"},
+	}
+	for _, tt := range tests {
+		if got := normalizePrompt(tt.in); got != tt.want {
+			t.Errorf("normalizePrompt(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizePrompt_EquivalentPhrasingsShareKey(t *testing.T) {
+	a := normalizePrompt("Tampilkan SEPULUH transaksi terakhir hari ini")
+	b := normalizePrompt("tampilkan 10 transaksi terakhir  hari ini.")
+	if a != b {
+		t.Errorf("expected same normalized prompt, got %q vs %q", a, b)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	if got := cosineSimilarity([]float32{1, 0}, []float32{1, 0}); got < 0.9999 {
+		t.Errorf("identical vectors: got %f, want 1", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, []float32{0, 1}); got != 0 {
+		t.Errorf("orthogonal vectors: got %f, want 0", got)
+	}
+	if got := cosineSimilarity([]float32{1, 0}, []float32{1, 0, 0}); got != 0 {
+		t.Errorf("length mismatch: got %f, want 0", got)
+	}
+}
+
+// ── semantic response cache ──────────────────────────────────────────────────
+
+// fakeEmbedder returns fixed vectors per normalized prompt.
+type fakeEmbedder struct {
+	vectors map[string][]float32
+}
+
+func (f *fakeEmbedder) Embed(_ context.Context, text string) ([]float32, error) {
+	if v, ok := f.vectors[text]; ok {
+		return v, nil
+	}
+	return nil, errors.New("no vector for " + text)
+}
+
+func cachedResp(prompt string) *models.AgentResponse {
+	return &models.AgentResponse{
+		Status:        "success",
+		Prompt:        prompt,
+		AgentMetadata: map[string]interface{}{"response_cache": "miss"},
+	}
+}
+
+func TestResponseCacheLookup_ExactModeUnchanged(t *testing.T) {
+	ctx := context.Background()
+	c := newResponseCache(time.Minute)
+
+	got, probe := c.lookup(ctx, "top 10 drivers", "ds", "technical")
+	if got != nil {
+		t.Fatal("expected miss on empty cache")
+	}
+	c.store(probe, cachedResp("top 10 drivers"))
+
+	if got, _ := c.lookup(ctx, "Top ten drivers", "ds", "technical"); got != nil {
+		t.Error("without semantic cache, a differently phrased prompt must miss")
+	}
+	got, _ = c.lookup(ctx, "top 10 drivers", "ds", "technical")
+	if got == nil {
+		t.Fatal("expected exact hit")
+	}
+	if got.AgentMetadata["response_cache"] != "hit" {
+		t.Errorf("response_cache = %v, want hit", got.AgentMetadata["response_cache"])
+	}
+	if _, ok := got.AgentMetadata["response_cache_similarity"]; ok {
+		t.Error("exact mode must not report similarity")
+	}
+}
+
+func TestResponseCacheLookup_NormalizedHit(t *testing.T) {
+	ctx := context.Background()
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{})
+
+	_, probe := c.lookup(ctx, "tampilkan sepuluh transaksi hari ini", "ds", "technical")
+	c.store(probe, cachedResp("tampilkan sepuluh transaksi hari ini"))
+
+	got, _ := c.lookup(ctx, "Tampilkan 10 transaksi  hari ini?", "ds", "technical")
+	if got == nil {
+		t.Fatal("expected normalized hit")
+	}
+	if got.AgentMetadata["response_cache_match"] != "normalized" {
+		t.Errorf("match = %v, want normalized", got.AgentMetadata["response_cache_match"])
+	}
+	if got.AgentMetadata["response_cache_matched_prompt"] != "tampilkan sepuluh transaksi hari ini" {
+		t.Errorf("matched_prompt = %v", got.AgentMetadata["response_cache_matched_prompt"])
+	}
+	if got.AgentMetadata["response_cache_similarity"] != 1.0 {
+		t.Errorf("similarity = %v, want 1", got.AgentMetadata["response_cache_similarity"])
+	}
+	if got.Prompt != "Tampilkan 10 transaksi  hari ini?" {
+		t.Errorf("hit should carry the current prompt, got %q", got.Prompt)
+	}
+}
+
+func TestResponseCacheLookup_EmbeddingHitAboveThreshold(t *testing.T) {
+	ctx := context.Background()
+	emb := &fakeEmbedder{vectors: map[string][]float32{
+		"top 10 drivers by rating":          {1, 0, 0},
+		"show the 10 highest rated drivers": {0.98, 0.2, 0},
+		"top 10 drivers by revenue":         {0.6, 0.8, 0},
+	}}
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{Threshold: 0.95, Embedder: emb})
+
+	_, probe := c.lookup(ctx, "top 10 drivers by rating", "ds", "executive")
+	c.store(probe, cachedResp("top 10 drivers by rating"))
+
+	got, _ := c.lookup(ctx, "show the 10 highest rated drivers", "ds", "executive")
+	if got == nil {
+		t.Fatal("expected embedding hit")
+	}
+	if got.AgentMetadata["response_cache_match"] != "embedding" {
+		t.Errorf("match = %v, want embedding", got.AgentMetadata["response_cache_match"])
+	}
+	if got.AgentMetadata["response_cache_matched_prompt"] != "top 10 drivers by rating" {
+		t.Errorf("matched_prompt = %v", got.AgentMetadata["response_cache_matched_prompt"])
+	}
+	if sim, _ := got.AgentMetadata["response_cache_similarity"].(float64); sim < 0.95 || sim >= 1 {
+		t.Errorf("similarity = %v, want in [0.95, 1)", sim)
+	}
+
+	if got, _ := c.lookup(ctx, "top 10 drivers by revenue", "ds", "executive"); got != nil {
+		t.Error("prompt below threshold must miss")
+	}
+}
+
+func TestResponseCacheLookup_EmbeddingHitNeedsSameNumbersAndDates(t *testing.T) {
+	ctx := context.Background()
+	emb := &fakeEmbedder{vectors: map[string][]float32{
+		"top 10 drivers @last_week":           {1, 0, 0},
+		"top 100 drivers @last_week":          {0.99, 0.1, 0},
+		"top 10 drivers @yesterday":           {0.99, 0, 0.1},
+		"10 best drivers @last_week":          {0.99, 0.05, 0.05},
+		"top drivers in 2025 @last_week":      {0.99, 0, 0.05},
+		"highest rated 10 drivers @last_week": {0.98, 0.1, 0.1},
+	}}
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{Embedder: emb})
+
+	_, probe := c.lookup(ctx, "top 10 drivers last week", "ds", "executive")
+	c.store(probe, cachedResp("top 10 drivers last week"))
+
+	for _, prompt := range []string{"top 100 drivers last week", "top 10 drivers yesterday", "top drivers in 2025 last week"} {
+		if got, _ := c.lookup(ctx, prompt, "ds", "executive"); got != nil {
+			t.Errorf("%q must not reuse the answer to %q", prompt, got.AgentMetadata["response_cache_matched_prompt"])
+		}
+	}
+	for _, prompt := range []string{"ten best drivers last week", "highest rated 10 drivers last week"} {
+		if got, _ := c.lookup(ctx, prompt, "ds", "executive"); got == nil {
+			t.Errorf("%q: expected an embedding hit", prompt)
+		}
+	}
+}
+
+func TestResponseCacheLookup_ScopedPerDatasetAndStyle(t *testing.T) {
+	ctx := context.Background()
+	emb := &fakeEmbedder{vectors: map[string][]float32{"top 10 drivers": {1, 0}}}
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{Embedder: emb})
+
+	_, probe := c.lookup(ctx, "top 10 drivers", "ds_a", "executive")
+	c.store(probe, cachedResp("top 10 drivers"))
+
+	if got, _ := c.lookup(ctx, "top 10 drivers", "ds_b", "executive"); got != nil {
+		t.Error("another dataset must not share entries")
+	}
+	if got, _ := c.lookup(ctx, "top 10 drivers", "ds_a", "technical"); got != nil {
+		t.Error("another persona style must not share entries")
+	}
+}
+
+func TestResponseCacheLookup_EmbedderErrorFallsBackToMiss(t *testing.T) {
+	ctx := context.Background()
+	emb := &fakeEmbedder{vectors: map[string][]float32{}}
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{Embedder: emb})
+
+	got, probe := c.lookup(ctx, "top 10 drivers", "ds", "executive")
+	if got != nil {
+		t.Fatal("expected miss")
+	}
+	c.store(probe, cachedResp("top 10 drivers")) // still cached under the normalized key
+	if got, _ := c.lookup(ctx, "TOP TEN drivers", "ds", "executive"); got == nil {
+		t.Error("normalized match must work even when embeddings fail")
+	}
+}
+
+func TestResponseCacheFlush_ResetsSemanticIndex(t *testing.T) {
+	ctx := context.Background()
+	emb := &fakeEmbedder{vectors: map[string][]float32{
+		"a": {1, 0},
+		"b": {0.99, 0.01},
+	}}
+	c := newResponseCache(time.Minute)
+	c.semantic = newSemanticMatcher(SemanticCacheOptions{Embedder: emb})
+
+	_, probe := c.lookup(ctx, "a", "ds", "executive")
+	c.store(probe, cachedResp("a"))
+	if err := c.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if got, _ := c.lookup(ctx, "b", "ds", "executive"); got != nil {
+		t.Error("flush must clear semantic matches")
+	}
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/tools"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of tool execution, used for zero RunOptions fields.
+const (
+	DefaultToolConcurrency = 4
+	DefaultToolTimeout     = 2 * time.Minute
+)
+
+// toolOutcome is the result of one tool call, as fed back to the model.
+type toolOutcome struct {
+	output  string
+	isErr   bool
+	latency time.Duration
+}
+
+// executeToolCalls runs the tool calls the model requested in one iteration,
+// up to opts.ToolConcurrency at a time, and returns their outcomes in call
+// order so tool results line up with the calls for the provider. Tool errors
+// become "error: ..." outputs for the model to react to. When ctx is
+// cancelled, running calls see it through their context and calls that have
+// not started yet are skipped.
+func executeToolCalls(ctx context.Context, calls []ToolCall, agentTools []tools.Tool, opts RunOptions) []toolOutcome {
+	outcomes := make([]toolOutcome, len(calls))
+	limit := opts.toolConcurrency()
+	if limit == 1 || len(calls) == 1 {
+		for i, tc := range calls {
+			outcomes[i] = runToolCall(ctx, tc, agentTools, opts.toolTimeout())
+		}
+		return outcomes
+	}
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, tc := range calls {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, tc ToolCall) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			outcomes[i] = runToolCall(ctx, tc, agentTools, opts.toolTimeout())
+		}(i, tc)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// runToolCall executes one tool call and converts its error into the output
+// returned to the model.
+func runToolCall(ctx context.Context, tc ToolCall, agentTools []tools.Tool, timeout time.Duration) toolOutcome {
+	start := time.Now()
+	result, err := executeTool(ctx, tc, agentTools, timeout)
+	if err != nil {
+		log.Warn().Err(err).Str("tool", tc.Name).Msg("tool execution error")
+		result = fmt.Sprintf("error: %v", err)
+	}
+	return toolOutcome{output: result, isErr: err != nil, latency: time.Since(start)}
+}
+
+// executeTool runs the tool named by tc under the tool's Timeout, or
+// defaultTimeout when it has none (<= 0 means no limit). A tool that ignores
+// its context is abandoned once the timeout or ctx expires.
+func executeTool(ctx context.Context, tc ToolCall, agentTools []tools.Tool, defaultTimeout time.Duration) (string, error) {
+	var tool *tools.Tool
+	for i := range agentTools {
+		if agentTools[i].Name == tc.Name {
+			tool = &agentTools[i]
+			break
+		}
+	}
+	if tool == nil {
+		return "", fmt.Errorf("unknown tool: %s", tc.Name)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err // cancelled before the call started
+	}
+
+	timeout := defaultTimeout
+	if tool.Timeout > 0 {
+		timeout = tool.Timeout
+	}
+	toolCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		toolCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		out string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := tool.Execute(toolCtx, tc.Input)
+		done <- result{out, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil && ctx.Err() == nil && errors.Is(toolCtx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("tool %s timed out after %s", tc.Name, timeout)
+		}
+		return r.out, r.err
+	case <-toolCtx.Done():
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("tool %s timed out after %s", tc.Name, timeout)
+	}
+}
@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/tools"
+)
+
+// gaugeTool returns a tool that records the peak number of concurrent calls
+// and answers with its "n" input after delay.
+func gaugeTool(name string, running, peak *int32, delay time.Duration) tools.Tool {
+	return tools.Tool{
+		Name: name,
+		Execute: func(ctx context.Context, input map[string]interface{}) (string, error) {
+			n := atomic.AddInt32(running, 1)
+			defer atomic.AddInt32(running, -1)
+			for {
+				p := atomic.LoadInt32(peak)
+				if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(delay)
+			return fmt.Sprint(input["n"]), nil
+		},
+	}
+}
+
+func TestExecuteToolCalls_ConcurrentInCallOrder(t *testing.T) {
+	var running, peak int32
+	ts := []tools.Tool{gaugeTool("get_bigquery_schema", &running, &peak, 20*time.Millisecond)}
+	calls := make([]ToolCall, 5)
+	for i := range calls {
+		calls[i] = ToolCall{Name: "get_bigquery_schema", Input: map[string]interface{}{"n": i}}
+	}
+
+	outcomes := executeToolCalls(context.Background(), calls, ts, RunOptions{ToolConcurrency: 2})
+	for i, out := range outcomes {
+		if out.output != fmt.Sprint(i) || out.isErr {
+			t.Errorf("outcome %d = %+v; results must keep call order", i, out)
+		}
+	}
+	if peak != 2 {
+		t.Errorf("peak concurrency = %d, want the limit 2", peak)
+	}
+
+	peak = 0
+	executeToolCalls(context.Background(), calls[:3], ts, RunOptions{ToolConcurrency: 1})
+	if peak != 1 {
+		t.Errorf("ToolConcurrency 1 ran %d calls at once", peak)
+	}
+}
+
+func TestExecuteToolCalls_Timeouts(t *testing.T) {
+	blocking := tools.Tool{Name: "honours_ctx", Execute: func(ctx context.Context, _ map[string]interface{}) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	stuck := tools.Tool{Name: "ignores_ctx", Timeout: 10 * time.Millisecond, Execute: func(context.Context, map[string]interface{}) (string, error) {
+		time.Sleep(time.Second)
+		return "late", nil
+	}}
+	calls := []ToolCall{{Name: "honours_ctx"}, {Name: "ignores_ctx"}, {Name: "missing"}}
+
+	start := time.Now()
+	outcomes := executeToolCalls(context.Background(), calls, []tools.Tool{blocking, stuck}, RunOptions{ToolTimeout: 20 * time.Millisecond})
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("timeouts not enforced: took %s", time.Since(start))
+	}
+	for i, want := range []string{"error: tool honours_ctx timed out after 20ms", "error: tool ignores_ctx timed out after 10ms", "error: unknown tool: missing"} {
+		if outcomes[i].output != want || !outcomes[i].isErr {
+			t.Errorf("outcome %d = %+v, want %q", i, outcomes[i], want)
+		}
+	}
+}
+
+func TestExecuteToolCalls_CancellationStopsSiblings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var started int32
+	wait := tools.Tool{Name: "wait", Execute: func(ctx context.Context, _ map[string]interface{}) (string, error) {
+		if atomic.AddInt32(&started, 1) == 2 {
+			cancel()
+		}
+		<-ctx.Done()
+		return "", ctx.Err()
+	}}
+	calls := []ToolCall{{Name: "wait"}, {Name: "wait"}, {Name: "wait"}, {Name: "wait"}}
+
+	outcomes := executeToolCalls(ctx, calls, []tools.Tool{wait}, RunOptions{ToolConcurrency: 2})
+	for i, out := range outcomes {
+		if !out.isErr || !strings.Contains(out.output, "context canceled") {
+			t.Errorf("outcome %d = %+v", i, out)
+		}
+	}
+	if started != 2 {
+		t.Errorf("%d calls started; queued calls must be skipped after cancellation", started)
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrTraceNotFound is returned when a trace does not exist, has expired, or
+// belongs to a different user/squad. The cases are deliberately
+// indistinguishable so trace IDs cannot be probed across squads.
+var ErrTraceNotFound = errors.New("trace not found or expired")
+
+// DefaultTraceTTL is how long traces are kept when NewTraceStore gets ttl <= 0.
+const DefaultTraceTTL = 24 * time.Hour
+
+// traceRecorder collects the runs of one request. Handlers may run the agent
+// from several goroutines (federated stages), so appends are locked.
+type traceRecorder struct {
+	mu    sync.Mutex
+	trace *models.AgentTrace
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context under which every agent run of a handler is
+// appended to trace. The caller must not read trace until the handler has
+// returned.
+func WithTrace(ctx context.Context, trace *models.AgentTrace) context.Context {
+	return context.WithValue(ctx, traceKey{}, &traceRecorder{trace: trace})
+}
+
+// recordRun appends the outcome of one LLMRunner call to the trace in ctx, if
+// any. res may be nil for a runner that failed without a result.
+func recordRun(ctx context.Context, prompt string, res *RunResult, err error) {
+	rec, ok := ctx.Value(traceKey{}).(*traceRecorder)
+	if !ok {
+		return
+	}
+	run := models.AgentRun{Prompt: prompt}
+	if res != nil {
+		run.Model = res.Model
+		run.Runner = res.Runner
+		run.Failovers = res.Failovers
+		run.Iterations = res.Iterations
+		run.StopReason = res.StopReason
+		run.Usage = res.Usage
+		run.LatencyMs = res.LatencyMs
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.trace.Runs = append(rec.trace.Runs, run)
+	rec.trace.Usage.Add(run.Usage)
+}
+
+// TraceStore keeps agent traces for debugging, JSON-encoded in a pluggable
+// cache.Cache so they are shared between replicas on a shared backend.
+type TraceStore struct {
+	backend cache.Cache
+	ttl     time.Duration
+}
+
+// NewTraceStore creates a store whose entries expire after ttl (<= 0 means
+// DefaultTraceTTL).
+func NewTraceStore(backend cache.Cache, ttl time.Duration) *TraceStore {
+	if ttl <= 0 {
+		ttl = DefaultTraceTTL
+	}
+	return &TraceStore{backend: backend, ttl: ttl}
+}
+
+// Save stores trace under its ID.
+func (s *TraceStore) Save(trace *models.AgentTrace) error {
+	b, err := json.Marshal(trace)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	return s.backend.Set(ctx, trace.ID, b, s.ttl)
+}
+
+// Get returns the trace with the given ID. Unless admin is set, the trace must
+// belong to userID/squadID; otherwise ErrTraceNotFound is returned.
+func (s *TraceStore) Get(id, userID, squadID string, admin bool) (*models.AgentTrace, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheOpTimeout)
+	defer cancel()
+	v, ok, err := s.backend.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTraceNotFound
+	}
+	var trace models.AgentTrace
+	if err := json.Unmarshal(v, &trace); err != nil {
+		log.Warn().Err(err).Str("trace_id", id).Msg("discarding undecodable trace")
+		return nil, ErrTraceNotFound
+	}
+	if !admin && (trace.UserID != userID || trace.SquadID != squadID) {
+		return nil, ErrTraceNotFound
+	}
+	return &trace, nil
+}
@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+func TestTraceStore_ScopedToOwner(t *testing.T) {
+	store := NewTraceStore(cache.NewMemoryStore().Namespace("agent_trace"), 0)
+	trace := &models.AgentTrace{ID: "t1", UserID: "u1", SquadID: "squad-a", Prompt: "q"}
+	if err := store.Save(trace); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if got, err := store.Get("t1", "u1", "squad-a", false); err != nil || got.Prompt != "q" {
+		t.Errorf("owner Get = %+v, %v", got, err)
+	}
+	if _, err := store.Get("t1", "u1", "squad-b", false); !errors.Is(err, ErrTraceNotFound) {
+		t.Errorf("other squad: expected ErrTraceNotFound, got %v", err)
+	}
+	if _, err := store.Get("t1", "admin", "", true); err != nil {
+		t.Errorf("admin Get: %v", err)
+	}
+	if _, err := store.Get("missing", "u1", "squad-a", false); !errors.Is(err, ErrTraceNotFound) {
+		t.Errorf("missing: expected ErrTraceNotFound, got %v", err)
+	}
+}
+
+func TestBigQueryHandle_RecordsEveryRepairRunInTrace(t *testing.T) {
+	h := newTestBigQueryHandler()
+	h.SetMaxRepairRounds(1)
+	runner := &scriptedRunner{outputs: []string{"```sql\nDELETE FROM ds.t\n```"}}
+	req := &models.AgentRequest{Prompt: "hapus data", Timeout: 30}
+
+	trace := &models.AgentTrace{}
+	_, err := h.Handle(WithTrace(context.Background(), trace), req, "key", "", nil, runner, RunOptions{}, "", nil)
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	if len(trace.Runs) != 2 {
+		t.Fatalf("trace runs = %d, want the first run and one repair round", len(trace.Runs))
+	}
+	if trace.Runs[0].Prompt != req.Prompt || trace.Runs[1].Prompt != runner.prompts[1] {
+		t.Errorf("run prompts = %q, %q", trace.Runs[0].Prompt, trace.Runs[1].Prompt)
+	}
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const maxResubscribeBackoff = 5 * time.Second
+
+// invalidation is the pub/sub message broadcast for Delete and Flush.
+type invalidation struct {
+	Origin    string `json:"origin"` // publishing replica; it ignores its own messages
+	Namespace string `json:"namespace"`
+	Key       string `json:"key,omitempty"`
+	Flush     bool   `json:"flush,omitempty"`
+}
+
+// BroadcastStore wraps a process-local Store (memory or file) and propagates
+// invalidations to every replica over Redis pub/sub. Reads and writes stay
+// local; only Delete and Flush are broadcast. Messages published while a
+// replica is disconnected from Redis are not replayed, so entries it cached
+// before the outage can live until their TTL expires.
+type BroadcastStore struct {
+	local   Store
+	client  *redisClient
+	channel string
+	origin  string
+
+	mu     sync.Mutex
+	sub    *redisConn
+	closed bool
+	done   chan struct{}
+}
+
+// NewBroadcastStore subscribes to channel and returns a Store whose
+// invalidations reach every other replica subscribed to the same channel.
+// The initial subscription is synchronous so a misconfigured address fails
+// at startup; later disconnects are retried in the background.
+func NewBroadcastStore(local Store, opts RedisOptions, channel string) (*BroadcastStore, error) {
+	s := &BroadcastStore{
+		local:   local,
+		client:  newRedisClient(opts),
+		channel: channel,
+		origin:  uuid.NewString(),
+		done:    make(chan struct{}),
+	}
+	sub, err := s.subscribe()
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to cache invalidations: %w", err)
+	}
+	go s.listen(sub)
+	return s, nil
+}
+
+func (s *BroadcastStore) Namespace(name string) Cache {
+	return &broadcastCache{Cache: s.local.Namespace(name), store: s, namespace: name}
+}
+
+// Close stops the subscriber and closes the local store.
+func (s *BroadcastStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.sub != nil {
+		s.sub.conn.Close()
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	s.client.close()
+	return s.local.Close()
+}
+
+func (s *BroadcastStore) subscribe() (*redisConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.opts.DialTimeout)
+	defer cancel()
+	rc, err := s.client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rc.do(ctx, "SUBSCRIBE", s.channel); err != nil {
+		rc.conn.Close()
+		return nil, err
+	}
+	rc.conn.SetDeadline(time.Time{}) // wait for messages indefinitely
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		rc.conn.Close()
+		return nil, fmt.Errorf("store closed")
+	}
+	s.sub = rc
+	return rc, nil
+}
+
+// listen applies invalidations received on sub, resubscribing with backoff
+// whenever the connection drops, until Close is called.
+func (s *BroadcastStore) listen(sub *redisConn) {
+	defer close(s.done)
+	backoff := 100 * time.Millisecond
+	for {
+		if sub != nil {
+			s.receive(sub)
+			sub.conn.Close()
+		}
+		if s.isClosed() {
+			return
+		}
+		time.Sleep(backoff)
+		if s.isClosed() {
+			return
+		}
+
+		var err error
+		if sub, err = s.subscribe(); err != nil {
+			log.Warn().Err(err).Str("channel", s.channel).Msg("cache invalidation resubscribe failed")
+			backoff = min(backoff*2, maxResubscribeBackoff)
+			continue
+		}
+		backoff = 100 * time.Millisecond
+		log.Info().Str("channel", s.channel).Msg("cache invalidation subscription restored")
+	}
+}
+
+// receive reads pub/sub messages until the connection fails.
+func (s *BroadcastStore) receive(sub *redisConn) {
+	for {
+		reply, err := sub.read()
+		if err != nil {
+			if !s.isClosed() {
+				log.Warn().Err(err).Str("channel", s.channel).Msg("cache invalidation subscription lost")
+			}
+			return
+		}
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) != 3 {
+			continue
+		}
+		if kind, _ := arr[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		payload, _ := arr[2].([]byte)
+		s.apply(payload)
+	}
+}
+
+// apply executes a remote invalidation against the local store.
+func (s *BroadcastStore) apply(payload []byte) {
+	var msg invalidation
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Warn().Err(err).Msg("ignoring malformed cache invalidation")
+		return
+	}
+	if msg.Origin == s.origin {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := s.local.Namespace(msg.Namespace)
+	var err error
+	if msg.Flush {
+		err = c.Flush(ctx)
+	} else {
+		err = c.Delete(ctx, msg.Key)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("namespace", msg.Namespace).Msg("failed to apply remote cache invalidation")
+	}
+}
+
+func (s *BroadcastStore) publish(ctx context.Context, msg invalidation) error {
+	msg.Origin = s.origin
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := s.client.do(ctx, "PUBLISH", s.channel, string(b)); err != nil {
+		return fmt.Errorf("broadcast cache invalidation: %w", err)
+	}
+	return nil
+}
+
+func (s *BroadcastStore) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// broadcastCache applies Delete and Flush locally, then broadcasts them.
+type broadcastCache struct {
+	Cache
+	store     *BroadcastStore
+	namespace string
+}
+
+func (c *broadcastCache) Delete(ctx context.Context, key string) error {
+	if err := c.Cache.Delete(ctx, key); err != nil {
+		return err
+	}
+	return c.store.publish(ctx, invalidation{Namespace: c.namespace, Key: key})
+}
+
+func (c *broadcastCache) Flush(ctx context.Context) error {
+	if err := c.Cache.Flush(ctx); err != nil {
+		return err
+	}
+	return c.store.publish(ctx, invalidation{Namespace: c.namespace, Flush: true})
+}
@@ -0,0 +1,104 @@
+// Package cache provides the pluggable key/value backends used for the agent
+// schema and response caches.
+//
+// Three backends are available:
+//
+//   - memory: process-local maps (the default; every replica has its own cache)
+//   - file:   one file per entry under a local directory, so a restarted pod
+//     comes back warm
+//   - redis:  a shared Redis (or Redis-protocol compatible) server, so all
+//     replicas share one cache
+//
+// When a process-local backend (memory or file) is combined with a Redis
+// address, invalidations (Delete and Flush) are broadcast over Redis pub/sub so
+// that an admin flushing the cache on one replica flushes it on all of them.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Backend names accepted by Options.Backend.
+const (
+	BackendMemory = "memory"
+	BackendFile   = "file"
+	BackendRedis  = "redis"
+)
+
+// DefaultInvalidationChannel is the Redis pub/sub channel used to broadcast
+// invalidations between replicas.
+const DefaultInvalidationChannel = "cortexai:cache:invalidate"
+
+// Cache is a namespaced TTL key/value store. Values are opaque bytes; callers
+// own serialization. Get reports a miss (not an error) for missing and expired
+// keys. Implementations are safe for concurrent use.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// IncrBy adds delta to the integer counter at key, starting from 0 when
+	// it is missing or expired, and returns the new value. The counter
+	// expires ttl after the call. Increments are atomic: across replicas on
+	// the redis backend, within the process on the others. Get returns the
+	// value in decimal.
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Flush removes every entry in this namespace; other namespaces are untouched.
+	Flush(ctx context.Context) error
+}
+
+// Store hands out namespaced caches that share one backend. Namespace returns
+// the same Cache for the same name.
+type Store interface {
+	Namespace(name string) Cache
+	Close() error
+}
+
+// Options selects and configures a backend for Open.
+type Options struct {
+	Backend string // "memory" (default) | "file" | "redis"
+	Dir     string // file backend: root directory
+	Redis   RedisOptions
+
+	// InvalidationChannel is the pub/sub channel used to broadcast invalidations
+	// when a process-local backend is combined with Redis.Addr.
+	// Empty = DefaultInvalidationChannel.
+	InvalidationChannel string
+}
+
+// Open builds the Store described by opts. For the memory and file backends a
+// non-empty Redis.Addr enables cross-replica invalidation; for the redis
+// backend the data itself is shared so no broadcast is needed.
+func Open(opts Options) (Store, error) {
+	var local Store
+	switch strings.ToLower(opts.Backend) {
+	case "", BackendMemory:
+		local = NewMemoryStore()
+	case BackendFile:
+		fs, err := NewFileStore(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		local = fs
+	case BackendRedis:
+		return NewRedisStore(opts.Redis)
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q (use memory, file or redis)", opts.Backend)
+	}
+
+	if opts.Redis.Addr == "" {
+		return local, nil
+	}
+	channel := opts.InvalidationChannel
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	bs, err := NewBroadcastStore(local, opts.Redis, channel)
+	if err != nil {
+		return nil, errors.Join(err, local.Close())
+	}
+	return bs, nil
+}
@@ -0,0 +1,327 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backends returns a fresh store per backend for the shared behaviour tests.
+func backends(t *testing.T) map[string]Store {
+	t.Helper()
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	rs, err := NewRedisStore(RedisOptions{Addr: newFakeRedis(t).addr()})
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	t.Cleanup(func() { rs.Close() })
+	return map[string]Store{
+		BackendMemory: NewMemoryStore(),
+		BackendFile:   fs,
+		BackendRedis:  rs,
+	}
+}
+
+func mustGet(t *testing.T, c Cache, key string) (string, bool) {
+	t.Helper()
+	v, ok, err := c.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	return string(v), ok
+}
+
+func TestStores_SetGetDelete(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			c := s.Namespace("bq_schema")
+			if _, ok := mustGet(t, c, "ds1"); ok {
+				t.Fatal("expected miss before set")
+			}
+			if err := c.Set(ctx, "ds1", []byte("prompt"), time.Minute); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if got, ok := mustGet(t, c, "ds1"); !ok || got != "prompt" {
+				t.Fatalf("got %q ok=%v, want prompt", got, ok)
+			}
+			if err := c.Set(ctx, "ds1", []byte("second"), time.Minute); err != nil {
+				t.Fatalf("Set overwrite: %v", err)
+			}
+			if got, _ := mustGet(t, c, "ds1"); got != "second" {
+				t.Errorf("overwrite: got %q, want second", got)
+			}
+			if err := c.Delete(ctx, "ds1"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, ok := mustGet(t, c, "ds1"); ok {
+				t.Error("expected miss after delete")
+			}
+			if err := c.Delete(ctx, "ghost"); err != nil {
+				t.Errorf("Delete of missing key should not fail: %v", err)
+			}
+		})
+	}
+}
+
+func TestStores_TTLExpiry(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			c := s.Namespace("bq_response")
+			if err := c.Set(ctx, "k", []byte("v"), 30*time.Millisecond); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if _, ok := mustGet(t, c, "k"); !ok {
+				t.Fatal("expected hit immediately after set")
+			}
+			time.Sleep(50 * time.Millisecond)
+			if _, ok := mustGet(t, c, "k"); ok {
+				t.Error("expected miss after TTL expiry")
+			}
+		})
+	}
+}
+
+func TestStores_FlushIsPerNamespace(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			resp := s.Namespace("bq_response")
+			schema := s.Namespace("bq_schema")
+			resp.Set(ctx, "a", []byte("1"), time.Minute)
+			resp.Set(ctx, "b", []byte("2"), time.Minute)
+			schema.Set(ctx, "a", []byte("schema"), time.Minute)
+
+			if err := resp.Flush(ctx); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			for _, k := range []string{"a", "b"} {
+				if _, ok := mustGet(t, resp, k); ok {
+					t.Errorf("response %q should be flushed", k)
+				}
+			}
+			if got, ok := mustGet(t, schema, "a"); !ok || got != "schema" {
+				t.Errorf("schema namespace must survive a response flush: got %q ok=%v", got, ok)
+			}
+		})
+	}
+}
+
+func TestStores_IncrBy(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			c := s.Namespace("budget")
+			var wg sync.WaitGroup
+			for range 20 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := c.IncrBy(ctx, "n", 5, time.Minute); err != nil {
+						t.Errorf("IncrBy: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if n, err := c.IncrBy(ctx, "n", -30, time.Minute); err != nil || n != 70 {
+				t.Fatalf("IncrBy = %d, %v; want 70 after 20 concurrent increments", n, err)
+			}
+			if got, ok := mustGet(t, c, "n"); !ok || got != "70" {
+				t.Errorf("Get = %q ok=%v, want 70", got, ok)
+			}
+
+			if _, err := c.IncrBy(ctx, "short", 1, 30*time.Millisecond); err != nil {
+				t.Fatalf("IncrBy: %v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+			if n, err := c.IncrBy(ctx, "short", 1, time.Minute); err != nil || n != 1 {
+				t.Errorf("IncrBy after expiry = %d, %v; want a fresh counter", n, err)
+			}
+		})
+	}
+}
+
+func TestFileStore_PersistsAcrossReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s1, _ := NewFileStore(dir)
+	s1.Namespace("pg_schema").Set(ctx, "payment:orders", []byte("schema"), time.Hour)
+	s1.Namespace("pg_schema").Set(ctx, "stale", []byte("old"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	s2, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	c := s2.Namespace("pg_schema") // sweeps the expired entry
+	if got, ok := mustGet(t, c, "payment:orders"); !ok || got != "schema" {
+		t.Errorf("entry should survive reopen: got %q ok=%v", got, ok)
+	}
+	if _, ok := mustGet(t, c, "stale"); ok {
+		t.Error("expired entry should not survive reopen")
+	}
+}
+
+func TestFileStore_RequiresDirectory(t *testing.T) {
+	if _, err := NewFileStore(""); err == nil {
+		t.Error("expected error for empty directory")
+	}
+}
+
+func TestRedisStore_KeyPrefixAndAuth(t *testing.T) {
+	fake := newFakeRedis(t)
+	fake.requirePassword("s3cret")
+
+	if _, err := NewRedisStore(RedisOptions{Addr: fake.addr()}); err == nil {
+		t.Fatal("expected NOAUTH error without password")
+	}
+	s, err := NewRedisStore(RedisOptions{Addr: fake.addr(), Password: "s3cret", KeyPrefix: "test:"})
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	defer s.Close()
+	s.Namespace("bq_schema").Set(context.Background(), "ds*1", []byte("x"), time.Minute)
+
+	keys := fake.keys()
+	if len(keys) != 1 || keys[0] != "test:bq_schema:ds*1" {
+		t.Errorf("keys = %v, want [test:bq_schema:ds*1]", keys)
+	}
+}
+
+func TestRedisStore_UnreachableFailsFast(t *testing.T) {
+	_, err := NewRedisStore(RedisOptions{Addr: "127.0.0.1:1", DialTimeout: 200 * time.Millisecond})
+	if err == nil {
+		t.Fatal("expected error for unreachable server")
+	}
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestBroadcastStore_PropagatesInvalidation(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis(t)
+	opts := RedisOptions{Addr: fake.addr()}
+
+	replicaA, err := NewBroadcastStore(NewMemoryStore(), opts, DefaultInvalidationChannel)
+	if err != nil {
+		t.Fatalf("replica A: %v", err)
+	}
+	defer replicaA.Close()
+	replicaB, err := NewBroadcastStore(NewMemoryStore(), opts, DefaultInvalidationChannel)
+	if err != nil {
+		t.Fatalf("replica B: %v", err)
+	}
+	defer replicaB.Close()
+
+	for _, r := range []Store{replicaA, replicaB} {
+		r.Namespace("bq_schema").Set(ctx, "ds1", []byte("schema"), time.Minute)
+		r.Namespace("bq_response").Set(ctx, "q1", []byte("answer"), time.Minute)
+		r.Namespace("bq_response").Set(ctx, "q2", []byte("answer"), time.Minute)
+	}
+
+	if err := replicaA.Namespace("bq_schema").Delete(ctx, "ds1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	waitFor(t, "schema delete on replica B", func() bool {
+		_, ok := mustGet(t, replicaB.Namespace("bq_schema"), "ds1")
+		return !ok
+	})
+
+	if err := replicaA.Namespace("bq_response").Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	waitFor(t, "response flush on replica B", func() bool {
+		_, ok1 := mustGet(t, replicaB.Namespace("bq_response"), "q1")
+		_, ok2 := mustGet(t, replicaB.Namespace("bq_response"), "q2")
+		return !ok1 && !ok2
+	})
+}
+
+func TestBroadcastStore_ResubscribesAfterDisconnect(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeRedis(t)
+	opts := RedisOptions{Addr: fake.addr()}
+
+	replicaA, _ := NewBroadcastStore(NewMemoryStore(), opts, "ch")
+	defer replicaA.Close()
+	replicaB, _ := NewBroadcastStore(NewMemoryStore(), opts, "ch")
+	defer replicaB.Close()
+
+	fake.dropConnections()
+	waitFor(t, "both replicas to resubscribe", func() bool { return fake.subscriberCount("ch") == 2 })
+
+	replicaB.Namespace("pg_schema").Set(ctx, "payment:orders", []byte("schema"), time.Minute)
+	if err := replicaA.Namespace("pg_schema").Delete(ctx, "payment:orders"); err != nil {
+		t.Fatalf("Delete after reconnect: %v", err)
+	}
+	waitFor(t, "delete on replica B after reconnect", func() bool {
+		_, ok := mustGet(t, replicaB.Namespace("pg_schema"), "payment:orders")
+		return !ok
+	})
+}
+
+func TestOpen(t *testing.T) {
+	fake := newFakeRedis(t)
+	tests := []struct {
+		name    string
+		opts    Options
+		want    string // type name prefix; empty = error expected
+		wantErr string
+	}{
+		{name: "default memory", opts: Options{}, want: "*cache.MemoryStore"},
+		{name: "file", opts: Options{Backend: "file", Dir: t.TempDir()}, want: "*cache.FileStore"},
+		{name: "file without dir", opts: Options{Backend: "file"}, wantErr: "requires a directory"},
+		{name: "redis", opts: Options{Backend: "redis", Redis: RedisOptions{Addr: fake.addr()}}, want: "*cache.RedisStore"},
+		{name: "memory with redis broadcasts", opts: Options{Backend: "memory", Redis: RedisOptions{Addr: fake.addr()}}, want: "*cache.BroadcastStore"},
+		{name: "unknown", opts: Options{Backend: "memcached"}, wantErr: "unknown cache backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer s.Close()
+			if got := typeName(s); got != tt.want {
+				t.Errorf("Open returned %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case *MemoryStore:
+		return "*cache.MemoryStore"
+	case *FileStore:
+		return "*cache.FileStore"
+	case *RedisStore:
+		return "*cache.RedisStore"
+	case *BroadcastStore:
+		return "*cache.BroadcastStore"
+	}
+	return "unknown"
+}
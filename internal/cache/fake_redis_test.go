@@ -0,0 +1,250 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server speaking enough RESP2 for the cache:
+// PING, AUTH, SELECT, GET, SET [PX], DEL, SCAN, PUBLISH and SUBSCRIBE.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu          sync.Mutex
+	password    string
+	data        map[string]fakeEntry
+	subscribers map[string][]net.Conn
+	conns       []net.Conn
+}
+
+type fakeEntry struct {
+	value     string
+	expiresAt time.Time // zero = no expiry
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{ln: ln, data: make(map[string]fakeEntry), subscribers: make(map[string][]net.Conn)}
+	go f.serve()
+	t.Cleanup(f.close)
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+// requirePassword makes new connections authenticate with AUTH first.
+func (f *fakeRedis) requirePassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.password = password
+}
+
+func (f *fakeRedis) close() {
+	f.ln.Close()
+	f.dropConnections()
+}
+
+// dropConnections closes every client connection, simulating a server restart.
+func (f *fakeRedis) dropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+	f.subscribers = make(map[string][]net.Conn)
+}
+
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subscribers[channel])
+}
+
+func (f *fakeRedis) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for k := range f.data {
+		out = append(out, k)
+	}
+	return out
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, c)
+		f.mu.Unlock()
+		go f.handle(c)
+	}
+}
+
+func (f *fakeRedis) handle(c net.Conn) {
+	defer c.Close()
+	rd := bufio.NewReader(c)
+	f.mu.Lock()
+	authed := f.password == ""
+	f.mu.Unlock()
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			io.WriteString(c, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+		f.mu.Lock()
+		reply := f.exec(c, cmd, args[1:], &authed)
+		f.mu.Unlock()
+		io.WriteString(c, reply)
+	}
+}
+
+// exec runs one command with f.mu held and returns the encoded reply.
+func (f *fakeRedis) exec(c net.Conn, cmd string, args []string, authed *bool) string {
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "AUTH":
+		if len(args) != 1 || args[0] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		e, ok := f.data[args[0]]
+		if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
+			delete(f.data, args[0])
+			return "$-1\r\n"
+		}
+		return bulk(e.value)
+	case "SET":
+		e := fakeEntry{value: args[1]}
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			e.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		f.data[args[0]] = e
+		return "+OK\r\n"
+	case "INCRBY":
+		e, ok := f.data[args[0]]
+		if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
+			e = fakeEntry{value: "0"}
+		}
+		n, err := strconv.ParseInt(e.value, 10, 64)
+		if err != nil {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+		delta, _ := strconv.ParseInt(args[1], 10, 64)
+		e.value = strconv.FormatInt(n+delta, 10)
+		f.data[args[0]] = e
+		return ":" + e.value + "\r\n"
+	case "PEXPIRE":
+		e, ok := f.data[args[0]]
+		if !ok {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.Atoi(args[1])
+		e.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		f.data[args[0]] = e
+		return ":1\r\n"
+	case "DEL":
+		n := 0
+		for _, k := range args {
+			if _, ok := f.data[k]; ok {
+				delete(f.data, k)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SCAN":
+		// Returns every match in a single page.
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for k := range f.data {
+			if ok, _ := path.Match(pattern, k); ok {
+				keys = append(keys, k)
+			}
+		}
+		var sb strings.Builder
+		sb.WriteString("*2\r\n" + bulk("0"))
+		fmt.Fprintf(&sb, "*%d\r\n", len(keys))
+		for _, k := range keys {
+			sb.WriteString(bulk(k))
+		}
+		return sb.String()
+	case "PUBLISH":
+		msg := "*3\r\n" + bulk("message") + bulk(args[0]) + bulk(args[1])
+		subs := f.subscribers[args[0]]
+		for _, s := range subs {
+			io.WriteString(s, msg)
+		}
+		return fmt.Sprintf(":%d\r\n", len(subs))
+	case "SUBSCRIBE":
+		f.subscribers[args[0]] = append(f.subscribers[args[0]], c)
+		return "*3\r\n" + bulk("subscribe") + bulk(args[0]) + ":1\r\n"
+	default:
+		return "-ERR unknown command '" + cmd + "'\r\n"
+	}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected array, got %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		hdr, err := rd.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(hdr[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rd, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
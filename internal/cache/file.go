@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fileHeaderLen is the size of the expiry header (Unix nanoseconds, big endian)
+// written before each value.
+const fileHeaderLen = 8
+
+var unsafeNamespaceChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// fileCache is a single namespace stored as one file per entry under dir.
+// File names are the SHA-256 of the key, so arbitrary keys are safe. Writes go
+// through a temp file and rename, so readers never see a partial entry.
+type fileCache struct {
+	dir string
+	now func() time.Time
+
+	counterMu sync.Mutex // serializes IncrBy's read-modify-write
+}
+
+func (c *fileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *fileCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	p := c.path(key)
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if len(data) < fileHeaderLen {
+		_ = os.Remove(p) // corrupt entry
+		return nil, false, nil
+	}
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(data[:fileHeaderLen])))
+	if c.now().After(expiresAt) {
+		_ = os.Remove(p)
+		return nil, false, nil
+	}
+	return data[fileHeaderLen:], true, nil
+}
+
+func (c *fileCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	buf := make([]byte, fileHeaderLen+len(value))
+	binary.BigEndian.PutUint64(buf[:fileHeaderLen], uint64(c.now().Add(ttl).UnixNano()))
+	copy(buf[fileHeaderLen:], value)
+
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (c *fileCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	c.counterMu.Lock()
+	defer c.counterMu.Unlock()
+	var n int64
+	value, ok, err := c.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, fmt.Errorf("cache: value of %q is not a counter", key)
+		}
+	}
+	n += delta
+	if err := c.Set(ctx, key, []byte(strconv.FormatInt(n, 10)), ttl); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *fileCache) Delete(_ context.Context, key string) error {
+	err := os.Remove(c.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (c *fileCache) Flush(_ context.Context) error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range entries {
+		if err := os.Remove(filepath.Join(c.dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweep removes expired and corrupt entries. It runs once when a namespace is
+// opened so files left behind by earlier runs do not accumulate.
+func (c *fileCache) sweep() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	now := c.now()
+	for _, e := range entries {
+		p := filepath.Join(c.dir, e.Name())
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		var header [fileHeaderLen]byte
+		_, readErr := f.Read(header[:])
+		f.Close()
+		if readErr != nil || now.After(time.Unix(0, int64(binary.BigEndian.Uint64(header[:])))) {
+			_ = os.Remove(p)
+		}
+	}
+}
+
+// FileStore persists entries on the local filesystem, one subdirectory per
+// namespace, so the cache survives restarts. It is not shared between
+// replicas; combine it with a Redis address in Options to propagate
+// invalidations.
+type FileStore struct {
+	root string
+
+	mu         sync.Mutex
+	namespaces map[string]*fileCache
+}
+
+// NewFileStore creates root if needed and returns a store rooted there.
+func NewFileStore(root string) (*FileStore, error) {
+	if root == "" {
+		return nil, fmt.Errorf("file cache backend requires a directory")
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	return &FileStore{root: root, namespaces: make(map[string]*fileCache)}, nil
+}
+
+// Namespace returns the cache stored under root/<name>. Characters outside
+// [A-Za-z0-9_.-] in name are replaced with '_'. If the directory cannot be
+// created the returned cache reports the error on every call.
+func (s *FileStore) Namespace(name string) Cache {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.namespaces[name]; ok {
+		return c
+	}
+	c := &fileCache{dir: filepath.Join(s.root, unsafeNamespaceChars.ReplaceAllString(name, "_")), now: time.Now}
+	if err := os.MkdirAll(c.dir, 0o700); err == nil {
+		c.sweep()
+	}
+	s.namespaces[name] = c
+	return c
+}
+
+func (s *FileStore) Close() error { return nil }
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// memoryCache is a single in-memory namespace.
+type memoryCache struct {
+	mu    sync.RWMutex
+	store map[string]memoryEntry
+	now   func() time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{store: make(map[string]memoryEntry), now: time.Now}
+}
+
+func (c *memoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.RLock()
+	e, ok := c.store[key]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if c.now().After(e.expiresAt) {
+		c.mu.Lock()
+		if cur, ok := c.store[key]; ok && cur.expiresAt.Equal(e.expiresAt) {
+			delete(c.store, key)
+		}
+		c.mu.Unlock()
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+func (c *memoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store[key] = memoryEntry{value: value, expiresAt: c.now().Add(ttl)}
+	return nil
+}
+
+func (c *memoryCache) IncrBy(_ context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	if e, ok := c.store[key]; ok && !c.now().After(e.expiresAt) {
+		v, err := strconv.ParseInt(string(e.value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cache: value of %q is not a counter", key)
+		}
+		n = v
+	}
+	n += delta
+	c.store[key] = memoryEntry{value: []byte(strconv.FormatInt(n, 10)), expiresAt: c.now().Add(ttl)}
+	return n, nil
+}
+
+func (c *memoryCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.store, key)
+	return nil
+}
+
+func (c *memoryCache) Flush(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.store = make(map[string]memoryEntry)
+	return nil
+}
+
+// MemoryStore is the process-local Store. Entries are lost on restart and are
+// not shared between replicas.
+type MemoryStore struct {
+	mu         sync.Mutex
+	namespaces map[string]*memoryCache
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{namespaces: make(map[string]*memoryCache)}
+}
+
+func (s *MemoryStore) Namespace(name string) Cache {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.namespaces[name]
+	if !ok {
+		c = newMemoryCache()
+		s.namespaces[name] = c
+	}
+	return c
+}
+
+func (s *MemoryStore) Close() error { return nil }
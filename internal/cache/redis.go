@@ -0,0 +1,340 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRedisPoolSize    = 10
+	defaultRedisDialTimeout = 2 * time.Second
+	defaultRedisKeyPrefix   = "cortexai:"
+	redisScanCount          = 500
+)
+
+// RedisOptions configures the Redis connection used by the redis backend and
+// by invalidation broadcasting.
+type RedisOptions struct {
+	Addr        string // host:port
+	Password    string
+	DB          int
+	KeyPrefix   string        // prepended to every key; empty = "cortexai:"
+	PoolSize    int           // idle connections kept; 0 = 10
+	DialTimeout time.Duration // 0 = 2s
+}
+
+// redisError is an error reply ("-ERR ...") from the server. The connection
+// that produced it is still usable.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is one RESP connection.
+type redisConn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// redisClient is a minimal RESP2 client with a small connection pool. It
+// supports exactly the commands the cache needs; there is no pipelining.
+type redisClient struct {
+	opts RedisOptions
+	pool chan *redisConn
+}
+
+func newRedisClient(opts RedisOptions) *redisClient {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = defaultRedisPoolSize
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultRedisDialTimeout
+	}
+	return &redisClient{opts: opts, pool: make(chan *redisConn, opts.PoolSize)}
+}
+
+// dial opens a new connection and authenticates / selects the database.
+func (c *redisClient) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: c.opts.DialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis dial %s: %w", c.opts.Addr, err)
+	}
+	rc := &redisConn{conn: nc, rd: bufio.NewReader(nc)}
+	if c.opts.Password != "" {
+		if _, err := rc.do(ctx, "AUTH", c.opts.Password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := rc.do(ctx, "SELECT", strconv.Itoa(c.opts.DB)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do runs one command on a pooled connection. Connections that fail with a
+// network or protocol error are discarded; error replies keep the connection.
+func (c *redisClient) do(ctx context.Context, args ...string) (interface{}, error) {
+	var rc *redisConn
+	select {
+	case rc = <-c.pool:
+	default:
+		var err error
+		if rc, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := rc.do(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		rc.conn.Close()
+		return nil, err
+	}
+	select {
+	case c.pool <- rc:
+	default:
+		rc.conn.Close()
+	}
+	return reply, err
+}
+
+func (c *redisClient) close() {
+	for {
+		select {
+		case rc := <-c.pool:
+			rc.conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// do writes one command and reads its reply, honouring the context deadline.
+func (rc *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if dl, ok := ctx.Deadline(); ok {
+		rc.conn.SetDeadline(dl)
+	} else {
+		rc.conn.SetDeadline(time.Time{})
+	}
+	if err := rc.write(args...); err != nil {
+		return nil, err
+	}
+	return rc.read()
+}
+
+func (rc *redisConn) write(args ...string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(rc.conn, sb.String())
+	return err
+}
+
+// read parses one RESP2 reply: simple strings become string, integers int64,
+// bulk strings []byte (nil for a null bulk), arrays []interface{} and error
+// replies a redisError.
+func (rc *redisConn) read() (interface{}, error) {
+	line, err := rc.rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	body := line[1 : len(line)-2]
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.rd, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			if out[i], err = rc.read(); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				out[i] = replyErr
+			}
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+// redisCache is a namespace: every key is stored as <prefix><key>.
+type redisCache struct {
+	client *redisClient
+	prefix string
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := c.client.do(ctx, "GET", c.prefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return b, true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	_, err := c.client.do(ctx, "SET", c.prefix+key, string(value), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// IncrBy runs INCRBY, then PEXPIRE. The increment itself is atomic on the
+// server; a failure between the two leaves the counter without an expiry
+// until the next call sets it.
+func (c *redisCache) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	reply, err := c.client.do(ctx, "INCRBY", c.prefix+key, strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected INCRBY reply %T", reply)
+	}
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		ms = 1
+	}
+	if _, err := c.client.do(ctx, "PEXPIRE", c.prefix+key, strconv.FormatInt(ms, 10)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *redisCache) Delete(ctx context.Context, key string) error {
+	_, err := c.client.do(ctx, "DEL", c.prefix+key)
+	return err
+}
+
+// Flush deletes every key under the namespace prefix using SCAN, so it does
+// not block the server the way KEYS would.
+func (c *redisCache) Flush(ctx context.Context) error {
+	cursor := "0"
+	match := escapeGlob(c.prefix) + "*"
+	for {
+		reply, err := c.client.do(ctx, "SCAN", cursor, "MATCH", match, "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return err
+		}
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) != 2 {
+			return fmt.Errorf("redis: unexpected SCAN reply %v", reply)
+		}
+		next, _ := arr[0].([]byte)
+		keys, _ := arr[1].([]interface{})
+		if len(keys) > 0 {
+			args := make([]string, 0, len(keys)+1)
+			args = append(args, "DEL")
+			for _, k := range keys {
+				if b, ok := k.([]byte); ok {
+					args = append(args, string(b))
+				}
+			}
+			if _, err := c.client.do(ctx, args...); err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// escapeGlob escapes the characters that are special in Redis MATCH patterns.
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// RedisStore keeps all entries in a shared Redis server, so every replica sees
+// the same cache and invalidations apply everywhere at once.
+type RedisStore struct {
+	client *redisClient
+	prefix string
+}
+
+// NewRedisStore connects to opts.Addr and verifies the server with PING.
+func NewRedisStore(opts RedisOptions) (*RedisStore, error) {
+	if opts.Addr == "" {
+		return nil, fmt.Errorf("redis cache backend requires an address")
+	}
+	prefix := opts.KeyPrefix
+	if prefix == "" {
+		prefix = defaultRedisKeyPrefix
+	}
+	client := newRedisClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), client.opts.DialTimeout)
+	defer cancel()
+	if _, err := client.do(ctx, "PING"); err != nil {
+		client.close()
+		return nil, fmt.Errorf("redis ping: %w", err)
+	}
+	return &RedisStore{client: client, prefix: prefix}, nil
+}
+
+func (s *RedisStore) Namespace(name string) Cache {
+	return &redisCache{client: s.client, prefix: s.prefix + name + ":"}
+}
+
+func (s *RedisStore) Close() error {
+	s.client.close()
+	return nil
+}
@@ -8,20 +8,33 @@ import (
 )
 
 // PersonaConfig defines the AI behavior for a named persona.
-// Provider references the LLM provider ("anthropic" or "deepseek").
+// Provider references the LLM provider (see LLMProviders).
 // SystemPromptStyle controls the response tone: "executive", "technical", or "support".
 // ExcludedTools lists tool names that will not be passed to the LLM for this persona.
 // Nil or empty means all tools are available (backward compatible).
 // AllowedDataSources lists the data sources this persona may query ("bigquery", "elasticsearch").
 // Nil or empty means all data sources are allowed (backward compatible).
 type PersonaConfig struct {
-	Provider           string   `json:"provider"`                        // "anthropic" | "deepseek"
+	Provider           string   `json:"provider"`                        // "anthropic" | "deepseek" | "openai" | "ollama"
 	Model              string   `json:"model"`                           // e.g. "claude-sonnet-4-6", "glm-4.5-air"
 	BaseURL            string   `json:"base_url,omitempty"`              // optional: override base URL for this persona
 	SystemPromptStyle  string   `json:"system_prompt_style"`             // "executive" | "technical" | "support"
-	MaxTokens          int      `json:"max_tokens,omitempty"`            // 0 = use agent default (4096)
+	MaxTokens          int      `json:"max_tokens,omitempty"`            // output tokens per LLM call; 0 = agent default (4096)
+	MaxIterations      int      `json:"max_iterations,omitempty"`        // tool-calling LLM calls per run; 0 = agent default (10)
+	ForceFinalAfter    int      `json:"force_final_after,omitempty"`     // iteration forcing an answer without tools; 0 = default (7), -1 = never
+	Temperature        *float64 `json:"temperature,omitempty"`           // nil = provider default
+	StopSequences      []string `json:"stop_sequences,omitempty"`
+	PromptCaching      *bool    `json:"prompt_caching,omitempty"`        // nil = on; false turns off prompt caching for this persona
 	ExcludedTools      []string `json:"excluded_tools,omitempty"`        // tool names to hide from LLM; nil = all tools
 	AllowedDataSources []string `json:"allowed_data_sources,omitempty"` // allowed data sources; nil = all sources
+	Fallbacks          []LLMTargetConfig `json:"fallbacks,omitempty"`      // tried in order when the primary provider fails
+}
+
+// LLMTargetConfig names a provider and model a persona fails over to.
+type LLMTargetConfig struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+	BaseURL  string `json:"base_url,omitempty"`
 }
 
 // PostgresConfig defines a per-squad PostgreSQL connection.
@@ -35,6 +48,26 @@ type PostgresConfig struct {
 	MaxConns  int      `json:"max_conns,omitempty"`
 }
 
+// BudgetConfig caps cumulative BigQuery usage over UTC calendar days and
+// months, and LLM tokens per UTC day. Zero fields are unlimited.
+type BudgetConfig struct {
+	DailyBytes   int64   `json:"daily_bytes,omitempty"`
+	MonthlyBytes int64   `json:"monthly_bytes,omitempty"`
+	DailyUSD     float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD   float64 `json:"monthly_usd,omitempty"`
+	DailyTokens  int64   `json:"daily_tokens,omitempty"` // LLM input + output + cache tokens
+}
+
+// LLMPriceConfig is the USD price per million tokens of one model, used to
+// report the LLM cost of agent requests. Zero cache prices fall back to
+// InputPerMTok.
+type LLMPriceConfig struct {
+	InputPerMTok      float64 `json:"input_per_mtok"`
+	OutputPerMTok     float64 `json:"output_per_mtok"`
+	CacheReadPerMTok  float64 `json:"cache_read_per_mtok,omitempty"`
+	CacheWritePerMTok float64 `json:"cache_write_per_mtok,omitempty"`
+}
+
 // SquadConfig defines a team's data access boundaries.
 type SquadConfig struct {
 	ID              string          `json:"id"`
@@ -42,6 +75,32 @@ type SquadConfig struct {
 	Datasets        []string        `json:"datasets"`          // allowed BigQuery dataset IDs
 	ESIndexPatterns []string        `json:"es_index_patterns"` // allowed Elasticsearch index patterns
 	Postgres        *PostgresConfig `json:"postgres,omitempty"` // per-squad PG connection
+	Budget          *BudgetConfig   `json:"budget,omitempty"`      // quota for the squad as a whole
+	UserBudget      *BudgetConfig   `json:"user_budget,omitempty"` // quota applied to each squad member
+	AllowedTables   []string        `json:"allowed_tables,omitempty"` // BQ "dataset.table" / PG "schema.table" patterns; empty = all
+	DeniedTables    []string        `json:"denied_tables,omitempty"`
+	DeniedColumns   []string        `json:"denied_columns,omitempty"` // "table.column" or bare "column"
+	RowFilters      []RowFilterConfig `json:"row_filters,omitempty"`  // row-level security for every squad member
+	Glossary        []GlossaryTermConfig `json:"glossary,omitempty"`  // the squad's business terms; replace shared terms of the same name
+}
+
+// GlossaryTermConfig defines a business term. Its definition is added to the
+// agent's system prompt when a prompt mentions the term or a synonym.
+type GlossaryTermConfig struct {
+	Term       string   `json:"term"`                 // e.g. "GMV"
+	Synonyms   []string `json:"synonyms,omitempty"`   // Indonesian and English alternatives
+	Definition string   `json:"definition,omitempty"`
+	SQL        string   `json:"sql,omitempty"`        // canonical metric expression, e.g. "SUM(total_amount)"
+	JoinHints  []string `json:"join_hints,omitempty"` // e.g. "orders.driver_id = drivers.id"
+	Datasets   []string `json:"datasets,omitempty"`   // BigQuery datasets / PostgreSQL databases it applies to; empty = all
+}
+
+// RowFilterConfig restricts the rows of matching tables to those satisfying
+// Predicate, a SQL boolean expression over the table's columns. Enforced
+// when EnableRowLevelSecurity is set.
+type RowFilterConfig struct {
+	Table     string `json:"table"`     // table pattern, as in SquadConfig.AllowedTables
+	Predicate string `json:"predicate"` // e.g. "merchant_id IN (101, 102)"
 }
 
 // UserConfig defines a named user with a role and an associated API key.
@@ -52,6 +111,7 @@ type UserConfig struct {
 	APIKey  string `json:"api_key"`
 	SquadID string `json:"squad_id"`          // references SquadConfig.ID; empty = no squad restriction
 	Persona string `json:"persona,omitempty"` // references Personas map key; empty = "default"
+	RowFilters []RowFilterConfig `json:"row_filters,omitempty"` // row-level security for this user, in addition to the squad's
 }
 
 type Config struct {
@@ -71,6 +131,7 @@ type Config struct {
 	Users        []UserConfig             `json:"users"`     // keys with explicit user profiles and roles
 	Squads       []SquadConfig            `json:"squads"`    // team data boundaries
 	Personas     map[string]PersonaConfig `json:"personas"`  // persona name → AI behavior config
+	Glossary     []GlossaryTermConfig     `json:"glossary"`  // business terms shared by every squad
 	EnableAuth   bool                     `json:"enable_auth"`
 
 	// Rate Limiting
@@ -103,14 +164,49 @@ type Config struct {
 	ElasticsearchTimeout    int    `json:"elasticsearch_timeout"`
 
 	// AI / LLM
-	LLMProvider         string            `json:"llm_provider"`           // "anthropic" (default) | "deepseek"
+	LLMProvider         string            `json:"llm_provider"`           // "anthropic" (default) | "deepseek" | "openai" | "ollama"
 	AnthropicAPIKey     string            `json:"anthropic_api_key"`
 	AnthropicBaseURL    string            `json:"anthropic_base_url"`     // override for Z.ai / custom proxy
 	DeepSeekAPIKey      string            `json:"deepseek_api_key"`
 	DeepSeekBaseURL     string            `json:"deepseek_base_url"`      // optional override
+	OpenAIAPIKey        string            `json:"openai_api_key"`         // optional for self-hosted servers (vLLM, LM Studio)
+	OpenAIBaseURL       string            `json:"openai_base_url"`        // any OpenAI-compatible API root; default api.openai.com
+	OpenAIAPIVersion    string            `json:"openai_api_version"`     // set for Azure OpenAI; model names the deployment
+	OllamaAPIKey        string            `json:"ollama_api_key"`         // optional bearer token for a proxied Ollama
+	OllamaBaseURL       string            `json:"ollama_base_url"`        // default http://localhost:11434
 	AgentTimeout        int               `json:"agent_timeout"`
 	SchemaCacheTTL      int               `json:"schema_cache_ttl"`       // minutes; 0 = default 5 min
+	SchemaMaxTables     int               `json:"schema_max_tables"`      // tables whose schema is put in the prompt, most relevant first; 0 = default 25, -1 = all
+	SchemaEmbeddings    bool              `json:"schema_embeddings"`      // also rank tables by embedding similarity, via the semantic_cache_embedding_* endpoint
+	FewShotExamples     int               `json:"few_shot_examples"`      // verified prompt→SQL examples put in the prompt, most similar first; 0 = default 3, -1 = off
+	FewShotEmbeddings   bool              `json:"few_shot_embeddings"`    // also rank examples by embedding similarity, via the semantic_cache_embedding_* endpoint
+	ExamplesDir         string            `json:"examples_dir"`           // durable directory the examples are kept in, shared by replicas; "" = examples off
+	ConversationTTL     int               `json:"conversation_ttl"`       // minutes of inactivity; 0 = default 30 min
+	ConversationMaxTurns int              `json:"conversation_max_turns"` // turns kept per conversation; 0 = default 10
+	AgentMaxRepairRounds int              `json:"agent_max_repair_rounds"` // correction rounds for failed final SQL; 0 = default 2, -1 = off
+	AgentTraceTTL        int              `json:"agent_trace_ttl"`         // minutes agent traces are kept; 0 = default 1440, -1 = off
+	AgentToolConcurrency int              `json:"agent_tool_concurrency"`  // parallel tool calls per agent iteration; 0 = default 4, 1 = sequential
+	AgentToolTimeout     int              `json:"agent_tool_timeout"`      // seconds per tool call; 0 = default 120, -1 = no limit
 	ModelList           map[string]string `json:"model_list"`             // provider -> model ID
+	LLMPrices           map[string]LLMPriceConfig `json:"llm_prices"`      // model ID -> token prices
+	LLMMaxRetries       int               `json:"llm_max_retries"`        // retries per LLM call on 429/5xx; 0 = default 2, -1 = off
+	LLMBreakerThreshold int               `json:"llm_breaker_threshold"`  // consecutive failures that open a runner's breaker; 0 = default 3
+	LLMBreakerCooldown  int               `json:"llm_breaker_cooldown"`   // seconds an open breaker skips the runner; 0 = default 30
+
+	// Cache backend for agent schema and response caches
+	CacheBackend       string `json:"cache_backend"`        // "memory" (default) | "file" | "redis"
+	CacheDir           string `json:"cache_dir"`            // file backend directory
+	CacheRedisAddr     string `json:"cache_redis_addr"`     // host:port; with memory/file, broadcasts invalidations
+	CacheRedisPassword string `json:"cache_redis_password"`
+	CacheRedisDB       int    `json:"cache_redis_db"`
+	CacheKeyPrefix     string `json:"cache_key_prefix"`     // redis key prefix; empty = "cortexai:"
+
+	// Semantic response cache: normalized prompt keys plus optional embedding similarity
+	SemanticCacheEnabled        bool    `json:"semantic_cache_enabled"`
+	SemanticCacheThreshold      float64 `json:"semantic_cache_threshold"`       // cosine similarity; 0 = default 0.92
+	SemanticCacheEmbeddingURL   string  `json:"semantic_cache_embedding_url"`   // OpenAI-compatible API root; empty = no embeddings
+	SemanticCacheEmbeddingModel string  `json:"semantic_cache_embedding_model"` // e.g. "text-embedding-3-small"
+	SemanticCacheEmbeddingKey   string  `json:"semantic_cache_embedding_key"`
 
 	// PostgreSQL
 	PostgresEnabled    bool    `json:"postgres_enabled"`
@@ -159,6 +255,9 @@ func Load() (*Config, error) {
 	// Environment overrides
 	applyEnvOverrides(cfg)
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
@@ -209,6 +308,18 @@ func applyEnvOverrides(cfg *Config) {
 	if v := getEnv("DEEPSEEK_BASE_URL", ""); v != "" {
 		cfg.DeepSeekBaseURL = v
 	}
+	if v := getEnv("OPENAI_API_KEY", ""); v != "" {
+		cfg.OpenAIAPIKey = v
+	}
+	if v := getEnv("OPENAI_BASE_URL", ""); v != "" {
+		cfg.OpenAIBaseURL = v
+	}
+	if v := getEnv("OLLAMA_API_KEY", ""); v != "" {
+		cfg.OllamaAPIKey = v
+	}
+	if v := getEnv("OLLAMA_BASE_URL", ""); v != "" {
+		cfg.OllamaBaseURL = v
+	}
 	if v := getEnv("POSTGRES_ENABLED", ""); v != "" {
 		cfg.PostgresEnabled = v == "true" || v == "1"
 	}
@@ -232,6 +343,24 @@ func applyEnvOverrides(cfg *Config) {
 	if v := getEnv("ELASTICSEARCH_PASSWORD", ""); v != "" {
 		cfg.ElasticsearchPassword = v
 	}
+	if v := getEnv("CACHE_BACKEND", ""); v != "" {
+		cfg.CacheBackend = v
+	}
+	if v := getEnv("CACHE_DIR", ""); v != "" {
+		cfg.CacheDir = v
+	}
+	if v := getEnv("EXAMPLES_DIR", ""); v != "" {
+		cfg.ExamplesDir = v
+	}
+	if v := getEnv("CACHE_REDIS_ADDR", ""); v != "" {
+		cfg.CacheRedisAddr = v
+	}
+	if v := getEnv("CACHE_REDIS_PASSWORD", ""); v != "" {
+		cfg.CacheRedisPassword = v
+	}
+	if v := getEnv("SEMANTIC_CACHE_EMBEDDING_KEY", ""); v != "" {
+		cfg.SemanticCacheEmbeddingKey = v
+	}
 	if v := getEnv("RATE_LIMIT_PER_MINUTE", ""); v != "" {
 		if r, err := strconv.Atoi(v); err == nil {
 			cfg.RateLimitPerMinute = r
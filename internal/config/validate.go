@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LLMProviders lists the provider names accepted in llm_provider, persona
+// providers and fallbacks. Each has an LLMRunner registered under the same
+// name by the server.
+var LLMProviders = []string{"anthropic", "deepseek", "openai", "ollama"}
+
+// providersWithoutDefaultModel must be given a model explicitly.
+var providersWithoutDefaultModel = map[string]bool{"openai": true, "ollama": true}
+
+// Validate reports configuration errors that would otherwise surface as
+// silently wrong behaviour at runtime.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.LLMProvider != "" {
+		if err := validateLLMTarget(c.LLMProvider, c.ModelList[c.LLMProvider]); err != nil {
+			errs = append(errs, fmt.Errorf("llm_provider: %w", err))
+		}
+	}
+
+	names := make([]string, 0, len(c.Personas))
+	for name := range c.Personas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pc := c.Personas[name]
+		if err := validateLLMTarget(pc.Provider, pc.Model); err != nil {
+			errs = append(errs, fmt.Errorf("persona %q: %w", name, err))
+		}
+		if err := validateRunTuning(pc); err != nil {
+			errs = append(errs, fmt.Errorf("persona %q: %w", name, err))
+		}
+		for i, fb := range pc.Fallbacks {
+			if err := validateLLMTarget(fb.Provider, fb.Model); err != nil {
+				errs = append(errs, fmt.Errorf("persona %q fallback %d: %w", name, i, err))
+			}
+		}
+	}
+
+	errs = append(errs, validateGlossary("glossary", c.Glossary)...)
+	for _, sq := range c.Squads {
+		errs = append(errs, validateGlossary(fmt.Sprintf("squad %q glossary", sq.ID), sq.Glossary)...)
+	}
+	return errors.Join(errs...)
+}
+
+// validateGlossary checks that every glossary term is named and says
+// something about it.
+func validateGlossary(where string, terms []GlossaryTermConfig) []error {
+	var errs []error
+	for i, t := range terms {
+		switch {
+		case strings.TrimSpace(t.Term) == "":
+			errs = append(errs, fmt.Errorf("%s term %d: term is required", where, i))
+		case t.Definition == "" && t.SQL == "" && len(t.JoinHints) == 0:
+			errs = append(errs, fmt.Errorf("%s term %q: needs a definition, sql or join_hints", where, t.Term))
+		}
+	}
+	return errs
+}
+
+// validateLLMTarget checks a provider/model pair. An empty provider means
+// "anthropic".
+func validateLLMTarget(provider, model string) error {
+	if provider == "" {
+		return nil
+	}
+	known := false
+	for _, p := range LLMProviders {
+		known = known || p == provider
+	}
+	if !known {
+		return fmt.Errorf("unknown LLM provider %q (want one of %v)", provider, LLMProviders)
+	}
+	if model == "" && providersWithoutDefaultModel[provider] {
+		return fmt.Errorf("provider %q requires a model", provider)
+	}
+	return nil
+}
+
+// validateRunTuning checks a persona's agent loop settings.
+func validateRunTuning(pc PersonaConfig) error {
+	switch {
+	case pc.MaxTokens < 0:
+		return fmt.Errorf("max_tokens must not be negative")
+	case pc.MaxIterations < 0:
+		return fmt.Errorf("max_iterations must not be negative")
+	case pc.ForceFinalAfter < -1:
+		return fmt.Errorf("force_final_after must be -1 (never) or more")
+	case pc.Temperature != nil && (*pc.Temperature < 0 || *pc.Temperature > 2):
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate_RejectsUnknownLLMProviders(t *testing.T) {
+	cfg := &Config{
+		LLMProvider: "anthropic",
+		Personas: map[string]PersonaConfig{
+			"developer": {Provider: "openai", Model: "gpt-4o"},
+			"executive": {Provider: "anthropc", Model: "glm-4.5-air"},
+			"support": {Provider: "deepseek", Fallbacks: []LLMTargetConfig{
+				{Provider: "ollama", Model: "qwen2.5"},
+				{Provider: "mistral", Model: "mistral-large"},
+			}},
+		},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{`persona "executive": unknown LLM provider "anthropc"`, `persona "support" fallback 1: unknown LLM provider "mistral"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "developer") {
+		t.Errorf("valid persona reported: %v", err)
+	}
+}
+
+func TestValidate_RequiresModelForSelfHostedProviders(t *testing.T) {
+	if err := (&Config{LLMProvider: "ollama"}).Validate(); err == nil {
+		t.Error("llm_provider ollama without model_list entry must be rejected")
+	}
+	cfg := &Config{LLMProvider: "ollama", ModelList: map[string]string{"ollama": "qwen2.5"}}
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("empty config: %v", err)
+	}
+}
+
+func TestValidate_PersonaRunTuning(t *testing.T) {
+	hot := 2.5
+	cfg := &Config{Personas: map[string]PersonaConfig{
+		"executive": {Provider: "anthropic", Temperature: &hot},
+		"developer": {Provider: "anthropic", MaxIterations: 4, ForceFinalAfter: -1},
+	}}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), `persona "executive": temperature`) {
+		t.Errorf("err = %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "developer") {
+		t.Errorf("valid persona reported: %v", err)
+	}
+}
+
+func TestValidate_Glossary(t *testing.T) {
+	cfg := &Config{
+		Glossary: []GlossaryTermConfig{
+			{Term: "GMV", SQL: "SUM(total_amount)"},
+			{Term: " ", Definition: "unnamed"},
+		},
+		Squads: []SquadConfig{{ID: "ops", Glossary: []GlossaryTermConfig{
+			{Term: "active driver", Synonyms: []string{"driver aktif"}},
+		}}},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"glossary term 1: term is required", `squad "ops" glossary term "active driver": needs a definition`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "GMV") {
+		t.Errorf("valid term reported: %v", err)
+	}
+}
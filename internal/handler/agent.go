@@ -2,42 +2,67 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/cortexai/cortexai/internal/agent"
 	"github.com/cortexai/cortexai/internal/config"
 	"github.com/cortexai/cortexai/internal/middleware"
 	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
 // AgentHandler handles POST /api/v1/query-agent
 type AgentHandler struct {
-	bqHandler *agent.BigQueryHandler
-	esHandler *agent.ElasticsearchHandler
-	pgHandler *agent.PostgresHandler
-	router    *service.IntentRouter
-	llmPool   *agent.LLMPool
-	personas  map[string]config.PersonaConfig
+	bqHandler  *agent.BigQueryHandler
+	esHandler  *agent.ElasticsearchHandler
+	pgHandler  *agent.PostgresHandler
+	fedHandler *agent.FederatedHandler
+	router     *service.IntentRouter
+	llmPool    *agent.LLMPool
+	personas   map[string]config.PersonaConfig
+
+	conversations *service.ConversationStore // nil disables multi-turn sessions
+	traces        *agent.TraceStore          // nil disables persisted agent traces
+	costTracker   *security.CostTracker      // nil disables LLM cost accounting and token quotas
+
+	toolConcurrency int           // parallel tool calls per iteration; 0 = agent default
+	toolTimeout     time.Duration // per tool call; 0 = agent default, < 0 = none
+	bqProject       string        // BigQuery project of the squads' datasets
 }
 
 func NewAgentHandler(
 	bqHandler *agent.BigQueryHandler,
 	esHandler *agent.ElasticsearchHandler,
 	pgHandler *agent.PostgresHandler,
+	fedHandler *agent.FederatedHandler,
 	router *service.IntentRouter,
 	llmPool *agent.LLMPool,
 	personas map[string]config.PersonaConfig,
+	conversations *service.ConversationStore,
 ) *AgentHandler {
 	return &AgentHandler{
-		bqHandler: bqHandler,
-		esHandler: esHandler,
-		pgHandler: pgHandler,
-		router:    router,
-		llmPool:   llmPool,
-		personas:  personas,
+		bqHandler:     bqHandler,
+		esHandler:     esHandler,
+		pgHandler:     pgHandler,
+		fedHandler:    fedHandler,
+		router:        router,
+		llmPool:       llmPool,
+		personas:      personas,
+		conversations: conversations,
+	}
+}
+
+// writeConversationError writes the HTTP error for a failed loadConversation.
+func writeConversationError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrConversationNotFound) {
+		models.WriteError(w, http.StatusNotFound, err.Error())
+		return
 	}
+	models.WriteError(w, http.StatusInternalServerError, err.Error())
 }
 
 // resolvePersona maps a user's persona name to the correct LLMRunner, prompt style,
@@ -54,6 +79,34 @@ func (h *AgentHandler) resolvePersona(user *models.User) (agent.LLMRunner, strin
 	return h.llmPool.Get(agent.PoolKey(pc.Provider, pc.Model)), pc.SystemPromptStyle, pc
 }
 
+// SetToolExecution sets how many tool calls of one agent iteration run
+// concurrently and how long each may take (see agent.RunOptions).
+func (h *AgentHandler) SetToolExecution(concurrency int, timeout time.Duration) {
+	h.toolConcurrency = concurrency
+	h.toolTimeout = timeout
+}
+
+// SetBigQueryProject sets the BigQuery project the squads' datasets belong
+// to. Squad queries may not name, or run in, any other project.
+func (h *AgentHandler) SetBigQueryProject(project string) {
+	h.bqProject = project
+}
+
+// runOptions returns the agent loop settings of a persona; the zero
+// PersonaConfig of users without one yields the runner defaults.
+func (h *AgentHandler) runOptions(pc config.PersonaConfig) agent.RunOptions {
+	return agent.RunOptions{
+		MaxTokens:       pc.MaxTokens,
+		MaxIterations:   pc.MaxIterations,
+		ForceFinalAfter: pc.ForceFinalAfter,
+		Temperature:     pc.Temperature,
+		StopSequences:   pc.StopSequences,
+		ToolConcurrency: h.toolConcurrency,
+		ToolTimeout:     h.toolTimeout,
+		NoPromptCache:   pc.PromptCaching != nil && !*pc.PromptCaching,
+	}
+}
+
 // checkDataSourceAllowed returns nil if the given dataSource is permitted for the
 // persona, or a descriptive error if it is not. An empty AllowedDataSources list
 // means all data sources are allowed (backward compatible default).
@@ -70,6 +123,112 @@ func (h *AgentHandler) checkDataSourceAllowed(pc config.PersonaConfig, dataSourc
 		dataSource, pc.AllowedDataSources)
 }
 
+// squadTableAccess builds the table access policy enforced on the SQL a
+// user's agent runs: the squad's datasets (in project) and databases plus
+// its table and column lists, and the squad's and user's row filters. Users
+// without a squad or row filters get nil (no restriction).
+func squadTableAccess(user *models.User, project string) *security.TableAccessPolicy {
+	if user == nil {
+		return nil
+	}
+	rowFilters := userRowFilters(user)
+	if user.Squad == nil {
+		if len(rowFilters) == 0 {
+			return nil
+		}
+		return &security.TableAccessPolicy{RowFilters: rowFilters}
+	}
+	return &security.TableAccessPolicy{
+		Project:       project,
+		Datasets:      user.Squad.Datasets,
+		Databases:     user.Squad.PGDatabases,
+		AllowedTables: user.Squad.AllowedTables,
+		DeniedTables:  user.Squad.DeniedTables,
+		DeniedColumns: user.Squad.DeniedColumns,
+		RowFilters:    rowFilters,
+	}
+}
+
+// userRowFilters returns the squad's row filters followed by the user's own.
+func userRowFilters(user *models.User) []security.RowFilter {
+	var out []security.RowFilter
+	if user.Squad != nil {
+		for _, f := range user.Squad.RowFilters {
+			out = append(out, security.RowFilter{Table: f.Table, Predicate: f.Predicate})
+		}
+	}
+	for _, f := range user.RowFilters {
+		out = append(out, security.RowFilter{Table: f.Table, Predicate: f.Predicate})
+	}
+	return out
+}
+
+// federatedScope builds the scope of a federated request: the persona's
+// AllowedDataSources (empty = all) and the squad's datasets, databases,
+// index patterns and table/column lists.
+func (h *AgentHandler) federatedScope(pc config.PersonaConfig, user *models.User) agent.FederatedScope {
+	var scope agent.FederatedScope
+	for _, ds := range pc.AllowedDataSources {
+		scope.Sources = append(scope.Sources, service.DataSource(ds))
+	}
+	if user != nil {
+		scope.SquadID = user.SquadID
+		if user.Squad != nil {
+			scope.Project = h.bqProject
+			scope.Datasets = user.Squad.Datasets
+			scope.ESIndexPatterns = user.Squad.ESIndexPatterns
+			scope.PGDatabases = user.Squad.PGDatabases
+			scope.AllowedTables = user.Squad.AllowedTables
+			scope.DeniedTables = user.Squad.DeniedTables
+			scope.DeniedColumns = user.Squad.DeniedColumns
+		}
+		scope.RowFilters = userRowFilters(user)
+	}
+	return scope
+}
+
+// checkFederatedAvailable writes 503/403 and returns false when a federated
+// request cannot run: federated mode is not configured, or none of the
+// configured sources is permitted for the persona.
+func (h *AgentHandler) checkFederatedAvailable(w http.ResponseWriter, scope agent.FederatedScope) bool {
+	if h.fedHandler == nil {
+		models.WriteError(w, http.StatusServiceUnavailable, "federated queries are not configured")
+		return false
+	}
+	if len(h.fedHandler.AvailableSources(scope)) == 0 {
+		models.WriteError(w, http.StatusForbidden, "no data sources are available to your persona for federated queries")
+		return false
+	}
+	return true
+}
+
+// checkSourceAvailable writes 503 (or 403 for a federated request with no
+// source left) and returns false when source cannot serve the request. It
+// runs before the trace starts, so a request rejected here leaves none.
+func (h *AgentHandler) checkSourceAvailable(w http.ResponseWriter, source service.DataSource, scope agent.FederatedScope) bool {
+	switch source {
+	case service.DataSourceFederated:
+		return h.checkFederatedAvailable(w, scope)
+	case service.DataSourceElasticsearch:
+		if h.esHandler == nil {
+			models.WriteError(w, http.StatusServiceUnavailable, "Elasticsearch is not configured")
+			return false
+		}
+	case service.DataSourcePostgres:
+		if h.pgHandler == nil {
+			models.WriteError(w, http.StatusServiceUnavailable, "PostgreSQL is not configured")
+			return false
+		}
+	default:
+		// FIX #1: nil check for bqHandler to prevent panic
+		if h.bqHandler == nil {
+			models.WriteError(w, http.StatusServiceUnavailable, "BigQuery is not configured")
+			return false
+		}
+	}
+	return true
+}
+
 // QueryAgent handles POST /api/v1/query-agent
 func (h *AgentHandler) QueryAgent(w http.ResponseWriter, r *http.Request) {
 	var req models.AgentRequest
@@ -87,22 +246,35 @@ func (h *AgentHandler) QueryAgent(w http.ResponseWriter, r *http.Request) {
 	apiKey := r.Header.Get("X-API-Key")
 
 	// Extract squad restrictions and persona from authenticated user
-	var allowedDatasets []string
 	var allowedESPatterns []string
-	var allowedPGDatabases []string
 	var currentUser *models.User
 	if user, ok := middleware.GetCurrentUser(r.Context()); ok {
 		currentUser = user
 		if user.Squad != nil {
-			allowedDatasets = user.Squad.Datasets
 			allowedESPatterns = user.Squad.ESIndexPatterns
-			allowedPGDatabases = user.Squad.PGDatabases
+		}
+	}
+	access := squadTableAccess(currentUser, h.bqProject)
+	if req.ProjectID != nil {
+		if msg := access.ProjectViolation(*req.ProjectID); msg != "" {
+			models.WriteError(w, http.StatusForbidden, msg)
+			return
 		}
 	}
 
 	// Resolve persona → LLM runner + prompt style + persona config
 	runner, promptStyle, pc := h.resolvePersona(currentUser)
 
+	if !h.checkTokenBudget(w, apiKey) {
+		return
+	}
+
+	// Load prior turns for follow-up requests (may also fill data_source/dataset_id)
+	if err := h.loadConversation(&req, currentUser); err != nil {
+		writeConversationError(w, err)
+		return
+	}
+
 	// Determine data source
 	var source service.DataSource
 	var routingConf float64
@@ -119,46 +291,50 @@ func (h *AgentHandler) QueryAgent(w http.ResponseWriter, r *http.Request) {
 		routingReason = routing.Reasoning
 	}
 
-	// Persona-based data source restriction
-	if err := h.checkDataSourceAllowed(pc, string(source)); err != nil {
-		models.WriteError(w, http.StatusForbidden, err.Error())
+	// Persona-based data source restriction. Federated requests are restricted
+	// per underlying source instead (see federatedScope).
+	if source != service.DataSourceFederated {
+		if err := h.checkDataSourceAllowed(pc, string(source)); err != nil {
+			models.WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	squadID := ""
+	if currentUser != nil {
+		squadID = currentUser.SquadID
+	}
+
+	fedScope := h.federatedScope(pc, currentUser)
+	if !h.checkSourceAvailable(w, source, fedScope) {
 		return
 	}
 
 	var resp *models.AgentResponse
 	var err error
+	ctx, trace := h.startTrace(r.Context(), &req, source, currentUser)
 
 	switch source {
+	case service.DataSourceFederated:
+		resp, err = h.fedHandler.Handle(ctx, &req, apiKey, fedScope, runner, h.runOptions(pc), promptStyle, pc.ExcludedTools)
 	case service.DataSourceElasticsearch:
-		if h.esHandler == nil {
-			models.WriteError(w, http.StatusServiceUnavailable, "Elasticsearch is not configured")
-			return
-		}
-		resp, err = h.esHandler.Handle(r.Context(), &req, apiKey, allowedESPatterns, runner, promptStyle)
+		resp, err = h.esHandler.Handle(ctx, &req, apiKey, allowedESPatterns, runner, h.runOptions(pc), promptStyle)
 	case service.DataSourcePostgres:
-		if h.pgHandler == nil {
-			models.WriteError(w, http.StatusServiceUnavailable, "PostgreSQL is not configured")
-			return
-		}
-		squadID := ""
-		if currentUser != nil {
-			squadID = currentUser.SquadID
-		}
-		resp, err = h.pgHandler.Handle(r.Context(), &req, apiKey, squadID, allowedPGDatabases, runner, promptStyle, pc.ExcludedTools)
+		resp, err = h.pgHandler.Handle(ctx, &req, apiKey, squadID, access, runner, h.runOptions(pc), promptStyle, pc.ExcludedTools)
 	default:
-		// FIX #1: nil check for bqHandler to prevent panic
-		if h.bqHandler == nil {
-			models.WriteError(w, http.StatusServiceUnavailable, "BigQuery is not configured")
-			return
-		}
-		resp, err = h.bqHandler.Handle(r.Context(), &req, apiKey, allowedDatasets, runner, promptStyle, pc.ExcludedTools)
+		resp, err = h.bqHandler.Handle(ctx, &req, apiKey, squadID, access, runner, h.runOptions(pc), promptStyle, pc.ExcludedTools)
 	}
+	traceID := h.finishTrace(trace, resp, err)
+	if usage := h.recordLLMUsage(trace, apiKey, currentUser); usage != nil && resp != nil {
+		resp.AgentMetadata["llm_usage"] = usage
+	}
+	recordRunner(resp, trace)
 
 	if err != nil {
 		if resp != nil {
 			resp.AgentMetadata["routing_confidence"] = routingConf
 			resp.AgentMetadata["routing_reasoning"] = routingReason
-			models.WriteJSON(w, http.StatusBadRequest, resp)
+			models.WriteJSON(w, http.StatusBadRequest, attachTrace(&req, resp, trace, traceID))
 			return
 		}
 		models.WriteError(w, http.StatusInternalServerError, err.Error())
@@ -170,7 +346,10 @@ func (h *AgentHandler) QueryAgent(w http.ResponseWriter, r *http.Request) {
 	if currentUser != nil && currentUser.Persona != "" {
 		resp.AgentMetadata["persona"] = currentUser.Persona
 	}
-	models.WriteJSON(w, http.StatusOK, resp)
+	if len(req.History) > 0 {
+		resp.AgentMetadata["conversation_turns"] = len(req.History)
+	}
+	models.WriteJSON(w, http.StatusOK, attachTrace(&req, h.recordTurn(&req, resp, source, currentUser), trace, traceID))
 }
 
 // QueryAgentStream handles POST /api/v1/query-agent/stream.
@@ -181,9 +360,14 @@ func (h *AgentHandler) QueryAgent(w http.ResponseWriter, r *http.Request) {
 //   - start          — request accepted, validation beginning
 //   - progress       — pipeline step update (step, dataset fields)
 //   - llm_call       — LLM API call starting (iteration field)
-//   - tool_call      — tool invocation (tool, iteration, sql_preview fields)
+//   - text_delta     — chunk of LLM output as it is generated (text, iteration)
+//   - llm_failover   — the persona's runner failed and the run restarts on
+//     the next runner of its failover chain (runner, next, error)
+//   - tool_call      — tool invocation (tool, iteration, sql_preview fields;
+//     index and query_preview for elasticsearch_search)
 //   - result         — AgentResponse payload on success
-//   - error          — error payload with message field
+//   - error          — error payload with message field (and trace_id/trace
+//     when the failed request's trace was stored/requested)
 func (h *AgentHandler) QueryAgentStream(w http.ResponseWriter, r *http.Request) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -203,50 +387,58 @@ func (h *AgentHandler) QueryAgentStream(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Determine data source
-	var source service.DataSource
-	if req.DataSource != nil && *req.DataSource != "" {
-		source = service.DataSource(*req.DataSource)
-	} else {
-		source = h.router.Route(req.Prompt).Source
-	}
-
 	// Resolve user context and persona before routing decisions so that
 	// persona-based restrictions (data source, tool filtering) are enforced
 	// before any SSE headers are written.
 	apiKey := r.Header.Get("X-API-Key")
-	var allowedDatasets []string
-	var allowedPGDatabases []string
+	var allowedESPatterns []string
 	var currentUser *models.User
 	if user, ok := middleware.GetCurrentUser(r.Context()); ok {
 		currentUser = user
 		if user.Squad != nil {
-			allowedDatasets = user.Squad.Datasets
-			allowedPGDatabases = user.Squad.PGDatabases
+			allowedESPatterns = user.Squad.ESIndexPatterns
+		}
+	}
+	access := squadTableAccess(currentUser, h.bqProject)
+	if req.ProjectID != nil {
+		if msg := access.ProjectViolation(*req.ProjectID); msg != "" {
+			models.WriteError(w, http.StatusForbidden, msg)
+			return
 		}
 	}
 	runner, promptStyle, pc := h.resolvePersona(currentUser)
 
-	// Persona-based data source restriction (must be before SSE headers so HTTP
-	// status 403 can still be written to the response).
-	if err := h.checkDataSourceAllowed(pc, string(source)); err != nil {
-		models.WriteError(w, http.StatusForbidden, err.Error())
+	if !h.checkTokenBudget(w, apiKey) {
 		return
 	}
 
-	// Streaming is currently only supported for BigQuery and PostgreSQL
-	if source == service.DataSourceElasticsearch {
-		models.WriteError(w, http.StatusNotImplemented, "streaming is not yet supported for Elasticsearch")
+	// Load prior turns for follow-up requests (may also fill data_source/dataset_id)
+	if err := h.loadConversation(&req, currentUser); err != nil {
+		writeConversationError(w, err)
 		return
 	}
 
-	// Pre-flight handler check before writing SSE headers
-	if source == service.DataSourcePostgres && h.pgHandler == nil {
-		models.WriteError(w, http.StatusServiceUnavailable, "PostgreSQL is not configured")
-		return
+	// Determine data source
+	var source service.DataSource
+	if req.DataSource != nil && *req.DataSource != "" {
+		source = service.DataSource(*req.DataSource)
+	} else {
+		source = h.router.Route(req.Prompt).Source
 	}
-	if source != service.DataSourcePostgres && h.bqHandler == nil {
-		models.WriteError(w, http.StatusServiceUnavailable, "BigQuery is not configured")
+
+	// Persona-based data source restriction (must be before SSE headers so HTTP
+	// status 403 can still be written to the response). Federated requests are
+	// restricted per underlying source instead (see federatedScope).
+	if source != service.DataSourceFederated {
+		if err := h.checkDataSourceAllowed(pc, string(source)); err != nil {
+			models.WriteError(w, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	// Pre-flight handler check before writing SSE headers
+	fedScope := h.federatedScope(pc, currentUser)
+	if !h.checkSourceAvailable(w, source, fedScope) {
 		return
 	}
 
@@ -256,7 +448,25 @@ func (h *AgentHandler) QueryAgentStream(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("X-Accel-Buffering", "no") // disable nginx buffering
 
+	ctx, trace := h.startTrace(r.Context(), &req, source, currentUser)
 	emitSSE := func(event string, data interface{}) {
+		switch event {
+		case "result":
+			if resp, ok := data.(*models.AgentResponse); ok {
+				traceID := h.finishTrace(trace, resp, nil)
+				if usage := h.recordLLMUsage(trace, apiKey, currentUser); usage != nil {
+					resp.AgentMetadata["llm_usage"] = usage
+				}
+				recordRunner(resp, trace)
+				data = attachTrace(&req, h.recordTurn(&req, resp, source, currentUser), trace, traceID)
+			}
+		case "error":
+			h.recordLLMUsage(trace, apiKey, currentUser)
+			if m, ok := data.(map[string]interface{}); ok && trace != nil {
+				msg, _ := m["message"].(string)
+				data = traceErrorData(m, trace, h.finishTrace(trace, nil, errors.New(msg)), req.IncludeTrace)
+			}
+		}
 		payload, err := json.Marshal(map[string]interface{}{"event": event, "data": data})
 		if err != nil {
 			return
@@ -265,13 +475,18 @@ func (h *AgentHandler) QueryAgentStream(w http.ResponseWriter, r *http.Request)
 		flusher.Flush()
 	}
 
-	if source == service.DataSourcePostgres {
-		squadID := ""
-		if currentUser != nil {
-			squadID = currentUser.SquadID
-		}
-		h.pgHandler.HandleStream(r.Context(), &req, apiKey, squadID, allowedPGDatabases, runner, promptStyle, emitSSE, pc.ExcludedTools)
-	} else {
-		h.bqHandler.HandleStream(r.Context(), &req, apiKey, allowedDatasets, runner, promptStyle, emitSSE, pc.ExcludedTools)
+	squadID := ""
+	if currentUser != nil {
+		squadID = currentUser.SquadID
+	}
+	switch source {
+	case service.DataSourceFederated:
+		h.fedHandler.HandleStream(ctx, &req, apiKey, fedScope, runner, h.runOptions(pc), promptStyle, emitSSE, pc.ExcludedTools)
+	case service.DataSourceElasticsearch:
+		h.esHandler.HandleStream(ctx, &req, apiKey, allowedESPatterns, runner, h.runOptions(pc), promptStyle, emitSSE)
+	case service.DataSourcePostgres:
+		h.pgHandler.HandleStream(ctx, &req, apiKey, squadID, access, runner, h.runOptions(pc), promptStyle, emitSSE, pc.ExcludedTools)
+	default:
+		h.bqHandler.HandleStream(ctx, &req, apiKey, squadID, access, runner, h.runOptions(pc), promptStyle, emitSSE, pc.ExcludedTools)
 	}
 }
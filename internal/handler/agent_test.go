@@ -1,10 +1,15 @@
 package handler
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/cortexai/cortexai/internal/agent"
 	"github.com/cortexai/cortexai/internal/config"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
 )
 
 // checkDataSourceAllowed is tested via a zero-value AgentHandler receiver since
@@ -98,6 +103,51 @@ func TestCheckDataSourceAllowed_ErrorMessageFormat(t *testing.T) {
 	}
 }
 
+func TestFederatedScope_PersonaAndSquad(t *testing.T) {
+	h := &AgentHandler{}
+	pc := config.PersonaConfig{AllowedDataSources: []string{"bigquery", "elasticsearch"}}
+	user := &models.User{
+		ID:      "u1",
+		SquadID: "payment",
+		Squad: &models.Squad{
+			Datasets:        []string{"payment_dwh"},
+			ESIndexPatterns: []string{"payment-logs-*"},
+			PGDatabases:     []string{"payment_db"},
+		},
+	}
+	scope := h.federatedScope(pc, user)
+	if len(scope.Sources) != 2 || scope.Sources[0] != service.DataSourceBigQuery || scope.Sources[1] != service.DataSourceElasticsearch {
+		t.Errorf("sources = %v", scope.Sources)
+	}
+	if scope.SquadID != "payment" || scope.Datasets[0] != "payment_dwh" || scope.PGDatabases[0] != "payment_db" || scope.ESIndexPatterns[0] != "payment-logs-*" {
+		t.Errorf("unexpected squad scope: %+v", scope)
+	}
+
+	// No persona restriction and no user → unrestricted scope
+	if scope := h.federatedScope(config.PersonaConfig{}, nil); scope.Sources != nil || scope.SquadID != "" {
+		t.Errorf("expected empty scope, got %+v", scope)
+	}
+}
+
+func TestCheckSourceAvailable_UnconfiguredSources(t *testing.T) {
+	h := &AgentHandler{}
+	for _, source := range []service.DataSource{
+		service.DataSourceBigQuery,
+		service.DataSourceElasticsearch,
+		service.DataSourcePostgres,
+		service.DataSourceFederated,
+	} {
+		rec := httptest.NewRecorder()
+		if h.checkSourceAvailable(rec, source, agent.FederatedScope{}) {
+			t.Errorf("%s: expected unavailable", source)
+			continue
+		}
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: status = %d, want 503", source, rec.Code)
+		}
+	}
+}
+
 func contains(s, sub string) bool {
 	return strings.Contains(s, sub)
 }
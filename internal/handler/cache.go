@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/cortexai/cortexai/internal/agent"
 	"github.com/cortexai/cortexai/internal/models"
 	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 )
 
 // CacheHandler handles cache management endpoints.
@@ -27,7 +29,11 @@ func (h *CacheHandler) InvalidateSchemaCache(w http.ResponseWriter, r *http.Requ
 		models.WriteError(w, http.StatusBadRequest, "dataset path parameter is required")
 		return
 	}
-	h.bqHandler.InvalidateSchemaCache(dataset)
+	if err := h.bqHandler.InvalidateSchemaCache(dataset); err != nil {
+		log.Error().Err(err).Str("dataset", dataset).Msg("schema cache invalidation failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to invalidate schema cache")
+		return
+	}
 	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"status":  "ok",
 		"message": "schema cache invalidated",
@@ -36,14 +42,20 @@ func (h *CacheHandler) InvalidateSchemaCache(w http.ResponseWriter, r *http.Requ
 }
 
 // FlushResponseCache handles DELETE /api/v1/cache/responses.
-// It clears all in-memory agent response caches for BQ and PG handlers,
+// It clears the agent response caches for BQ and PG handlers on every replica,
 // forcing the next identical query to run the full LLM pipeline again.
 func (h *CacheHandler) FlushResponseCache(w http.ResponseWriter, r *http.Request) {
+	var errs []error
 	if h.bqHandler != nil {
-		h.bqHandler.FlushResponseCache()
+		errs = append(errs, h.bqHandler.FlushResponseCache())
 	}
 	if h.pgHandler != nil {
-		h.pgHandler.FlushResponseCache()
+		errs = append(errs, h.pgHandler.FlushResponseCache())
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.Error().Err(err).Msg("response cache flush failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to flush response cache")
+		return
 	}
 	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"status":  "ok",
@@ -65,7 +77,11 @@ func (h *CacheHandler) InvalidatePGSchemaCache(w http.ResponseWriter, r *http.Re
 		return
 	}
 	cacheKey := squad + ":" + database
-	h.pgHandler.InvalidateSchemaCache(cacheKey)
+	if err := h.pgHandler.InvalidateSchemaCache(cacheKey); err != nil {
+		log.Error().Err(err).Str("cache_key", cacheKey).Msg("pg schema cache invalidation failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to invalidate pg schema cache")
+		return
+	}
 	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
 		"status":   "ok",
 		"message":  "pg schema cache invalidated",
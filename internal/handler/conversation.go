@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	maxTurnAnswerLen  = 2000 // answer text kept per turn
+	maxTurnSummaryLen = 1000 // result summary kept per turn
+	turnSampleRows    = 3    // rows included in the result summary
+)
+
+// conversationOwner returns the (userID, squadID) pair a conversation is bound to.
+// Unauthenticated requests (auth disabled) share the empty owner.
+func conversationOwner(user *models.User) (string, string) {
+	if user == nil {
+		return "", ""
+	}
+	return user.ID, user.SquadID
+}
+
+// loadConversation populates req.History for a follow-up request. When the
+// follow-up omits data_source or dataset_id they are inherited from the last
+// turn, so "now break that down by region" stays on the same dataset.
+// Returns service.ErrConversationNotFound for unknown, expired, or foreign
+// conversation IDs. It is a no-op when conversations are disabled or the
+// request starts a new conversation.
+func (h *AgentHandler) loadConversation(req *models.AgentRequest, user *models.User) error {
+	if h.conversations == nil || req.ConversationID == "" {
+		return nil
+	}
+	userID, squadID := conversationOwner(user)
+	history, err := h.conversations.History(req.ConversationID, userID, squadID)
+	if err != nil {
+		return err
+	}
+	req.History = history
+
+	if len(history) > 0 {
+		last := history[len(history)-1]
+		if (req.DataSource == nil || *req.DataSource == "") && last.DataSource != "" {
+			ds := last.DataSource
+			req.DataSource = &ds
+		}
+		if (req.DatasetID == nil || *req.DatasetID == "") && last.DatasetID != "" {
+			id := last.DatasetID
+			req.DatasetID = &id
+		}
+	}
+	return nil
+}
+
+// recordTurn appends a successful exchange to the request's conversation,
+// creating the conversation on the first turn, and returns a shallow copy of
+// resp carrying the conversation ID. resp itself is not mutated because it may
+// be shared with the response cache. Non-success responses are returned as-is.
+func (h *AgentHandler) recordTurn(req *models.AgentRequest, resp *models.AgentResponse, source service.DataSource, user *models.User) *models.AgentResponse {
+	if h.conversations == nil || resp == nil || resp.Status != "success" {
+		return resp
+	}
+	userID, squadID := conversationOwner(user)
+	id := req.ConversationID
+	if id == "" {
+		var err error
+		if id, err = h.conversations.Create(userID, squadID); err != nil {
+			log.Warn().Err(err).Msg("failed to start conversation")
+			return resp
+		}
+	}
+	if err := h.conversations.Append(id, userID, squadID, buildTurn(req, resp, source)); err != nil {
+		log.Warn().Err(err).Str("conversation_id", id).Msg("failed to record conversation turn")
+		return resp
+	}
+	out := *resp
+	out.ConversationID = id
+	return &out
+}
+
+// buildTurn condenses a response into a ConversationTurn: answer and result
+// summary are truncated and only a few (already masked) sample rows are kept.
+func buildTurn(req *models.AgentRequest, resp *models.AgentResponse, source service.DataSource) models.ConversationTurn {
+	turn := models.ConversationTurn{
+		Prompt:        req.Prompt,
+		DataSource:    string(source),
+		ResultSummary: summarizeResult(resp.ExecutionResult),
+	}
+	if req.DatasetID != nil {
+		turn.DatasetID = *req.DatasetID
+	}
+	if resp.GeneratedSQL != nil {
+		turn.GeneratedSQL = *resp.GeneratedSQL
+	}
+	if resp.Answer != nil {
+		turn.Answer = truncateRunes(*resp.Answer, maxTurnAnswerLen)
+	}
+	return turn
+}
+
+// summarizeResult renders row count, columns and a few sample rows of an
+// execution result as compact text for LLM context.
+func summarizeResult(res *models.QueryResponse) string {
+	if res == nil {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d rows", res.RowCount)
+	if len(res.Columns) > 0 {
+		sb.WriteString("; columns: " + strings.Join(res.Columns, ", "))
+	}
+	if n := min(turnSampleRows, len(res.Data)); n > 0 {
+		if b, err := json.Marshal(res.Data[:n]); err == nil {
+			sb.WriteString("; first rows: ")
+			sb.Write(b)
+		}
+	}
+	return truncateRunes(sb.String(), maxTurnSummaryLen)
+}
+
+// truncateRunes shortens s to at most n bytes without splitting a UTF-8 rune.
+func truncateRunes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestLoadConversation_InheritsSourceAndDataset(t *testing.T) {
+	store := service.NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), 0, 0)
+	h := &AgentHandler{conversations: store}
+	user := &models.User{ID: "u1", SquadID: "squad-a"}
+
+	id, _ := store.Create("u1", "squad-a")
+	_ = store.Append(id, "u1", "squad-a", models.ConversationTurn{
+		Prompt: "revenue by month", DataSource: "bigquery", DatasetID: "sales",
+	})
+
+	req := models.AgentRequest{Prompt: "now by region", ConversationID: id}
+	if err := h.loadConversation(&req, user); err != nil {
+		t.Fatalf("loadConversation: %v", err)
+	}
+	if len(req.History) != 1 {
+		t.Fatalf("expected 1 history turn, got %d", len(req.History))
+	}
+	if req.DataSource == nil || *req.DataSource != "bigquery" {
+		t.Errorf("data_source should be inherited from last turn, got %v", req.DataSource)
+	}
+	if req.DatasetID == nil || *req.DatasetID != "sales" {
+		t.Errorf("dataset_id should be inherited from last turn, got %v", req.DatasetID)
+	}
+
+	// Explicit values win over inherited ones
+	req = models.AgentRequest{Prompt: "q", ConversationID: id, DatasetID: strPtr("marketing")}
+	_ = h.loadConversation(&req, user)
+	if *req.DatasetID != "marketing" {
+		t.Errorf("explicit dataset_id overwritten: %q", *req.DatasetID)
+	}
+}
+
+func TestLoadConversation_OtherSquadNotFound(t *testing.T) {
+	store := service.NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), 0, 0)
+	h := &AgentHandler{conversations: store}
+	id, _ := store.Create("u1", "squad-a")
+
+	req := models.AgentRequest{Prompt: "q", ConversationID: id}
+	err := h.loadConversation(&req, &models.User{ID: "u1", SquadID: "squad-b"})
+	if !errors.Is(err, service.ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+}
+
+func TestRecordTurn_CreatesConversationWithoutMutatingResponse(t *testing.T) {
+	store := service.NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), 0, 0)
+	h := &AgentHandler{conversations: store}
+	user := &models.User{ID: "u1", SquadID: "squad-a"}
+
+	resp := &models.AgentResponse{
+		Status:       "success",
+		Answer:       strPtr("Total 42"),
+		GeneratedSQL: strPtr("SELECT 42"),
+		ExecutionResult: &models.QueryResponse{
+			RowCount: 1,
+			Columns:  []string{"total"},
+			Data:     []map[string]interface{}{{"total": 42}},
+		},
+	}
+	req := models.AgentRequest{Prompt: "total?", DatasetID: strPtr("sales")}
+	out := h.recordTurn(&req, resp, service.DataSourceBigQuery, user)
+
+	if out.ConversationID == "" {
+		t.Fatal("expected conversation_id on recorded response")
+	}
+	if resp.ConversationID != "" {
+		t.Error("original response must not be mutated (may be shared with the cache)")
+	}
+	hist, err := store.History(out.ConversationID, "u1", "squad-a")
+	if err != nil || len(hist) != 1 {
+		t.Fatalf("expected 1 recorded turn, got %d (err %v)", len(hist), err)
+	}
+	turn := hist[0]
+	if turn.DataSource != "bigquery" || turn.DatasetID != "sales" || turn.GeneratedSQL != "SELECT 42" {
+		t.Errorf("unexpected turn: %+v", turn)
+	}
+	if !strings.Contains(turn.ResultSummary, "1 rows") || !strings.Contains(turn.ResultSummary, "total") {
+		t.Errorf("unexpected result summary: %q", turn.ResultSummary)
+	}
+}
+
+func TestRecordTurn_SkipsErrors(t *testing.T) {
+	h := &AgentHandler{conversations: service.NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), 0, 0)}
+	resp := &models.AgentResponse{Status: "error"}
+	out := h.recordTurn(&models.AgentRequest{Prompt: "q"}, resp, service.DataSourceBigQuery, nil)
+	if out.ConversationID != "" {
+		t.Error("error responses should not start a conversation")
+	}
+}
+
+func TestTruncateRunes(t *testing.T) {
+	if got := truncateRunes("short", 10); got != "short" {
+		t.Errorf("got %q", got)
+	}
+	// "é" is two bytes; cutting at 2 would split it
+	if got := truncateRunes("aébc", 2); got != "a..." {
+		t.Errorf("got %q, want %q", got, "a...")
+	}
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// ExamplesHandler manages the verified prompt→SQL examples the agent uses as
+// few-shot context.
+type ExamplesHandler struct {
+	store  *agent.ExampleStore
+	sqlVal *security.SQLValidator
+}
+
+func NewExamplesHandler(store *agent.ExampleStore, sqlVal *security.SQLValidator) *ExamplesHandler {
+	return &ExamplesHandler{store: store, sqlVal: sqlVal}
+}
+
+// exampleRequest is the body of POST and PUT /api/v1/examples.
+type exampleRequest struct {
+	SquadID    *string `json:"squad_id,omitempty"` // admins only; "" = shared by every squad
+	DataSource string  `json:"data_source"`
+	Dataset    string  `json:"dataset"`
+	Prompt     string  `json:"prompt"`
+	SQL        string  `json:"sql"`
+	Notes      *string `json:"notes,omitempty"`
+	Verified   *bool   `json:"verified,omitempty"` // admins only
+}
+
+// currentExampleUser returns the caller, writing 401 when there is none.
+func currentExampleUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		models.WriteError(w, http.StatusUnauthorized, "not authenticated")
+		return nil, false
+	}
+	return user, true
+}
+
+// visibleExample reports whether user may see ex: admins see every example,
+// other users their squad's and the shared ones.
+func visibleExample(user *models.User, ex *models.QueryExample) bool {
+	return user.Role == models.RoleAdmin || ex.SquadID == "" || ex.SquadID == user.SquadID
+}
+
+// List handles GET /api/v1/examples.
+// Returns the caller's squad examples and the shared ones, filtered by the
+// optional ?data_source=, ?dataset= and ?verified= parameters. Admins see
+// every squad's examples, or one squad's with ?squad_id=.
+func (h *ExamplesHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentExampleUser(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+
+	var examples []models.QueryExample
+	var err error
+	switch {
+	case user.Role != models.RoleAdmin:
+		examples, err = h.store.List(user.SquadID)
+	case q.Has("squad_id"):
+		examples, err = h.store.List(q.Get("squad_id"))
+	default:
+		examples, err = h.store.ListAll()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("list examples failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to list examples")
+		return
+	}
+
+	out := []models.QueryExample{}
+	for _, ex := range examples {
+		if (q.Get("data_source") != "" && ex.DataSource != q.Get("data_source")) ||
+			(q.Get("dataset") != "" && ex.Dataset != q.Get("dataset")) ||
+			(q.Get("verified") != "" && fmt.Sprint(ex.Verified) != q.Get("verified")) {
+			continue
+		}
+		out = append(out, ex)
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"examples": out,
+		"count":    len(out),
+	})
+}
+
+// Get handles GET /api/v1/examples/{id}. Examples of other squads are
+// reported as not found, except to admins.
+func (h *ExamplesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentExampleUser(w, r)
+	if !ok {
+		return
+	}
+	ex, ok := h.find(w, user, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, ex)
+}
+
+// Create handles POST /api/v1/examples.
+// Admins save verified examples for any squad, or shared ones with an empty
+// squad_id. Other users submit feedback: an unverified example for their
+// own squad on a dataset or database it can access, which the agent uses
+// once an admin verifies it.
+func (h *ExamplesHandler) Create(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentExampleUser(w, r)
+	if !ok {
+		return
+	}
+	var req exampleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.WriteError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	ex := &models.QueryExample{
+		SquadID:    user.SquadID,
+		DataSource: req.DataSource,
+		Dataset:    req.Dataset,
+		Prompt:     strings.TrimSpace(req.Prompt),
+		SQL:        strings.TrimSpace(req.SQL),
+		CreatedBy:  user.ID,
+	}
+	if req.Notes != nil {
+		ex.Notes = *req.Notes
+	}
+	if user.Role == models.RoleAdmin {
+		ex.Source = models.ExampleSourceAdmin
+		ex.Verified = req.Verified == nil || *req.Verified
+		if req.SquadID != nil {
+			ex.SquadID = *req.SquadID
+		}
+	} else {
+		ex.Source = models.ExampleSourceFeedback
+		if !checkExampleDatasetAccess(w, user, ex) {
+			return
+		}
+	}
+	if !h.validate(w, ex) {
+		return
+	}
+
+	if err := h.store.Create(ex); err != nil {
+		log.Error().Err(err).Msg("create example failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to save example")
+		return
+	}
+	log.Info().Str("example_id", ex.ID).Str("squad", ex.SquadID).Str("source", ex.Source).Str("dataset", ex.Dataset).Msg("example saved")
+	models.WriteJSON(w, http.StatusCreated, ex)
+}
+
+// Update handles PUT /api/v1/examples/{id} (admins). Fields left out of the
+// body keep their value; the squad of an example cannot be changed.
+func (h *ExamplesHandler) Update(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentExampleUser(w, r)
+	if !ok {
+		return
+	}
+	var req exampleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		models.WriteError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	ex, ok := h.find(w, user, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	if req.SquadID != nil && *req.SquadID != ex.SquadID {
+		models.WriteError(w, http.StatusBadRequest, "the squad of an example cannot be changed")
+		return
+	}
+
+	if req.DataSource != "" {
+		ex.DataSource = req.DataSource
+	}
+	if req.Dataset != "" {
+		ex.Dataset = req.Dataset
+	}
+	if req.Prompt != "" {
+		ex.Prompt = strings.TrimSpace(req.Prompt)
+	}
+	if req.SQL != "" {
+		ex.SQL = strings.TrimSpace(req.SQL)
+	}
+	if req.Notes != nil {
+		ex.Notes = *req.Notes
+	}
+	if req.Verified != nil {
+		ex.Verified = *req.Verified
+	}
+	if !h.validate(w, ex) {
+		return
+	}
+
+	if err := h.store.Update(ex); err != nil {
+		if errors.Is(err, agent.ErrExampleNotFound) {
+			models.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		log.Error().Err(err).Str("example_id", ex.ID).Msg("update example failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to save example")
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, ex)
+}
+
+// Delete handles DELETE /api/v1/examples/{id} (admins).
+func (h *ExamplesHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentExampleUser(w, r)
+	if !ok {
+		return
+	}
+	ex, ok := h.find(w, user, chi.URLParam(r, "id"))
+	if !ok {
+		return
+	}
+	if err := h.store.Delete(ex.SquadID, ex.ID); err != nil && !errors.Is(err, agent.ErrExampleNotFound) {
+		log.Error().Err(err).Str("example_id", ex.ID).Msg("delete example failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to delete example")
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"message": "example deleted",
+		"id":      ex.ID,
+	})
+}
+
+// find returns the example with the given ID if user may see it, writing
+// 404 otherwise.
+func (h *ExamplesHandler) find(w http.ResponseWriter, user *models.User, id string) (*models.QueryExample, bool) {
+	ex, err := h.store.Get(id)
+	if err != nil && !errors.Is(err, agent.ErrExampleNotFound) {
+		log.Error().Err(err).Str("example_id", id).Msg("get example failed")
+		models.WriteError(w, http.StatusInternalServerError, "failed to load example")
+		return nil, false
+	}
+	if ex == nil || !visibleExample(user, ex) {
+		models.WriteError(w, http.StatusNotFound, agent.ErrExampleNotFound.Error())
+		return nil, false
+	}
+	return ex, true
+}
+
+// validate checks the fields of ex and that its SQL is a read-only query,
+// writing 400 when it is not.
+func (h *ExamplesHandler) validate(w http.ResponseWriter, ex *models.QueryExample) bool {
+	var violation string
+	switch {
+	case ex.Prompt == "" || ex.SQL == "" || ex.Dataset == "":
+		violation = "prompt, sql and dataset are required"
+	case ex.DataSource == string(service.DataSourceBigQuery):
+		violation = h.sqlVal.Validate(ex.SQL)
+	case ex.DataSource == string(service.DataSourcePostgres):
+		violation = h.sqlVal.ValidatePG(ex.SQL)
+	default:
+		violation = "data_source must be 'bigquery' or 'postgres'"
+	}
+	if violation != "" {
+		models.WriteError(w, http.StatusBadRequest, violation)
+		return false
+	}
+	return true
+}
+
+// checkExampleDatasetAccess returns false and writes a 403 if the dataset or
+// database of ex is outside user's squad.
+func checkExampleDatasetAccess(w http.ResponseWriter, user *models.User, ex *models.QueryExample) bool {
+	if user.Squad == nil {
+		return true
+	}
+	allowed := true
+	switch ex.DataSource {
+	case string(service.DataSourceBigQuery):
+		allowed = user.Squad.AllowsDataset(ex.Dataset)
+	case string(service.DataSourcePostgres):
+		allowed = user.Squad.AllowsDatabase(ex.Dataset)
+	}
+	if !allowed {
+		models.WriteError(w, http.StatusForbidden,
+			fmt.Sprintf("%s '%s' is not accessible for your squad", ex.DataSource, ex.Dataset))
+		return false
+	}
+	return true
+}
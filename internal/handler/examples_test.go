@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/go-chi/chi/v5"
+)
+
+func newExamplesServer() http.Handler {
+	h := NewExamplesHandler(agent.NewExampleStore(cache.NewMemoryStore().Namespace("agent_examples"), agent.ExampleOptions{}), security.NewSQLValidator())
+	users := keyLookup{
+		"analyst-a": {ID: "u1", Role: models.RoleAnalyst, SquadID: "squad-a", Squad: &models.Squad{ID: "squad-a", Datasets: []string{"sales"}}},
+		"analyst-b": {ID: "u2", Role: models.RoleAnalyst, SquadID: "squad-b", Squad: &models.Squad{ID: "squad-b"}},
+		"admin":     {ID: "u3", Role: models.RoleAdmin},
+	}
+	r := chi.NewRouter()
+	r.Use(middleware.Auth(users, "X-API-Key"))
+	r.Get("/examples", h.List)
+	r.Post("/examples", h.Create)
+	r.Get("/examples/{id}", h.Get)
+	r.Put("/examples/{id}", h.Update)
+	r.Delete("/examples/{id}", h.Delete)
+	return r
+}
+
+func doExample(t *testing.T, srv http.Handler, key, method, path, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-API-Key", key)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+	if out != nil && rec.Code < 300 {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestExamples_FeedbackIsUnverifiedAndSquadScoped(t *testing.T) {
+	srv := newExamplesServer()
+
+	var ex models.QueryExample
+	body := `{"data_source":"bigquery","dataset":"sales","prompt":"revenue per month","sql":"SELECT 1","squad_id":"squad-b","verified":true}`
+	if code := doExample(t, srv, "analyst-a", http.MethodPost, "/examples", body, &ex); code != http.StatusCreated {
+		t.Fatalf("feedback status = %d", code)
+	}
+	if ex.Verified || ex.Source != models.ExampleSourceFeedback || ex.SquadID != "squad-a" || ex.CreatedBy != "u1" {
+		t.Errorf("feedback example = %+v, want unverified feedback of squad-a", ex)
+	}
+
+	if code := doExample(t, srv, "analyst-a", http.MethodPost, "/examples",
+		`{"data_source":"bigquery","dataset":"finance","prompt":"p","sql":"SELECT 1"}`, nil); code != http.StatusForbidden {
+		t.Errorf("dataset outside squad: status = %d, want 403", code)
+	}
+	if code := doExample(t, srv, "analyst-a", http.MethodPost, "/examples",
+		`{"data_source":"bigquery","dataset":"sales","prompt":"p","sql":"DELETE FROM sales.orders WHERE 1=1"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("DML example: status = %d, want 400", code)
+	}
+
+	if code := doExample(t, srv, "analyst-b", http.MethodGet, "/examples/"+ex.ID, "", nil); code != http.StatusNotFound {
+		t.Errorf("other squad's example: status = %d, want 404", code)
+	}
+	var list struct {
+		Count int `json:"count"`
+	}
+	if doExample(t, srv, "analyst-b", http.MethodGet, "/examples", "", &list); list.Count != 0 {
+		t.Errorf("squad-b lists %d examples, want 0", list.Count)
+	}
+
+	var verified models.QueryExample
+	if code := doExample(t, srv, "admin", http.MethodPut, "/examples/"+ex.ID, `{"verified":true,"notes":"checked"}`, &verified); code != http.StatusOK {
+		t.Fatalf("verify status = %d", code)
+	}
+	if !verified.Verified || verified.Notes != "checked" || verified.Prompt != "revenue per month" {
+		t.Errorf("verified example = %+v", verified)
+	}
+	if doExample(t, srv, "analyst-a", http.MethodGet, "/examples?verified=true", "", &list); list.Count != 1 {
+		t.Errorf("squad-a lists %d verified examples, want 1", list.Count)
+	}
+
+	if code := doExample(t, srv, "admin", http.MethodDelete, "/examples/"+ex.ID, "", nil); code != http.StatusOK {
+		t.Errorf("delete status = %d", code)
+	}
+	if code := doExample(t, srv, "admin", http.MethodGet, "/examples/"+ex.ID, "", nil); code != http.StatusNotFound {
+		t.Errorf("deleted example: status = %d, want 404", code)
+	}
+}
+
+func TestExamples_AdminCreatesSharedVerified(t *testing.T) {
+	srv := newExamplesServer()
+
+	var ex models.QueryExample
+	body := `{"squad_id":"","data_source":"postgres","dataset":"payment_db","prompt":"refunds today","sql":"SELECT count(*) FROM refunds WHERE created_at >= current_date"}`
+	if code := doExample(t, srv, "admin", http.MethodPost, "/examples", body, &ex); code != http.StatusCreated {
+		t.Fatalf("status = %d", code)
+	}
+	if !ex.Verified || ex.Source != models.ExampleSourceAdmin || ex.SquadID != "" {
+		t.Errorf("admin example = %+v, want verified and shared", ex)
+	}
+	if code := doExample(t, srv, "analyst-b", http.MethodGet, "/examples/"+ex.ID, "", nil); code != http.StatusOK {
+		t.Errorf("shared example: status = %d, want 200", code)
+	}
+	if code := doExample(t, srv, "admin", http.MethodPost, "/examples",
+		`{"data_source":"elasticsearch","dataset":"logs","prompt":"p","sql":"SELECT 1"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("unsupported data source: status = %d, want 400", code)
+	}
+}
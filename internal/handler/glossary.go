@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+// GlossaryHandler serves the business glossary the agent uses.
+type GlossaryHandler struct {
+	glossary *service.Glossary
+}
+
+func NewGlossaryHandler(glossary *service.Glossary) *GlossaryHandler {
+	return &GlossaryHandler{glossary: glossary}
+}
+
+// List handles GET /api/v1/glossary.
+// Returns the terms visible to the caller's squad: its own and the shared
+// ones. ?dataset= narrows the list to terms for one BigQuery dataset or
+// PostgreSQL database. Admins may pass ?squad_id= to list another squad's
+// glossary; other users get 403 for any squad but their own.
+func (h *GlossaryHandler) List(w http.ResponseWriter, r *http.Request) {
+	squadID := r.URL.Query().Get("squad_id")
+	if user, ok := middleware.GetCurrentUser(r.Context()); ok {
+		switch {
+		case squadID == "":
+			squadID = user.SquadID
+		case squadID != user.SquadID && user.Role != models.RoleAdmin:
+			models.WriteError(w, http.StatusForbidden,
+				fmt.Sprintf("glossary of squad '%s' is not accessible for your squad", squadID))
+			return
+		}
+	}
+
+	terms := h.glossary.Terms(squadID, r.URL.Query().Get("dataset"))
+	if terms == nil {
+		terms = []service.GlossaryTerm{}
+	}
+	models.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"squad_id": squadID,
+		"terms":    terms,
+		"count":    len(terms),
+	})
+}
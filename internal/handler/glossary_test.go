@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+type keyLookup map[string]*models.User
+
+func (l keyLookup) GetByKey(key string) (*models.User, bool) {
+	u, ok := l[key]
+	return u, ok
+}
+
+func TestGlossaryList_SquadScoping(t *testing.T) {
+	h := NewGlossaryHandler(service.NewGlossary(
+		[]service.GlossaryTerm{{Term: "GMV", SQL: "SUM(amount)"}},
+		map[string][]service.GlossaryTerm{
+			"squad-a": {{Term: "Churn", Definition: "no order in 60 days"}},
+			"squad-b": {{Term: "AOV", SQL: "AVG(amount)"}},
+		},
+	))
+	users := keyLookup{
+		"analyst": {ID: "u1", Role: models.RoleAnalyst, SquadID: "squad-a"},
+		"admin":   {ID: "u2", Role: models.RoleAdmin, SquadID: "squad-a"},
+	}
+	srv := middleware.Auth(users, "X-API-Key")(http.HandlerFunc(h.List))
+
+	tests := []struct {
+		key, query string
+		status     int
+		want       []string
+	}{
+		{"analyst", "", http.StatusOK, []string{"Churn", "GMV"}},
+		{"analyst", "?squad_id=squad-b", http.StatusForbidden, nil},
+		{"admin", "?squad_id=squad-b", http.StatusOK, []string{"AOV", "GMV"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/glossary"+tt.query, nil)
+		req.Header.Set("X-API-Key", tt.key)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.key, tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Terms []service.GlossaryTerm `json:"terms"`
+			Count int                    `json:"count"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, term := range body.Terms {
+			got = append(got, term.Term)
+		}
+		if !slices.Equal(got, tt.want) || body.Count != len(tt.want) {
+			t.Errorf("%s %s: terms = %v (count %d), want %v", tt.key, tt.query, got, body.Count, tt.want)
+		}
+	}
+}
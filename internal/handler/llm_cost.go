@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+// llmUsage is agent_metadata["llm_usage"]: the tokens of every LLM call made
+// for a request and their cost.
+type llmUsage struct {
+	models.TokenUsage
+	CostUSD *float64 `json:"cost_usd,omitempty"` // nil when a model used has no price
+	Models  []string `json:"models"`
+	Runs    int      `json:"runs"`
+}
+
+// SetCostTracker enables LLM cost accounting and daily token quotas; nil
+// disables them. Token usage is still reported in agent_metadata.
+func (h *AgentHandler) SetCostTracker(ct *security.CostTracker) {
+	h.costTracker = ct
+}
+
+// checkTokenBudget writes 429 and returns false when the caller has used up a
+// daily LLM token quota.
+func (h *AgentHandler) checkTokenBudget(w http.ResponseWriter, apiKey string) bool {
+	if h.costTracker == nil {
+		return true
+	}
+	if ok, msg := h.costTracker.CheckTokenBudget(apiKey); !ok {
+		models.WriteError(w, http.StatusTooManyRequests, msg)
+		return false
+	}
+	return true
+}
+
+// recordLLMUsage sums the token usage of the runs in trace per model, logs
+// and charges each model's usage through the cost tracker, and returns the
+// total. It returns nil when the LLM was not called.
+func (h *AgentHandler) recordLLMUsage(trace *models.AgentTrace, apiKey string, user *models.User) *llmUsage {
+	if trace == nil || len(trace.Runs) == 0 {
+		return nil
+	}
+	perModel := make(map[string]*models.TokenUsage)
+	latency := make(map[string]int64)
+	for _, run := range trace.Runs {
+		u, ok := perModel[run.Model]
+		if !ok {
+			u = &models.TokenUsage{}
+			perModel[run.Model] = u
+		}
+		u.Add(run.Usage)
+		latency[run.Model] += run.LatencyMs
+	}
+
+	persona := ""
+	if user != nil {
+		persona = user.Persona
+	}
+	out := &llmUsage{Runs: len(trace.Runs)}
+	var cost float64
+	allPriced := h.costTracker != nil
+	for model, u := range perModel {
+		out.TokenUsage.Add(*u)
+		out.Models = append(out.Models, model)
+		if h.costTracker != nil {
+			usd, priced := h.costTracker.LogLLMCost(model, persona, *u, apiKey, latency[model])
+			cost += usd
+			allPriced = allPriced && priced
+		}
+	}
+	sort.Strings(out.Models)
+	if allPriced {
+		out.CostUSD = &cost
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+func TestRecordLLMUsage_ChargesTokensAndReportsCost(t *testing.T) {
+	budget := security.NewBudgetTracker(cache.NewMemoryStore().Namespace("budget"))
+	budget.SetSquadQuota("payment", security.BudgetQuota{}, security.BudgetQuota{DailyTokens: 1_000})
+	bob := security.BudgetSubject{UserID: "u2", SquadID: "payment"}
+	ct := security.NewCostTracker(0).
+		WithBudget(budget, func(string) (security.BudgetSubject, bool) { return bob, true }).
+		WithLLMPrices(security.LLMPriceTable{"m1": {Input: 1, Output: 2}})
+	h := &AgentHandler{}
+	h.SetCostTracker(ct)
+
+	trace := &models.AgentTrace{Runs: []models.AgentRun{
+		{Model: "m1", Usage: models.TokenUsage{InputTokens: 600, OutputTokens: 100}},
+		{Model: "m1", Usage: models.TokenUsage{InputTokens: 400, OutputTokens: 100}},
+	}}
+	usage := h.recordLLMUsage(trace, "key", &models.User{ID: "u2", Persona: "analyst"})
+	if usage == nil || usage.InputTokens != 1_000 || usage.OutputTokens != 200 || usage.Runs != 2 {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if usage.CostUSD == nil || *usage.CostUSD != 0.0014 {
+		t.Errorf("cost = %v, want 0.0014", usage.CostUSD)
+	}
+
+	rec := httptest.NewRecorder()
+	if h.checkTokenBudget(rec, "key") || rec.Code != http.StatusTooManyRequests {
+		t.Errorf("exhausted token quota: status = %d, want 429", rec.Code)
+	}
+
+	trace.Runs = append(trace.Runs, models.AgentRun{Model: "unpriced", Usage: models.TokenUsage{InputTokens: 1}})
+	if usage := h.recordLLMUsage(trace, "key", nil); usage.CostUSD != nil {
+		t.Errorf("cost = %v, want none when a model has no price", *usage.CostUSD)
+	}
+	if h.recordLLMUsage(&models.AgentTrace{}, "key", nil) != nil {
+		t.Error("requests that never called the LLM must not report usage")
+	}
+}
@@ -38,6 +38,13 @@ func NewQueryHandler(
 	}
 }
 
+// sqlValidationError is the 400 body for rejected SQL: the usual error
+// envelope plus every violation found.
+type sqlValidationError struct {
+	models.ErrorResponse
+	Violations []security.SQLViolation `json:"violations"`
+}
+
 // Execute handles POST /api/v1/query
 func (h *QueryHandler) Execute(w http.ResponseWriter, r *http.Request) {
 	var req models.QueryRequest
@@ -48,8 +55,19 @@ func (h *QueryHandler) Execute(w http.ResponseWriter, r *http.Request) {
 	req.SetDefaults()
 
 	// SQL validation
-	if errMsg := h.sqlVal.Validate(req.SQL); errMsg != "" {
-		models.WriteError(w, http.StatusBadRequest, "SQL validation failed: "+errMsg)
+	dialect := security.DialectBigQuery
+	if req.UseLegacySQL {
+		dialect = security.DialectBigQueryLegacy
+	}
+	if analysis := h.sqlVal.Analyze(req.SQL, dialect); !analysis.Valid() {
+		models.WriteJSON(w, http.StatusBadRequest, sqlValidationError{
+			ErrorResponse: models.ErrorResponse{
+				Status:  "error",
+				Message: "SQL validation failed: " + analysis.Message(),
+				Code:    http.StatusBadRequest,
+			},
+			Violations: analysis.Violations,
+		})
 		return
 	}
 
@@ -61,7 +79,9 @@ func (h *QueryHandler) Execute(w http.ResponseWriter, r *http.Request) {
 		projectID = *req.ProjectID
 	}
 
-	result, err := h.bq.ExecuteQuery(r.Context(), req.SQL, projectID, req.DryRun, req.TimeoutMs, req.UseQueryCache, req.UseLegacySQL)
+	// Estimate first: cost and budget limits are enforced on the dry-run
+	// bytes so rejected queries are never billed.
+	estimate, err := h.bq.ExecuteQuery(r.Context(), req.SQL, projectID, true, req.TimeoutMs, req.UseQueryCache, req.UseLegacySQL)
 	if err != nil {
 		execMs := time.Since(start).Milliseconds()
 		h.auditLogger.LogQuery(req.SQL, apiKey, "", execMs, 0, 0, false, err.Error())
@@ -69,16 +89,32 @@ func (h *QueryHandler) Execute(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	execMs := time.Since(start).Milliseconds()
-
 	// Cost check
-	if ok, errMsg := h.costTracker.CheckLimits(result.TotalBytesProcessed, apiKey); !ok {
-		h.auditLogger.LogQuery(req.SQL, apiKey, "", execMs, 0, result.TotalBytesProcessed, false, errMsg)
+	reservation, ok, errMsg := h.costTracker.CheckLimits(estimate.TotalBytesProcessed, apiKey)
+	if !ok {
+		execMs := time.Since(start).Milliseconds()
+		h.auditLogger.LogQuery(req.SQL, apiKey, "", execMs, 0, estimate.TotalBytesProcessed, false, errMsg)
 		models.WriteError(w, http.StatusTooManyRequests, errMsg)
 		return
 	}
+	defer reservation.Release() // dry runs and failed queries are not billed
+
+	result := estimate
+	if !req.DryRun {
+		result, err = h.bq.ExecuteQuery(r.Context(), req.SQL, projectID, false, req.TimeoutMs, req.UseQueryCache, req.UseLegacySQL)
+		if err != nil {
+			execMs := time.Since(start).Milliseconds()
+			h.auditLogger.LogQuery(req.SQL, apiKey, "", execMs, 0, 0, false, err.Error())
+			models.WriteError(w, http.StatusInternalServerError, "query execution failed: "+err.Error())
+			return
+		}
+	}
 
-	h.costTracker.LogQueryCost(req.SQL, result.TotalBytesProcessed, apiKey, execMs)
+	execMs := time.Since(start).Milliseconds()
+	if !req.DryRun {
+		reservation.Settle(result.TotalBytesProcessed)
+		h.costTracker.LogQueryCost(req.SQL, result.TotalBytesProcessed, apiKey, execMs)
+	}
 
 	// Data masking
 	data := result.Data
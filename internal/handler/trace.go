@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// SetTraceStore enables persisting agent traces; nil disables it. Traces are
+// still returned to requests that set include_trace.
+func (h *AgentHandler) SetTraceStore(traces *agent.TraceStore) {
+	h.traces = traces
+}
+
+// startTrace returns a context collecting the agent runs of req, and the
+// trace they are collected into. Every request is traced: the trace also
+// feeds LLM cost accounting (see recordLLMUsage).
+func (h *AgentHandler) startTrace(ctx context.Context, req *models.AgentRequest, source service.DataSource, user *models.User) (context.Context, *models.AgentTrace) {
+	userID, squadID := conversationOwner(user)
+	trace := &models.AgentTrace{
+		ID:         uuid.New().String(),
+		CreatedAt:  time.Now().UTC(),
+		UserID:     userID,
+		SquadID:    squadID,
+		Prompt:     req.Prompt,
+		DataSource: string(source),
+		Runs:       []models.AgentRun{},
+	}
+	return agent.WithTrace(ctx, trace), trace
+}
+
+// finishTrace records the outcome of the request on trace and persists it
+// when the LLM was called at all (cache hits and requests rejected before
+// the agent ran have nothing to debug). It returns the trace ID, or "" when
+// the trace was not stored.
+func (h *AgentHandler) finishTrace(trace *models.AgentTrace, resp *models.AgentResponse, err error) string {
+	if trace == nil {
+		return ""
+	}
+	trace.Status = "error"
+	if resp != nil {
+		trace.Status = resp.Status
+	}
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	if h.traces == nil || len(trace.Runs) == 0 {
+		return ""
+	}
+	if saveErr := h.traces.Save(trace); saveErr != nil {
+		log.Warn().Err(saveErr).Str("trace_id", trace.ID).Msg("failed to store agent trace")
+		return ""
+	}
+	if err != nil {
+		log.Info().Str("trace_id", trace.ID).Str("error", trace.Error).Msg("agent request failed; trace stored")
+	}
+	return trace.ID
+}
+
+// attachTrace returns resp with the trace ID in its metadata and, when the
+// request set include_trace, the trace itself. A shallow copy is returned
+// when the trace is attached because resp may be shared with the response
+// cache.
+func attachTrace(req *models.AgentRequest, resp *models.AgentResponse, trace *models.AgentTrace, traceID string) *models.AgentResponse {
+	if resp == nil || trace == nil {
+		return resp
+	}
+	if traceID != "" && resp.AgentMetadata != nil {
+		resp.AgentMetadata["trace_id"] = traceID
+	}
+	if !req.IncludeTrace {
+		return resp
+	}
+	out := *resp
+	out.Trace = trace
+	return &out
+}
+
+// GetTrace handles GET /api/v1/traces/{trace_id}. Users can read the traces
+// of their own requests; admins can read any trace.
+func (h *AgentHandler) GetTrace(w http.ResponseWriter, r *http.Request) {
+	if h.traces == nil {
+		models.WriteError(w, http.StatusServiceUnavailable, "agent traces are not enabled")
+		return
+	}
+	user, _ := middleware.GetCurrentUser(r.Context())
+	userID, squadID := conversationOwner(user)
+	admin := user != nil && user.Role == models.RoleAdmin
+
+	trace, err := h.traces.Get(chi.URLParam(r, "trace_id"), userID, squadID, admin)
+	if err != nil {
+		if errors.Is(err, agent.ErrTraceNotFound) {
+			models.WriteError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		models.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	models.WriteJSON(w, http.StatusOK, trace)
+}
+
+// traceErrorData returns a copy of the payload of a streamed "error" event
+// carrying the trace ID and, when requested, the trace.
+func traceErrorData(data map[string]interface{}, trace *models.AgentTrace, traceID string, include bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(data)+2)
+	for k, v := range data {
+		out[k] = v
+	}
+	if traceID != "" {
+		out["trace_id"] = traceID
+	}
+	if include {
+		out["trace"] = trace
+	}
+	return out
+}
+
+// recordRunner reports in resp's metadata which LLM answered: "model" is
+// replaced by the model of the last agent run, which differs from the
+// persona's when a failover chain moved to a fallback; "llm_runner" names
+// the chain member and "llm_failovers" lists the runners that failed first.
+func recordRunner(resp *models.AgentResponse, trace *models.AgentTrace) {
+	if resp == nil || resp.AgentMetadata == nil || trace == nil || len(trace.Runs) == 0 {
+		return
+	}
+	last := trace.Runs[len(trace.Runs)-1]
+	if last.Model != "" {
+		resp.AgentMetadata["model"] = last.Model
+	}
+	if last.Runner != "" {
+		resp.AgentMetadata["llm_runner"] = last.Runner
+	}
+	var failovers []models.RunnerFailure
+	for _, run := range trace.Runs {
+		failovers = append(failovers, run.Failovers...)
+	}
+	if len(failovers) > 0 {
+		resp.AgentMetadata["llm_failovers"] = failovers
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestFinishTrace_StoresOnlyWhenAgentRan(t *testing.T) {
+	h := &AgentHandler{}
+	h.SetTraceStore(agent.NewTraceStore(cache.NewMemoryStore().Namespace("agent_trace"), 0))
+	req := &models.AgentRequest{Prompt: "q"}
+	user := &models.User{ID: "u1", SquadID: "squad-a"}
+
+	_, trace := h.startTrace(context.Background(), req, "bigquery", user)
+	if id := h.finishTrace(trace, &models.AgentResponse{Status: "success"}, nil); id != "" {
+		t.Errorf("trace without runs stored as %q", id)
+	}
+
+	_, trace = h.startTrace(context.Background(), req, "bigquery", user)
+	trace.Runs = append(trace.Runs, models.AgentRun{Prompt: "q"})
+	id := h.finishTrace(trace, nil, errors.New("agent run: boom"))
+	if id != trace.ID {
+		t.Fatalf("finishTrace = %q, want %q", id, trace.ID)
+	}
+	got, err := h.traces.Get(id, "u1", "squad-a", false)
+	if err != nil || got.Status != "error" || got.Error != "agent run: boom" {
+		t.Errorf("stored trace = %+v, %v", got, err)
+	}
+}
+
+func TestAttachTrace_CopiesResponseWhenIncluded(t *testing.T) {
+	resp := &models.AgentResponse{Status: "success", AgentMetadata: map[string]interface{}{}}
+	trace := &models.AgentTrace{ID: "t1"}
+
+	out := attachTrace(&models.AgentRequest{IncludeTrace: true}, resp, trace, "t1")
+	if out.Trace != trace || resp.Trace != nil {
+		t.Errorf("trace must be attached to a copy: out=%v resp=%v", out.Trace, resp.Trace)
+	}
+	if resp.AgentMetadata["trace_id"] != "t1" {
+		t.Errorf("trace_id = %v", resp.AgentMetadata["trace_id"])
+	}
+	if out := attachTrace(&models.AgentRequest{}, resp, trace, "t1"); out.Trace != nil {
+		t.Error("trace attached without include_trace")
+	}
+}
+
+func TestGetTrace_ForeignTraceNotFound(t *testing.T) {
+	h := &AgentHandler{}
+	h.SetTraceStore(agent.NewTraceStore(cache.NewMemoryStore().Namespace("agent_trace"), 0))
+	_ = h.traces.Save(&models.AgentTrace{ID: "t1", UserID: "u1", SquadID: "squad-a"})
+
+	r := chi.NewRouter()
+	r.Get("/traces/{trace_id}", h.GetTrace)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/traces/t1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for another user's trace", rec.Code)
+	}
+}
+
+func TestRecordRunner_ReportsAnsweringRunner(t *testing.T) {
+	resp := &models.AgentResponse{AgentMetadata: map[string]interface{}{"model": "glm-4.5-air"}}
+	trace := &models.AgentTrace{Runs: []models.AgentRun{{
+		Model:     "deepseek-chat",
+		Runner:    "deepseek:deepseek-chat",
+		Failovers: []models.RunnerFailure{{Runner: "anthropic:glm-4.5-air", Error: "API error 503"}},
+	}}}
+	recordRunner(resp, trace)
+
+	if resp.AgentMetadata["model"] != "deepseek-chat" || resp.AgentMetadata["llm_runner"] != "deepseek:deepseek-chat" {
+		t.Errorf("metadata = %v", resp.AgentMetadata)
+	}
+	if f, _ := resp.AgentMetadata["llm_failovers"].([]models.RunnerFailure); len(f) != 1 {
+		t.Errorf("llm_failovers = %v", resp.AgentMetadata["llm_failovers"])
+	}
+}
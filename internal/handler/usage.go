@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/cortexai/cortexai/internal/middleware"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+// UsageHandler reports BigQuery budget consumption.
+type UsageHandler struct {
+	budget *security.BudgetTracker
+}
+
+func NewUsageHandler(budget *security.BudgetTracker) *UsageHandler {
+	return &UsageHandler{budget: budget}
+}
+
+type usageResponse struct {
+	UserID  string `json:"user_id"`
+	SquadID string `json:"squad_id,omitempty"`
+	security.BudgetReport
+}
+
+// Usage handles GET /api/v1/usage.
+// Returns the caller's and their squad's usage and remaining budget for the
+// current UTC day and month.
+func (h *UsageHandler) Usage(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetCurrentUser(r.Context())
+	if !ok {
+		models.WriteError(w, http.StatusUnauthorized, "not authenticated")
+		return
+	}
+	report := h.budget.Report(security.BudgetSubject{UserID: user.ID, SquadID: user.SquadID})
+	models.WriteJSON(w, http.StatusOK, usageResponse{
+		UserID:       user.ID,
+		SquadID:      user.SquadID,
+		BudgetReport: report,
+	})
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ConversationTurn is one completed prompt/answer exchange in a multi-turn
+// agent conversation. Only summarized results are kept — never full row sets —
+// so a conversation stays small enough to replay as LLM context.
+type ConversationTurn struct {
+	Prompt        string    `json:"prompt"`
+	DataSource    string    `json:"data_source,omitempty"`
+	DatasetID     string    `json:"dataset_id,omitempty"`
+	GeneratedSQL  string    `json:"generated_sql,omitempty"`
+	Answer        string    `json:"answer,omitempty"`
+	ResultSummary string    `json:"result_summary,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
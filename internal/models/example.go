@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Sources of a QueryExample.
+const (
+	ExampleSourceAdmin    = "admin"    // saved by an admin, verified on creation
+	ExampleSourceFeedback = "feedback" // submitted by an analyst for a good answer, verified by an admin
+)
+
+// QueryExample is a prompt and the SQL that answers it on one BigQuery
+// dataset or PostgreSQL database. Verified examples are shown to the agent as
+// few-shot examples for similar prompts.
+type QueryExample struct {
+	ID         string    `json:"id"`
+	SquadID    string    `json:"squad_id,omitempty"` // empty for examples shared by every squad
+	DataSource string    `json:"data_source"`        // "bigquery" | "postgres"
+	Dataset    string    `json:"dataset"`            // BigQuery dataset or PostgreSQL database
+	Prompt     string    `json:"prompt"`
+	SQL        string    `json:"sql"`
+	Notes      string    `json:"notes,omitempty"` // why the SQL is written this way, shown to the agent
+	Verified   bool      `json:"verified"`
+	Source     string    `json:"source"` // ExampleSourceAdmin | ExampleSourceFeedback
+	CreatedBy  string    `json:"created_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -27,12 +27,18 @@ func (r *QueryRequest) SetDefaults() {
 
 // AgentRequest for POST /api/v1/query-agent
 type AgentRequest struct {
-	Prompt     string  `json:"prompt"`
-	ProjectID  *string `json:"project_id,omitempty"`
-	DatasetID  *string `json:"dataset_id,omitempty"`
-	DataSource *string `json:"data_source,omitempty"` // "bigquery" | "elasticsearch"
-	DryRun     bool    `json:"dry_run"`
-	Timeout    int     `json:"timeout"`
+	Prompt         string  `json:"prompt"`
+	ProjectID      *string `json:"project_id,omitempty"`
+	DatasetID      *string `json:"dataset_id,omitempty"`
+	DataSource     *string `json:"data_source,omitempty"` // "bigquery" | "postgres" | "elasticsearch" | "federated"
+	DryRun         bool    `json:"dry_run"`
+	Timeout        int     `json:"timeout"`
+	ConversationID string  `json:"conversation_id,omitempty"` // empty = start a new conversation
+	IncludeTrace   bool    `json:"include_trace,omitempty"`   // return the LLM transcript in AgentResponse.Trace
+
+	// History holds the prior turns of ConversationID, loaded server-side by the
+	// HTTP handler. It is never read from the request body.
+	History []ConversationTurn `json:"-"`
 }
 
 func (r *AgentRequest) SetDefaults() {
@@ -52,4 +52,6 @@ type AgentResponse struct {
 	ExecutionResult *QueryResponse         `json:"execution_result,omitempty"`
 	AgentMetadata   map[string]interface{} `json:"agent_metadata"`
 	Answer          *string                `json:"answer,omitempty"`
+	ConversationID  string                 `json:"conversation_id,omitempty"`
+	Trace           *AgentTrace            `json:"trace,omitempty"` // only with AgentRequest.IncludeTrace
 }
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// TokenUsage counts the LLM tokens consumed by a call or a run. InputTokens
+// excludes prompt tokens read from or written to the provider's prompt cache,
+// which are counted separately because they are priced differently.
+type TokenUsage struct {
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+	CacheReadTokens  int64 `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int64 `json:"cache_write_tokens,omitempty"`
+}
+
+// Add adds u2 to u.
+func (u *TokenUsage) Add(u2 TokenUsage) {
+	u.InputTokens += u2.InputTokens
+	u.OutputTokens += u2.OutputTokens
+	u.CacheReadTokens += u2.CacheReadTokens
+	u.CacheWriteTokens += u2.CacheWriteTokens
+}
+
+// Total is the number of tokens counted against token quotas.
+func (u TokenUsage) Total() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheReadTokens + u.CacheWriteTokens
+}
+
+// AgentTrace is the transcript of everything the LLM did to produce one agent
+// response: every run of the agent loop (a SQL repair round starts another)
+// with each iteration's text, tool calls and tool outputs. It is returned in
+// AgentResponse.Trace when the request sets include_trace, and stored for
+// debugging under ID.
+type AgentTrace struct {
+	ID         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     string     `json:"user_id,omitempty"`
+	SquadID    string     `json:"squad_id,omitempty"`
+	Prompt     string     `json:"prompt"`
+	DataSource string     `json:"data_source,omitempty"`
+	Status     string     `json:"status"` // response status, "error" when no response was produced
+	Error      string     `json:"error,omitempty"`
+	Runs       []AgentRun `json:"runs"`
+	Usage      TokenUsage `json:"usage"`
+}
+
+// AgentRun is one run of the agent loop.
+type AgentRun struct {
+	Prompt     string           `json:"prompt"` // the user message of this run
+	Model      string           `json:"model,omitempty"`
+	Runner     string           `json:"runner,omitempty"`    // pool key of the runner that answered, for failover chains
+	Failovers  []RunnerFailure  `json:"failovers,omitempty"` // runners of the chain that failed first
+	Iterations []AgentIteration `json:"iterations"`
+	StopReason string           `json:"stop_reason,omitempty"` // of the last LLM call
+	Usage      TokenUsage       `json:"usage"`
+	LatencyMs  int64            `json:"latency_ms"`
+	Error      string           `json:"error,omitempty"`
+}
+
+// RunnerFailure is a runner of a failover chain that could not answer.
+type RunnerFailure struct {
+	Runner string `json:"runner"`
+	Error  string `json:"error"`
+}
+
+// AgentIteration is one LLM call of a run and the tools it called.
+type AgentIteration struct {
+	Iteration  int             `json:"iteration"`
+	Text       string          `json:"text,omitempty"`
+	ToolCalls  []AgentToolCall `json:"tool_calls,omitempty"`
+	StopReason string          `json:"stop_reason"`
+	Usage      TokenUsage      `json:"usage"`
+	LatencyMs  int64           `json:"latency_ms"` // LLM call only, excluding tool execution
+}
+
+// AgentToolCall is one tool invocation. Output is what the model saw,
+// truncated for the trace.
+type AgentToolCall struct {
+	ID              string                 `json:"id,omitempty"`
+	Name            string                 `json:"name"`
+	Input           map[string]interface{} `json:"input"`
+	Output          string                 `json:"output"`
+	OutputTruncated bool                   `json:"output_truncated,omitempty"`
+	IsError         bool                   `json:"is_error,omitempty"`
+	LatencyMs       int64                  `json:"latency_ms"`
+}
@@ -17,6 +17,17 @@ type Squad struct {
 	Datasets        []string // allowed BigQuery dataset IDs
 	ESIndexPatterns []string // allowed Elasticsearch index patterns
 	PGDatabases     []string // allowed PostgreSQL database names
+	AllowedTables   []string // table patterns generated SQL may read; empty = all
+	DeniedTables    []string // table patterns generated SQL may never read
+	DeniedColumns   []string // "table.column" or "column" patterns generated SQL may never read
+	RowFilters      []RowFilter // row-level security applied to every member's queries
+}
+
+// RowFilter limits the rows of tables matching Table to those satisfying
+// the SQL boolean expression Predicate.
+type RowFilter struct {
+	Table     string
+	Predicate string
 }
 
 // AllowsDataset returns true if the given dataset ID is accessible to this squad.
@@ -56,6 +67,7 @@ type User struct {
 	SquadID string `json:"squad_id,omitempty"`
 	Squad   *Squad `json:"-"`                  // resolved at startup, not serialised
 	Persona string `json:"persona,omitempty"`  // references persona config; empty = "default"
+	RowFilters []RowFilter `json:"-"`         // row-level security for this user, in addition to the squad's
 }
 
 // UserResponse is returned by GET /api/v1/me.
@@ -0,0 +1,404 @@
+package security
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/rs/zerolog/log"
+)
+
+// BudgetQuota caps cumulative BigQuery usage and, with DailyTokens, LLM
+// tokens. Zero fields are unlimited.
+type BudgetQuota struct {
+	DailyBytes   int64   `json:"daily_bytes,omitempty"`
+	MonthlyBytes int64   `json:"monthly_bytes,omitempty"`
+	DailyUSD     float64 `json:"daily_usd,omitempty"`
+	MonthlyUSD   float64 `json:"monthly_usd,omitempty"`
+	DailyTokens  int64   `json:"daily_tokens,omitempty"`
+}
+
+func (q BudgetQuota) isZero() bool { return q == BudgetQuota{} }
+
+// BudgetSubject identifies who a query is billed to.
+type BudgetSubject struct {
+	UserID  string
+	SquadID string
+}
+
+// PeriodUsage is the usage and remaining budget for one period. Limits and
+// remaining values are omitted when the period is unlimited. USD fields are
+// BigQuery cost; LLMUSDUsed is the LLM cost of the tokens in TokensUsed.
+type PeriodUsage struct {
+	Period          string   `json:"period"` // "2026-10-16" or "2026-10" (UTC)
+	BytesUsed       int64    `json:"bytes_used"`
+	USDUsed         float64  `json:"usd_used"`
+	BytesLimit      *int64   `json:"bytes_limit,omitempty"`
+	USDLimit        *float64 `json:"usd_limit,omitempty"`
+	BytesRemaining  *int64   `json:"bytes_remaining,omitempty"`
+	USDRemaining    *float64 `json:"usd_remaining,omitempty"`
+	TokensUsed      int64    `json:"tokens_used"`
+	LLMUSDUsed      float64  `json:"llm_usd_used"`
+	TokensLimit     *int64   `json:"tokens_limit,omitempty"`
+	TokensRemaining *int64   `json:"tokens_remaining,omitempty"`
+}
+
+// BudgetUsage reports daily and monthly usage for one user or squad.
+type BudgetUsage struct {
+	ID      string      `json:"id"`
+	Daily   PeriodUsage `json:"daily"`
+	Monthly PeriodUsage `json:"monthly"`
+}
+
+// BudgetReport is the usage view for one subject, returned by GET /api/v1/usage.
+type BudgetReport struct {
+	User  *BudgetUsage `json:"user,omitempty"`
+	Squad *BudgetUsage `json:"squad,omitempty"`
+}
+
+type usageCounter struct {
+	bytes  int64
+	tokens int64
+	llmUSD float64
+}
+
+// Usage counters, stored per subject and period under
+// "<kind>:<id>:<period>:<metric>".
+const (
+	metricBytes      = "bytes"
+	metricTokens     = "tokens"
+	metricLLMNanoUSD = "llm_nano_usd" // LLM cost in billionths of a dollar
+)
+
+const (
+	budgetOpTimeout = 500 * time.Millisecond
+	nanoPerUSD      = 1e9
+	// budgetGrace keeps a period's counters a little past its end so a
+	// replica with a slow clock still finds them.
+	budgetGrace = time.Hour
+)
+
+// BudgetTracker accumulates BigQuery bytes per user and squad for the
+// current UTC day and month, and rejects queries whose estimated bytes would
+// push any applicable quota over its limit. It also counts LLM tokens and
+// their cost, rejecting agent requests once a daily token quota is used up.
+// Quotas are configured per squad: one for the squad as a whole and one
+// applied to each member individually. Estimated USD is derived from bytes.
+//
+// Usage is kept in counters of a cache namespace, so with the redis backend
+// every replica enforces the same quota and counters survive restarts. Each
+// counter expires shortly after its period ends. When the counters cannot be
+// reached, queries are admitted and the error is logged.
+type BudgetTracker struct {
+	mu          sync.RWMutex
+	squadQuotas map[string]BudgetQuota
+	userQuotas  map[string]BudgetQuota // per-member quota keyed by squad ID
+	counters    cache.Cache
+	now         func() time.Time
+}
+
+// NewBudgetTracker keeps usage counters in counters.
+func NewBudgetTracker(counters cache.Cache) *BudgetTracker {
+	return &BudgetTracker{
+		squadQuotas: make(map[string]BudgetQuota),
+		userQuotas:  make(map[string]BudgetQuota),
+		counters:    counters,
+		now:         time.Now,
+	}
+}
+
+// SetSquadQuota configures the quota for a squad as a whole and the quota
+// applied to each of its members. Call during setup, before serving requests.
+func (b *BudgetTracker) SetSquadQuota(squadID string, squad, perUser BudgetQuota) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !squad.isZero() {
+		b.squadQuotas[squadID] = squad
+	}
+	if !perUser.isZero() {
+		b.userQuotas[squadID] = perUser
+	}
+}
+
+// bytesToUSD converts processed bytes to estimated on-demand cost.
+func bytesToUSD(bytes int64) float64 {
+	return float64(bytes) / bytesPerGB / 1000.0 * bigQueryCostPerTB
+}
+
+// budgetPeriod is one counting window: a UTC day or month.
+type budgetPeriod struct {
+	name string    // "daily" or "monthly"
+	id   string    // "2026-10-16" or "2026-10"
+	end  time.Time // start of the next period
+}
+
+func periods(t time.Time) (day, month budgetPeriod) {
+	t = t.UTC()
+	dayStart := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return budgetPeriod{"daily", t.Format("2006-01-02"), dayStart.AddDate(0, 0, 1)},
+		budgetPeriod{"monthly", t.Format("2006-01"), monthStart.AddDate(0, 1, 0)}
+}
+
+// budgetScope is one quota that applies to a subject.
+type budgetScope struct {
+	kind, id, label string
+	quota           BudgetQuota
+}
+
+func (sc budgetScope) key(p budgetPeriod, metric string) string {
+	return sc.kind + ":" + sc.id + ":" + p.id + ":" + metric
+}
+
+// scopes returns the quotas that apply to s: the per-member quota of its
+// squad, then the squad's own.
+func (b *BudgetTracker) scopes(s BudgetSubject) []budgetScope {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var scopes []budgetScope
+	if s.SquadID != "" {
+		if q, ok := b.userQuotas[s.SquadID]; ok && s.UserID != "" {
+			scopes = append(scopes, budgetScope{"user", s.UserID, "user " + s.UserID, q})
+		}
+		if q, ok := b.squadQuotas[s.SquadID]; ok {
+			scopes = append(scopes, budgetScope{"squad", s.SquadID, "squad " + s.SquadID, q})
+		}
+	}
+	return scopes
+}
+
+// counted returns every scope s is counted under, with its quota if any:
+// the user, then the squad. Usage is counted without a quota so it can be
+// reported.
+func (b *BudgetTracker) counted(s BudgetSubject) []budgetScope {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var scopes []budgetScope
+	if s.UserID != "" {
+		scopes = append(scopes, budgetScope{"user", s.UserID, "user " + s.UserID, b.userQuotas[s.SquadID]})
+	}
+	if s.SquadID != "" {
+		scopes = append(scopes, budgetScope{"squad", s.SquadID, "squad " + s.SquadID, b.squadQuotas[s.SquadID]})
+	}
+	return scopes
+}
+
+// BudgetReservation is the estimated bytes of one query, charged to every
+// counter of its subject until Settle replaces them with the bytes actually
+// billed or Release returns them. A nil reservation charges nothing.
+type BudgetReservation struct {
+	tracker *BudgetTracker
+	keys    map[string]time.Time // counter → expiry
+	bytes   int64
+
+	mu      sync.Mutex
+	settled bool
+}
+
+// Reserve checks that a query estimated at estimatedBytes fits within every
+// quota that applies to s and charges the estimate in the same step: each
+// counter is incremented first and the new totals are checked, so
+// concurrent queries, on any replica, see each other's reservations. When a
+// quota would be exceeded the reservation is returned and the message names
+// the first exhausted quota.
+func (b *BudgetTracker) Reserve(s BudgetSubject, estimatedBytes int64) (*BudgetReservation, bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), budgetOpTimeout)
+	defer cancel()
+	now := b.now()
+	day, month := periods(now)
+	r := &BudgetReservation{tracker: b, keys: make(map[string]time.Time), bytes: estimatedBytes}
+
+	totals := make(map[string]int64)
+	for _, sc := range b.counted(s) {
+		for _, p := range []budgetPeriod{day, month} {
+			key := sc.key(p, metricBytes)
+			n, err := b.counters.IncrBy(ctx, key, estimatedBytes, p.end.Sub(now)+budgetGrace)
+			if err != nil {
+				log.Warn().Err(err).Str("counter", key).Msg("budget counter unavailable, query not charged")
+				r.Release()
+				return nil, true, ""
+			}
+			r.keys[key] = p.end
+			totals[key] = n
+		}
+	}
+
+	estUSD := bytesToUSD(estimatedBytes)
+	for _, sc := range b.scopes(s) {
+		for _, p := range []struct {
+			period budgetPeriod
+			bytes  int64
+			usd    float64
+		}{
+			{day, sc.quota.DailyBytes, sc.quota.DailyUSD},
+			{month, sc.quota.MonthlyBytes, sc.quota.MonthlyUSD},
+		} {
+			total := totals[sc.key(p.period, metricBytes)]
+			used := total - estimatedBytes
+			if p.bytes > 0 && total > p.bytes {
+				r.Release()
+				return nil, false, fmt.Sprintf(
+					"Budget exceeded: %s %s quota %.2fGB, used %.2fGB, query needs ~%.2fGB",
+					sc.label, p.period.name, float64(p.bytes)/bytesPerGB, float64(used)/bytesPerGB, float64(estimatedBytes)/bytesPerGB,
+				)
+			}
+			if p.usd > 0 && bytesToUSD(total) > p.usd {
+				r.Release()
+				return nil, false, fmt.Sprintf(
+					"Budget exceeded: %s %s quota $%.2f, used $%.4f, query needs ~$%.4f",
+					sc.label, p.period.name, p.usd, bytesToUSD(used), estUSD,
+				)
+			}
+		}
+	}
+	return r, true, ""
+}
+
+// Settle replaces the reserved estimate with the bytes the query was billed
+// for. Later calls, and Release after Settle, do nothing.
+func (r *BudgetReservation) Settle(billedBytes int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.settled {
+		return
+	}
+	r.settled = true
+	if delta := billedBytes - r.bytes; delta != 0 {
+		r.tracker.add(r.keys, delta)
+	}
+}
+
+// Release returns the reserved bytes, for a query that was not run or
+// failed. It does nothing once the reservation is settled.
+func (r *BudgetReservation) Release() {
+	r.Settle(0)
+}
+
+// CheckTokens reports whether s has LLM tokens left in every daily token
+// quota that applies to it. Token counts are only known after the LLM
+// answers, so a request is admitted while any quota is left and may overrun
+// it by its own usage.
+func (b *BudgetTracker) CheckTokens(s BudgetSubject) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), budgetOpTimeout)
+	defer cancel()
+	day, _ := periods(b.now())
+	for _, sc := range b.scopes(s) {
+		limit := sc.quota.DailyTokens
+		if limit <= 0 {
+			continue
+		}
+		if used := b.read(ctx, sc.key(day, metricTokens)); used >= limit {
+			return false, fmt.Sprintf("Token budget exceeded: %s daily quota %d tokens, used %d", sc.label, limit, used)
+		}
+	}
+	return true, ""
+}
+
+// RecordTokens adds the LLM tokens of an agent request and their cost to the
+// user's and squad's daily and monthly counters.
+func (b *BudgetTracker) RecordTokens(s BudgetSubject, tokens int64, usd float64) {
+	if tokens <= 0 {
+		return
+	}
+	day, month := periods(b.now())
+	tokenKeys := make(map[string]time.Time)
+	costKeys := make(map[string]time.Time)
+	for _, sc := range b.counted(s) {
+		for _, p := range []budgetPeriod{day, month} {
+			tokenKeys[sc.key(p, metricTokens)] = p.end
+			costKeys[sc.key(p, metricLLMNanoUSD)] = p.end
+		}
+	}
+	b.add(tokenKeys, tokens)
+	if nano := int64(math.Round(usd * nanoPerUSD)); nano != 0 {
+		b.add(costKeys, nano)
+	}
+}
+
+// add adds delta to each counter in keys, which map to the end of their
+// period.
+func (b *BudgetTracker) add(keys map[string]time.Time, delta int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), budgetOpTimeout)
+	defer cancel()
+	now := b.now()
+	for key, end := range keys {
+		if _, err := b.counters.IncrBy(ctx, key, delta, end.Sub(now)+budgetGrace); err != nil {
+			log.Warn().Err(err).Str("counter", key).Int64("delta", delta).Msg("budget counter update failed")
+		}
+	}
+}
+
+// read returns the value of a counter, 0 when it is missing or unreadable.
+func (b *BudgetTracker) read(ctx context.Context, key string) int64 {
+	v, ok, err := b.counters.Get(ctx, key)
+	if err != nil {
+		log.Warn().Err(err).Str("counter", key).Msg("budget counter unavailable")
+		return 0
+	}
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(string(v), 10, 64)
+	return n
+}
+
+// Report returns current usage and remaining budget for s. The squad section
+// is omitted for users without a squad.
+func (b *BudgetTracker) Report(s BudgetSubject) BudgetReport {
+	ctx, cancel := context.WithTimeout(context.Background(), budgetOpTimeout)
+	defer cancel()
+	day, month := periods(b.now())
+	var r BudgetReport
+	for _, sc := range b.counted(s) {
+		u := &BudgetUsage{
+			ID:      sc.id,
+			Daily:   periodUsage(day.id, b.usage(ctx, sc, day), sc.quota.DailyBytes, sc.quota.DailyUSD, sc.quota.DailyTokens),
+			Monthly: periodUsage(month.id, b.usage(ctx, sc, month), sc.quota.MonthlyBytes, sc.quota.MonthlyUSD, 0),
+		}
+		if sc.kind == "user" {
+			r.User = u
+		} else {
+			r.Squad = u
+		}
+	}
+	return r
+}
+
+func (b *BudgetTracker) usage(ctx context.Context, sc budgetScope, p budgetPeriod) usageCounter {
+	return usageCounter{
+		bytes:  b.read(ctx, sc.key(p, metricBytes)),
+		tokens: b.read(ctx, sc.key(p, metricTokens)),
+		llmUSD: float64(b.read(ctx, sc.key(p, metricLLMNanoUSD))) / nanoPerUSD,
+	}
+}
+
+func periodUsage(period string, used usageCounter, bytesLimit int64, usdLimit float64, tokensLimit int64) PeriodUsage {
+	usd := bytesToUSD(used.bytes)
+	pu := PeriodUsage{
+		Period:     period,
+		BytesUsed:  used.bytes,
+		USDUsed:    usd,
+		TokensUsed: used.tokens,
+		LLMUSDUsed: used.llmUSD,
+	}
+	if bytesLimit > 0 {
+		remaining := max(bytesLimit-used.bytes, 0)
+		pu.BytesLimit, pu.BytesRemaining = &bytesLimit, &remaining
+	}
+	if usdLimit > 0 {
+		remaining := max(usdLimit-usd, 0)
+		pu.USDLimit, pu.USDRemaining = &usdLimit, &remaining
+	}
+	if tokensLimit > 0 {
+		remaining := max(tokensLimit-used.tokens, 0)
+		pu.TokensLimit, pu.TokensRemaining = &tokensLimit, &remaining
+	}
+	return pu
+}
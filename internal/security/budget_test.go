@@ -0,0 +1,276 @@
+package security
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+func newTestBudget(now *time.Time) *BudgetTracker {
+	b := NewBudgetTracker(cache.NewMemoryStore().Namespace("budget"))
+	b.now = func() time.Time { return *now }
+	return b
+}
+
+// fits reports whether a query of bytes fits s's quotas, releasing the
+// reservation.
+func fits(b *BudgetTracker, s BudgetSubject, bytes int64) (bool, string) {
+	r, ok, msg := b.Reserve(s, bytes)
+	r.Release()
+	return ok, msg
+}
+
+// charge reserves and settles bytes for s, as a query that ran.
+func charge(t *testing.T, b *BudgetTracker, s BudgetSubject, bytes int64) {
+	t.Helper()
+	r, ok, msg := b.Reserve(s, 0)
+	if !ok {
+		t.Fatalf("Reserve: %s", msg)
+	}
+	r.Settle(bytes)
+}
+
+func TestBudgetTracker_UserDailyQuota(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newTestBudget(&now)
+	b.SetSquadQuota("payment", BudgetQuota{}, BudgetQuota{DailyBytes: 10_000_000_000})
+	bob := BudgetSubject{UserID: "u2", SquadID: "payment"}
+
+	if ok, msg := fits(b, bob, 6_000_000_000); !ok {
+		t.Fatalf("6GB should fit a 10GB daily quota: %s", msg)
+	}
+	charge(t, b, bob, 6_000_000_000)
+
+	ok, msg := fits(b, bob, 5_000_000_000)
+	if ok {
+		t.Fatal("6GB used + 5GB estimate should exceed a 10GB daily quota")
+	}
+	if !strings.Contains(msg, "Budget exceeded") || !strings.Contains(msg, "user u2 daily") {
+		t.Errorf("unexpected message: %q", msg)
+	}
+
+	// Another member of the squad has their own allowance.
+	if ok, _ := fits(b, BudgetSubject{UserID: "u4", SquadID: "payment"}, 5_000_000_000); !ok {
+		t.Error("per-user quota must not be shared between squad members")
+	}
+
+	// The daily counter resets on the next UTC day.
+	now = now.Add(24 * time.Hour)
+	if ok, msg := fits(b, bob, 5_000_000_000); !ok {
+		t.Errorf("quota should reset on a new day: %s", msg)
+	}
+}
+
+func TestBudgetTracker_SquadQuotaSharedByMembers(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newTestBudget(&now)
+	b.SetSquadQuota("payment", BudgetQuota{MonthlyUSD: 1.0}, BudgetQuota{})
+
+	charge(t, b, BudgetSubject{UserID: "u2", SquadID: "payment"}, 150_000_000_000)       // $0.75
+	ok, msg := fits(b, BudgetSubject{UserID: "u4", SquadID: "payment"}, 100_000_000_000) // +$0.50
+	if ok {
+		t.Fatal("squad monthly USD quota should be shared across members")
+	}
+	if !strings.Contains(msg, "squad payment monthly") {
+		t.Errorf("unexpected message: %q", msg)
+	}
+
+	// Still counted later in the month, cleared in the next one.
+	now = time.Date(2026, 10, 30, 9, 0, 0, 0, time.UTC)
+	charge(t, b, BudgetSubject{UserID: "u2", SquadID: "payment"}, 1)
+	if ok, _ := fits(b, BudgetSubject{UserID: "u4", SquadID: "payment"}, 100_000_000_000); ok {
+		t.Error("monthly usage must survive day rollover")
+	}
+	now = time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	if ok, msg := fits(b, BudgetSubject{UserID: "u4", SquadID: "payment"}, 100_000_000_000); !ok {
+		t.Errorf("quota should reset on a new month: %s", msg)
+	}
+}
+
+func TestBudgetTracker_NoQuotaIsUnlimited(t *testing.T) {
+	b := NewBudgetTracker(cache.NewMemoryStore().Namespace("budget"))
+	s := BudgetSubject{UserID: "u1"}
+	charge(t, b, s, 1<<50)
+	if ok, msg := fits(b, s, 1<<50); !ok {
+		t.Errorf("users without a squad quota must not be limited: %s", msg)
+	}
+}
+
+func TestBudgetTracker_Report(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newTestBudget(&now)
+	b.SetSquadQuota("payment", BudgetQuota{MonthlyBytes: 100_000_000_000}, BudgetQuota{DailyBytes: 10_000_000_000})
+	bob := BudgetSubject{UserID: "u2", SquadID: "payment"}
+	charge(t, b, bob, 4_000_000_000)
+
+	r := b.Report(bob)
+	if r.User == nil || r.Squad == nil {
+		t.Fatalf("expected user and squad sections, got %+v", r)
+	}
+	if r.User.Daily.Period != "2026-10-16" || r.User.Monthly.Period != "2026-10" {
+		t.Errorf("periods = %q / %q", r.User.Daily.Period, r.User.Monthly.Period)
+	}
+	if r.User.Daily.BytesUsed != 4_000_000_000 {
+		t.Errorf("user daily bytes = %d", r.User.Daily.BytesUsed)
+	}
+	if r.User.Daily.BytesRemaining == nil || *r.User.Daily.BytesRemaining != 6_000_000_000 {
+		t.Errorf("user daily remaining = %v, want 6GB", r.User.Daily.BytesRemaining)
+	}
+	if r.User.Monthly.BytesLimit != nil {
+		t.Error("unlimited period must omit its limit")
+	}
+	if r.Squad.Monthly.BytesRemaining == nil || *r.Squad.Monthly.BytesRemaining != 96_000_000_000 {
+		t.Errorf("squad monthly remaining = %v, want 96GB", r.Squad.Monthly.BytesRemaining)
+	}
+	if r.User.Daily.USDUsed <= 0 {
+		t.Error("USD usage should be estimated from bytes")
+	}
+
+	if r := b.Report(BudgetSubject{UserID: "u1"}); r.Squad != nil {
+		t.Error("users without a squad should have no squad section")
+	}
+}
+
+func TestCostTracker_WithBudget(t *testing.T) {
+	b := NewBudgetTracker(cache.NewMemoryStore().Namespace("budget"))
+	b.SetSquadQuota("payment", BudgetQuota{}, BudgetQuota{DailyBytes: 10_000_000_000})
+	ct := NewCostTracker(50_000_000_000).WithBudget(b, func(apiKey string) (BudgetSubject, bool) {
+		if apiKey == "key-bob" {
+			return BudgetSubject{UserID: "u2", SquadID: "payment"}, true
+		}
+		return BudgetSubject{}, false
+	})
+
+	r, ok, msg := ct.CheckLimits(6_000_000_000, "key-bob")
+	if !ok || r == nil {
+		t.Fatalf("6GB should fit a 10GB daily quota: %s", msg)
+	}
+	// Reserved until settled: a concurrent query cannot use the same bytes.
+	if _, ok, msg := ct.CheckLimits(5_000_000_000, "key-bob"); ok || !strings.Contains(msg, "Budget exceeded") {
+		t.Errorf("expected budget rejection while 6GB is reserved, got ok=%v msg=%q", ok, msg)
+	}
+	r.Settle(8_000_000_000) // billed more than estimated
+	r.Release()             // no-op once settled
+	if _, ok, msg := ct.CheckLimits(3_000_000_000, "key-bob"); ok || !strings.Contains(msg, "used 8.00GB") {
+		t.Errorf("expected the billed bytes to count, got ok=%v msg=%q", ok, msg)
+	}
+	if r, ok, _ := ct.CheckLimits(3_000_000_000, "unknown-key"); !ok || r != nil {
+		t.Error("unresolved keys are only subject to the per-query limit")
+	}
+	if _, ok, msg := ct.CheckLimits(60_000_000_000, "unknown-key"); ok || !strings.Contains(msg, "Query cost limit exceeded") {
+		t.Errorf("per-query limit must still apply, got ok=%v msg=%q", ok, msg)
+	}
+	if ok, msg := ct.CheckQueryLimit(3_000_000_000); !ok {
+		t.Errorf("CheckQueryLimit must ignore the budget, got %q", msg)
+	}
+	if ok, _ := ct.CheckQueryLimit(60_000_000_000); ok {
+		t.Error("CheckQueryLimit must apply the per-query limit")
+	}
+}
+
+func TestBudgetTracker_ReleaseReturnsReservation(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newTestBudget(&now)
+	b.SetSquadQuota("payment", BudgetQuota{}, BudgetQuota{DailyBytes: 10_000_000_000})
+	bob := BudgetSubject{UserID: "u2", SquadID: "payment"}
+
+	r, ok, _ := b.Reserve(bob, 7_000_000_000)
+	if !ok {
+		t.Fatal("7GB should fit a 10GB daily quota")
+	}
+	if got := b.Report(bob).User.Daily.BytesUsed; got != 7_000_000_000 {
+		t.Errorf("reserved bytes used = %d, want 7GB", got)
+	}
+	r.Release() // the query failed
+	if got := b.Report(bob).User.Daily.BytesUsed; got != 0 {
+		t.Errorf("bytes used after release = %d, want 0", got)
+	}
+	if ok, msg := fits(b, bob, 7_000_000_000); !ok {
+		t.Errorf("released bytes must be available again: %s", msg)
+	}
+}
+
+func TestBudgetTracker_SharedAcrossReplicas(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	counters := cache.NewMemoryStore().Namespace("budget")
+	replicas := make([]*BudgetTracker, 2)
+	for i := range replicas {
+		replicas[i] = NewBudgetTracker(counters)
+		replicas[i].now = func() time.Time { return now }
+		replicas[i].SetSquadQuota("payment", BudgetQuota{DailyBytes: 10_000_000_000}, BudgetQuota{})
+	}
+	bob := BudgetSubject{UserID: "u2", SquadID: "payment"}
+
+	var wg sync.WaitGroup
+	var admitted atomic.Int64
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := replicas[i%2].Reserve(bob, 3_000_000_000); ok {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := admitted.Load(); got != 3 {
+		t.Errorf("admitted %d concurrent 3GB queries across replicas, want 3 within a 10GB quota", got)
+	}
+	if r := replicas[1].Report(bob); r.Squad.Daily.BytesUsed != 9_000_000_000 {
+		t.Errorf("squad daily bytes = %d, want 9GB", r.Squad.Daily.BytesUsed)
+	}
+}
+
+func TestBudgetTracker_DailyTokenQuota(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	b := newTestBudget(&now)
+	b.SetSquadQuota("payment", BudgetQuota{DailyTokens: 10_000}, BudgetQuota{DailyTokens: 4_000})
+	bob := BudgetSubject{UserID: "u2", SquadID: "payment"}
+
+	b.RecordTokens(bob, 3_999, 0.02)
+	if ok, msg := fits(b, bob, 0); !ok {
+		t.Fatalf("token usage must not count against byte quotas: %s", msg)
+	}
+	if ok, msg := b.CheckTokens(bob); !ok {
+		t.Fatalf("tokens left in the quota: %s", msg)
+	}
+	b.RecordTokens(bob, 500, 0.01) // a request may overrun the quota
+	ok, msg := b.CheckTokens(bob)
+	if ok || !strings.Contains(msg, "user u2 daily quota 4000 tokens") {
+		t.Fatalf("CheckTokens = %v, %q; want the user quota exhausted", ok, msg)
+	}
+
+	r := b.Report(bob)
+	if r.User.Daily.TokensUsed != 4_499 || *r.User.Daily.TokensRemaining != 0 || r.Squad.Daily.LLMUSDUsed != 0.03 {
+		t.Errorf("report = %+v / %+v", r.User.Daily, r.Squad.Daily)
+	}
+	if r.User.Monthly.TokensUsed != 4_499 || r.User.Monthly.TokensLimit != nil {
+		t.Errorf("monthly tokens = %+v; want usage without a limit", r.User.Monthly)
+	}
+
+	now = now.Add(24 * time.Hour)
+	if ok, msg := b.CheckTokens(bob); !ok {
+		t.Errorf("token quota should reset on a new day: %s", msg)
+	}
+}
+
+func TestLLMPriceTable_Cost(t *testing.T) {
+	prices := LLMPriceTable{
+		"claude-sonnet-4-6": {Input: 3, Output: 15, CacheRead: 0.3},
+	}
+	usage := models.TokenUsage{InputTokens: 1_000_000, OutputTokens: 100_000, CacheReadTokens: 1_000_000, CacheWriteTokens: 100_000}
+	cost, ok := prices.Cost("claude-sonnet-4-6", usage)
+	// 3 + 1.5 + 0.3 + 0.3 (cache writes fall back to the input price)
+	if !ok || math.Abs(cost-5.1) > 1e-9 {
+		t.Errorf("Cost = %v, %v; want 5.1", cost, ok)
+	}
+	if cost, ok := prices.Cost("unknown", usage); ok || cost != 0 {
+		t.Errorf("unpriced model: Cost = %v, %v", cost, ok)
+	}
+}
@@ -10,29 +10,76 @@ import (
 const bytesPerGB = 1_000_000_000.0
 const bigQueryCostPerTB = 5.0 // USD
 
-// CostTracker enforces BigQuery query byte limits
+// CostTracker enforces BigQuery query byte limits and, when a BudgetTracker
+// is attached, cumulative per-user and per-squad quotas. It also accounts
+// for the LLM tokens agent requests consume (see llm_cost.go).
 type CostTracker struct {
 	maxBytes int64
+	budget   *BudgetTracker
+	identify func(apiKey string) (BudgetSubject, bool)
+
+	llmPrices LLMPriceTable // see llm_cost.go
 }
 
 func NewCostTracker(maxBytes int64) *CostTracker {
 	return &CostTracker{maxBytes: maxBytes}
 }
 
-// CheckLimits returns an error string if bytes exceed the limit
-func (ct *CostTracker) CheckLimits(totalBytesProcessed int64, apiKey string) (bool, string) {
-	if totalBytesProcessed <= ct.maxBytes {
-		return true, ""
+// WithBudget attaches cumulative quota enforcement. identify resolves the
+// API key passed to CheckLimits to the user and squad billed; keys it cannot
+// resolve are only subject to the per-query limit.
+func (ct *CostTracker) WithBudget(budget *BudgetTracker, identify func(apiKey string) (BudgetSubject, bool)) *CostTracker {
+	ct.budget = budget
+	ct.identify = identify
+	return ct
+}
+
+// Budget returns the attached BudgetTracker, or nil.
+func (ct *CostTracker) Budget() *BudgetTracker {
+	return ct.budget
+}
+
+// CheckLimits returns an error string if bytes exceed the per-query limit or
+// would exceed the caller's remaining budget. Pass the dry-run estimate so
+// over-budget queries are rejected before they are billed. On success the
+// estimate is reserved from the budget: Settle the reservation with the
+// bytes billed once the query ran, or Release it if it did not. The
+// reservation is nil when the caller has no budget.
+func (ct *CostTracker) CheckLimits(totalBytesProcessed int64, apiKey string) (*BudgetReservation, bool, string) {
+	if ok, msg := ct.CheckQueryLimit(totalBytesProcessed); !ok {
+		return nil, false, msg
 	}
-	processedGB := float64(totalBytesProcessed) / bytesPerGB
-	limitGB := float64(ct.maxBytes) / bytesPerGB
-	return false, fmt.Sprintf(
-		"Query cost limit exceeded. Processed: %.2fGB, Limit: %.2fGB",
-		processedGB, limitGB,
-	)
+	if subject, ok := ct.subject(apiKey); ok {
+		return ct.budget.Reserve(subject, totalBytesProcessed)
+	}
+	return nil, true, ""
+}
+
+// CheckQueryLimit returns an error string if bytes exceed the per-query
+// limit. Unlike CheckLimits it ignores the caller's budget, for results that
+// were already billed and charged.
+func (ct *CostTracker) CheckQueryLimit(totalBytesProcessed int64) (bool, string) {
+	if totalBytesProcessed > ct.maxBytes {
+		processedGB := float64(totalBytesProcessed) / bytesPerGB
+		limitGB := float64(ct.maxBytes) / bytesPerGB
+		return false, fmt.Sprintf(
+			"Query cost limit exceeded. Processed: %.2fGB, Limit: %.2fGB",
+			processedGB, limitGB,
+		)
+	}
+	return true, ""
+}
+
+func (ct *CostTracker) subject(apiKey string) (BudgetSubject, bool) {
+	if ct.budget == nil || ct.identify == nil {
+		return BudgetSubject{}, false
+	}
+	return ct.identify(apiKey)
 }
 
-// LogQueryCost logs query cost info with hashed identifiers
+// LogQueryCost logs query cost info with hashed identifiers. The bytes are
+// charged to the caller's budget by settling the reservation CheckLimits
+// returned.
 func (ct *CostTracker) LogQueryCost(sql string, totalBytesProcessed int64, apiKey string, durationMs int64) {
 	processedGB := float64(totalBytesProcessed) / bytesPerGB
 	costUSD := processedGB / 1000.0 * bigQueryCostPerTB // GB → TB → cost
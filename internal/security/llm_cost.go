@@ -0,0 +1,84 @@
+package security
+
+import (
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+const tokensPerMillion = 1_000_000.0
+
+// LLMPrice is the USD price per million tokens of one model. Zero cache
+// prices fall back to Input.
+type LLMPrice struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64
+	CacheWrite float64
+}
+
+// LLMPriceTable maps model IDs to their prices.
+type LLMPriceTable map[string]LLMPrice
+
+// Cost returns the USD cost of usage on model. ok is false when the model has
+// no price, in which case the cost is 0.
+func (t LLMPriceTable) Cost(model string, usage models.TokenUsage) (usd float64, ok bool) {
+	p, ok := t[model]
+	if !ok {
+		return 0, false
+	}
+	cacheRead, cacheWrite := p.CacheRead, p.CacheWrite
+	if cacheRead == 0 {
+		cacheRead = p.Input
+	}
+	if cacheWrite == 0 {
+		cacheWrite = p.Input
+	}
+	usd = float64(usage.InputTokens)*p.Input +
+		float64(usage.OutputTokens)*p.Output +
+		float64(usage.CacheReadTokens)*cacheRead +
+		float64(usage.CacheWriteTokens)*cacheWrite
+	return usd / tokensPerMillion, true
+}
+
+// WithLLMPrices sets the price table LogLLMCost converts token usage with.
+func (ct *CostTracker) WithLLMPrices(prices LLMPriceTable) *CostTracker {
+	ct.llmPrices = prices
+	return ct
+}
+
+// CheckTokenBudget returns an error string when the caller has used up a
+// daily LLM token quota. Callers without a budget subject are not limited.
+func (ct *CostTracker) CheckTokenBudget(apiKey string) (bool, string) {
+	if subject, ok := ct.subject(apiKey); ok {
+		return ct.budget.CheckTokens(subject)
+	}
+	return true, ""
+}
+
+// LogLLMCost logs the LLM token usage of one model in an agent request with
+// hashed identifiers, charges the tokens and their cost to the caller's
+// budget, and returns the cost. priced is false when model is not in the
+// price table; the tokens are still charged.
+func (ct *CostTracker) LogLLMCost(model, persona string, usage models.TokenUsage, apiKey string, durationMs int64) (costUSD float64, priced bool) {
+	costUSD, priced = ct.llmPrices.Cost(model, usage)
+	if subject, ok := ct.subject(apiKey); ok {
+		ct.budget.RecordTokens(subject, usage.Total(), costUSD)
+	}
+
+	keyHash := hashStr(apiKey)[:16]
+	log.Info().
+		Str("event", "llm_cost").
+		Str("model", model).
+		Str("persona", persona).
+		Str("api_key_hash", keyHash).
+		Int64("input_tokens", usage.InputTokens).
+		Int64("output_tokens", usage.OutputTokens).
+		Int64("cache_read_tokens", usage.CacheReadTokens).
+		Int64("cache_write_tokens", usage.CacheWriteTokens).
+		Float64("cost_usd", costUSD).
+		Bool("priced", priced).
+		Int64("duration_ms", durationMs).
+		Msgf("LLM cost: %d in / %d out tokens ($%.4f) | Model: %s | Duration: %dms | API: %s...",
+			usage.InputTokens+usage.CacheReadTokens+usage.CacheWriteTokens, usage.OutputTokens, costUSD, model, durationMs, keyHash)
+	return costUSD, priced
+}
@@ -0,0 +1,133 @@
+package security
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RowFilter limits the rows of the tables matching Table (a pattern as in
+// TableAccessPolicy.AllowedTables) to those satisfying Predicate, a boolean
+// SQL expression over the table's columns such as "region = 'ID-JK'".
+type RowFilter struct {
+	Table     string
+	Predicate string
+}
+
+// ApplyRowFilters rewrites sql, whose analysis is a, so that every table
+// reference matching a row filter reads from a subquery selecting only the
+// permitted rows:
+//
+//	FROM ds.orders o  →  FROM (SELECT * FROM ds.orders WHERE (merchant_id IN (1, 2))) o
+//
+// Unaliased tables are aliased with their table name so column qualifiers
+// keep resolving. A PostgreSQL ONLY moves into the subquery, and a BigQuery
+// TABLE argument of a table-valued function is replaced by the subquery;
+// MODEL arguments are not filtered. Several matching filters are ANDed. It returns the
+// rewritten SQL and the names of the filtered tables; with no matching
+// filter sql is returned unchanged. The rewrite is re-parsed and an error
+// returned if it is not a valid query, so a broken predicate fails closed.
+func (p *TableAccessPolicy) ApplyRowFilters(sql string, a *SQLAnalysis, defaultScope string) (string, []string, error) {
+	if p == nil || len(p.RowFilters) == 0 {
+		return sql, nil, nil
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	var filtered []string
+	for _, ref := range a.Tables {
+		if ref.arg == "MODEL" {
+			continue
+		}
+		name := qualifiedTableName(a.Dialect, ref, defaultScope)
+		var preds []string
+		for _, f := range p.RowFilters {
+			if !matchTable(strings.Split(f.Table, "."), name, true) {
+				continue
+			}
+			if err := checkRowPredicate(f.Predicate, a.Dialect); err != nil {
+				return "", nil, fmt.Errorf("row filter for %s: %w", f.Table, err)
+			}
+			preds = append(preds, "("+strings.TrimSpace(f.Predicate)+")")
+		}
+		if len(preds) == 0 {
+			continue
+		}
+		start, path := ref.Start, sql[ref.Start:ref.End]
+		from := path
+		if ref.lead > 0 {
+			start = ref.lead
+			if ref.arg == "" { // FROM ONLY orders
+				from = sql[ref.lead:ref.End]
+			}
+		}
+		text := "(SELECT * FROM " + from + " WHERE " + strings.Join(preds, " AND ") + ")"
+		if ref.Alias == "" && ref.arg == "" {
+			text += " AS " + rowFilterAlias(a.Dialect, ref, path)
+		}
+		edits = append(edits, edit{start, ref.End, text})
+		if !containsFold(filtered, ref.Name()) {
+			filtered = append(filtered, ref.Name())
+		}
+	}
+	if len(edits) == 0 {
+		return sql, nil, nil
+	}
+
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := sql
+	for _, e := range edits {
+		out = out[:e.start] + e.text + out[e.end:]
+	}
+	if msg := NewSQLValidator().Analyze(out, a.Dialect).Message(); msg != "" {
+		return "", nil, fmt.Errorf("row filter for %s produced an invalid query: %s", strings.Join(filtered, ", "), msg)
+	}
+	return out, filtered, nil
+}
+
+// checkRowPredicate rejects a predicate that is empty or whose parentheses
+// or statement separators would end the filtered subquery early.
+func checkRowPredicate(pred string, dialect SQLDialect) error {
+	toks, err := lexSQL(pred, dialect)
+	if err != nil {
+		return fmt.Errorf("invalid predicate: %s", err.(*sqlLexError).Msg)
+	}
+	if toks[0].Kind == tokEOF {
+		return fmt.Errorf("empty predicate")
+	}
+	depth := 0
+	for _, t := range toks {
+		switch {
+		case t.isOp("("):
+			depth++
+		case t.isOp(")"):
+			depth--
+		case t.isOp(";"):
+			depth = -1
+		}
+		if depth < 0 {
+			return fmt.Errorf("predicate is not a self-contained expression")
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("predicate has unbalanced parentheses")
+	}
+	return nil
+}
+
+// rowFilterAlias quotes the table name for use as the alias of its filtered
+// subquery. An unquoted PostgreSQL name is folded to lower case, as the
+// server would.
+func rowFilterAlias(dialect SQLDialect, ref SQLTableRef, path string) string {
+	name := ref.Table()
+	if dialect == DialectPostgres {
+		if !strings.HasSuffix(path, `"`) {
+			name = strings.ToLower(name)
+		}
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
@@ -0,0 +1,136 @@
+package security_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+func applyRowFilters(t *testing.T, p *security.TableAccessPolicy, sql string, dialect security.SQLDialect, defaultScope string) (string, []string) {
+	t.Helper()
+	a := security.NewSQLValidator().Analyze(sql, dialect)
+	if !a.Valid() {
+		t.Fatalf("Analyze(%q): unexpected violation %s", sql, a.Message())
+	}
+	out, filtered, err := p.ApplyRowFilters(sql, a, defaultScope)
+	if err != nil {
+		t.Fatalf("ApplyRowFilters(%q): %v", sql, err)
+	}
+	return out, filtered
+}
+
+func TestRowFilters_BigQueryRewrite(t *testing.T) {
+	p := &security.TableAccessPolicy{RowFilters: []security.RowFilter{
+		{Table: "payment_ds.orders", Predicate: "merchant_id IN (101, 102)"},
+		{Table: "payment_ds.orders", Predicate: "region = 'ID-JK'"},
+		{Table: "refunds", Predicate: "region = 'ID-JK'"},
+	}}
+	tests := []struct {
+		sql, dataset string
+		want         string
+	}{
+		{
+			"SELECT o.id FROM payment_ds.orders o",
+			"",
+			"SELECT o.id FROM (SELECT * FROM payment_ds.orders WHERE (merchant_id IN (101, 102)) AND (region = 'ID-JK')) o",
+		},
+		{
+			"SELECT orders.id FROM `proj.payment_ds.orders` WHERE orders.amount > 10",
+			"",
+			"SELECT orders.id FROM (SELECT * FROM `proj.payment_ds.orders` WHERE (merchant_id IN (101, 102)) AND (region = 'ID-JK')) AS `orders` WHERE orders.amount > 10",
+		},
+		{
+			"SELECT COUNT(*) FROM orders",
+			"payment_ds",
+			"SELECT COUNT(*) FROM (SELECT * FROM orders WHERE (merchant_id IN (101, 102)) AND (region = 'ID-JK')) AS `orders`",
+		},
+		{
+			"SELECT r.id FROM payment_ds.refunds AS r JOIN payment_ds.customers c ON c.id = r.customer_id",
+			"",
+			"SELECT r.id FROM (SELECT * FROM payment_ds.refunds WHERE (region = 'ID-JK')) AS r JOIN payment_ds.customers c ON c.id = r.customer_id",
+		},
+		{
+			"WITH x AS (SELECT id FROM payment_ds.refunds) SELECT * FROM x WHERE id IN (SELECT id FROM payment_ds.refunds)",
+			"",
+			"WITH x AS (SELECT id FROM (SELECT * FROM payment_ds.refunds WHERE (region = 'ID-JK')) AS `refunds`) SELECT * FROM x WHERE id IN (SELECT id FROM (SELECT * FROM payment_ds.refunds WHERE (region = 'ID-JK')) AS `refunds`)",
+		},
+		{
+			"SELECT id FROM payment_ds.customers",
+			"",
+			"SELECT id FROM payment_ds.customers",
+		},
+		{
+			"SELECT * FROM ML.PREDICT(MODEL payment_ds.refunds_model, TABLE payment_ds.refunds)",
+			"",
+			"SELECT * FROM ML.PREDICT(MODEL payment_ds.refunds_model, (SELECT * FROM payment_ds.refunds WHERE (region = 'ID-JK')))",
+		},
+	}
+	for _, tt := range tests {
+		got, _ := applyRowFilters(t, p, tt.sql, security.DialectBigQuery, tt.dataset)
+		if got != tt.want {
+			t.Errorf("ApplyRowFilters(%q)\n got %s\nwant %s", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestRowFilters_PostgresRewrite(t *testing.T) {
+	p := &security.TableAccessPolicy{RowFilters: []security.RowFilter{
+		{Table: "public.orders", Predicate: "merchant_id = 7"},
+		{Table: "Ledger", Predicate: "region = 'ID-JK'"},
+	}}
+	got, filtered := applyRowFilters(t, p, "SELECT Orders.id, l.amount FROM Orders JOIN \"Ledger\" l ON l.order_id = Orders.id", security.DialectPostgres, "payment_db")
+	want := "SELECT Orders.id, l.amount FROM (SELECT * FROM Orders WHERE (merchant_id = 7)) AS \"orders\" JOIN (SELECT * FROM \"Ledger\" WHERE (region = 'ID-JK')) l ON l.order_id = Orders.id"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if !reflect.DeepEqual(filtered, []string{"Orders", "Ledger"}) {
+		t.Errorf("filtered = %v", filtered)
+	}
+}
+
+func TestRowFilters_PostgresOnly(t *testing.T) {
+	p := &security.TableAccessPolicy{RowFilters: []security.RowFilter{{Table: "orders", Predicate: "merchant_id = 7"}}}
+	got, _ := applyRowFilters(t, p, "SELECT o.id FROM ONLY orders o JOIN ONLY public.orders ON orders.id = o.parent_id", security.DialectPostgres, "payment_db")
+	want := "SELECT o.id FROM (SELECT * FROM ONLY orders WHERE (merchant_id = 7)) o JOIN (SELECT * FROM ONLY public.orders WHERE (merchant_id = 7)) AS \"orders\" ON orders.id = o.parent_id"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	if a := security.NewSQLValidator().Analyze("SELECT id FROM ONLY (SELECT * FROM orders) o", security.DialectPostgres); a.Valid() {
+		t.Error("ONLY before a subquery must be rejected")
+	}
+}
+
+func TestRowFilters_WildcardTableIsFiltered(t *testing.T) {
+	p := &security.TableAccessPolicy{RowFilters: []security.RowFilter{{Table: "events_2024", Predicate: "region = 'ID-JK'"}}}
+	got, _ := applyRowFilters(t, p, "SELECT COUNT(*) FROM `ds.events_*`", security.DialectBigQuery, "")
+	if !strings.Contains(got, "WHERE (region = 'ID-JK')") {
+		t.Errorf("wildcard table covering a filtered table was not filtered: %s", got)
+	}
+}
+
+func TestRowFilters_InvalidPredicateFailsClosed(t *testing.T) {
+	sql := "SELECT id FROM ds.orders"
+	a := security.NewSQLValidator().Analyze(sql, security.DialectBigQuery)
+	for _, pred := range []string{
+		"1=1) UNION ALL (SELECT * FROM secrets", // breaks out of the subquery
+		"region = 'ID-JK'; DROP TABLE ds.orders",
+		"",
+	} {
+		p := &security.TableAccessPolicy{RowFilters: []security.RowFilter{{Table: "orders", Predicate: pred}}}
+		if out, _, err := p.ApplyRowFilters(sql, a, ""); err == nil {
+			t.Errorf("predicate %q: expected an error, got %q", pred, out)
+		}
+	}
+}
+
+func TestRowFilters_NoFiltersLeavesSQLUnchanged(t *testing.T) {
+	var nilPolicy *security.TableAccessPolicy
+	for _, p := range []*security.TableAccessPolicy{nilPolicy, {DeniedTables: []string{"x"}}} {
+		got, filtered := applyRowFilters(t, p, "SELECT id FROM ds.orders", security.DialectBigQuery, "")
+		if got != "SELECT id FROM ds.orders" || filtered != nil {
+			t.Errorf("got %q, %v; want SQL unchanged", got, filtered)
+		}
+	}
+}
@@ -199,11 +199,18 @@ func TestSQLValidatorPG_BlocksTerminateBackend(t *testing.T) {
 	}
 }
 
-func TestSQLValidatorPG_InheritsBasePatterns(t *testing.T) {
+func TestSQLValidatorPG_InheritsBaseRules(t *testing.T) {
 	v := security.NewSQLValidator()
-	// Should still catch base patterns like UNION SELECT
-	if msg := v.ValidatePG("SELECT * FROM users UNION SELECT * FROM passwords"); msg == "" {
-		t.Error("UNION SELECT should be blocked by base patterns")
+	// Shared rules (single statement, no DML, no injected tautologies) apply to PG too
+	if msg := v.ValidatePG("SELECT * FROM users WHERE id = 1 OR 1=1"); msg == "" {
+		t.Error("OR 1=1 should be blocked for PG")
+	}
+	if msg := v.ValidatePG("WITH x AS (DELETE FROM orders RETURNING id) SELECT * FROM x"); msg == "" {
+		t.Error("DML in a CTE should be blocked for PG")
+	}
+	// Bare UNION is valid PostgreSQL (BigQuery requires ALL/DISTINCT)
+	if msg := v.ValidatePG("SELECT id FROM users UNION SELECT id FROM admins"); msg != "" {
+		t.Errorf("UNION should be allowed for PG, got %s", msg)
 	}
 }
 
@@ -634,19 +641,19 @@ func TestCostTracker(t *testing.T) {
 	ct := security.NewCostTracker(10_000_000_000) // 10GB
 
 	// Under limit
-	ok, errMsg := ct.CheckLimits(5_000_000_000, "test-key")
+	_, ok, errMsg := ct.CheckLimits(5_000_000_000, "test-key")
 	if !ok || errMsg != "" {
 		t.Errorf("5GB should be within 10GB limit")
 	}
 
 	// Exactly at limit
-	ok, _ = ct.CheckLimits(10_000_000_000, "test-key")
+	_, ok, _ = ct.CheckLimits(10_000_000_000, "test-key")
 	if !ok {
 		t.Errorf("10GB should be within 10GB limit")
 	}
 
 	// Over limit
-	ok, errMsg = ct.CheckLimits(11_000_000_000, "test-key")
+	_, ok, errMsg = ct.CheckLimits(11_000_000_000, "test-key")
 	if ok {
 		t.Errorf("11GB should exceed 10GB limit")
 	}
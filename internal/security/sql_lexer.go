@@ -0,0 +1,436 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SQLDialect selects the lexical and grammar rules used by SQLValidator.
+type SQLDialect string
+
+const (
+	DialectBigQuery       SQLDialect = "bigquery"        // BigQuery GoogleSQL (standard SQL)
+	DialectBigQueryLegacy SQLDialect = "bigquery_legacy" // BigQuery legacy SQL ([project:dataset.table])
+	DialectPostgres       SQLDialect = "postgres"
+)
+
+type sqlTokenKind int
+
+const (
+	tokEOF    sqlTokenKind = iota
+	tokWord                // unquoted identifier or keyword
+	tokQuoted              // quoted identifier: `...` (BigQuery), "..." (PostgreSQL), [...] (legacy)
+	tokString
+	tokNumber
+	tokParam // @name, @@var, ?, $1
+	tokOp    // punctuation and operators
+)
+
+// sqlToken is one lexical token. Pos and End are byte offsets into the
+// source; Value is the unquoted identifier/string content, or the upper-cased
+// text for words so keyword checks are a plain comparison.
+type sqlToken struct {
+	Kind  sqlTokenKind
+	Text  string
+	Value string
+	Pos   int
+	End   int
+}
+
+func (t sqlToken) is(kind sqlTokenKind, value string) bool {
+	return t.Kind == kind && t.Value == value
+}
+
+// isWord reports whether t is the unquoted word kw (upper-case).
+func (t sqlToken) isWord(kw string) bool { return t.is(tokWord, kw) }
+
+// isOp reports whether t is the operator/punctuation op.
+func (t sqlToken) isOp(op string) bool { return t.is(tokOp, op) }
+
+// sqlLexError is a lexical error at a byte offset.
+type sqlLexError struct {
+	Pos int
+	Msg string
+}
+
+func (e *sqlLexError) Error() string { return fmt.Sprintf("%s at position %d", e.Msg, e.Pos) }
+
+// multi-character operators, longest first.
+var sqlMultiOps = []string{"->>", "#>>", "::", "<=", ">=", "<>", "!=", "||", "->", "#>", "@>", "<@", "=>", "<<", ">>", "**"}
+
+// lexSQL splits src into tokens, dropping whitespace and comments. The
+// returned slice always ends with a tokEOF token.
+func lexSQL(src string, dialect SQLDialect) ([]sqlToken, error) {
+	l := &sqlLexer{src: src, dialect: dialect}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		l.toks = append(l.toks, tok)
+		if tok.Kind == tokEOF {
+			return l.toks, nil
+		}
+	}
+}
+
+// CanonicalSQL returns sql with whitespace and comments normalised and
+// trailing semicolons dropped, so two spellings of the same query compare
+// equal. Identifiers and literals are kept verbatim. SQL that does not lex
+// is returned trimmed.
+func CanonicalSQL(sql string, dialect SQLDialect) string {
+	toks, err := lexSQL(sql, dialect)
+	if err != nil {
+		return strings.TrimSpace(sql)
+	}
+	toks = toks[:len(toks)-1] // tokEOF
+	for len(toks) > 0 && toks[len(toks)-1].isOp(";") {
+		toks = toks[:len(toks)-1]
+	}
+	parts := make([]string, len(toks))
+	for i, t := range toks {
+		parts[i] = t.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+type sqlLexer struct {
+	src     string
+	pos     int
+	dialect SQLDialect
+	toks    []sqlToken
+}
+
+func (l *sqlLexer) peekByte(off int) byte {
+	if l.pos+off < len(l.src) {
+		return l.src[l.pos+off]
+	}
+	return 0
+}
+
+func (l *sqlLexer) errorf(pos int, format string, args ...interface{}) error {
+	return &sqlLexError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *sqlLexer) bigQuery() bool {
+	return l.dialect == DialectBigQuery || l.dialect == DialectBigQueryLegacy
+}
+
+func (l *sqlLexer) next() (sqlToken, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return sqlToken{}, err
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return sqlToken{Kind: tokEOF, Pos: start, End: start}, nil
+	}
+	c := l.src[l.pos]
+
+	switch {
+	case c == '\'' || (c == '"' && l.bigQuery()):
+		return l.lexString(start, start, false)
+	case c == '"':
+		return l.lexDoubledQuote(start, '"', tokQuoted)
+	case c == '`' && l.bigQuery():
+		return l.lexBacktick(start)
+	case c == '[' && l.dialect == DialectBigQueryLegacy:
+		end := strings.IndexByte(l.src[l.pos+1:], ']')
+		if end < 0 {
+			return sqlToken{}, l.errorf(start, "unterminated [identifier]")
+		}
+		l.pos += end + 2
+		return l.tok(tokQuoted, start, l.src[start+1:l.pos-1]), nil
+	case c == '$' && l.dialect == DialectPostgres:
+		if tok, ok, err := l.lexDollar(start); ok || err != nil {
+			return tok, err
+		}
+	case c == '@' && l.bigQuery() && (isIdentStart(l.peekByte(1)) || l.peekByte(1) == '@' || l.peekByte(1) == '`'):
+		l.pos++
+		if l.peekByte(0) == '@' {
+			l.pos++
+		}
+		if l.peekByte(0) == '`' {
+			tok, err := l.lexBacktick(l.pos)
+			if err != nil {
+				return sqlToken{}, err
+			}
+			return l.tok(tokParam, start, tok.Value), nil
+		}
+		l.scanIdent()
+		return l.tok(tokParam, start, l.src[start:l.pos]), nil
+	case c == '?' && l.bigQuery():
+		l.pos++
+		return l.tok(tokParam, start, "?"), nil
+	case isDigit(c) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.lexNumber(start), nil
+	case isIdentStart(c) || c >= utf8.RuneSelf:
+		return l.lexWord(start)
+	}
+
+	for _, op := range sqlMultiOps {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return l.tok(tokOp, start, op), nil
+		}
+	}
+	l.pos++
+	return l.tok(tokOp, start, string(c)), nil
+}
+
+func (l *sqlLexer) tok(kind sqlTokenKind, start int, value string) sqlToken {
+	return sqlToken{Kind: kind, Text: l.src[start:l.pos], Value: value, Pos: start, End: l.pos}
+}
+
+func (l *sqlLexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-', c == '#' && l.bigQuery():
+			if nl := strings.IndexByte(l.src[l.pos:], '\n'); nl >= 0 {
+				l.pos += nl + 1
+			} else {
+				l.pos = len(l.src)
+			}
+		case c == '/' && l.peekByte(1) == '*':
+			if err := l.skipBlockComment(); err != nil {
+				return err
+			}
+		default:
+			if r, size := utf8.DecodeRuneInString(l.src[l.pos:]); r != utf8.RuneError && unicode.IsSpace(r) {
+				l.pos += size
+				continue
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// skipBlockComment skips /* ... */. PostgreSQL block comments nest.
+func (l *sqlLexer) skipBlockComment() error {
+	start := l.pos
+	depth := 0
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			if depth == 0 || l.dialect == DialectPostgres {
+				depth++
+			}
+			l.pos += 2
+		case strings.HasPrefix(l.src[l.pos:], "*/"):
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.pos++
+		}
+	}
+	return l.errorf(start, "unterminated comment")
+}
+
+func (l *sqlLexer) lexWord(start int) (sqlToken, error) {
+	// String literal prefixes: BigQuery r/b/rb/br, PostgreSQL E/B/X/N/U&.
+	if l.bigQuery() {
+		for _, p := range []string{"rb", "br", "r", "b"} {
+			if len(l.src)-l.pos > len(p) && strings.EqualFold(l.src[l.pos:l.pos+len(p)], p) {
+				if q := l.src[l.pos+len(p)]; q == '\'' || q == '"' {
+					raw := strings.ContainsAny(p, "rR")
+					l.pos += len(p)
+					return l.lexString(start, l.pos, raw)
+				}
+			}
+		}
+	} else if l.dialect == DialectPostgres {
+		switch {
+		case strings.EqualFold(l.src[l.pos:min(l.pos+3, len(l.src))], "u&'"):
+			l.pos += 2
+			return l.lexDoubledQuote(start, '\'', tokString)
+		case strings.EqualFold(l.src[l.pos:min(l.pos+3, len(l.src))], `u&"`):
+			l.pos += 2
+			return l.lexDoubledQuote(start, '"', tokQuoted)
+		case l.peekByte(1) == '\'' && strings.ContainsRune("eE", rune(l.src[l.pos])):
+			l.pos++
+			return l.lexString(start, l.pos, false)
+		case l.peekByte(1) == '\'' && strings.ContainsRune("bBxXnN", rune(l.src[l.pos])):
+			l.pos++
+			return l.lexDoubledQuote(start, '\'', tokString)
+		}
+	}
+	l.scanIdent()
+	if l.pos == start { // non-letter, non-space rune
+		_, size := utf8.DecodeRuneInString(l.src[l.pos:])
+		l.pos += size
+		return l.tok(tokOp, start, l.src[start:l.pos]), nil
+	}
+	return l.tok(tokWord, start, strings.ToUpper(l.src[start:l.pos])), nil
+}
+
+func (l *sqlLexer) scanIdent() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if isIdentStart(c) || isDigit(c) || (c == '$' && l.dialect == DialectPostgres) {
+			l.pos++
+			continue
+		}
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				l.pos += size
+				continue
+			}
+		}
+		return
+	}
+}
+
+func (l *sqlLexer) lexNumber(start int) sqlToken {
+	if l.peekByte(0) == '0' && (l.peekByte(1) == 'x' || l.peekByte(1) == 'X') {
+		l.pos += 2
+		for l.pos < len(l.src) && strings.IndexByte("0123456789abcdefABCDEF", l.src[l.pos]) >= 0 {
+			l.pos++
+		}
+		return l.tok(tokNumber, start, l.src[start:l.pos])
+	}
+	for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.' || l.src[l.pos] == '_') {
+		l.pos++
+	}
+	if c := l.peekByte(0); c == 'e' || c == 'E' {
+		n := 1
+		if s := l.peekByte(1); s == '+' || s == '-' {
+			n++
+		}
+		if isDigit(l.peekByte(n)) {
+			l.pos += n
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	return l.tok(tokNumber, start, l.src[start:l.pos])
+}
+
+// lexString lexes a '...' or "..." literal starting at l.pos (after any
+// prefix). BigQuery supports triple-quoted strings; backslash escapes apply
+// unless raw. PostgreSQL plain strings use ” doubling; E” strings also
+// accept backslash escapes.
+func (l *sqlLexer) lexString(start, quotePos int, raw bool) (sqlToken, error) {
+	q := l.src[quotePos]
+	if l.dialect == DialectPostgres && quotePos == start {
+		return l.lexDoubledQuote(start, '\'', tokString)
+	}
+	delim := string(q)
+	if l.bigQuery() && strings.HasPrefix(l.src[quotePos:], strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	l.pos = quotePos + len(delim)
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		if strings.HasPrefix(l.src[l.pos:], delim) {
+			l.pos += len(delim)
+			return l.tok(tokString, start, b.String()), nil
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '\\' && l.pos+1 < len(l.src):
+			if raw {
+				b.WriteString(l.src[l.pos : l.pos+2])
+			} else {
+				b.WriteByte(l.src[l.pos+1])
+			}
+			l.pos += 2
+		case c == '\'' && l.dialect == DialectPostgres && l.peekByte(1) == '\'':
+			b.WriteByte('\'')
+			l.pos += 2
+		case c == '\n' && len(delim) == 1 && l.bigQuery():
+			return sqlToken{}, l.errorf(start, "unterminated string")
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return sqlToken{}, l.errorf(start, "unterminated string")
+}
+
+// lexDoubledQuote lexes a literal or identifier delimited by q in which the
+// delimiter is escaped by doubling it (SQL-standard quoting).
+func (l *sqlLexer) lexDoubledQuote(start int, q byte, kind sqlTokenKind) (sqlToken, error) {
+	l.pos++ // opening quote
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == q {
+			if l.peekByte(1) == q {
+				b.WriteByte(q)
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			return l.tok(kind, start, b.String()), nil
+		}
+		b.WriteByte(c)
+		l.pos++
+	}
+	if kind == tokQuoted {
+		return sqlToken{}, l.errorf(start, "unterminated quoted identifier")
+	}
+	return sqlToken{}, l.errorf(start, "unterminated string")
+}
+
+func (l *sqlLexer) lexBacktick(start int) (sqlToken, error) {
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '`':
+			l.pos++
+			return l.tok(tokQuoted, start, b.String()), nil
+		case c == '\\' && l.pos+1 < len(l.src):
+			b.WriteByte(l.src[l.pos+1])
+			l.pos += 2
+		default:
+			b.WriteByte(c)
+			l.pos++
+		}
+	}
+	return sqlToken{}, l.errorf(start, "unterminated quoted identifier")
+}
+
+// lexDollar lexes a PostgreSQL positional parameter ($1) or dollar-quoted
+// string ($$...$$, $tag$...$tag$). ok is false when '$' starts neither.
+func (l *sqlLexer) lexDollar(start int) (sqlToken, bool, error) {
+	if isDigit(l.peekByte(1)) {
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return l.tok(tokParam, start, l.src[start:l.pos]), true, nil
+	}
+	end := l.pos + 1
+	for end < len(l.src) && (isIdentStart(l.src[end]) || isDigit(l.src[end])) {
+		end++
+	}
+	if end >= len(l.src) || l.src[end] != '$' || (end > l.pos+1 && isDigit(l.src[l.pos+1])) {
+		return sqlToken{}, false, nil
+	}
+	tag := l.src[l.pos : end+1]
+	body := end + 1
+	close := strings.Index(l.src[body:], tag)
+	if close < 0 {
+		return sqlToken{}, true, l.errorf(start, "unterminated dollar-quoted string")
+	}
+	l.pos = body + close + len(tag)
+	return l.tok(tokString, start, l.src[body:body+close]), true, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
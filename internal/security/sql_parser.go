@@ -0,0 +1,966 @@
+package security
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ─── AST ──────────────────────────────────────────────────────────────────────
+//
+// The parser builds a statement-level tree: queries, set operations, SELECT
+// clauses and FROM items are parsed structurally. Expressions are kept as
+// token spans with their nested subqueries and function calls, which is all
+// the validator needs and keeps it tolerant of dialect-specific expression
+// syntax (STRUCT<...>, ARRAY[...], ::casts, INTERVAL, ...).
+
+// sqlQuery is a query expression: optional WITH clause, a body of SELECTs
+// combined by set operators, then query-level ORDER BY / LIMIT / OFFSET.
+type sqlQuery struct {
+	With []*sqlCTE
+	Body sqlSetExpr
+	Tail []*sqlExpr
+	Pos  int
+	End  int
+}
+
+type sqlCTE struct {
+	Name  string
+	Query *sqlQuery
+}
+
+// sqlSetExpr is a query body: *sqlSelect, *sqlValues, *sqlSetOp or a
+// parenthesized *sqlQuery.
+type sqlSetExpr interface{ setExpr() }
+
+type sqlSetOp struct {
+	Op          string // UNION, INTERSECT, EXCEPT
+	Quantifier  string // ALL, DISTINCT or empty
+	Left, Right sqlSetExpr
+}
+
+type sqlSelect struct {
+	Items   *sqlExpr
+	From    []sqlFromItem
+	Clauses []*sqlExpr // WHERE, GROUP BY, HAVING, QUALIFY, WINDOW
+	Pos     int
+	End     int
+}
+
+type sqlValues struct {
+	Rows *sqlExpr
+}
+
+func (*sqlQuery) setExpr()  {}
+func (*sqlSetOp) setExpr()  {}
+func (*sqlSelect) setExpr() {}
+func (*sqlValues) setExpr() {}
+
+// sqlFromItem is one FROM-clause item.
+type sqlFromItem interface{ fromItem() }
+
+type sqlTableItem struct {
+	Ref *SQLTableRef
+}
+
+// sqlCTERefItem is a FROM reference to a CTE defined in an enclosing WITH.
+type sqlCTERefItem struct {
+	Name  string
+	Alias string
+}
+
+type sqlSubqueryItem struct {
+	Query *sqlQuery
+	Alias string
+}
+
+// sqlFuncItem is a table-valued function call, including UNNEST. Tables
+// holds the tables passed to it as TABLE arguments.
+type sqlFuncItem struct {
+	Call   sqlCall
+	Args   *sqlExpr
+	Alias  string
+	Tables []*SQLTableRef
+}
+
+type sqlJoin struct {
+	Kind        string // e.g. "JOIN", "LEFT OUTER JOIN"
+	Left, Right sqlFromItem
+	On          *sqlExpr
+}
+
+func (*sqlTableItem) fromItem()    {}
+func (*sqlCTERefItem) fromItem()   {}
+func (*sqlSubqueryItem) fromItem() {}
+func (*sqlFuncItem) fromItem()     {}
+func (*sqlJoin) fromItem()         {}
+
+// sqlExpr is an expression (or expression list) as a token span, with the
+// subqueries and function calls found inside it.
+type sqlExpr struct {
+	Pos        int
+	End        int
+	Subqueries []*sqlQuery
+	Calls      []sqlCall
+}
+
+type sqlCall struct {
+	Name []string // path as written, e.g. ["pg_catalog", "pg_sleep"]
+	Pos  int
+}
+
+func (c sqlCall) base() string { return strings.ToLower(c.Name[len(c.Name)-1]) }
+
+// ─── keyword tables ───────────────────────────────────────────────────────────
+
+// sqlReservedWords are words that end an expression or FROM item and so can
+// never be an implicit alias. It absorbs the old SELECT keyword allow-list.
+var sqlReservedWords = map[string]bool{
+	"SELECT": true, "FROM": true, "WHERE": true, "JOIN": true,
+	"INNER": true, "LEFT": true, "RIGHT": true, "OUTER": true,
+	"FULL": true, "CROSS": true, "NATURAL": true, "LATERAL": true,
+	"ON": true, "USING": true, "AND": true, "OR": true, "NOT": true,
+	"IN": true, "EXISTS": true, "BETWEEN": true, "LIKE": true,
+	"IS": true, "NULL": true, "ORDER": true, "BY": true,
+	"GROUP": true, "HAVING": true, "QUALIFY": true, "WINDOW": true,
+	"LIMIT": true, "OFFSET": true, "FETCH": true, "FOR": true,
+	"ASC": true, "DESC": true, "DISTINCT": true, "ALL": true, "AS": true,
+	"WITH": true, "CASE": true, "WHEN": true, "THEN": true,
+	"ELSE": true, "END": true, "UNION": true, "INTERSECT": true,
+	"EXCEPT": true, "INTO": true, "TABLESAMPLE": true,
+}
+
+// sqlClauseStops end a select list, WHERE/HAVING/... expression or
+// query-level tail expression.
+var sqlClauseStops = map[string]bool{
+	"FROM": true, "INTO": true, "WHERE": true, "GROUP": true,
+	"HAVING": true, "QUALIFY": true, "WINDOW": true, "ORDER": true,
+	"LIMIT": true, "OFFSET": true, "FETCH": true, "FOR": true,
+	"UNION": true, "INTERSECT": true, "EXCEPT": true,
+}
+
+// sqlJoinStops additionally end a JOIN ... ON condition.
+var sqlJoinStops = func() map[string]bool {
+	m := map[string]bool{
+		"JOIN": true, "INNER": true, "LEFT": true, "RIGHT": true,
+		"FULL": true, "CROSS": true, "NATURAL": true, ",": true,
+	}
+	for k := range sqlClauseStops {
+		m[k] = true
+	}
+	return m
+}()
+
+// sqlWriteStatements start statements that modify data, schema, session or
+// server state, or run procedural code.
+var sqlWriteStatements = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true,
+	"UPSERT": true, "TRUNCATE": true, "COPY": true, "DROP": true,
+	"CREATE": true, "ALTER": true, "RENAME": true, "GRANT": true,
+	"REVOKE": true, "COMMENT": true, "SET": true, "RESET": true,
+	"DECLARE": true, "EXECUTE": true, "EXEC": true, "CALL": true,
+	"DO": true, "BEGIN": true, "START": true, "COMMIT": true,
+	"ROLLBACK": true, "LOCK": true, "VACUUM": true, "REINDEX": true,
+	"CLUSTER": true, "ANALYZE": true, "REFRESH": true, "LISTEN": true,
+	"NOTIFY": true, "PREPARE": true, "EXPORT": true, "LOAD": true,
+	"ASSERT": true, "RAISE": true, "IF": true, "LOOP": true,
+	"WHILE": true, "REPEAT": true, "RETURN": true,
+}
+
+// ─── parser ───────────────────────────────────────────────────────────────────
+
+type sqlParser struct {
+	dialect    SQLDialect
+	toks       []sqlToken
+	i          int
+	tables     []*SQLTableRef
+	columns    []*SQLColumnRef
+	calls      []sqlCall
+	violations []SQLViolation
+	ctes       []map[string]bool // WITH scopes, innermost last
+	aliases    []map[string]bool // FROM alias scopes per SELECT, innermost last
+	inCall     bool              // parsing the arguments of a function call
+}
+
+// sqlParseError aborts parsing with a syntax violation.
+type sqlParseError struct {
+	tok sqlToken
+	msg string
+}
+
+// sqlAbort aborts parsing after a violation has already been recorded.
+type sqlAbort struct{}
+
+// parseStatement parses a single statement (tokens up to EOF). Violations
+// found while parsing are recorded on p; a nil query means parsing stopped.
+func (p *sqlParser) parseStatement() (q *sqlQuery) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case sqlParseError:
+				p.violate(ViolationSyntax, e.tok, "%s", e.msg)
+			case sqlAbort:
+			default:
+				panic(r)
+			}
+			q = nil
+		}
+	}()
+
+	t := p.peek()
+	if !p.startsQuery(t) {
+		p.violate(ViolationNotReadOnly, t, "only SELECT queries are allowed (got %s)", describeToken(t))
+		return nil
+	}
+	q = p.parseQuery()
+	if t := p.peek(); t.Kind != tokEOF {
+		p.fail(t, "unexpected %s", describeToken(t))
+	}
+	return q
+}
+
+func (p *sqlParser) startsQuery(t sqlToken) bool {
+	return t.isWord("SELECT") || t.isWord("WITH") || t.isWord("VALUES") || t.isOp("(")
+}
+
+func (p *sqlParser) peek() sqlToken { return p.toks[p.i] }
+
+func (p *sqlParser) peekAt(n int) sqlToken {
+	if p.i+n < len(p.toks) {
+		return p.toks[p.i+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *sqlParser) prev() sqlToken {
+	if p.i == 0 {
+		return sqlToken{}
+	}
+	return p.toks[p.i-1]
+}
+
+func (p *sqlParser) advance() sqlToken {
+	t := p.toks[p.i]
+	if t.Kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *sqlParser) acceptWord(kw string) bool {
+	if p.peek().isWord(kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) acceptOp(op string) bool {
+	if p.peek().isOp(op) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectWord(kw string) {
+	if !p.acceptWord(kw) {
+		p.fail(p.peek(), "expected %s, got %s", kw, describeToken(p.peek()))
+	}
+}
+
+func (p *sqlParser) expectOp(op string) {
+	if !p.acceptOp(op) {
+		p.fail(p.peek(), "expected %q, got %s", op, describeToken(p.peek()))
+	}
+}
+
+func (p *sqlParser) expectIdent() string {
+	t := p.peek()
+	switch t.Kind {
+	case tokWord:
+		p.i++
+		return t.Text
+	case tokQuoted:
+		p.i++
+		return t.Value
+	}
+	p.fail(t, "expected identifier, got %s", describeToken(t))
+	return ""
+}
+
+func (p *sqlParser) fail(t sqlToken, format string, args ...interface{}) {
+	panic(sqlParseError{tok: t, msg: "syntax error: " + fmt.Sprintf(format, args...)})
+}
+
+func (p *sqlParser) violate(code string, t sqlToken, format string, args ...interface{}) {
+	p.violations = append(p.violations, SQLViolation{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Pos:     t.Pos,
+		Token:   t.Text,
+	})
+}
+
+func describeToken(t sqlToken) string {
+	if t.Kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// writeStatementAt reports whether the token n positions ahead starts a
+// non-query statement. A following '(' makes it a function call instead
+// (IF(...), REPEAT(...)).
+func (p *sqlParser) writeStatementAt(n int) bool {
+	t := p.peekAt(n)
+	return t.Kind == tokWord && sqlWriteStatements[t.Value] && !p.peekAt(n+1).isOp("(")
+}
+
+// parseQuery parses [WITH ...] body [ORDER BY ...] [LIMIT ...] ...
+func (p *sqlParser) parseQuery() *sqlQuery {
+	q := &sqlQuery{Pos: p.peek().Pos}
+	defer func(inCall bool) { p.inCall = inCall }(p.inCall)
+	p.inCall = false
+	if p.acceptWord("WITH") {
+		p.acceptWord("RECURSIVE")
+		scope := map[string]bool{}
+		p.ctes = append(p.ctes, scope)
+		defer func() { p.ctes = p.ctes[:len(p.ctes)-1] }()
+		for {
+			name := p.expectIdent()
+			scope[strings.ToLower(name)] = true
+			if p.peek().isOp("(") { // column list
+				p.advance()
+				p.skipBalanced(")")
+			}
+			p.expectWord("AS")
+			p.acceptWord("NOT")
+			p.acceptWord("MATERIALIZED")
+			p.expectOp("(")
+			q.With = append(q.With, &sqlCTE{Name: name, Query: p.parseParenQuery()})
+			if !p.acceptOp(",") {
+				break
+			}
+		}
+	}
+	q.Body = p.parseSetExpr()
+	q.Tail = p.parseQueryTail()
+	q.End = p.prev().End
+	return q
+}
+
+// parseParenQuery parses a query after its opening parenthesis, through the
+// closing one. A data-modifying statement in its place is a violation.
+func (p *sqlParser) parseParenQuery() *sqlQuery {
+	t := p.peek()
+	if p.writeStatementAt(0) {
+		p.violate(ViolationNotReadOnly, t, "data-modifying statement inside a query is not allowed (got %s)", t.Value)
+		p.skipBalanced(")")
+		return nil
+	}
+	if !p.startsQuery(t) {
+		p.fail(t, "expected subquery, got %s", describeToken(t))
+	}
+	q := p.parseQuery()
+	p.expectOp(")")
+	return q
+}
+
+func (p *sqlParser) parseSetExpr() sqlSetExpr {
+	left := p.parseSetTerm()
+	for {
+		t := p.peek()
+		if !t.isWord("UNION") && !t.isWord("INTERSECT") && !t.isWord("EXCEPT") {
+			return left
+		}
+		p.advance()
+		op := &sqlSetOp{Op: t.Value, Left: left}
+		if q := p.peek(); q.isWord("ALL") || q.isWord("DISTINCT") {
+			op.Quantifier = p.advance().Value
+		} else if p.dialect == DialectBigQuery {
+			p.violate(ViolationSyntax, t, "BigQuery requires %s ALL or %s DISTINCT", t.Value, t.Value)
+		}
+		if p.acceptWord("BY") { // BigQuery UNION ALL BY NAME
+			p.expectWord("NAME")
+		}
+		op.Right = p.parseSetTerm()
+		left = op
+	}
+}
+
+func (p *sqlParser) parseSetTerm() sqlSetExpr {
+	t := p.peek()
+	switch {
+	case t.isWord("SELECT"):
+		return p.parseSelect()
+	case t.isWord("VALUES"):
+		p.advance()
+		return &sqlValues{Rows: p.parseExpr(sqlClauseStops)}
+	case t.isOp("("):
+		p.advance()
+		return p.parseParenQuery()
+	case t.Kind == tokWord && sqlWriteStatements[t.Value]:
+		p.violate(ViolationNotReadOnly, t, "only SELECT queries are allowed (got %s)", t.Value)
+		panic(sqlAbort{})
+	}
+	p.fail(t, "expected SELECT, got %s", describeToken(t))
+	return nil
+}
+
+// parseQueryTail parses query-level ORDER BY, LIMIT, OFFSET, FETCH and
+// row-locking clauses.
+func (p *sqlParser) parseQueryTail() []*sqlExpr {
+	var tail []*sqlExpr
+	for {
+		t := p.peek()
+		switch {
+		case t.isWord("ORDER"):
+			p.advance()
+			p.expectWord("BY")
+		case t.isWord("LIMIT"), t.isWord("OFFSET"), t.isWord("FETCH"):
+			p.advance()
+		case t.isWord("FOR"):
+			p.advance()
+			if n := p.peek(); n.isWord("UPDATE") || n.isWord("SHARE") || n.isWord("NO") || n.isWord("KEY") {
+				p.violate(ViolationNotReadOnly, t, "row-locking clause FOR %s is not allowed", n.Value)
+			} else {
+				p.fail(n, "unexpected %s after FOR", describeToken(n))
+			}
+		default:
+			return tail
+		}
+		tail = append(tail, p.parseExpr(sqlClauseStops))
+	}
+}
+
+func (p *sqlParser) parseSelect() *sqlSelect {
+	s := &sqlSelect{Pos: p.advance().Pos}
+	p.aliases = append(p.aliases, map[string]bool{})
+	firstTable, firstColumn := len(p.tables), len(p.columns)
+	defer func() {
+		// BigQuery correlated array paths (FROM orders o, o.items) look like
+		// dataset.table; any path rooted at an alias of this SELECT is one.
+		scope := p.aliases[len(p.aliases)-1]
+		for _, ref := range p.tables[firstTable:] {
+			if ref.arg == "" && len(ref.Parts) > 1 && scope[strings.ToLower(ref.Parts[0])] {
+				ref.correlated = true
+			}
+		}
+		p.aliases = p.aliases[:len(p.aliases)-1]
+
+		// Columns see this SELECT's tables after those of any nested SELECT
+		// they appear in; own counts the tables a star expands to.
+		direct := fromTables(s.From, nil)
+		for _, c := range p.columns[firstColumn:] {
+			if !c.scoped {
+				c.scoped, c.own = true, len(direct)
+			}
+			c.scope = append(c.scope, direct...)
+		}
+	}()
+
+	if p.peek().isWord("AS") { // BigQuery SELECT AS STRUCT / AS VALUE
+		p.advance()
+		p.expectIdent()
+	}
+	if !p.acceptWord("ALL") {
+		p.acceptWord("DISTINCT")
+	}
+	s.Items = p.parseExpr(sqlClauseStops)
+	if t := p.peek(); t.isWord("INTO") {
+		p.violate(ViolationNotReadOnly, t, "SELECT INTO is not allowed")
+		p.advance()
+		p.parseExpr(sqlClauseStops)
+	}
+	if p.acceptWord("FROM") {
+		s.From = p.parseFromList()
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.isWord("WHERE"), t.isWord("HAVING"), t.isWord("QUALIFY"), t.isWord("WINDOW"):
+			p.advance()
+		case t.isWord("GROUP"):
+			p.advance()
+			p.expectWord("BY")
+		default:
+			s.End = p.prev().End
+			return s
+		}
+		s.Clauses = append(s.Clauses, p.parseExpr(sqlClauseStops))
+	}
+}
+
+func (p *sqlParser) parseFromList() []sqlFromItem {
+	items := []sqlFromItem{p.parseJoinedItem()}
+	for p.acceptOp(",") {
+		items = append(items, p.parseJoinedItem())
+	}
+	return items
+}
+
+func (p *sqlParser) parseJoinedItem() sqlFromItem {
+	left := p.parseFromItem()
+	for {
+		kind, ok := p.acceptJoin()
+		if !ok {
+			return left
+		}
+		j := &sqlJoin{Kind: kind, Left: left, Right: p.parseFromItem()}
+		if p.acceptWord("ON") {
+			j.On = p.parseExpr(sqlJoinStops)
+		} else if p.acceptWord("USING") {
+			p.expectOp("(")
+			p.skipBalanced(")")
+		}
+		left = j
+	}
+}
+
+// fromTables appends the table references named directly in a FROM list
+// (not inside subqueries), skipping correlated array paths.
+func fromTables(items []sqlFromItem, out []*SQLTableRef) []*SQLTableRef {
+	for _, item := range items {
+		switch it := item.(type) {
+		case *sqlTableItem:
+			if !it.Ref.correlated {
+				out = append(out, it.Ref)
+			}
+		case *sqlFuncItem:
+			out = append(out, it.Tables...)
+		case *sqlJoin:
+			out = fromTables([]sqlFromItem{it.Left, it.Right}, out)
+		}
+	}
+	return out
+}
+
+// acceptJoin consumes [NATURAL] [INNER|CROSS|LEFT|RIGHT|FULL] [OUTER] JOIN.
+func (p *sqlParser) acceptJoin() (string, bool) {
+	start := p.i
+	var words []string
+	for {
+		t := p.peek()
+		switch {
+		case t.isWord("JOIN"):
+			p.advance()
+			return strings.Join(append(words, "JOIN"), " "), true
+		case t.isWord("NATURAL"), t.isWord("INNER"), t.isWord("CROSS"), t.isWord("OUTER"),
+			t.isWord("LEFT"), t.isWord("RIGHT"), t.isWord("FULL"):
+			words = append(words, t.Value)
+			p.advance()
+		default:
+			p.i = start
+			return "", false
+		}
+	}
+}
+
+func (p *sqlParser) parseFromItem() sqlFromItem {
+	p.acceptWord("LATERAL")
+	only := 0
+	if p.peek().isWord("ONLY") {
+		only = p.advance().Pos
+	}
+	t := p.peek()
+	switch {
+	case t.isOp("("):
+		if p.parenStartsQuery() {
+			if only > 0 {
+				p.fail(t, "ONLY must be followed by a table name")
+			}
+			p.advance()
+			item := &sqlSubqueryItem{Query: p.parseParenQuery()}
+			item.Alias = p.parseAlias()
+			return item
+		}
+		p.advance()
+		inner := p.parseJoinedItem()
+		p.expectOp(")")
+		p.parseAlias()
+		return inner
+	case t.Kind == tokWord || t.Kind == tokQuoted:
+		if t.Kind == tokWord && sqlReservedWords[t.Value] {
+			p.fail(t, "expected table name, got %s", describeToken(t))
+		}
+		parts, start, end := p.parsePath()
+		if p.peek().isOp("(") {
+			call := sqlCall{Name: parts, Pos: start}
+			p.advance()
+			firstTable, inCall := len(p.tables), p.inCall
+			p.inCall = true
+			item := &sqlFuncItem{Call: call, Args: p.parseExpr(nil)}
+			p.inCall = inCall
+			for _, ref := range p.tables[firstTable:] {
+				if ref.arg == "TABLE" {
+					item.Tables = append(item.Tables, ref)
+				}
+			}
+			p.expectOp(")")
+			if call.base() != "unnest" {
+				p.recordCall(call, t)
+			}
+			item.Alias = p.parseAlias()
+			if p.peek().isWord("WITH") && p.peekAt(1).isWord("OFFSET") {
+				p.advance()
+				p.advance()
+				p.addAlias(p.parseAlias())
+			}
+			p.addAlias(item.Alias)
+			return item
+		}
+		p.tableModifiers()
+		alias := p.parseAlias()
+		p.tableModifiers()
+		if alias != "" {
+			p.addAlias(alias)
+		} else {
+			p.addAlias(parts[len(parts)-1])
+		}
+		if len(parts) == 1 && p.isCTE(parts[0]) {
+			return &sqlCTERefItem{Name: parts[0], Alias: alias}
+		}
+		ref := &SQLTableRef{Parts: parts, Alias: alias, Start: start, End: end, lead: only}
+		p.tables = append(p.tables, ref)
+		return &sqlTableItem{Ref: ref}
+	}
+	p.fail(t, "expected table name or subquery, got %s", describeToken(t))
+	return nil
+}
+
+// parenStartsQuery reports whether the '(' at the cursor opens a query
+// (possibly nested in more parentheses) rather than a join group.
+func (p *sqlParser) parenStartsQuery() bool {
+	for n := 0; ; n++ {
+		t := p.peekAt(n)
+		if !t.isOp("(") {
+			return p.startsQuery(t) || p.writeStatementAt(n)
+		}
+	}
+}
+
+// parsePath parses a dotted table or function path. BigQuery quoted paths
+// (`project.dataset.table`) and legacy [project:dataset.table] are split
+// into their components, and unquoted dashed project IDs are joined.
+func (p *sqlParser) parsePath() (parts []string, start, end int) {
+	first := p.advance()
+	parts = p.pathParts(first)
+	start, end = first.Pos, first.End
+	for {
+		t := p.peek()
+		if p.dialect == DialectBigQuery && t.isOp("-") && t.Pos == end {
+			if n := p.peekAt(1); (n.Kind == tokWord || n.Kind == tokNumber) && n.Pos == t.End {
+				p.advance()
+				p.advance()
+				parts[len(parts)-1] += "-" + n.Text
+				end = n.End
+				continue
+			}
+		}
+		if t.isOp(".") {
+			if n := p.peekAt(1); n.Kind == tokWord || n.Kind == tokQuoted {
+				p.advance()
+				p.advance()
+				parts = append(parts, p.pathParts(n)...)
+				end = n.End
+				continue
+			}
+		}
+		return parts, start, end
+	}
+}
+
+func (p *sqlParser) pathParts(t sqlToken) []string {
+	if t.Kind == tokWord {
+		return []string{t.Text}
+	}
+	switch p.dialect {
+	case DialectBigQuery:
+		return strings.Split(t.Value, ".")
+	case DialectBigQueryLegacy:
+		return strings.FieldsFunc(t.Value, func(r rune) bool { return r == '.' || r == ':' })
+	}
+	return []string{t.Value}
+}
+
+// tableModifiers skips BigQuery FOR SYSTEM_TIME AS OF and TABLESAMPLE.
+func (p *sqlParser) tableModifiers() {
+	for {
+		switch t := p.peek(); {
+		case t.isWord("FOR") && p.peekAt(1).isWord("SYSTEM_TIME"):
+			p.advance()
+			p.advance()
+			p.expectWord("AS")
+			p.expectWord("OF")
+			p.parseExpr(sqlJoinStops)
+		case t.isWord("TABLESAMPLE"):
+			p.advance()
+			p.expectIdent()
+			p.expectOp("(")
+			p.skipBalanced(")")
+		default:
+			return
+		}
+	}
+}
+
+// parseAlias parses an optional [AS] alias with an optional column list.
+func (p *sqlParser) parseAlias() string {
+	var alias string
+	if p.acceptWord("AS") {
+		alias = p.expectIdent()
+	} else if t := p.peek(); t.Kind == tokQuoted || (t.Kind == tokWord && !sqlReservedWords[t.Value]) {
+		alias = p.expectIdent()
+	}
+	if alias != "" && p.peek().isOp("(") {
+		p.advance()
+		p.skipBalanced(")")
+	}
+	return alias
+}
+
+func (p *sqlParser) addAlias(alias string) {
+	if alias != "" && len(p.aliases) > 0 {
+		p.aliases[len(p.aliases)-1][strings.ToLower(alias)] = true
+	}
+}
+
+func (p *sqlParser) isCTE(name string) bool {
+	name = strings.ToLower(name)
+	for _, scope := range p.ctes {
+		if scope[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// skipBalanced consumes tokens through the closer matching an already
+// consumed opener.
+func (p *sqlParser) skipBalanced(closer string) {
+	depth := 1
+	for {
+		t := p.advance()
+		switch {
+		case t.Kind == tokEOF:
+			p.fail(t, "missing %q", closer)
+		case t.isOp("(") || t.isOp("["):
+			depth++
+		case t.isOp(")") || t.isOp("]"):
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// parseExpr consumes an expression (or comma-separated list) up to a stop
+// word at this nesting level, a closing bracket, ';' or EOF. Parenthesized
+// queries are parsed as subqueries; other groups are scanned recursively.
+func (p *sqlParser) parseExpr(stops map[string]bool) *sqlExpr {
+	e := &sqlExpr{Pos: p.peek().Pos}
+	for {
+		t := p.peek()
+		switch {
+		case t.Kind == tokEOF, t.isOp(";"), t.isOp(")"), t.isOp("]"):
+			e.End = p.prev().End
+			return e
+		case t.isOp(",") && stops[","]:
+			e.End = p.prev().End
+			return e
+		case t.Kind == tokWord && stops[t.Value] && !p.continuesExpr(t):
+			e.End = p.prev().End
+			return e
+		case t.isOp("("):
+			call, isCall := p.callBefore()
+			p.advance()
+			if n := p.peek(); p.startsQuery(n) && !n.isOp("(") || p.writeStatementAt(0) {
+				if q := p.parseParenQuery(); q != nil {
+					e.Subqueries = append(e.Subqueries, q)
+				}
+				continue
+			}
+			if isCall {
+				p.recordCall(call, p.toks[p.i-2])
+				e.Calls = append(e.Calls, call)
+			}
+			inCall := p.inCall
+			p.inCall = isCall
+			inner := p.parseExpr(nil)
+			p.inCall = inCall
+			e.Subqueries = append(e.Subqueries, inner.Subqueries...)
+			e.Calls = append(e.Calls, inner.Calls...)
+			p.expectOp(")")
+		case t.isOp("["):
+			p.advance()
+			inCall := p.inCall
+			p.inCall = false
+			inner := p.parseExpr(nil)
+			p.inCall = inCall
+			e.Subqueries = append(e.Subqueries, inner.Subqueries...)
+			e.Calls = append(e.Calls, inner.Calls...)
+			p.expectOp("]")
+		case t.isWord("OR"):
+			p.checkTautology()
+			p.advance()
+		default:
+			if !p.parseTableArg() && !p.parseColumnRef() {
+				p.advance()
+			}
+		}
+	}
+}
+
+// parseTableArg consumes and records a TABLE or MODEL argument of a BigQuery
+// table-valued function (ML.PREDICT(MODEL ds.m, TABLE ds.t), APPENDS(TABLE
+// ds.t, NULL, NULL)), which reads the named table as a FROM item does. It
+// reports false without consuming anything for every other token.
+func (p *sqlParser) parseTableArg() bool {
+	t, prev := p.peek(), p.prev()
+	if p.dialect != DialectBigQuery || !p.inCall || !(t.isWord("TABLE") || t.isWord("MODEL")) ||
+		!(prev.isOp("(") || prev.isOp(",")) {
+		return false
+	}
+	if n := p.peekAt(1); n.Kind != tokQuoted && (n.Kind != tokWord || sqlReservedWords[n.Value]) {
+		return false
+	}
+	p.advance()
+	parts, start, end := p.parsePath()
+	if len(parts) == 1 && p.isCTE(parts[0]) {
+		return true
+	}
+	p.tables = append(p.tables, &SQLTableRef{Parts: parts, Start: start, End: end, lead: t.Pos, arg: t.Value})
+	return true
+}
+
+// parseColumnRef consumes and records the column path or select-list star
+// at the cursor. Function names, typed literals (DATE '...') and names
+// declared with AS or after :: are not column references; it reports false
+// without consuming anything for those and for every other token.
+func (p *sqlParser) parseColumnRef() bool {
+	t, prev := p.peek(), p.prev()
+	if t.isOp("*") {
+		if !(prev.isWord("SELECT") || prev.isWord("DISTINCT") || prev.isWord("ALL") || prev.isOp(",")) {
+			return false // multiplication or COUNT(*)
+		}
+		p.advance()
+		p.columns = append(p.columns, &SQLColumnRef{Star: true, Pos: t.Pos, Except: p.parseStarExcept()})
+		return true
+	}
+	if !(t.Kind == tokQuoted || t.Kind == tokWord && !sqlReservedWords[t.Value]) ||
+		prev.isWord("AS") || prev.isOp(".") || prev.isOp("::") {
+		return false
+	}
+
+	parts, n := p.pathParts(t), 0
+	for p.peekAt(n + 1).isOp(".") {
+		next := p.peekAt(n + 2)
+		if next.Kind != tokWord && next.Kind != tokQuoted {
+			break
+		}
+		parts = append(parts, p.pathParts(next)...)
+		n += 2
+	}
+	if after := p.peekAt(n + 1); after.isOp("(") || after.Kind == tokString {
+		return false
+	}
+	star := p.peekAt(n+1).isOp(".") && p.peekAt(n+2).isOp("*")
+	if star {
+		n += 2
+	}
+	p.i += n + 1
+	ref := &SQLColumnRef{Parts: parts, Star: star, Pos: t.Pos}
+	if star {
+		ref.Except = p.parseStarExcept()
+	}
+	p.columns = append(p.columns, ref)
+	return true
+}
+
+// parseStarExcept consumes BigQuery's EXCEPT (col, ...) after a select-list
+// star and returns the excluded columns.
+func (p *sqlParser) parseStarExcept() []string {
+	if !p.peek().isWord("EXCEPT") || !p.peekAt(1).isOp("(") || p.startsQuery(p.peekAt(2)) {
+		return nil
+	}
+	p.advance()
+	p.advance()
+	var cols []string
+	for {
+		cols = append(cols, p.expectIdent())
+		if !p.acceptOp(",") {
+			break
+		}
+	}
+	p.expectOp(")")
+	return cols
+}
+
+// continuesExpr reports whether a stop word at the cursor is actually part
+// of the expression: IS [NOT] DISTINCT FROM, WITHIN GROUP, BigQuery
+// SELECT * EXCEPT (...), and the LEFT()/RIGHT() string functions.
+func (p *sqlParser) continuesExpr(t sqlToken) bool {
+	prev := p.prev()
+	switch t.Value {
+	case "FROM":
+		return prev.isWord("DISTINCT") && p.i >= 2 && (p.toks[p.i-2].isWord("IS") || p.toks[p.i-2].isWord("NOT"))
+	case "GROUP":
+		return prev.isWord("WITHIN")
+	case "EXCEPT":
+		return prev.isOp("*") && p.peekAt(1).isOp("(")
+	case "LEFT", "RIGHT":
+		return p.peekAt(1).isOp("(")
+	}
+	return false
+}
+
+// callBefore returns the function path ending just before the '(' at the
+// cursor, if the parenthesis is a call.
+func (p *sqlParser) callBefore() (sqlCall, bool) {
+	k := p.i - 1
+	if k < 0 {
+		return sqlCall{}, false
+	}
+	name := p.toks[k]
+	if !(name.Kind == tokWord && !sqlReservedWords[name.Value]) && name.Kind != tokQuoted {
+		return sqlCall{}, false
+	}
+	parts := []string{p.identText(name)}
+	pos := name.Pos
+	for k >= 2 && p.toks[k-1].isOp(".") && (p.toks[k-2].Kind == tokWord || p.toks[k-2].Kind == tokQuoted) {
+		k -= 2
+		parts = append([]string{p.identText(p.toks[k])}, parts...)
+		pos = p.toks[k].Pos
+	}
+	return sqlCall{Name: parts, Pos: pos}, true
+}
+
+func (p *sqlParser) identText(t sqlToken) string {
+	if t.Kind == tokWord {
+		return t.Text
+	}
+	return t.Value
+}
+
+func (p *sqlParser) recordCall(call sqlCall, at sqlToken) {
+	p.calls = append(p.calls, call)
+	if reason, ok := forbiddenSQLFunction(p.dialect, call.base()); ok {
+		p.violations = append(p.violations, SQLViolation{
+			Code:    ViolationForbiddenFunction,
+			Message: fmt.Sprintf("function %s is not allowed (%s)", strings.Join(call.Name, "."), reason),
+			Pos:     call.Pos,
+			Token:   at.Text,
+		})
+	}
+}
+
+// checkTautology flags OR <literal> = <same literal>, the classic injected
+// always-true condition.
+func (p *sqlParser) checkTautology() {
+	a, eq, b := p.peekAt(1), p.peekAt(2), p.peekAt(3)
+	literal := func(t sqlToken) bool { return t.Kind == tokNumber || t.Kind == tokString }
+	if literal(a) && eq.isOp("=") && literal(b) && a.Kind == b.Kind && a.Value == b.Value {
+		p.violate(ViolationTautology, p.peek(), "always-true condition OR %s=%s", a.Text, b.Text)
+	}
+}
@@ -1,116 +1,224 @@
 package security
 
 import (
-	"regexp"
 	"strings"
 )
 
-// sqlDangerousPatterns mirrors Python validators.py DANGEROUS_PATTERNS exactly
-var sqlDangerousPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`(?i);\s*DROP\s+`),
-	regexp.MustCompile(`(?i);\s*DELETE\s+`),
-	regexp.MustCompile(`(?i);\s*INSERT\s+`),
-	regexp.MustCompile(`(?i);\s*UPDATE\s+`),
-	regexp.MustCompile(`(?i);\s*ALTER\s+`),
-	regexp.MustCompile(`(?i);\s*CREATE\s+`),
-	regexp.MustCompile(`(?i);\s*TRUNCATE\s+`),
-	// DML embedded in subquery/CTE (no semicolon prefix needed)
-	regexp.MustCompile(`(?i)\bDELETE\s+FROM\b`),
-	regexp.MustCompile(`(?i)\bINSERT\s+INTO\b`),
-	regexp.MustCompile(`(?i)\bUPDATE\s+\w+\s+SET\b`),
-	regexp.MustCompile(`(?i);\s*EXEC\s*\(?`),
-	regexp.MustCompile(`(?i);\s*EXECUTE\s+`),
-	regexp.MustCompile(`(?i)\bUNION\s+SELECT\b`), // UNION ALL SELECT is allowed; UNION SELECT is injection
-	regexp.MustCompile(`(?i)\bINTO\s+OUTFILE\b`),
-	regexp.MustCompile(`(?i)\bINTO\s+DUMPFILE\b`),
-	regexp.MustCompile(`(?i)\bLOAD\s+DATA\b`),
-	regexp.MustCompile(`(?i)\bLOAD_FILE\s*\(`),
-	regexp.MustCompile(`(?i)\bBENCHMARK\s*\(`),
-	regexp.MustCompile(`(?i)\bSLEEP\s*\(`),
-	regexp.MustCompile(`(?i)\bWAITFOR\s+DELAY\b`),
-	regexp.MustCompile(`'.*--`),    // comment injection after string literal
-	regexp.MustCompile(`;\s*--`),   // statement terminator + comment
-	regexp.MustCompile(`/\*.*?\*/`),
-	regexp.MustCompile(`(?i)\bor\s+1\s*=\s*1\b`),
-	regexp.MustCompile(`(?i)\band\s+1\s*=\s*1\b`),
-	regexp.MustCompile(`(?i)\bor\s+'1'\s*=\s*'1'`),
-	regexp.MustCompile(`(?i)\band\s+'1'\s*=\s*'1'`),
+// Violation codes reported in SQLViolation.Code.
+const (
+	ViolationEmpty              = "empty"
+	ViolationSyntax             = "syntax"
+	ViolationMultipleStatements = "multiple_statements"
+	ViolationNotReadOnly        = "not_read_only"
+	ViolationForbiddenFunction  = "forbidden_function"
+	ViolationTautology          = "tautology"
+)
+
+// SQLViolation is one reason a query was rejected. Pos is the byte offset
+// in the query where the problem starts.
+type SQLViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Pos     int    `json:"pos"`
+	Token   string `json:"token,omitempty"`
+}
+
+// SQLTableRef is a table referenced in a FROM clause or passed as a TABLE
+// or MODEL argument to a BigQuery table-valued function, with its path split
+// into components (project/dataset/table for BigQuery, schema/table for
+// PostgreSQL). Start and End are the byte offsets of the path in the query.
+// CTE names and BigQuery correlated array paths are not table references.
+type SQLTableRef struct {
+	Parts []string `json:"parts"`
+	Alias string   `json:"alias,omitempty"`
+	Start int      `json:"-"`
+	End   int      `json:"-"`
+
+	correlated bool
+	lead       int    // offset of a preceding ONLY, TABLE or MODEL keyword; 0 = none
+	arg        string // "TABLE" or "MODEL" for a table-valued function argument
+}
+
+// Name returns the dotted path as written, e.g. "payment_ds.orders".
+func (r SQLTableRef) Name() string { return strings.Join(r.Parts, ".") }
+
+// Table returns the last path component.
+func (r SQLTableRef) Table() string { return r.Parts[len(r.Parts)-1] }
+
+// SQLColumnRef is an identifier path used in an expression (a column,
+// possibly qualified by a table alias, or a struct field), or a select-list
+// star when Star is set, with Parts holding its optional qualifier. Except
+// lists the columns removed by BigQuery SELECT * EXCEPT (...). Collection is
+// conservative: date-part keywords such as DAY may also appear.
+type SQLColumnRef struct {
+	Parts  []string `json:"parts,omitempty"`
+	Star   bool     `json:"star,omitempty"`
+	Except []string `json:"except,omitempty"`
+	Pos    int      `json:"pos"`
+
+	scope  []*SQLTableRef // FROM tables of the enclosing SELECTs, innermost first
+	own    int            // how many of scope belong to the innermost SELECT
+	scoped bool
+}
+
+// SQLAnalysis is the result of parsing a query.
+type SQLAnalysis struct {
+	Dialect    SQLDialect     `json:"dialect"`
+	Tables     []SQLTableRef  `json:"tables"`
+	Columns    []SQLColumnRef `json:"columns,omitempty"`
+	Violations []SQLViolation `json:"violations,omitempty"`
+
+	query *sqlQuery
+}
+
+// Valid reports whether the query is a single read-only statement with no
+// violations.
+func (a *SQLAnalysis) Valid() bool { return len(a.Violations) == 0 }
+
+// Message returns the first violation's message, or "" when valid.
+func (a *SQLAnalysis) Message() string {
+	if len(a.Violations) == 0 {
+		return ""
+	}
+	return a.Violations[0].Message
+}
+
+// TableNames returns the distinct referenced table paths in order of first
+// appearance.
+func (a *SQLAnalysis) TableNames() []string {
+	seen := make(map[string]bool, len(a.Tables))
+	names := make([]string, 0, len(a.Tables))
+	for _, t := range a.Tables {
+		n := t.Name()
+		if k := strings.ToLower(n); !seen[k] {
+			seen[k] = true
+			names = append(names, n)
+		}
+	}
+	return names
 }
 
-// pgDangerousPatterns are PostgreSQL-specific dangerous patterns checked by ValidatePG.
-var pgDangerousPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`(?i)\bCOPY\s+`),
-	regexp.MustCompile(`(?i)\bSET\s+`),
-	regexp.MustCompile(`(?i)\bGRANT\s+`),
-	regexp.MustCompile(`(?i)\bREVOKE\s+`),
-	regexp.MustCompile(`(?i)\bDO\s+\$`),            // DO $$ ... $$ anonymous block
-	regexp.MustCompile(`(?i)\bpg_sleep\s*\(`),
-	regexp.MustCompile(`(?i)\bpg_read_file\s*\(`),
-	regexp.MustCompile(`(?i)\bpg_read_binary_file\s*\(`),
-	regexp.MustCompile(`(?i)\blo_import\s*\(`),
-	regexp.MustCompile(`(?i)\blo_export\s*\(`),
-	regexp.MustCompile(`(?i)\bVACUUM\b`),
-	regexp.MustCompile(`(?i)\bREINDEX\b`),
-	regexp.MustCompile(`(?i)\bLISTEN\s+`),
-	regexp.MustCompile(`(?i)\bNOTIFY\s+`),
-	regexp.MustCompile(`(?i)\bpg_terminate_backend\s*\(`),
-	regexp.MustCompile(`(?i)\bpg_cancel_backend\s*\(`),
+// forbiddenFunctions are callable from a SELECT but have side effects, read
+// server files, block, or run SQL outside the validated statement. The value
+// is the reason reported in the violation.
+var forbiddenFunctions = map[SQLDialect]map[string]string{
+	DialectPostgres: pgForbiddenFunctions,
+	DialectBigQuery: {
+		"external_query": "runs SQL outside the query",
+	},
 }
 
-var allowedKeywords = map[string]bool{
-	"SELECT": true, "FROM": true, "WHERE": true, "JOIN": true,
-	"INNER": true, "LEFT": true, "RIGHT": true, "OUTER": true,
-	"FULL": true, "ON": true, "AND": true, "OR": true, "NOT": true,
-	"IN": true, "EXISTS": true, "BETWEEN": true, "LIKE": true,
-	"IS": true, "NULL": true, "ORDER": true, "BY": true,
-	"GROUP": true, "HAVING": true, "LIMIT": true, "OFFSET": true,
-	"ASC": true, "DESC": true, "DISTINCT": true, "AS": true,
-	"WITH": true, "CASE": true, "WHEN": true, "THEN": true,
-	"ELSE": true, "END": true, "COUNT": true, "SUM": true,
-	"AVG": true, "MIN": true, "MAX": true, "ARRAY_AGG": true,
-	"STRING_AGG": true,
+var pgForbiddenFunctions = func() map[string]string {
+	m := map[string]string{}
+	for reason, names := range map[string][]string{
+		"blocks":                     {"pg_sleep", "pg_sleep_for", "pg_sleep_until"},
+		"reads server files":         {"pg_read_file", "pg_read_binary_file", "pg_ls_dir", "pg_stat_file", "lo_import"},
+		"writes server files":        {"pg_file_write", "pg_file_rename", "pg_file_unlink", "lo_export"},
+		"modifies data":              {"nextval", "setval", "lo_create", "lo_creat", "lo_unlink", "lo_put", "lo_from_bytea", "pg_logical_emit_message", "pg_notify"},
+		"changes session settings":   {"set_config"},
+		"takes locks":                {"pg_advisory_lock", "pg_advisory_lock_shared", "pg_advisory_xact_lock", "pg_advisory_xact_lock_shared", "pg_try_advisory_lock", "pg_try_advisory_xact_lock"},
+		"controls the server":        {"pg_terminate_backend", "pg_cancel_backend", "pg_reload_conf", "pg_rotate_logfile", "pg_switch_wal", "pg_promote", "pg_create_restore_point"},
+		"runs SQL outside the query": {"dblink", "dblink_exec", "dblink_connect", "query_to_xml", "query_to_xml_and_xmlschema", "query_to_xmlschema", "cursor_to_xml", "table_to_xml", "table_to_xml_and_xmlschema"},
+	} {
+		for _, n := range names {
+			m[n] = reason
+		}
+	}
+	return m
+}()
+
+// commonForbiddenFunctions are classic injection probes (MySQL/T-SQL).
+var commonForbiddenFunctions = map[string]string{
+	"sleep": "blocks", "benchmark": "blocks", "load_file": "reads server files",
 }
 
-// SQLValidator validates SQL queries for injection and disallowed operations
+func forbiddenSQLFunction(dialect SQLDialect, name string) (string, bool) {
+	if dialect == DialectBigQueryLegacy {
+		dialect = DialectBigQuery
+	}
+	if reason, ok := forbiddenFunctions[dialect][name]; ok {
+		return reason, true
+	}
+	reason, ok := commonForbiddenFunctions[name]
+	return reason, ok
+}
+
+// SQLValidator checks that generated or user-supplied SQL is a single
+// read-only query. It tokenizes and parses the statement for the target
+// dialect instead of pattern-matching, so string literals and comments
+// cannot hide or fake dangerous keywords.
 type SQLValidator struct{}
 
 func NewSQLValidator() *SQLValidator {
 	return &SQLValidator{}
 }
 
-// Validate returns an error string if SQL is invalid, or empty string if OK
-func (v *SQLValidator) Validate(sql string) string {
+// Analyze parses sql in the given dialect and returns its referenced tables
+// and columns and any violations.
+func (v *SQLValidator) Analyze(sql string, dialect SQLDialect) *SQLAnalysis {
+	a := &SQLAnalysis{Dialect: dialect, Tables: []SQLTableRef{}}
 	if strings.TrimSpace(sql) == "" {
-		return "SQL cannot be empty"
+		a.Violations = []SQLViolation{{Code: ViolationEmpty, Message: "SQL cannot be empty"}}
+		return a
 	}
 
-	trimmed := strings.TrimSpace(sql)
-	upperSQL := strings.ToUpper(trimmed)
+	toks, err := lexSQL(sql, dialect)
+	if err != nil {
+		le := err.(*sqlLexError)
+		a.Violations = []SQLViolation{{Code: ViolationSyntax, Message: "syntax error: " + le.Msg, Pos: le.Pos}}
+		return a
+	}
 
-	// Must start with SELECT or WITH (CTEs)
-	if !strings.HasPrefix(upperSQL, "SELECT") && !strings.HasPrefix(upperSQL, "WITH") {
-		return "only SELECT queries are allowed"
+	// Split on ';': one statement plus optional trailing semicolons.
+	start := 0
+	for start < len(toks) && toks[start].isOp(";") {
+		start++
+	}
+	end := start
+	for end < len(toks) && toks[end].Kind != tokEOF && !toks[end].isOp(";") {
+		end++
+	}
+	if start == end {
+		a.Violations = []SQLViolation{{Code: ViolationEmpty, Message: "SQL cannot be empty"}}
+		return a
 	}
+	stmt := append(toks[start:end:end], sqlToken{Kind: tokEOF, Pos: toks[end].Pos, End: toks[end].Pos})
 
-	// Check dangerous patterns
-	for _, pattern := range sqlDangerousPatterns {
-		if pattern.MatchString(sql) {
-			return "SQL injection pattern detected: " + pattern.String()
+	p := &sqlParser{dialect: dialect, toks: stmt}
+	a.query = p.parseStatement()
+
+	for rest := end; rest < len(toks) && toks[rest].Kind != tokEOF; rest++ {
+		if !toks[rest].isOp(";") {
+			p.violations = append(p.violations, SQLViolation{
+				Code:    ViolationMultipleStatements,
+				Message: "only a single statement is allowed",
+				Pos:     toks[rest].Pos,
+				Token:   toks[rest].Text,
+			})
+			break
 		}
 	}
 
-	return ""
+	for _, ref := range p.tables {
+		if !ref.correlated {
+			a.Tables = append(a.Tables, *ref)
+		}
+	}
+	for _, c := range p.columns {
+		a.Columns = append(a.Columns, *c)
+	}
+	a.Violations = p.violations
+	return a
+}
+
+// Validate checks BigQuery standard SQL and returns the first violation
+// message, or an empty string if the query is allowed.
+func (v *SQLValidator) Validate(sql string) string {
+	return v.Analyze(sql, DialectBigQuery).Message()
 }
 
-// ValidatePG runs the base validation plus PostgreSQL-specific patterns.
+// ValidatePG checks PostgreSQL SQL and returns the first violation message,
+// or an empty string if the query is allowed.
 func (v *SQLValidator) ValidatePG(sql string) string {
-	if msg := v.Validate(sql); msg != "" {
-		return msg
-	}
-	for _, pattern := range pgDangerousPatterns {
-		if pattern.MatchString(sql) {
-			return "PG dangerous pattern detected: " + pattern.String()
-		}
-	}
-	return ""
+	return v.Analyze(sql, DialectPostgres).Message()
 }
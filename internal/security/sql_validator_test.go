@@ -0,0 +1,264 @@
+package security_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+func violationCodes(a *security.SQLAnalysis) []string {
+	codes := make([]string, len(a.Violations))
+	for i, v := range a.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestAnalyze_BigQueryValidQueries(t *testing.T) {
+	v := security.NewSQLValidator()
+	valid := []string{
+		// Formerly over-blocked by the regex validator
+		"SELECT name FROM `proj.ds.users` WHERE note = 'it''s -- not a comment'",
+		"SELECT 'a' AS x UNION DISTINCT SELECT 'b'",
+		"SELECT id FROM ds.t WHERE 1=1 AND status = 'ok' /* generated */",
+		"SELECT * FROM ds.t WHERE id = 1 AND 1 = 1",
+		// BigQuery-specific syntax
+		"SELECT * EXCEPT (email) FROM `my-project.payment_ds.users`",
+		"SELECT u.* EXCEPT (email) REPLACE (UPPER(name) AS name) FROM ds.users u",
+		"SELECT o.id, item.sku FROM ds.orders o, o.items AS item",
+		"SELECT o.id, i FROM ds.orders AS o CROSS JOIN UNNEST(o.tags) AS i WITH OFFSET AS pos",
+		"SELECT arr[OFFSET(0)], arr[SAFE_ORDINAL(1)] FROM ds.t",
+		"SELECT STRUCT<a INT64, b STRING>(1, 'x') AS s, ARRAY<INT64>[1, 2] AS a",
+		"SELECT DATE_TRUNC(created_at, MONTH) m, COUNT(*) FROM my-project.ds.orders GROUP BY 1 ORDER BY 1 LIMIT 10",
+		"SELECT * FROM ds.t FOR SYSTEM_TIME AS OF TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL 1 HOUR)",
+		"SELECT id FROM ds.t QUALIFY ROW_NUMBER() OVER (PARTITION BY user_id ORDER BY ts DESC) = 1",
+		"SELECT EXTRACT(YEAR FROM ts) y, SAFE.PARSE_DATE('%Y', s) FROM ds.t WHERE a IS DISTINCT FROM b",
+		"SELECT IF(x > 0, 'pos', 'neg'), REPEAT('a', 3), LEFT(name, 3) FROM ds.t",
+		"SELECT * FROM `proj.ds.events_*` WHERE _TABLE_SUFFIX BETWEEN '20260101' AND '20260131'",
+		"SELECT r'raw\\d', b'bytes', \"\"\"triple\n'quoted'\"\"\" FROM ds.t # trailing comment",
+		"SELECT @start_date, id FROM ds.t WHERE d >= @start_date;",
+		"(SELECT 1) UNION ALL (SELECT 2) ORDER BY 1",
+		"WITH a AS (SELECT 1 x), b AS (SELECT x FROM a) SELECT * FROM b",
+		"SELECT ARRAY(SELECT AS STRUCT x FROM UNNEST([1,2]) x) arr",
+		"SELECT CASE WHEN a THEN 1 ELSE 2 END FROM ds.t t1 LEFT JOIN ds.u t2 USING (id)",
+	}
+	for _, sql := range valid {
+		if a := v.Analyze(sql, security.DialectBigQuery); !a.Valid() {
+			t.Errorf("valid BigQuery SQL rejected: %q -> %v", sql, a.Violations)
+		}
+	}
+}
+
+func TestAnalyze_PostgresValidQueries(t *testing.T) {
+	v := security.NewSQLValidator()
+	valid := []string{
+		`SELECT id, name FROM "public"."users" WHERE id = $1`,
+		"SELECT u.id, o.total FROM users u JOIN LATERAL (SELECT total FROM orders WHERE user_id = u.id LIMIT 1) o ON true",
+		"SELECT DISTINCT ON (user_id) user_id, created_at::date FROM orders ORDER BY user_id, created_at DESC",
+		"SELECT percentile_cont(0.5) WITHIN GROUP (ORDER BY amount) FROM payments",
+		"SELECT count(*) FILTER (WHERE status = 'paid') FROM payments",
+		"SELECT data->>'email', tags @> ARRAY['a'] FROM events",
+		"SELECT $$literal; DROP TABLE x$$ AS s, E'\\n', 'it''s'",
+		"SELECT * FROM generate_series(1, 10) AS g(n)",
+		"SELECT * FROM (VALUES (1, 'a'), (2, 'b')) AS t(id, name)",
+		"WITH RECURSIVE r(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM r WHERE n < 5) SELECT * FROM r",
+		"SELECT id FROM a UNION SELECT id FROM b EXCEPT SELECT id FROM c",
+		"SELECT * FROM orders LIMIT 10 OFFSET 20 /* nested /* comment */ ok */",
+	}
+	for _, sql := range valid {
+		if a := v.Analyze(sql, security.DialectPostgres); !a.Valid() {
+			t.Errorf("valid PG SQL rejected: %q -> %v", sql, a.Violations)
+		}
+	}
+}
+
+func TestAnalyze_Violations(t *testing.T) {
+	v := security.NewSQLValidator()
+	tests := []struct {
+		name    string
+		dialect security.SQLDialect
+		sql     string
+		code    string
+	}{
+		{"empty", security.DialectBigQuery, "  -- nothing\n ; ", security.ViolationEmpty},
+		{"stacked statement", security.DialectBigQuery, "SELECT 1;\nDELETE FROM ds.t WHERE true", security.ViolationMultipleStatements},
+		{"stacked after comment", security.DialectBigQuery, "SELECT 1 -- harmless\n; DROP TABLE ds.t", security.ViolationMultipleStatements},
+		{"bq scripting", security.DialectBigQuery, "DECLARE x INT64 DEFAULT 1", security.ViolationNotReadOnly},
+		{"bq execute immediate", security.DialectBigQuery, "EXECUTE IMMEDIATE 'DROP TABLE ds.t'", security.ViolationNotReadOnly},
+		{"bq call", security.DialectBigQuery, "CALL ds.proc()", security.ViolationNotReadOnly},
+		{"odd whitespace DML", security.DialectBigQuery, "DELETE\n\t FROM ds.t WHERE true", security.ViolationNotReadOnly},
+		{"merge", security.DialectBigQuery, "MERGE ds.t USING ds.s ON t.id = s.id WHEN MATCHED THEN DELETE", security.ViolationNotReadOnly},
+		{"bq bare union", security.DialectBigQuery, "SELECT 1 UNION SELECT 2", security.ViolationSyntax},
+		{"external query", security.DialectBigQuery, "SELECT * FROM EXTERNAL_QUERY('conn', 'SELECT * FROM secrets')", security.ViolationForbiddenFunction},
+		{"tautology", security.DialectBigQuery, "SELECT * FROM ds.t WHERE id = 'x' OR '1'='1'", security.ViolationTautology},
+		{"unterminated string", security.DialectBigQuery, "SELECT 'abc FROM ds.t", security.ViolationSyntax},
+		{"unbalanced parens", security.DialectBigQuery, "SELECT (1 FROM ds.t", security.ViolationSyntax},
+		{"trailing garbage", security.DialectBigQuery, "SELECT 1 FROM ds.t x y", security.ViolationSyntax},
+		{"pg dml cte", security.DialectPostgres, "WITH d AS (DELETE FROM orders RETURNING id) SELECT * FROM d", security.ViolationNotReadOnly},
+		{"pg with insert body", security.DialectPostgres, "WITH s AS (SELECT 1) INSERT INTO t SELECT * FROM s", security.ViolationNotReadOnly},
+		{"pg select into", security.DialectPostgres, "SELECT * INTO backup_users FROM users", security.ViolationNotReadOnly},
+		{"pg for update", security.DialectPostgres, "SELECT * FROM users WHERE id = 1 FOR UPDATE", security.ViolationNotReadOnly},
+		{"pg qualified sleep", security.DialectPostgres, "SELECT pg_catalog.pg_sleep(5)", security.ViolationForbiddenFunction},
+		{"pg quoted sleep", security.DialectPostgres, `SELECT "pg_sleep"(5)`, security.ViolationForbiddenFunction},
+		{"pg file in from", security.DialectPostgres, "SELECT * FROM pg_read_file('/etc/passwd') AS f", security.ViolationForbiddenFunction},
+		{"pg nextval", security.DialectPostgres, "SELECT nextval('orders_id_seq')", security.ViolationForbiddenFunction},
+		{"pg query_to_xml", security.DialectPostgres, "SELECT query_to_xml('DELETE FROM users RETURNING 1', true, false, '')", security.ViolationForbiddenFunction},
+		{"pg unterminated dollar", security.DialectPostgres, "SELECT $$abc", security.ViolationSyntax},
+		{"pg copy", security.DialectPostgres, "COPY users TO '/tmp/x'", security.ViolationNotReadOnly},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := v.Analyze(tt.sql, tt.dialect)
+			if a.Valid() {
+				t.Fatalf("expected %s violation for %q", tt.code, tt.sql)
+			}
+			if a.Violations[0].Code != tt.code {
+				t.Errorf("violations = %v, want first code %s", a.Violations, tt.code)
+			}
+			if a.Message() == "" {
+				t.Error("Message() must describe the violation")
+			}
+		})
+	}
+}
+
+func TestAnalyze_StringsAndCommentsCannotFakeViolations(t *testing.T) {
+	v := security.NewSQLValidator()
+	for _, sql := range []string{
+		"SELECT 'x; DROP TABLE users' AS s",
+		"SELECT '/* DELETE FROM users */' AS s",
+		"SELECT id FROM ds.t -- ; DELETE FROM ds.t",
+		"SELECT `delete` FROM ds.t",
+	} {
+		if a := v.Analyze(sql, security.DialectBigQuery); !a.Valid() {
+			t.Errorf("%q rejected: %v", sql, a.Violations)
+		}
+	}
+}
+
+func TestAnalyze_ViolationPosition(t *testing.T) {
+	sql := "SELECT id FROM users WHERE pg_sleep(1) IS NULL"
+	a := security.NewSQLValidator().Analyze(sql, security.DialectPostgres)
+	if len(a.Violations) != 1 {
+		t.Fatalf("violations = %v", a.Violations)
+	}
+	if got := a.Violations[0]; got.Pos != 27 || got.Token != "pg_sleep" {
+		t.Errorf("violation at pos=%d token=%q, want 27 pg_sleep", got.Pos, got.Token)
+	}
+}
+
+func TestAnalyze_ReferencedTables(t *testing.T) {
+	v := security.NewSQLValidator()
+	tests := []struct {
+		dialect security.SQLDialect
+		sql     string
+		want    []string
+	}{
+		{
+			security.DialectBigQuery,
+			"WITH recent AS (SELECT * FROM `proj.payment_ds.orders` WHERE d > '2026-01-01') " +
+				"SELECT r.id, u.name FROM recent r JOIN payment_ds.users AS u ON u.id = r.user_id " +
+				"WHERE r.merchant_id IN (SELECT id FROM my-proj.merchant_ds.merchants)",
+			[]string{"proj.payment_ds.orders", "payment_ds.users", "my-proj.merchant_ds.merchants"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT o.id, item FROM ds.orders o, o.items item, UNNEST(o.tags) tag",
+			[]string{"ds.orders"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT (SELECT COUNT(*) FROM ds.refunds r WHERE r.order_id = o.id) FROM ds.orders o",
+			[]string{"ds.refunds", "ds.orders"},
+		},
+		{
+			security.DialectPostgres,
+			`SELECT * FROM public.orders o LEFT JOIN "Sales"."Invoices" i ON i.order_id = o.id, merchants`,
+			[]string{"public.orders", "Sales.Invoices", "merchants"},
+		},
+		{
+			security.DialectBigQueryLegacy,
+			"SELECT id FROM [proj:ds.orders] WHERE x = 1",
+			[]string{"proj.ds.orders"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT * FROM ML.PREDICT(MODEL mine.m, TABLE other.secret)",
+			[]string{"mine.m", "other.secret"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT * FROM APPENDS(TABLE other.t, NULL, NULL)",
+			[]string{"other.t"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT * FROM mine.t other, ML.PREDICT(MODEL `mine.m`, TABLE other.secret)",
+			[]string{"mine.t", "mine.m", "other.secret"},
+		},
+		{
+			security.DialectBigQuery,
+			"SELECT model, table_name FROM ds.cars WHERE model IN (SELECT MAX(model) FROM ds.cars)",
+			[]string{"ds.cars"},
+		},
+	}
+	for _, tt := range tests {
+		a := v.Analyze(tt.sql, tt.dialect)
+		if !a.Valid() {
+			t.Errorf("%q rejected: %v", tt.sql, a.Violations)
+			continue
+		}
+		if got := a.TableNames(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("TableNames(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyze_TableOffsets(t *testing.T) {
+	sql := "SELECT * FROM `proj.ds.orders` AS o"
+	a := security.NewSQLValidator().Analyze(sql, security.DialectBigQuery)
+	if len(a.Tables) != 1 {
+		t.Fatalf("tables = %v", a.Tables)
+	}
+	ref := a.Tables[0]
+	if got := sql[ref.Start:ref.End]; got != "`proj.ds.orders`" {
+		t.Errorf("span = %q", got)
+	}
+	if ref.Alias != "o" || ref.Table() != "orders" {
+		t.Errorf("alias=%q table=%q", ref.Alias, ref.Table())
+	}
+}
+
+func TestAnalyze_MultipleViolationsReported(t *testing.T) {
+	a := security.NewSQLValidator().Analyze(
+		"SELECT pg_sleep(1), nextval('s') FROM t WHERE a = 1 OR 1=1",
+		security.DialectPostgres,
+	)
+	want := []string{security.ViolationForbiddenFunction, security.ViolationForbiddenFunction, security.ViolationTautology}
+	if got := violationCodes(a); !reflect.DeepEqual(got, want) {
+		t.Errorf("codes = %v, want %v", got, want)
+	}
+}
+
+func TestCanonicalSQL(t *testing.T) {
+	same := []string{
+		"SELECT id, name FROM ds.users WHERE note = 'a  b' LIMIT 10",
+		"SELECT id,\n  name\nFROM ds.users -- latest\nWHERE note = 'a  b'\nLIMIT 10;",
+		"  SELECT id , name FROM ds.users /* x */ WHERE note = 'a  b' LIMIT 10 ;;",
+	}
+	want := security.CanonicalSQL(same[0], security.DialectBigQuery)
+	for _, sql := range same[1:] {
+		if got := security.CanonicalSQL(sql, security.DialectBigQuery); got != want {
+			t.Errorf("CanonicalSQL(%q) = %q, want %q", sql, got, want)
+		}
+	}
+	for _, sql := range []string{
+		"SELECT id, name FROM ds.users WHERE note = 'a b' LIMIT 10",
+		"SELECT id, name FROM ds.Users WHERE note = 'a  b' LIMIT 10",
+	} {
+		if got := security.CanonicalSQL(sql, security.DialectBigQuery); got == want {
+			t.Errorf("CanonicalSQL(%q) must differ from %q", sql, want)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Access-control violation codes reported by TableAccessPolicy.Check.
+const (
+	ViolationTableNotAllowed  = "table_not_allowed"
+	ViolationColumnNotAllowed = "column_not_allowed"
+)
+
+// TableAccessPolicy limits the tables and columns a squad's queries may
+// reference. Empty lists impose no restriction.
+//
+// Table patterns are matched against the trailing components of a table's
+// qualified name — dataset.table for BigQuery, database.schema.table for
+// PostgreSQL (unqualified PostgreSQL tables are taken to be in public) — and
+// may use * wildcards: "orders" matches that table in any dataset or schema,
+// "payment_ds.*" every table of a dataset. Column patterns are a table
+// pattern followed by the column name ("users.email"); a bare column name
+// ("email") denies it in every table. RowFilters are not checked by Check
+// but applied to queries by ApplyRowFilters.
+//
+// Datasets are those of Project: when Datasets is set, a BigQuery path that
+// names any other project is rejected, as is every project-qualified path
+// if Project is empty.
+type TableAccessPolicy struct {
+	Project       string   // BigQuery project the Datasets belong to
+	Datasets      []string // BigQuery datasets queries may read
+	Databases     []string // PostgreSQL databases queries may read
+	AllowedTables []string // when set, only matching tables may be read
+	DeniedTables  []string
+	DeniedColumns []string
+	RowFilters    []RowFilter
+}
+
+// Fingerprint returns a digest of every rule of the policy, row filters
+// included, so results derived under it can be cached per policy. A nil
+// policy has an empty fingerprint.
+func (p *TableAccessPolicy) Fingerprint() string {
+	if p == nil {
+		return ""
+	}
+	b, _ := json.Marshal(p) // only strings and slices: cannot fail
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// Check returns a violation for every table or column in a that the policy
+// does not allow. defaultScope is the dataset unqualified BigQuery tables
+// resolve to, or the PostgreSQL database the query runs against. A nil
+// policy allows everything.
+func (p *TableAccessPolicy) Check(a *SQLAnalysis, defaultScope string) []SQLViolation {
+	if p == nil {
+		return nil
+	}
+	var out []SQLViolation
+	seen := map[string]bool{}
+	add := func(v SQLViolation) {
+		if !seen[v.Message] {
+			seen[v.Message] = true
+			out = append(out, v)
+		}
+	}
+
+	for _, ref := range a.Tables {
+		if msg := p.checkTable(a.Dialect, ref, defaultScope); msg != "" {
+			add(SQLViolation{Code: ViolationTableNotAllowed, Message: msg, Pos: ref.Start, Token: ref.Name()})
+		}
+	}
+
+	rules := parseColumnRules(p.DeniedColumns)
+	if len(rules) == 0 {
+		return out
+	}
+	all := make([]*SQLTableRef, len(a.Tables))
+	for i := range a.Tables {
+		all[i] = &a.Tables[i]
+	}
+	for _, c := range a.Columns {
+		scope, own := c.scope, c.own
+		if !c.scoped { // query-level ORDER BY etc.
+			scope, own = all, len(all)
+		}
+		for _, hit := range deniedColumnHits(a.Dialect, defaultScope, c, scope[:own], scope, rules) {
+			add(SQLViolation{Code: ViolationColumnNotAllowed, Message: hit, Pos: c.Pos, Token: strings.Join(c.Parts, ".")})
+		}
+	}
+	return out
+}
+
+// ProjectViolation returns why queries may not run with project as their
+// default BigQuery project, or "". Unqualified dataset.table paths resolve
+// to that project, so it must be the policy's when Datasets is set.
+func (p *TableAccessPolicy) ProjectViolation(project string) string {
+	if p == nil || len(p.Datasets) == 0 || project == "" || strings.EqualFold(project, p.Project) {
+		return ""
+	}
+	return fmt.Sprintf("project %s is not accessible for your squad", project)
+}
+
+// TableViolation returns why the table at path parts, as a query would name
+// it, may not be read, or "". A nil policy allows every table.
+func (p *TableAccessPolicy) TableViolation(dialect SQLDialect, parts []string, defaultScope string) string {
+	if p == nil {
+		return ""
+	}
+	return p.checkTable(dialect, SQLTableRef{Parts: parts}, defaultScope)
+}
+
+// DeniedColumnsFor returns the names of the DeniedColumns rules that apply
+// to the table at path parts, bare column names included.
+func (p *TableAccessPolicy) DeniedColumnsFor(dialect SQLDialect, parts []string, defaultScope string) []string {
+	if p == nil {
+		return nil
+	}
+	t := &SQLTableRef{Parts: parts}
+	var out []string
+	for _, r := range parseColumnRules(p.DeniedColumns) {
+		if r.appliesTo(dialect, defaultScope, t) && !containsFold(out, r.column) {
+			out = append(out, r.column)
+		}
+	}
+	return out
+}
+
+// checkTable returns why ref may not be read, or "".
+func (p *TableAccessPolicy) checkTable(dialect SQLDialect, ref SQLTableRef, defaultScope string) string {
+	name := qualifiedTableName(dialect, ref, defaultScope)
+	switch project := tableProject(dialect, ref); {
+	case dialect == DialectPostgres:
+		if len(p.Databases) > 0 && !containsFold(p.Databases, name[0]) {
+			return fmt.Sprintf("table %s is in database %s, which is not accessible for your squad", ref.Name(), name[0])
+		}
+	case len(p.Datasets) > 0 && project != "" && !strings.EqualFold(project, p.Project):
+		return fmt.Sprintf("table %s is in project %s, which is not accessible for your squad", ref.Name(), project)
+	case len(p.Datasets) > 0 && name[0] == "" && len(name) > 1 && strings.EqualFold(name[1], "INFORMATION_SCHEMA"):
+		return fmt.Sprintf("table %s spans all datasets and is not accessible for your squad", ref.Name())
+	case len(p.Datasets) > 0 && name[0] == "":
+		return fmt.Sprintf("table %s must be qualified with a dataset", ref.Name())
+	case len(p.Datasets) > 0 && !containsFold(p.Datasets, name[0]):
+		return fmt.Sprintf("table %s is in dataset %s, which is not accessible for your squad", ref.Name(), name[0])
+	}
+	if len(p.AllowedTables) > 0 && !matchesAnyTable(p.AllowedTables, name, false) {
+		return fmt.Sprintf("table %s is not in your squad's allowed tables", ref.Name())
+	}
+	if matchesAnyTable(p.DeniedTables, name, true) {
+		return fmt.Sprintf("table %s is not accessible for your squad", ref.Name())
+	}
+	return ""
+}
+
+// qualifiedTableName resolves ref to [dataset, table...] for BigQuery or
+// [database, schema, table] for PostgreSQL. The BigQuery dataset is "" when
+// it cannot be determined or, for region-level INFORMATION_SCHEMA views,
+// when the view spans every dataset.
+func qualifiedTableName(dialect SQLDialect, ref SQLTableRef, defaultScope string) []string {
+	parts := ref.Parts
+	if dialect == DialectPostgres {
+		switch len(parts) {
+		case 1:
+			return []string{defaultScope, "public", parts[0]}
+		case 2:
+			return []string{defaultScope, parts[0], parts[1]}
+		}
+		return parts[len(parts)-3:]
+	}
+	for i, part := range parts {
+		if strings.EqualFold(part, "INFORMATION_SCHEMA") {
+			if i == 0 || strings.HasPrefix(strings.ToLower(parts[i-1]), "region-") {
+				return append([]string{""}, parts[i:]...)
+			}
+			return parts[i-1:]
+		}
+	}
+	if len(parts) == 1 {
+		return []string{defaultScope, parts[0]}
+	}
+	return parts[len(parts)-2:]
+}
+
+// tableProject returns the project a BigQuery path names explicitly, or ""
+// when it resolves to the query's default project.
+func tableProject(dialect SQLDialect, ref SQLTableRef) string {
+	if dialect == DialectPostgres {
+		return ""
+	}
+	parts := ref.Parts
+	for i, part := range parts {
+		if strings.EqualFold(part, "INFORMATION_SCHEMA") {
+			if i >= 2 {
+				return parts[i-2] // project.dataset or project.`region-us`
+			}
+			return ""
+		}
+	}
+	if len(parts) >= 3 {
+		return parts[len(parts)-3]
+	}
+	return ""
+}
+
+// matchesAnyTable reports whether any pattern matches the trailing
+// components of name. For a deny check, a BigQuery wildcard table
+// (events_*) also matches every pattern that names one of its tables.
+func matchesAnyTable(patterns []string, name []string, deny bool) bool {
+	for _, pat := range patterns {
+		if matchTable(strings.Split(pat, "."), name, deny) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchTable(pat, name []string, deny bool) bool {
+	if len(pat) == 0 || len(pat) > len(name) {
+		return false
+	}
+	name = name[len(name)-len(pat):]
+	for i := range pat {
+		p, n := strings.ToLower(pat[i]), strings.ToLower(name[i])
+		if ok, _ := path.Match(p, n); ok {
+			continue
+		}
+		if deny && i == len(pat)-1 && strings.HasSuffix(n, "*") && strings.HasPrefix(p, strings.TrimSuffix(n, "*")) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// columnRule is one DeniedColumns entry: a table pattern (empty for every
+// table) and a column name.
+type columnRule struct {
+	table  []string
+	column string
+}
+
+func parseColumnRules(patterns []string) []columnRule {
+	rules := make([]columnRule, 0, len(patterns))
+	for _, pat := range patterns {
+		parts := strings.Split(pat, ".")
+		rules = append(rules, columnRule{table: parts[:len(parts)-1], column: parts[len(parts)-1]})
+	}
+	return rules
+}
+
+func (r columnRule) appliesTo(dialect SQLDialect, defaultScope string, t *SQLTableRef) bool {
+	return len(r.table) == 0 || matchTable(r.table, qualifiedTableName(dialect, *t, defaultScope), true)
+}
+
+// deniedColumnHits returns a message for each denied column c reads. A
+// qualified reference is checked against the table its qualifier names; an
+// unqualified one, or one whose qualifier is not a table of scope (a CTE,
+// subquery alias or struct column), against every table in scope. A star
+// reads every column of the tables of its own SELECT (own) not listed in
+// EXCEPT, and a reference naming a table of scope itself every column of
+// that table.
+func deniedColumnHits(dialect SQLDialect, defaultScope string, c SQLColumnRef, own, scope []*SQLTableRef, rules []columnRule) []string {
+	var hits []string
+	check := func(column string, tables []*SQLTableRef) {
+		for _, r := range rules {
+			if !strings.EqualFold(r.column, column) {
+				continue
+			}
+			if len(r.table) == 0 {
+				hits = append(hits, fmt.Sprintf("column %s is not accessible for your squad", column))
+				continue
+			}
+			for _, t := range tables {
+				if r.appliesTo(dialect, defaultScope, t) {
+					hits = append(hits, fmt.Sprintf("column %s of table %s is not accessible for your squad", column, t.Name()))
+				}
+			}
+		}
+	}
+
+	if c.Star {
+		tables := own
+		if len(c.Parts) > 0 {
+			t, n := resolveQualifier(c.Parts, scope)
+			if t == nil || n != len(c.Parts) {
+				return nil // a CTE or subquery: its own columns are checked
+			}
+			tables = []*SQLTableRef{t}
+		}
+		for _, t := range tables {
+			for _, r := range rules {
+				if !containsFold(c.Except, r.column) && r.appliesTo(dialect, defaultScope, t) {
+					hits = append(hits, fmt.Sprintf("SELECT * on %s includes column %s, which is not accessible for your squad; list the columns explicitly", t.Name(), r.column))
+				}
+			}
+		}
+		return hits
+	}
+
+	if t, n := resolveQualifier(c.Parts, scope); t != nil && n < len(c.Parts) {
+		check(c.Parts[n], []*SQLTableRef{t})
+		return hits
+	} else if t != nil {
+		// A bare alias or table name used as a value (SELECT u,
+		// TO_JSON_STRING(u), row_to_json(u)) reads the whole row, like u.*.
+		for _, r := range rules {
+			if r.appliesTo(dialect, defaultScope, t) {
+				hits = append(hits, fmt.Sprintf("%s reads every column of %s, including column %s, which is not accessible for your squad; list the columns explicitly", strings.Join(c.Parts, "."), t.Name(), r.column))
+			}
+		}
+		if len(c.Parts) > 1 {
+			return hits
+		}
+	}
+	check(c.Parts[0], scope)
+	if len(c.Parts) > 1 {
+		check(c.Parts[len(c.Parts)-1], scope)
+	}
+	return hits
+}
+
+// resolveQualifier finds the table in scope named by the longest prefix of
+// parts — its alias, or for an unaliased table the trailing components of
+// its path — and returns it with the prefix length.
+func resolveQualifier(parts []string, scope []*SQLTableRef) (*SQLTableRef, int) {
+	for n := len(parts); n >= 1; n-- {
+		prefix := parts[:n]
+		for _, t := range scope {
+			if t.Alias != "" {
+				if n == 1 && strings.EqualFold(t.Alias, prefix[0]) {
+					return t, n
+				}
+				continue
+			}
+			if n <= len(t.Parts) && equalFoldParts(t.Parts[len(t.Parts)-n:], prefix) {
+				return t, n
+			}
+		}
+	}
+	return nil, 0
+}
+
+func equalFoldParts(a, b []string) bool {
+	for i := range a {
+		if !strings.EqualFold(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
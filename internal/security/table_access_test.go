@@ -0,0 +1,239 @@
+package security_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/security"
+)
+
+func accessViolations(t *testing.T, p *security.TableAccessPolicy, sql string, dialect security.SQLDialect, defaultScope string) []security.SQLViolation {
+	t.Helper()
+	a := security.NewSQLValidator().Analyze(sql, dialect)
+	if !a.Valid() {
+		t.Fatalf("Analyze(%q): unexpected violation %s", sql, a.Message())
+	}
+	return p.Check(a, defaultScope)
+}
+
+func TestTableAccess_BigQueryDatasets(t *testing.T) {
+	p := &security.TableAccessPolicy{Project: "my-project", Datasets: []string{"payment_ds", "payment_analytics"}}
+	tests := []struct {
+		sql, dataset string
+		want         string // substring of the first violation; "" = allowed
+	}{
+		{"SELECT id FROM payment_ds.orders", "", ""},
+		{"SELECT id FROM `my-project.payment_analytics.daily`", "", ""},
+		{"SELECT id FROM `MY-PROJECT`.payment_ds.orders", "", ""},
+		{"SELECT * FROM `my-project.payment_ds.INFORMATION_SCHEMA.TABLES`", "", ""},
+		{"SELECT id FROM proj2.payment_ds.orders", "", "project proj2"},
+		{"SELECT id FROM `proj2.payment_analytics.daily`", "", "project proj2"},
+		{"SELECT * FROM proj2.payment_ds.INFORMATION_SCHEMA.TABLES", "", "project proj2"},
+		{"SELECT * FROM proj2.`region-us`.INFORMATION_SCHEMA.TABLES", "", "project proj2"},
+		{"SELECT id FROM orders", "payment_ds", ""},
+		{"SELECT * FROM payment_ds.INFORMATION_SCHEMA.TABLES", "", ""},
+		{"SELECT id FROM user_ds.users", "payment_ds", "dataset user_ds"},
+		{"SELECT o.id FROM payment_ds.orders o JOIN `my-project.user_ds.users` u ON u.id = o.user_id", "", "dataset user_ds"},
+		{"SELECT id FROM payment_ds.orders WHERE user_id IN (SELECT id FROM user_ds.users)", "", "dataset user_ds"},
+		{"WITH u AS (SELECT id FROM user_ds.users) SELECT * FROM u", "", "dataset user_ds"},
+		{"SELECT id FROM orders", "", "must be qualified"},
+		{"SELECT * FROM `region-us`.INFORMATION_SCHEMA.TABLES", "", "spans all datasets"},
+		{"SELECT * FROM ML.PREDICT(MODEL payment_ds.m, TABLE payment_ds.orders)", "", ""},
+		{"SELECT * FROM ML.PREDICT(MODEL payment_ds.m, TABLE user_ds.users)", "", "dataset user_ds"},
+		{"SELECT * FROM ML.PREDICT(MODEL user_ds.m, (SELECT * FROM payment_ds.orders))", "", "dataset user_ds"},
+		{"SELECT * FROM APPENDS(TABLE user_ds.users, NULL, NULL)", "", "dataset user_ds"},
+	}
+	for _, tt := range tests {
+		vs := accessViolations(t, p, tt.sql, security.DialectBigQuery, tt.dataset)
+		switch {
+		case tt.want == "" && len(vs) > 0:
+			t.Errorf("%q: unexpected violation %q", tt.sql, vs[0].Message)
+		case tt.want != "" && len(vs) == 0:
+			t.Errorf("%q: expected violation containing %q", tt.sql, tt.want)
+		case tt.want != "" && !strings.Contains(vs[0].Message, tt.want):
+			t.Errorf("%q: violation %q, want it to contain %q", tt.sql, vs[0].Message, tt.want)
+		case tt.want != "" && vs[0].Code != security.ViolationTableNotAllowed:
+			t.Errorf("%q: code %q, want %q", tt.sql, vs[0].Code, security.ViolationTableNotAllowed)
+		}
+	}
+}
+
+func TestTableAccess_PostgresDatabases(t *testing.T) {
+	p := &security.TableAccessPolicy{Databases: []string{"payment_db"}}
+	if vs := accessViolations(t, p, "SELECT id FROM public.orders", security.DialectPostgres, "payment_db"); len(vs) != 0 {
+		t.Errorf("unexpected violation %q", vs[0].Message)
+	}
+	vs := accessViolations(t, p, "SELECT id FROM user_db.public.users", security.DialectPostgres, "payment_db")
+	if len(vs) != 1 || !strings.Contains(vs[0].Message, "database user_db") {
+		t.Errorf("violations = %+v, want one naming database user_db", vs)
+	}
+}
+
+func TestTableAccess_AllowedAndDeniedTables(t *testing.T) {
+	p := &security.TableAccessPolicy{
+		AllowedTables: []string{"payment_ds.*", "shared.fx_rates"},
+		DeniedTables:  []string{"payment_ds.payouts_raw", "audit_*"},
+	}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT * FROM payment_ds.orders JOIN shared.fx_rates USING (currency)", ""},
+		{"SELECT * FROM shared.users", "not in your squad's allowed tables"},
+		{"SELECT * FROM payment_ds.payouts_raw", "payment_ds.payouts_raw is not accessible"},
+		{"SELECT * FROM payment_ds.audit_log", "payment_ds.audit_log is not accessible"},
+		{"SELECT * FROM `proj.payment_ds.payouts_*`", "is not accessible"}, // wildcard covers a denied table
+	}
+	for _, tt := range tests {
+		vs := accessViolations(t, p, tt.sql, security.DialectBigQuery, "")
+		if tt.want == "" {
+			if len(vs) > 0 {
+				t.Errorf("%q: unexpected violation %q", tt.sql, vs[0].Message)
+			}
+			continue
+		}
+		if len(vs) == 0 || !strings.Contains(vs[0].Message, tt.want) {
+			t.Errorf("%q: violations = %+v, want one containing %q", tt.sql, vs, tt.want)
+		}
+	}
+
+	pg := &security.TableAccessPolicy{DeniedTables: []string{"public.api_tokens"}}
+	if vs := accessViolations(t, pg, "SELECT * FROM api_tokens", security.DialectPostgres, "app"); len(vs) != 1 {
+		t.Errorf("unqualified PG table should resolve to public: got %+v", vs)
+	}
+}
+
+func TestTableAccess_DeniedColumns(t *testing.T) {
+	p := &security.TableAccessPolicy{DeniedColumns: []string{"users.email", "national_id"}}
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT id, name FROM ds.users", ""},
+		{"SELECT email FROM ds.contacts", ""}, // email is only denied on users
+		{"SELECT name AS email FROM ds.users", ""},
+		{"SELECT * EXCEPT (email, national_id) FROM ds.users", ""},
+		{"SELECT o.id, c.email FROM ds.orders o JOIN ds.contacts c ON c.id = o.contact_id", ""},
+		{"SELECT * FROM (SELECT id FROM ds.users)", ""},
+		{"SELECT email FROM ds.users", "column email of table ds.users"},
+		{"SELECT u.email FROM ds.users u", "column email of table ds.users"},
+		{"SELECT users.email FROM ds.users", "column email of table ds.users"},
+		{"SELECT id FROM ds.users WHERE email LIKE '%@corp.com'", "column email"},
+		{"SELECT national_id FROM ds.drivers", "column national_id is not accessible"},
+		{"SELECT * FROM ds.users", "SELECT * on ds.users includes column email"},
+		{"SELECT u.* FROM ds.orders o JOIN ds.users u ON u.id = o.user_id", "SELECT * on ds.users"},
+		{"SELECT * EXCEPT (email) FROM ds.users", "includes column national_id"},
+		{"WITH x AS (SELECT email FROM ds.users) SELECT * FROM x", "column email of table ds.users"},
+		{"SELECT u FROM ds.users u", "u reads every column of ds.users, including column email"},
+		{"SELECT TO_JSON_STRING(u) FROM ds.users u", "u reads every column of ds.users, including column email"},
+		{"SELECT TO_JSON_STRING(users) FROM ds.users", "users reads every column of ds.users"},
+		{"WITH x AS (SELECT id FROM ds.users) SELECT TO_JSON_STRING(x) FROM x", ""},
+		{"SELECT * FROM ML.PREDICT(MODEL ds.m, TABLE ds.users)", "SELECT * on ds.users includes column email"},
+		{"SELECT email FROM ML.PREDICT(MODEL ds.m, TABLE ds.users)", "column email of table ds.users"},
+	}
+	for _, tt := range tests {
+		vs := accessViolations(t, p, tt.sql, security.DialectBigQuery, "")
+		if tt.want == "" {
+			if len(vs) > 0 {
+				t.Errorf("%q: unexpected violation %q", tt.sql, vs[0].Message)
+			}
+			continue
+		}
+		if len(vs) == 0 || !strings.Contains(vs[0].Message, tt.want) {
+			t.Errorf("%q: violations = %+v, want one containing %q", tt.sql, vs, tt.want)
+		} else if vs[0].Code != security.ViolationColumnNotAllowed {
+			t.Errorf("%q: code %q, want %q", tt.sql, vs[0].Code, security.ViolationColumnNotAllowed)
+		}
+	}
+}
+
+func TestTableAccess_UnqualifiedColumnFromOuterQuery(t *testing.T) {
+	// An unqualified column in a correlated subquery may belong to any
+	// enclosing SELECT's tables.
+	p := &security.TableAccessPolicy{DeniedColumns: []string{"orders.email"}}
+	sql := "SELECT o.id FROM ds.orders o WHERE EXISTS (SELECT 1 FROM ds.tickets t WHERE t.note = email)"
+	vs := accessViolations(t, p, sql, security.DialectBigQuery, "")
+	if len(vs) != 1 || !strings.Contains(vs[0].Message, "column email of table ds.orders") {
+		t.Errorf("violations = %+v, want email of ds.orders", vs)
+	}
+}
+
+func TestTableAccess_DeniedColumnsPostgres(t *testing.T) {
+	p := &security.TableAccessPolicy{DeniedColumns: []string{"public.customers.phone"}}
+	if vs := accessViolations(t, p, "SELECT c.phone::text FROM customers c", security.DialectPostgres, "payment_db"); len(vs) != 1 {
+		t.Errorf("violations = %+v, want one", vs)
+	}
+	if vs := accessViolations(t, p, "SELECT id, created_at::date FROM customers", security.DialectPostgres, "payment_db"); len(vs) != 0 {
+		t.Errorf("unexpected violations %+v", vs)
+	}
+	vs := accessViolations(t, p, "SELECT row_to_json(c) FROM customers c", security.DialectPostgres, "payment_db")
+	if len(vs) != 1 || !strings.Contains(vs[0].Message, "c reads every column of customers, including column phone") {
+		t.Errorf("row_to_json(c): violations = %+v, want the whole row flagged", vs)
+	}
+}
+
+func TestTableAccess_NilPolicyAllowsEverything(t *testing.T) {
+	var p *security.TableAccessPolicy
+	if vs := accessViolations(t, p, "SELECT * FROM other.secrets", security.DialectBigQuery, ""); len(vs) != 0 {
+		t.Errorf("nil policy returned %+v", vs)
+	}
+}
+
+func TestTableAccess_ProjectViolation(t *testing.T) {
+	p := &security.TableAccessPolicy{Project: "my-project", Datasets: []string{"payment_ds"}}
+	for project, denied := range map[string]bool{"": false, "my-project": false, "MY-PROJECT": false, "proj2": true} {
+		if msg := p.ProjectViolation(project); (msg != "") != denied {
+			t.Errorf("ProjectViolation(%q) = %q, want denied=%v", project, msg, denied)
+		}
+	}
+	noProject := &security.TableAccessPolicy{Datasets: []string{"payment_ds"}}
+	if vs := accessViolations(t, noProject, "SELECT id FROM `my-project.payment_ds.orders`", security.DialectBigQuery, ""); len(vs) == 0 {
+		t.Error("policy without a project must reject project-qualified paths")
+	}
+	unrestricted := &security.TableAccessPolicy{DeniedTables: []string{"secrets"}}
+	if msg := unrestricted.ProjectViolation("proj2"); msg != "" {
+		t.Errorf("policy without datasets: unexpected violation %q", msg)
+	}
+}
+
+func TestTableAccessPolicyFingerprint(t *testing.T) {
+	a := &security.TableAccessPolicy{Datasets: []string{"ds"}, RowFilters: []security.RowFilter{{Table: "orders", Predicate: "merchant_id = 1"}}}
+	same := &security.TableAccessPolicy{Datasets: []string{"ds"}, RowFilters: []security.RowFilter{{Table: "orders", Predicate: "merchant_id = 1"}}}
+	if a.Fingerprint() != same.Fingerprint() {
+		t.Error("equal policies must have equal fingerprints")
+	}
+	for name, p := range map[string]*security.TableAccessPolicy{
+		"row filter":    {Datasets: []string{"ds"}, RowFilters: []security.RowFilter{{Table: "orders", Predicate: "merchant_id = 2"}}},
+		"denied column": {Datasets: []string{"ds"}, RowFilters: a.RowFilters, DeniedColumns: []string{"email"}},
+		"nil":           nil,
+	} {
+		if p.Fingerprint() == a.Fingerprint() {
+			t.Errorf("%s: fingerprint matches a different policy", name)
+		}
+	}
+}
+
+func TestTableAccess_TableViolationAndDeniedColumnsFor(t *testing.T) {
+	p := &security.TableAccessPolicy{
+		Datasets:      []string{"payment_ds"},
+		DeniedTables:  []string{"payment_ds.secrets"},
+		DeniedColumns: []string{"users.email", "national_id", "orders.card_number"},
+	}
+	if msg := p.TableViolation(security.DialectBigQuery, []string{"payment_ds", "users"}, ""); msg != "" {
+		t.Errorf("payment_ds.users: unexpected violation %q", msg)
+	}
+	for _, parts := range [][]string{{"payment_ds", "secrets"}, {"user_ds", "users"}} {
+		if msg := p.TableViolation(security.DialectBigQuery, parts, ""); msg == "" {
+			t.Errorf("%v: expected a violation", parts)
+		}
+	}
+	if got := p.DeniedColumnsFor(security.DialectBigQuery, []string{"payment_ds", "users"}, ""); !slices.Equal(got, []string{"email", "national_id"}) {
+		t.Errorf("DeniedColumnsFor(users) = %v, want [email national_id]", got)
+	}
+
+	var none *security.TableAccessPolicy
+	if none.TableViolation(security.DialectPostgres, []string{"public", "users"}, "db") != "" || none.DeniedColumnsFor(security.DialectPostgres, []string{"users"}, "db") != nil {
+		t.Error("nil policy must allow every table and column")
+	}
+}
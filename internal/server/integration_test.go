@@ -13,6 +13,7 @@
 //   - CORS preflight handling
 //   - Rate limiting (429)
 //   - GET /api/v1/me — user profile + permissions
+//   - GET /api/v1/usage — budget usage and remaining quota
 //   - POST /api/v1/query-agent — prompt/PII security validation through HTTP
 //   - DELETE /api/v1/cache/responses — admin-only cache flush
 package server_test
@@ -32,6 +33,7 @@ import (
 	chiMiddleware "github.com/go-chi/chi/v5/middleware"
 
 	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/cache"
 	"github.com/cortexai/cortexai/internal/config"
 	"github.com/cortexai/cortexai/internal/handler"
 	"github.com/cortexai/cortexai/internal/middleware"
@@ -49,11 +51,11 @@ import (
 // stub lets integration tests verify 400/403 rejection paths without credentials.
 type stubLLMRunner struct{}
 
-func (s *stubLLMRunner) Run(_ context.Context, _, _ string, _ []tools.Tool) (string, []string, string, error) {
-	return "stub answer", nil, "", nil
+func (s *stubLLMRunner) Run(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ agent.RunOptions) (*agent.RunResult, error) {
+	return &agent.RunResult{Text: "stub answer"}, nil
 }
-func (s *stubLLMRunner) RunWithEmit(_ context.Context, _, _ string, _ []tools.Tool, _ agent.EmitFn) (string, []string, string, error) {
-	return "stub answer", nil, "", nil
+func (s *stubLLMRunner) RunWithEmit(_ context.Context, _, _ string, _ []models.ConversationTurn, _ []tools.Tool, _ agent.RunOptions, _ agent.EmitFn) (*agent.RunResult, error) {
+	return &agent.RunResult{Text: "stub answer"}, nil
 }
 func (s *stubLLMRunner) Model() string { return "stub-model" }
 
@@ -93,6 +95,8 @@ func buildTestServer(t *testing.T) *httptest.Server {
 	})
 	promptVal   := security.NewPromptValidator()
 	sqlVal      := security.NewSQLValidator()
+	budget      := security.NewBudgetTracker(cache.NewMemoryStore().Namespace("budget"))
+	budget.SetSquadQuota("payment", security.BudgetQuota{MonthlyBytes: 100_000_000_000}, security.BudgetQuota{DailyBytes: 10_000_000_000})
 	costTracker := security.NewCostTracker(0) // 0 = no byte limit
 	dataMasker  := security.NewDataMasker([]string{"email", "phone", "password"})
 	auditLogger := security.NewAuditLogger(false)
@@ -123,14 +127,15 @@ func buildTestServer(t *testing.T) *httptest.Server {
 	bqH := agent.NewBigQueryHandler(
 		stub, nil,
 		piiDetector, promptVal, sqlVal, costTracker, dataMasker, auditLogger,
-		5*time.Minute,
+		5*time.Minute, nil,
 	)
 
 	// Handlers
 	healthH := handler.NewHealthHandler(nil, nil) // BQ/ES disabled → "disabled" in checks
 	userH   := handler.NewUserHandler()
+	usageH  := handler.NewUsageHandler(budget)
 	router  := service.NewIntentRouter()
-	agentH  := handler.NewAgentHandler(bqH, nil, nil, router, llmPool, personas)
+	agentH  := handler.NewAgentHandler(bqH, nil, nil, nil, router, llmPool, personas, service.NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), 0, 0))
 	cacheH  := handler.NewCacheHandler(bqH, nil)
 
 	// Chi router
@@ -151,6 +156,7 @@ func buildTestServer(t *testing.T) *httptest.Server {
 
 		r.Route("/api/v1", func(r chi.Router) {
 			r.Get("/me", userH.Me)
+			r.Get("/usage", usageH.Usage)
 
 			r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
 				Post("/query-agent", agentH.QueryAgent)
@@ -407,6 +413,44 @@ func TestIntegration_Me_Viewer_LimitedPermissions(t *testing.T) {
 	}
 }
 
+func TestIntegration_Usage_SquadMember(t *testing.T) {
+	srv := buildTestServer(t)
+	defer srv.Close()
+
+	resp := get(t, srv, "/api/v1/usage", keyViewer)
+	assertStatus(t, resp, http.StatusOK)
+	body := decodeJSON(t, resp)
+
+	if body["user_id"] != "u4" || body["squad_id"] != "payment" {
+		t.Errorf("usage subject: got user=%v squad=%v", body["user_id"], body["squad_id"])
+	}
+	user, _ := body["user"].(map[string]interface{})
+	daily, _ := user["daily"].(map[string]interface{})
+	if daily["bytes_limit"] != float64(10_000_000_000) || daily["bytes_remaining"] != float64(10_000_000_000) {
+		t.Errorf("user daily budget: %v", daily)
+	}
+	squad, _ := body["squad"].(map[string]interface{})
+	monthly, _ := squad["monthly"].(map[string]interface{})
+	if monthly["bytes_limit"] != float64(100_000_000_000) {
+		t.Errorf("squad monthly budget: %v", monthly)
+	}
+}
+
+func TestIntegration_Usage_NoSquad(t *testing.T) {
+	srv := buildTestServer(t)
+	defer srv.Close()
+
+	body := decodeJSON(t, get(t, srv, "/api/v1/usage", keyAdmin))
+	if _, ok := body["squad"]; ok {
+		t.Errorf("user without squad should have no squad usage: %v", body)
+	}
+	user, _ := body["user"].(map[string]interface{})
+	daily, _ := user["daily"].(map[string]interface{})
+	if _, ok := daily["bytes_limit"]; ok {
+		t.Errorf("unlimited user should have no limit: %v", daily)
+	}
+}
+
 // ── 4. RBAC ───────────────────────────────────────────────────────────────────
 
 func TestIntegration_RBAC_Viewer_CannotQueryAgent(t *testing.T) {
@@ -876,6 +920,55 @@ func TestIntegration_QueryAgent_Metadata_ModelAndPersona(t *testing.T) {
 	}
 }
 
+// ── 11b. Streaming endpoint ──────────────────────────────────────────────────
+
+func TestIntegration_QueryAgentStream_Elasticsearch_NotConfigured_503(t *testing.T) {
+	srv := buildTestServer(t)
+	defer srv.Close()
+
+	// ES handler is nil in the test server — streaming must fail with 503 before
+	// SSE headers are written, the same as the non-streaming endpoint.
+	resp := postJSON(t, srv, "/api/v1/query-agent/stream", keyAnalyst, map[string]interface{}{
+		"prompt":      "cari log error untuk trace id abc123",
+		"data_source": "elasticsearch",
+	})
+	assertStatus(t, resp, http.StatusServiceUnavailable)
+	resp.Body.Close()
+}
+
+func TestIntegration_QueryAgent_Federated_NotConfigured_503(t *testing.T) {
+	srv := buildTestServer(t)
+	defer srv.Close()
+
+	// The test server has no federated handler: both endpoints must fail with
+	// 503 (and the stream before SSE headers are written).
+	for _, path := range []string{"/api/v1/query-agent", "/api/v1/query-agent/stream"} {
+		resp := postJSON(t, srv, path, keyAnalyst, map[string]interface{}{
+			"prompt":      "merchant mana yang punya error log kemarin dan berapa revenue mereka",
+			"data_source": "federated",
+		})
+		assertStatus(t, resp, http.StatusServiceUnavailable)
+		resp.Body.Close()
+	}
+}
+
+// ── 11c. Conversations ───────────────────────────────────────────────────────
+
+func TestIntegration_QueryAgent_UnknownConversation_404(t *testing.T) {
+	srv := buildTestServer(t)
+	defer srv.Close()
+
+	for _, path := range []string{"/api/v1/query-agent", "/api/v1/query-agent/stream"} {
+		resp := postJSON(t, srv, path, keyAnalyst, map[string]interface{}{
+			"prompt":          "sekarang breakdown per region",
+			"conversation_id": "does-not-exist",
+		})
+		assertStatus(t, resp, http.StatusNotFound)
+		body := readBody(t, resp)
+		assertContains(t, body, "conversation not found", path)
+	}
+}
+
 // ── 12. 404 on unknown routes ─────────────────────────────────────────────────
 
 func TestIntegration_UnknownRoute_404(t *testing.T) {
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/config"
+)
+
+// llmRunner is an LLMRunner whose LLM calls follow a RetryPolicy.
+type llmRunner interface {
+	agent.LLMRunner
+	SetRetryPolicy(agent.RetryPolicy)
+}
+
+// llmProviderFactory builds the runner for target. ok is false when the
+// provider is not configured, e.g. its API key is missing.
+type llmProviderFactory func(cfg *config.Config, target config.LLMTargetConfig) (runner llmRunner, ok bool)
+
+// llmProviders is the provider registry: every name in config.LLMProviders
+// maps to the factory of its runner.
+var llmProviders = map[string]llmProviderFactory{
+	"anthropic": func(cfg *config.Config, target config.LLMTargetConfig) (llmRunner, bool) {
+		if cfg.AnthropicAPIKey == "" {
+			return nil, false
+		}
+		return agent.NewCortexAgent(cfg.AnthropicAPIKey, target.Model, orDefault(target.BaseURL, cfg.AnthropicBaseURL)), true
+	},
+	"deepseek": func(cfg *config.Config, target config.LLMTargetConfig) (llmRunner, bool) {
+		if cfg.DeepSeekAPIKey == "" {
+			return nil, false
+		}
+		return agent.NewDeepSeekAgent(cfg.DeepSeekAPIKey, target.Model, orDefault(target.BaseURL, cfg.DeepSeekBaseURL)), true
+	},
+	"openai": func(cfg *config.Config, target config.LLMTargetConfig) (llmRunner, bool) {
+		baseURL := orDefault(target.BaseURL, cfg.OpenAIBaseURL)
+		// Self-hosted servers need no key, but api.openai.com does.
+		if cfg.OpenAIAPIKey == "" && baseURL == "" {
+			return nil, false
+		}
+		return agent.NewOpenAIAgent(agent.OpenAIOptions{
+			APIKey:     cfg.OpenAIAPIKey,
+			Model:      target.Model,
+			BaseURL:    baseURL,
+			APIVersion: cfg.OpenAIAPIVersion,
+		}), true
+	},
+	"ollama": func(cfg *config.Config, target config.LLMTargetConfig) (llmRunner, bool) {
+		return agent.NewOllamaAgent(cfg.OllamaAPIKey, target.Model, orDefault(target.BaseURL, cfg.OllamaBaseURL)), true
+	},
+}
+
+// newLLMRunner builds the runner for target with the configured retry policy.
+// An empty provider means "anthropic"; unknown providers are rejected by
+// config.Validate and reported as not configured here.
+func newLLMRunner(cfg *config.Config, target config.LLMTargetConfig) (agent.LLMRunner, bool) {
+	provider := orDefault(target.Provider, "anthropic")
+	factory, known := llmProviders[provider]
+	if !known {
+		return nil, false
+	}
+	runner, ok := factory(cfg, target)
+	if !ok {
+		return nil, false
+	}
+	runner.SetRetryPolicy(llmRetryPolicy(cfg))
+	return runner, true
+}
+
+func orDefault(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
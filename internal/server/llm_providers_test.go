@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/config"
+)
+
+func TestLLMProviders_RegistryMatchesConfig(t *testing.T) {
+	for _, name := range config.LLMProviders {
+		if llmProviders[name] == nil {
+			t.Errorf("provider %q passes config validation but has no runner", name)
+		}
+	}
+	if len(llmProviders) != len(config.LLMProviders) {
+		t.Errorf("registry has %d providers, config.LLMProviders %d", len(llmProviders), len(config.LLMProviders))
+	}
+}
+
+func TestNewLLMRunner(t *testing.T) {
+	cfg := &config.Config{OllamaBaseURL: "http://ollama:11434"}
+	tests := []struct {
+		target config.LLMTargetConfig
+		ok     bool
+	}{
+		{config.LLMTargetConfig{Provider: "ollama", Model: "qwen2.5"}, true},
+		{config.LLMTargetConfig{Provider: "openai", Model: "gpt-4o"}, false}, // no key, no self-hosted URL
+		{config.LLMTargetConfig{Provider: "openai", Model: "local", BaseURL: "http://vllm:8000/v1"}, true},
+		{config.LLMTargetConfig{Provider: "", Model: "glm-4.5-air"}, false}, // anthropic without key
+		{config.LLMTargetConfig{Provider: "mistral", Model: "x"}, false},
+	}
+	for _, tt := range tests {
+		runner, ok := newLLMRunner(cfg, tt.target)
+		if ok != tt.ok {
+			t.Errorf("newLLMRunner(%+v) ok = %v, want %v", tt.target, ok, tt.ok)
+			continue
+		}
+		if ok && runner.Model() != tt.target.Model {
+			t.Errorf("runner model = %q, want %q", runner.Model(), tt.target.Model)
+		}
+	}
+	if runner, _ := newLLMRunner(cfg, config.LLMTargetConfig{Provider: "ollama", Model: "qwen2.5"}); runner != nil {
+		if _, isOllama := runner.(*agent.OllamaAgent); !isOllama {
+			t.Errorf("ollama runner is %T", runner)
+		}
+	}
+}
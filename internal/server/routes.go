@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/cortexai/cortexai/internal/agent"
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/config"
 	"github.com/cortexai/cortexai/internal/handler"
 	"github.com/cortexai/cortexai/internal/middleware"
 	"github.com/cortexai/cortexai/internal/models"
@@ -75,7 +77,18 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 	}
 
 	// ─── User Store ───────────────────────────────────────────────────────────────
-	// Convert config types → service entry types
+	// Convert config types → service entry types. Row filters are dropped
+	// unless row-level security is enabled.
+	rowFilters := func(fs []config.RowFilterConfig) []models.RowFilter {
+		if !cfg.EnableRowLevelSecurity || len(fs) == 0 {
+			return nil
+		}
+		out := make([]models.RowFilter, len(fs))
+		for i, f := range fs {
+			out[i] = models.RowFilter{Table: f.Table, Predicate: f.Predicate}
+		}
+		return out
+	}
 	squadEntries := make([]service.SquadEntry, len(cfg.Squads))
 	for i, s := range cfg.Squads {
 		var pgDatabases []string
@@ -88,6 +101,10 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 			Datasets:        s.Datasets,
 			ESIndexPatterns: s.ESIndexPatterns,
 			PGDatabases:     pgDatabases,
+			AllowedTables:   s.AllowedTables,
+			DeniedTables:    s.DeniedTables,
+			DeniedColumns:   s.DeniedColumns,
+			RowFilters:      rowFilters(s.RowFilters),
 		}
 	}
 	userEntries := make([]service.UserEntry, len(cfg.Users))
@@ -99,6 +116,8 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 			APIKey:  u.APIKey,
 			SquadID: u.SquadID,
 			Persona: u.Persona,
+
+			RowFilters: rowFilters(u.RowFilters),
 		}
 	}
 	userStore := service.NewUserStore(userEntries, squadEntries, cfg.APIKeys)
@@ -115,6 +134,7 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 		Bool("data_masking", cfg.EnableDataMasking).
 		Bool("audit_logging", cfg.EnableAuditLogging).
 		Bool("pii_detection", cfg.EnablePIIDetection).
+		Bool("row_level_security", cfg.EnableRowLevelSecurity).
 		Msg("service configuration")
 
 	if bqSvc == nil && !cfg.ElasticsearchEnabled && pgRegistry == nil {
@@ -129,7 +149,28 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 	promptVal := security.NewPromptValidator()
 	sqlVal := security.NewSQLValidator()
 	esPromptVal := security.NewESPromptValidator()
-	costTracker := security.NewCostTracker(cfg.MaxQueryBytesProcessed)
+	// The cache backend also holds the budget counters, so with redis every
+	// replica enforces the same quotas.
+	caches := openCacheStore(cfg)
+	s.cacheStore = caches
+	budget := security.NewBudgetTracker(caches.Namespace("budget"))
+	budgeted := false
+	for _, sq := range cfg.Squads {
+		budget.SetSquadQuota(sq.ID, budgetQuota(sq.Budget), budgetQuota(sq.UserBudget))
+		budgeted = budgeted || sq.Budget != nil || sq.UserBudget != nil
+	}
+	if _, shared := caches.(*cache.RedisStore); budgeted && !shared {
+		log.Warn().Msg("squad budgets are counted per replica; behind several replicas each admits the full quota. Use cache_backend=redis to share them")
+	}
+	costTracker := security.NewCostTracker(cfg.MaxQueryBytesProcessed).
+		WithBudget(budget, func(apiKey string) (security.BudgetSubject, bool) {
+			u, ok := userStore.GetByKey(apiKey)
+			if !ok {
+				return security.BudgetSubject{}, false
+			}
+			return security.BudgetSubject{UserID: u.ID, SquadID: u.SquadID}, true
+		}).
+		WithLLMPrices(llmPriceTable(cfg.LLMPrices))
 	dataMasker := security.NewDataMasker(cfg.SensitiveColumns)
 	auditLogger := security.NewAuditLogger(cfg.EnableAuditLogging)
 
@@ -137,55 +178,42 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 	// Build a fallback runner from the legacy LLMProvider config (backward compat).
 	// This runner is used for users with no persona or an unknown persona.
 	llmPool := agent.NewLLMPool()
-	switch cfg.LLMProvider {
-	case "deepseek":
-		if cfg.DeepSeekAPIKey != "" {
-			model := cfg.ModelList["deepseek"]
-			llmPool.SetFallback(agent.NewDeepSeekAgent(cfg.DeepSeekAPIKey, model, cfg.DeepSeekBaseURL))
-			log.Info().Str("provider", "deepseek").Str("model", model).Msg("AI fallback runner initialized")
-		} else {
-			log.Warn().Msg("LLM_PROVIDER=deepseek but DEEPSEEK_API_KEY not set - AI agent disabled")
-		}
-	default: // "anthropic" + GLM via Z.ai
-		if cfg.AnthropicAPIKey != "" {
-			model := cfg.ModelList["anthropic"]
-			llmPool.SetFallback(agent.NewCortexAgent(cfg.AnthropicAPIKey, model, cfg.AnthropicBaseURL))
-			log.Info().Str("provider", "anthropic").Str("model", model).Msg("AI fallback runner initialized")
-		} else {
-			log.Warn().Msg("ANTHROPIC_API_KEY not set - AI agent disabled")
-		}
+	fallbackProvider := cfg.LLMProvider
+	if fallbackProvider == "" {
+		fallbackProvider = "anthropic" // + GLM via Z.ai
+	}
+	fallbackModel := cfg.ModelList[fallbackProvider]
+	if runner, ok := newLLMRunner(cfg, config.LLMTargetConfig{Provider: fallbackProvider, Model: fallbackModel}); ok {
+		llmPool.SetFallback(runner)
+		log.Info().Str("provider", fallbackProvider).Str("model", runner.Model()).Msg("AI fallback runner initialized")
+	} else {
+		log.Warn().Str("provider", fallbackProvider).Msg("LLM provider API key not set - AI agent disabled")
 	}
 
 	// Register per-persona runners. Personas sharing the same provider+model reuse
 	// the same LLMRunner instance (LLMPool deduplicates by PoolKey).
+	llmPool.SetCircuitBreaker(cfg.LLMBreakerThreshold, time.Duration(cfg.LLMBreakerCooldown)*time.Second)
 	for name, pc := range cfg.Personas {
-		var runner agent.LLMRunner
-		apiKey := cfg.AnthropicAPIKey
-		baseURL := cfg.AnthropicBaseURL
-		switch pc.Provider {
-		case "deepseek":
-			apiKey = cfg.DeepSeekAPIKey
-			baseURL = cfg.DeepSeekBaseURL
-			if pc.BaseURL != "" {
-				baseURL = pc.BaseURL
-			}
-			if apiKey != "" {
-				runner = agent.NewDeepSeekAgent(apiKey, pc.Model, baseURL)
-			}
-		default: // "anthropic"
-			if pc.BaseURL != "" {
-				baseURL = pc.BaseURL
-			}
-			if apiKey != "" {
-				runner = agent.NewCortexAgent(apiKey, pc.Model, baseURL)
+		target := config.LLMTargetConfig{Provider: pc.Provider, Model: pc.Model, BaseURL: pc.BaseURL}
+		key, ok := registerLLMRunner(llmPool, cfg, target)
+		if !ok {
+			log.Warn().Str("persona", name).Str("provider", pc.Provider).Msg("persona skipped: missing API key")
+			continue
+		}
+		log.Info().Str("persona", name).Str("provider", pc.Provider).Str("model", pc.Model).Msg("persona LLM registered")
+
+		// Failover chain: fallbacks without an API key are left out.
+		var chain []string
+		for _, fb := range pc.Fallbacks {
+			if fbKey, ok := registerLLMRunner(llmPool, cfg, fb); ok {
+				chain = append(chain, fbKey)
+			} else {
+				log.Warn().Str("persona", name).Str("provider", fb.Provider).Msg("persona fallback skipped: missing API key")
 			}
 		}
-		if runner != nil {
-			key := agent.PoolKey(pc.Provider, pc.Model)
-			llmPool.Register(key, runner)
-			log.Info().Str("persona", name).Str("provider", pc.Provider).Str("model", pc.Model).Msg("persona LLM registered")
-		} else {
-			log.Warn().Str("persona", name).Str("provider", pc.Provider).Msg("persona skipped: missing API key")
+		if len(chain) > 0 {
+			llmPool.SetChain(key, chain...)
+			log.Info().Str("persona", name).Strs("fallbacks", chain).Msg("persona LLM failover chain configured")
 		}
 	}
 
@@ -195,12 +223,23 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 	// FIX #6: pass bqSvc and esSvc to health handler for dependency checks
 	healthH := handler.NewHealthHandler(bqSvc, esSvc)
 	userH := handler.NewUserHandler()
+	usageH := handler.NewUsageHandler(budget)
+
+	squadGlossaries := make(map[string][]service.GlossaryTerm, len(cfg.Squads))
+	for _, sq := range cfg.Squads {
+		if len(sq.Glossary) > 0 {
+			squadGlossaries[sq.ID] = glossaryTerms(sq.Glossary)
+		}
+	}
+	glossary := service.NewGlossary(glossaryTerms(cfg.Glossary), squadGlossaries)
+	glossaryH := handler.NewGlossaryHandler(glossary)
 
 	var datasetsH *handler.DatasetsHandler
 	var tablesH *handler.TablesHandler
 	var queryH *handler.QueryHandler
 	var agentH *handler.AgentHandler
 	var cacheH *handler.CacheHandler
+	var examplesH *handler.ExamplesHandler
 
 	if bqSvc != nil {
 		datasetsH = handler.NewDatasetsHandler(bqSvc)
@@ -215,23 +254,93 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 		var bqAgentH *agent.BigQueryHandler
 		var esAgentH *agent.ElasticsearchHandler
 		var pgAgentH *agent.PostgresHandler
+		var fedAgentH *agent.FederatedHandler
 
 		// Pass the pool fallback to handler constructors for the stored h.agent field.
 		// Handle() and HandleStream() use the runner parameter passed per-request instead.
 		fallbackRunner := llmPool.Get("")
 		schemaTTL := time.Duration(cfg.SchemaCacheTTL) * time.Minute
 		if bqSvc != nil {
-			bqAgentH = agent.NewBigQueryHandler(fallbackRunner, bqSvc, piiDetector, promptVal, sqlVal, costTracker, dataMasker, auditLogger, schemaTTL)
+			bqAgentH = agent.NewBigQueryHandler(fallbackRunner, bqSvc, piiDetector, promptVal, sqlVal, costTracker, dataMasker, auditLogger, schemaTTL, caches)
 		}
 		if esSvc != nil {
 			esAgentH = agent.NewElasticsearchHandler(fallbackRunner, esSvc, piiDetector, promptVal, esPromptVal, auditLogger)
 		}
 		if pgRegistry != nil {
-			pgAgentH = agent.NewPostgresHandler(fallbackRunner, pgRegistry, piiDetector, promptVal, sqlVal, pgCostTracker, dataMasker, auditLogger, schemaTTL)
+			pgAgentH = agent.NewPostgresHandler(fallbackRunner, pgRegistry, piiDetector, promptVal, sqlVal, pgCostTracker, dataMasker, auditLogger, schemaTTL, caches)
+		}
+		if cfg.AgentMaxRepairRounds != 0 {
+			if bqAgentH != nil {
+				bqAgentH.SetMaxRepairRounds(cfg.AgentMaxRepairRounds)
+			}
+			if pgAgentH != nil {
+				pgAgentH.SetMaxRepairRounds(cfg.AgentMaxRepairRounds)
+			}
+		}
+		exampleOpts := agent.ExampleOptions{MaxPerPrompt: cfg.FewShotExamples}
+		if cfg.FewShotEmbeddings && cfg.SemanticCacheEmbeddingURL != "" {
+			exampleOpts.Embedder = service.NewOpenAIEmbedder(cfg.SemanticCacheEmbeddingURL, cfg.SemanticCacheEmbeddingKey, cfg.SemanticCacheEmbeddingModel)
+		}
+		// Examples are curated data: they live in their own file store, not
+		// in the cache backend, whose entries are lost on restart or evicted.
+		var examples *agent.ExampleStore
+		if cfg.ExamplesDir == "" {
+			log.Info().Msg("few-shot examples disabled: examples_dir is not set")
+		} else if exampleFiles, err := cache.NewFileStore(cfg.ExamplesDir); err != nil {
+			log.Warn().Err(err).Msg("few-shot examples disabled")
+		} else {
+			examples = agent.NewExampleStore(exampleFiles.Namespace("agent_examples"), exampleOpts)
+			examplesH = handler.NewExamplesHandler(examples, sqlVal)
+		}
+		if bqAgentH != nil {
+			bqAgentH.SetGlossary(glossary)
+			bqAgentH.SetExamples(examples)
+		}
+		if pgAgentH != nil {
+			pgAgentH.SetGlossary(glossary)
+			pgAgentH.SetExamples(examples)
+		}
+		if cfg.SchemaMaxTables != 0 || cfg.SchemaEmbeddings {
+			opts := agent.SchemaSelectionOptions{MaxTables: cfg.SchemaMaxTables}
+			if cfg.SchemaEmbeddings && cfg.SemanticCacheEmbeddingURL != "" {
+				opts.Embedder = service.NewOpenAIEmbedder(cfg.SemanticCacheEmbeddingURL, cfg.SemanticCacheEmbeddingKey, cfg.SemanticCacheEmbeddingModel)
+			}
+			if bqAgentH != nil {
+				bqAgentH.SetSchemaSelection(opts)
+			}
+			if pgAgentH != nil {
+				pgAgentH.SetSchemaSelection(opts)
+			}
+		}
+		if cfg.SemanticCacheEnabled {
+			opts := agent.SemanticCacheOptions{Threshold: cfg.SemanticCacheThreshold}
+			if cfg.SemanticCacheEmbeddingURL != "" {
+				opts.Embedder = service.NewOpenAIEmbedder(cfg.SemanticCacheEmbeddingURL, cfg.SemanticCacheEmbeddingKey, cfg.SemanticCacheEmbeddingModel)
+			}
+			if bqAgentH != nil {
+				bqAgentH.EnableSemanticCache(opts)
+			}
+			if pgAgentH != nil {
+				pgAgentH.EnableSemanticCache(opts)
+			}
+			log.Info().
+				Bool("embeddings", opts.Embedder != nil).
+				Float64("threshold", cfg.SemanticCacheThreshold).
+				Msg("semantic response cache enabled")
+		}
+		if bqSvc != nil || esSvc != nil || pgRegistry != nil {
+			fedAgentH = agent.NewFederatedHandler(bqSvc, pgRegistry, esSvc, piiDetector, promptVal, esPromptVal, sqlVal, costTracker, pgCostTracker, dataMasker, auditLogger)
 		}
 		cacheH = handler.NewCacheHandler(bqAgentH, pgAgentH)
 		// FIX #1: agentH is created even if bqAgentH is nil; nil check is inside QueryAgent
-		agentH = handler.NewAgentHandler(bqAgentH, esAgentH, pgAgentH, router, llmPool, cfg.Personas)
+		conversations := service.NewConversationStore(caches.Namespace("conversations"), time.Duration(cfg.ConversationTTL)*time.Minute, cfg.ConversationMaxTurns)
+		agentH = handler.NewAgentHandler(bqAgentH, esAgentH, pgAgentH, fedAgentH, router, llmPool, cfg.Personas, conversations)
+		agentH.SetCostTracker(costTracker)
+		agentH.SetBigQueryProject(cfg.GCPProjectID)
+		agentH.SetToolExecution(cfg.AgentToolConcurrency, time.Duration(cfg.AgentToolTimeout)*time.Second)
+		if cfg.AgentTraceTTL >= 0 {
+			agentH.SetTraceStore(agent.NewTraceStore(caches.Namespace("agent_trace"), time.Duration(cfg.AgentTraceTTL)*time.Minute))
+		}
 	}
 
 	// ─── Router ──────────────────────────────────────────────────────────────────
@@ -265,6 +374,8 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 		r.Route(fmt.Sprintf("%s", cfg.APIPrefix), func(r chi.Router) {
 			// User profile — available to all authenticated users
 			r.Get("/me", userH.Me)
+			r.Get("/usage", usageH.Usage)
+			r.Get("/glossary", glossaryH.List)
 
 			// BigQuery — datasets/tables: viewer+; query/agent: analyst+
 			if datasetsH != nil {
@@ -286,6 +397,22 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 					Post("/query-agent", agentH.QueryAgent)
 				r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
 					Post("/query-agent/stream", agentH.QueryAgentStream)
+				r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
+					Get("/traces/{trace_id}", agentH.GetTrace)
+			}
+
+			// Few-shot examples — analysts read and submit feedback; admins manage
+			if examplesH != nil {
+				r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
+					Get("/examples", examplesH.List)
+				r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
+					Post("/examples", examplesH.Create)
+				r.With(middleware.RequireRole(models.RoleAnalyst, models.RoleAdmin)).
+					Get("/examples/{id}", examplesH.Get)
+				r.With(middleware.RequireRole(models.RoleAdmin)).
+					Put("/examples/{id}", examplesH.Update)
+				r.With(middleware.RequireRole(models.RoleAdmin)).
+					Delete("/examples/{id}", examplesH.Delete)
 			}
 
 			// Cache management — admin only
@@ -302,3 +429,105 @@ func (s *Server) setupRoutes() (http.Handler, *service.BigQueryService, *service
 
 	return r, bqSvc, pgRegistry, nil
 }
+
+// registerLLMRunner registers the runner for target in the pool, unless one is
+// already registered under its PoolKey, and returns the key. ok is false when
+// the provider is not configured (see llmProviders).
+func registerLLMRunner(pool *agent.LLMPool, cfg *config.Config, target config.LLMTargetConfig) (key string, ok bool) {
+	key = agent.PoolKey(target.Provider, target.Model)
+	if pool.Has(key) {
+		return key, true
+	}
+	runner, ok := newLLMRunner(cfg, target)
+	if !ok {
+		return key, false
+	}
+	pool.Register(key, runner)
+	return key, true
+}
+
+// llmRetryPolicy returns the retry policy of LLM runners.
+func llmRetryPolicy(cfg *config.Config) agent.RetryPolicy {
+	retry := agent.DefaultRetryPolicy
+	switch {
+	case cfg.LLMMaxRetries < 0:
+		retry.MaxRetries = 0
+	case cfg.LLMMaxRetries > 0:
+		retry.MaxRetries = cfg.LLMMaxRetries
+	}
+	return retry
+}
+
+// budgetQuota converts a squad budget from config; nil means unlimited.
+func budgetQuota(b *config.BudgetConfig) security.BudgetQuota {
+	if b == nil {
+		return security.BudgetQuota{}
+	}
+	return security.BudgetQuota{
+		DailyBytes:   b.DailyBytes,
+		MonthlyBytes: b.MonthlyBytes,
+		DailyUSD:     b.DailyUSD,
+		MonthlyUSD:   b.MonthlyUSD,
+		DailyTokens:  b.DailyTokens,
+	}
+}
+
+// glossaryTerms converts configured glossary terms.
+func glossaryTerms(terms []config.GlossaryTermConfig) []service.GlossaryTerm {
+	out := make([]service.GlossaryTerm, len(terms))
+	for i, t := range terms {
+		out[i] = service.GlossaryTerm{
+			Term:       t.Term,
+			Synonyms:   t.Synonyms,
+			Definition: t.Definition,
+			SQL:        t.SQL,
+			JoinHints:  t.JoinHints,
+			Datasets:   t.Datasets,
+		}
+	}
+	return out
+}
+
+// llmPriceTable converts the configured LLM prices.
+func llmPriceTable(prices map[string]config.LLMPriceConfig) security.LLMPriceTable {
+	table := make(security.LLMPriceTable, len(prices))
+	for model, p := range prices {
+		table[model] = security.LLMPrice{
+			Input:      p.InputPerMTok,
+			Output:     p.OutputPerMTok,
+			CacheRead:  p.CacheReadPerMTok,
+			CacheWrite: p.CacheWritePerMTok,
+		}
+	}
+	return table
+}
+
+// openCacheStore builds the backend of the agent caches and budget counters
+// from config. A backend that
+// cannot be opened (e.g. Redis unreachable at startup) falls back to the
+// in-memory store so the service still starts, with per-replica caches.
+func openCacheStore(cfg *config.Config) cache.Store {
+	store, err := cache.Open(cache.Options{
+		Backend: cfg.CacheBackend,
+		Dir:     cfg.CacheDir,
+		Redis: cache.RedisOptions{
+			Addr:      cfg.CacheRedisAddr,
+			Password:  cfg.CacheRedisPassword,
+			DB:        cfg.CacheRedisDB,
+			KeyPrefix: cfg.CacheKeyPrefix,
+		},
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("backend", cfg.CacheBackend).Msg("cache backend unavailable, falling back to in-memory cache")
+		return cache.NewMemoryStore()
+	}
+	backend := cfg.CacheBackend
+	if backend == "" {
+		backend = cache.BackendMemory
+	}
+	log.Info().
+		Str("backend", backend).
+		Bool("shared_invalidation", cfg.CacheRedisAddr != "").
+		Msg("agent cache backend ready")
+	return store
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/cortexai/cortexai/internal/cache"
 	"github.com/cortexai/cortexai/internal/config"
 	"github.com/cortexai/cortexai/internal/service"
 	"github.com/rs/zerolog/log"
@@ -16,6 +17,7 @@ type Server struct {
 	http       *http.Server
 	bqSvc      *service.BigQueryService  // FIX #7: held for graceful close
 	pgRegistry *service.PGPoolRegistry   // held for graceful close
+	cacheStore cache.Store               // held for graceful close
 }
 
 func New(cfg *config.Config) (*Server, error) {
@@ -74,6 +76,12 @@ func (s *Server) Run(ctx context.Context) error {
 			}
 		}
 
+		if s.cacheStore != nil {
+			if closeErr := s.cacheStore.Close(); closeErr != nil {
+				log.Warn().Err(closeErr).Msg("error closing cache store")
+			}
+		}
+
 		return err
 	case err := <-errCh:
 		return err
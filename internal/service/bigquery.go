@@ -2,12 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"github.com/cortexai/cortexai/internal/models"
 	"github.com/rs/zerolog/log"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
@@ -253,11 +257,88 @@ func (s *BigQueryService) ExecuteQuery(ctx context.Context, sql, projectID strin
 	}, nil
 }
 
-// SchemaToString formats a BigQuery schema as a human-readable string for LLM context
+// IsQueryError reports whether err from ExecuteQuery is BigQuery rejecting
+// the query itself (a syntax error, unknown name or type mismatch) rather
+// than a transport, permission or timeout failure, i.e. whether rewriting
+// the SQL can fix it.
+func IsQueryError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusBadRequest
+	}
+	var jobErr *bigquery.Error
+	if errors.As(err, &jobErr) {
+		return jobErr.Reason == "invalidQuery" || jobErr.Reason == "invalid"
+	}
+	return false
+}
+
+// SchemaToString formats a BigQuery schema as a human-readable string for LLM
+// context, one column per line with its description, if any, as a comment.
 func SchemaToString(schema bigquery.Schema) string {
-	var sb string
+	var sb strings.Builder
 	for _, f := range schema {
-		sb += fmt.Sprintf("  %s %s\n", f.Name, f.Type)
+		sb.WriteString(fmt.Sprintf("  %s %s", f.Name, f.Type))
+		if f.Repeated {
+			sb.WriteString(" REPEATED")
+		}
+		if d := oneLine(f.Description); d != "" {
+			sb.WriteString(" -- " + d)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// PartitionColumn returns the column a table is partitioned on, or "" if it
+// is not partitioned. Ingestion-time partitioned tables report the
+// _PARTITIONTIME pseudo-column.
+func PartitionColumn(meta *bigquery.TableMetadata) string {
+	switch {
+	case meta == nil:
+		return ""
+	case meta.TimePartitioning != nil && meta.TimePartitioning.Field != "":
+		return meta.TimePartitioning.Field
+	case meta.TimePartitioning != nil:
+		return "_PARTITIONTIME"
+	case meta.RangePartitioning != nil:
+		return meta.RangePartitioning.Field
+	}
+	return ""
+}
+
+// TableDetailsToString formats the table-level metadata useful for writing
+// SQL (description, partitioning, clustering and last-modified time) for
+// LLM context, one "Label: value" line each. It returns "" when there is
+// none.
+func TableDetailsToString(meta *bigquery.TableMetadata) string {
+	if meta == nil {
+		return ""
+	}
+	var sb strings.Builder
+	if d := oneLine(meta.Description); d != "" {
+		sb.WriteString("Description: " + d + "\n")
+	}
+	if col := PartitionColumn(meta); col != "" {
+		sb.WriteString("Partitioned by: " + col)
+		if meta.TimePartitioning != nil && meta.TimePartitioning.Type != "" {
+			sb.WriteString(" (" + string(meta.TimePartitioning.Type) + ")")
+		}
+		if meta.RequirePartitionFilter {
+			sb.WriteString(", partition filter required")
+		}
+		sb.WriteString("\n")
+	}
+	if meta.Clustering != nil && len(meta.Clustering.Fields) > 0 {
+		sb.WriteString("Clustered by: " + strings.Join(meta.Clustering.Fields, ", ") + "\n")
+	}
+	if !meta.LastModifiedTime.IsZero() {
+		sb.WriteString("Last modified: " + meta.LastModifiedTime.UTC().Format("2006-01-02 15:04 UTC") + "\n")
 	}
-	return sb
+	return sb.String()
+}
+
+// oneLine collapses whitespace, including newlines, in a description.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
 }
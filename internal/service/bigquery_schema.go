@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/iterator"
+)
+
+// schemaFetchConcurrency bounds the per-table metadata calls of the
+// GetDatasetSchema fallback.
+const schemaFetchConcurrency = 8
+
+// datasetIDPattern matches valid BigQuery dataset IDs, which are spliced
+// into the INFORMATION_SCHEMA query.
+var datasetIDPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// TableSchema is the schema and metadata of one table of a dataset.
+type TableSchema struct {
+	ID   string
+	Meta *bigquery.TableMetadata // Schema holds the columns
+}
+
+// GetDatasetSchema returns the schema and metadata of every table in a
+// dataset, ordered by table ID. It reads them with a single query over the
+// dataset's INFORMATION_SCHEMA; when that fails (for example without
+// permission to query the dataset) it falls back to one metadata call per
+// table, at most schemaFetchConcurrency at a time. Metadata read from
+// INFORMATION_SCHEMA leaves the time partitioning type unset and reports
+// column types in Standard SQL names (INT64 rather than INTEGER).
+func (s *BigQueryService) GetDatasetSchema(ctx context.Context, datasetID string) ([]TableSchema, error) {
+	tables, err := s.datasetSchemaFromInformationSchema(ctx, datasetID)
+	if err == nil {
+		return tables, nil
+	}
+	log.Warn().Err(err).Str("dataset", datasetID).Msg("INFORMATION_SCHEMA schema load failed, falling back to per-table metadata")
+	return s.datasetSchemaFromMetadata(ctx, datasetID)
+}
+
+// schemaColumnRow is one row of datasetSchemaQuery: a column of a table,
+// with the table's options and storage statistics repeated on each row.
+type schemaColumnRow struct {
+	TableName              string              `bigquery:"table_name"`
+	ColumnName             string              `bigquery:"column_name"`
+	DataType               string              `bigquery:"data_type"`
+	IsHidden               string              `bigquery:"is_hidden"`
+	IsPartitioningColumn   string              `bigquery:"is_partitioning_column"`
+	ClusteringPosition     bigquery.NullInt64  `bigquery:"clustering_ordinal_position"`
+	ColumnDescription      bigquery.NullString `bigquery:"column_description"`
+	TableDescription       bigquery.NullString `bigquery:"table_description"`
+	RequirePartitionFilter bigquery.NullBool   `bigquery:"require_partition_filter"`
+	RowCount               bigquery.NullInt64  `bigquery:"row_count"`
+	LastModifiedMs         bigquery.NullInt64  `bigquery:"last_modified_time"`
+}
+
+// datasetSchemaQuery lists the columns of every table of a dataset with
+// their descriptions, partitioning and clustering, the table descriptions
+// and partition-filter options, and row counts and last-modified times from
+// the __TABLES__ meta-table. %[1]s is the backquoted `project.dataset`.
+const datasetSchemaQuery = `
+WITH options AS (
+  SELECT
+    table_name,
+    MAX(IF(option_name = 'description', option_value, NULL)) AS table_description,
+    LOGICAL_OR(option_name = 'require_partition_filter' AND option_value = 'true') AS require_partition_filter
+  FROM %[1]s.INFORMATION_SCHEMA.TABLE_OPTIONS
+  GROUP BY table_name
+)
+SELECT
+  c.table_name, c.column_name, c.data_type, c.is_hidden,
+  c.is_partitioning_column, c.clustering_ordinal_position,
+  f.description AS column_description,
+  o.table_description, o.require_partition_filter,
+  t.row_count, t.last_modified_time
+FROM %[1]s.INFORMATION_SCHEMA.COLUMNS AS c
+LEFT JOIN %[1]s.INFORMATION_SCHEMA.COLUMN_FIELD_PATHS AS f
+  ON f.table_name = c.table_name AND f.field_path = c.column_name
+LEFT JOIN options AS o ON o.table_name = c.table_name
+LEFT JOIN %[1]s.__TABLES__ AS t ON t.table_id = c.table_name
+ORDER BY c.table_name, c.ordinal_position`
+
+func (s *BigQueryService) datasetSchemaFromInformationSchema(ctx context.Context, datasetID string) ([]TableSchema, error) {
+	if !datasetIDPattern.MatchString(datasetID) {
+		return nil, fmt.Errorf("invalid dataset ID %q", datasetID)
+	}
+	ref := "`" + strings.ReplaceAll(s.projectID, "`", "") + "." + datasetID + "`"
+	q := s.client.Query(fmt.Sprintf(datasetSchemaQuery, ref))
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query INFORMATION_SCHEMA: %w", err)
+	}
+	var rows []schemaColumnRow
+	for {
+		var row schemaColumnRow
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read INFORMATION_SCHEMA row: %w", err)
+		}
+		rows = append(rows, row)
+	}
+	return buildDatasetSchema(rows), nil
+}
+
+// buildDatasetSchema assembles the rows of datasetSchemaQuery, ordered by
+// table, into one TableSchema per table.
+func buildDatasetSchema(rows []schemaColumnRow) []TableSchema {
+	var tables []TableSchema
+	var clustering map[int64]string
+	for _, row := range rows {
+		if len(tables) == 0 || tables[len(tables)-1].ID != row.TableName {
+			tables = append(tables, TableSchema{ID: row.TableName, Meta: tableMetaFromRow(row)})
+			clustering = map[int64]string{}
+		}
+		meta := tables[len(tables)-1].Meta
+
+		if row.IsPartitioningColumn == "YES" {
+			setPartitioning(meta, row)
+		}
+		if row.ClusteringPosition.Valid {
+			clustering[row.ClusteringPosition.Int64] = row.ColumnName
+			meta.Clustering = &bigquery.Clustering{Fields: orderedFields(clustering)}
+		}
+		if row.IsHidden == "YES" {
+			continue // _PARTITIONTIME and other pseudo-columns
+		}
+		meta.Schema = append(meta.Schema, fieldFromRow(row))
+	}
+	return tables
+}
+
+func tableMetaFromRow(row schemaColumnRow) *bigquery.TableMetadata {
+	meta := &bigquery.TableMetadata{
+		Description:            unquoteOption(row.TableDescription.StringVal),
+		RequirePartitionFilter: row.RequirePartitionFilter.Valid && row.RequirePartitionFilter.Bool,
+	}
+	if row.RowCount.Valid {
+		meta.NumRows = uint64(row.RowCount.Int64)
+	}
+	if row.LastModifiedMs.Valid {
+		meta.LastModifiedTime = time.UnixMilli(row.LastModifiedMs.Int64).UTC()
+	}
+	return meta
+}
+
+// setPartitioning records the partitioning column of row on meta. Hidden
+// pseudo-columns mean ingestion-time partitioning; an integer column means
+// range partitioning.
+func setPartitioning(meta *bigquery.TableMetadata, row schemaColumnRow) {
+	switch {
+	case meta.TimePartitioning != nil || meta.RangePartitioning != nil:
+		// _PARTITIONTIME and _PARTITIONDATE both report as partitioning
+	case row.IsHidden == "YES":
+		meta.TimePartitioning = &bigquery.TimePartitioning{}
+	case row.DataType == "INT64":
+		meta.RangePartitioning = &bigquery.RangePartitioning{Field: row.ColumnName}
+	default:
+		meta.TimePartitioning = &bigquery.TimePartitioning{Field: row.ColumnName}
+	}
+}
+
+func fieldFromRow(row schemaColumnRow) *bigquery.FieldSchema {
+	f := &bigquery.FieldSchema{
+		Name:        row.ColumnName,
+		Description: row.ColumnDescription.StringVal,
+	}
+	dataType := row.DataType
+	if inner, ok := strings.CutPrefix(dataType, "ARRAY<"); ok {
+		f.Repeated = true
+		dataType = strings.TrimSuffix(inner, ">")
+	}
+	f.Type = bigquery.FieldType(dataType)
+	return f
+}
+
+func orderedFields(byPosition map[int64]string) []string {
+	positions := make([]int64, 0, len(byPosition))
+	for p := range byPosition {
+		positions = append(positions, p)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	fields := make([]string, len(positions))
+	for i, p := range positions {
+		fields[i] = byPosition[p]
+	}
+	return fields
+}
+
+// unquoteOption decodes a TABLE_OPTIONS string value, which is a quoted
+// string literal.
+func unquoteOption(v string) string {
+	if u, err := strconv.Unquote(v); err == nil {
+		return u
+	}
+	return strings.Trim(v, `"`)
+}
+
+// datasetSchemaFromMetadata reads the metadata of each table of a dataset
+// with the tables API.
+func (s *BigQueryService) datasetSchemaFromMetadata(ctx context.Context, datasetID string) ([]TableSchema, error) {
+	var ids []string
+	it := s.client.Dataset(datasetID).Tables(ctx)
+	for {
+		tbl, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list tables: %w", err)
+		}
+		ids = append(ids, tbl.TableID)
+	}
+	sort.Strings(ids)
+
+	metas := make([]*bigquery.TableMetadata, len(ids))
+	var g errgroup.Group
+	g.SetLimit(schemaFetchConcurrency)
+	for i, id := range ids {
+		g.Go(func() error {
+			_, meta, err := s.GetTableSchema(ctx, datasetID, id)
+			if err != nil {
+				log.Warn().Err(err).Str("table", id).Msg("load dataset schema: get schema failed")
+				return nil
+			}
+			metas[i] = meta
+			return nil
+		})
+	}
+	g.Wait() // tables that fail are logged and skipped
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var tables []TableSchema
+	for i, meta := range metas {
+		if meta != nil {
+			tables = append(tables, TableSchema{ID: ids[i], Meta: meta})
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+func TestSchemaToString(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "order_id", Type: bigquery.StringFieldType},
+		{Name: "amount", Type: bigquery.NumericFieldType, Description: "gross amount\nin IDR"},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	}
+	want := "  order_id STRING\n" +
+		"  amount NUMERIC -- gross amount in IDR\n" +
+		"  tags STRING REPEATED\n"
+	if got := SchemaToString(schema); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPartitionColumn(t *testing.T) {
+	tests := []struct {
+		name string
+		meta *bigquery.TableMetadata
+		want string
+	}{
+		{"nil", nil, ""},
+		{"unpartitioned", &bigquery.TableMetadata{}, ""},
+		{"column", &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "created_at"}}, "created_at"},
+		{"ingestion time", &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType}}, "_PARTITIONTIME"},
+		{"integer range", &bigquery.TableMetadata{RangePartitioning: &bigquery.RangePartitioning{Field: "customer_id"}}, "customer_id"},
+	}
+	for _, tt := range tests {
+		if got := PartitionColumn(tt.meta); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTableDetailsToString(t *testing.T) {
+	meta := &bigquery.TableMetadata{
+		Description:            "Completed orders",
+		TimePartitioning:       &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "created_at"},
+		RequirePartitionFilter: true,
+		Clustering:             &bigquery.Clustering{Fields: []string{"city", "status"}},
+		LastModifiedTime:       time.Date(2026, 10, 1, 8, 30, 0, 0, time.UTC),
+	}
+	want := "Description: Completed orders\n" +
+		"Partitioned by: created_at (DAY), partition filter required\n" +
+		"Clustered by: city, status\n" +
+		"Last modified: 2026-10-01 08:30 UTC\n"
+	if got := TableDetailsToString(meta); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := TableDetailsToString(&bigquery.TableMetadata{}); got != "" {
+		t.Errorf("empty metadata: got %q", got)
+	}
+}
+
+func TestBuildDatasetSchema(t *testing.T) {
+	str := func(s string) bigquery.NullString { return bigquery.NullString{StringVal: s, Valid: true} }
+	pos := func(n int64) bigquery.NullInt64 { return bigquery.NullInt64{Int64: n, Valid: true} }
+	orders := func(r schemaColumnRow) schemaColumnRow {
+		r.TableName = "orders"
+		r.TableDescription = str(`"Completed \"orders\""`)
+		r.RequirePartitionFilter = bigquery.NullBool{Bool: true, Valid: true}
+		r.RowCount = pos(1200)
+		r.LastModifiedMs = pos(time.Date(2026, 10, 1, 8, 30, 0, 0, time.UTC).UnixMilli())
+		if r.IsHidden == "" {
+			r.IsHidden = "NO"
+		}
+		if r.IsPartitioningColumn == "" {
+			r.IsPartitioningColumn = "NO"
+		}
+		return r
+	}
+	rows := []schemaColumnRow{
+		orders(schemaColumnRow{ColumnName: "order_id", DataType: "STRING"}),
+		orders(schemaColumnRow{ColumnName: "status", DataType: "STRING", ClusteringPosition: pos(2)}),
+		orders(schemaColumnRow{ColumnName: "city", DataType: "STRING", ClusteringPosition: pos(1), ColumnDescription: str("delivery city")}),
+		orders(schemaColumnRow{ColumnName: "created_at", DataType: "TIMESTAMP", IsPartitioningColumn: "YES"}),
+		orders(schemaColumnRow{ColumnName: "tags", DataType: "ARRAY<STRING>"}),
+		{TableName: "events", ColumnName: "name", DataType: "STRING", IsHidden: "NO", IsPartitioningColumn: "NO"},
+		{TableName: "events", ColumnName: "_PARTITIONTIME", DataType: "TIMESTAMP", IsHidden: "YES", IsPartitioningColumn: "YES"},
+		{TableName: "events", ColumnName: "_PARTITIONDATE", DataType: "DATE", IsHidden: "YES", IsPartitioningColumn: "YES"},
+		{TableName: "shards", ColumnName: "shard", DataType: "INT64", IsHidden: "NO", IsPartitioningColumn: "YES"},
+	}
+
+	tables := buildDatasetSchema(rows)
+	if len(tables) != 3 || tables[0].ID != "orders" || tables[1].ID != "events" || tables[2].ID != "shards" {
+		t.Fatalf("tables = %+v", tables)
+	}
+
+	meta := tables[0].Meta
+	want := "  order_id STRING\n" +
+		"  status STRING\n" +
+		"  city STRING -- delivery city\n" +
+		"  created_at TIMESTAMP\n" +
+		"  tags STRING REPEATED\n"
+	if got := SchemaToString(meta.Schema); got != want {
+		t.Errorf("orders schema:\n%s\nwant:\n%s", got, want)
+	}
+	wantDetails := "Description: Completed \"orders\"\n" +
+		"Partitioned by: created_at, partition filter required\n" +
+		"Clustered by: city, status\n" +
+		"Last modified: 2026-10-01 08:30 UTC\n"
+	if got := TableDetailsToString(meta); got != wantDetails {
+		t.Errorf("orders details:\n%s\nwant:\n%s", got, wantDetails)
+	}
+	if meta.NumRows != 1200 {
+		t.Errorf("orders rows = %d", meta.NumRows)
+	}
+
+	if got := PartitionColumn(tables[1].Meta); got != "_PARTITIONTIME" {
+		t.Errorf("events partition column = %q", got)
+	}
+	if len(tables[1].Meta.Schema) != 1 {
+		t.Errorf("events schema should omit pseudo-columns: %v", tables[1].Meta.Schema)
+	}
+	if r := tables[2].Meta.RangePartitioning; r == nil || r.Field != "shard" {
+		t.Errorf("shards range partitioning = %+v", r)
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// conversationOpTimeout bounds each backend call so a slow shared cache
+// cannot stall the request that carries the conversation.
+const conversationOpTimeout = 500 * time.Millisecond
+
+// ErrConversationNotFound is returned when a conversation does not exist, has
+// expired, or belongs to a different user/squad. The cases are deliberately
+// indistinguishable so conversation IDs cannot be probed across squads.
+var ErrConversationNotFound = errors.New("conversation not found or expired")
+
+// conversation is a single multi-turn session owned by one user in one squad,
+// stored JSON-encoded under its ID.
+type conversation struct {
+	UserID    string                    `json:"user_id"`
+	SquadID   string                    `json:"squad_id"`
+	Turns     []models.ConversationTurn `json:"turns"`
+	UpdatedAt time.Time                 `json:"updated_at"`
+}
+
+// ConversationStore keeps multi-turn agent sessions in a pluggable
+// cache.Cache, so on a shared backend a follow-up can be served by any
+// replica. A conversation expires ttl after its last turn and keeps at most
+// maxTurns turns (oldest are dropped first).
+//
+// Appends are serialized within the process only: two replicas appending to
+// the same conversation at once may lose one of the turns.
+type ConversationStore struct {
+	mu       sync.Mutex
+	backend  cache.Cache
+	ttl      time.Duration
+	maxTurns int
+	now      func() time.Time // overridable in tests
+}
+
+// NewConversationStore creates a store on backend. A ttl <= 0 defaults to 30
+// minutes and a maxTurns <= 0 defaults to 10.
+func NewConversationStore(backend cache.Cache, ttl time.Duration, maxTurns int) *ConversationStore {
+	if ttl <= 0 {
+		ttl = 30 * time.Minute
+	}
+	if maxTurns <= 0 {
+		maxTurns = 10
+	}
+	return &ConversationStore{
+		backend:  backend,
+		ttl:      ttl,
+		maxTurns: maxTurns,
+		now:      time.Now,
+	}
+}
+
+// Create starts a new empty conversation owned by userID/squadID and returns its ID.
+func (s *ConversationStore) Create(userID, squadID string) (string, error) {
+	id := uuid.New().String()
+	c := &conversation{UserID: userID, SquadID: squadID, UpdatedAt: s.now()}
+	if err := s.save(id, c); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// History returns the turns recorded for the conversation.
+// Returns ErrConversationNotFound if the conversation is unknown, expired, or
+// not owned by userID/squadID.
+func (s *ConversationStore) History(id, userID, squadID string) ([]models.ConversationTurn, error) {
+	c, err := s.lookup(id, userID, squadID)
+	if err != nil {
+		return nil, err
+	}
+	return c.Turns, nil
+}
+
+// Append records a completed turn and refreshes the conversation's expiry.
+func (s *ConversationStore) Append(id, userID, squadID string, turn models.ConversationTurn) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := s.lookup(id, userID, squadID)
+	if err != nil {
+		return err
+	}
+	if turn.CreatedAt.IsZero() {
+		turn.CreatedAt = s.now()
+	}
+	c.Turns = append(c.Turns, turn)
+	if len(c.Turns) > s.maxTurns {
+		c.Turns = c.Turns[len(c.Turns)-s.maxTurns:]
+	}
+	c.UpdatedAt = s.now()
+	return s.save(id, c)
+}
+
+func (s *ConversationStore) lookup(id, userID, squadID string) (*conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), conversationOpTimeout)
+	defer cancel()
+	v, ok, err := s.backend.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrConversationNotFound
+	}
+	var c conversation
+	if err := json.Unmarshal(v, &c); err != nil {
+		log.Warn().Err(err).Str("conversation_id", id).Msg("discarding undecodable conversation")
+		return nil, ErrConversationNotFound
+	}
+	// The backend expires entries too; this check keeps the TTL exact on
+	// backends that expire lazily or coarsely.
+	if s.now().Sub(c.UpdatedAt) > s.ttl {
+		return nil, ErrConversationNotFound
+	}
+	if c.UserID != userID || c.SquadID != squadID {
+		return nil, ErrConversationNotFound
+	}
+	return &c, nil
+}
+
+func (s *ConversationStore) save(id string, c *conversation) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), conversationOpTimeout)
+	defer cancel()
+	return s.backend.Set(ctx, id, b, s.ttl)
+}
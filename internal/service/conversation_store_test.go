@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/cache"
+	"github.com/cortexai/cortexai/internal/models"
+)
+
+func TestConversationStore_AppendAndHistory(t *testing.T) {
+	s := newTestConversationStore(time.Minute, 10)
+	id, _ := s.Create("u1", "squad-a")
+
+	hist, err := s.History(id, "u1", "squad-a")
+	if err != nil {
+		t.Fatalf("History on new conversation: %v", err)
+	}
+	if len(hist) != 0 {
+		t.Fatalf("new conversation should be empty, got %d turns", len(hist))
+	}
+
+	for _, p := range []string{"revenue by month", "now by region"} {
+		if err := s.Append(id, "u1", "squad-a", models.ConversationTurn{Prompt: p}); err != nil {
+			t.Fatalf("Append(%q): %v", p, err)
+		}
+	}
+	hist, err = s.History(id, "u1", "squad-a")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(hist) != 2 || hist[0].Prompt != "revenue by month" || hist[1].Prompt != "now by region" {
+		t.Errorf("unexpected history: %+v", hist)
+	}
+	if hist[0].CreatedAt.IsZero() {
+		t.Error("CreatedAt should be set on append")
+	}
+
+	// Returned slice is a copy
+	hist[0].Prompt = "mutated"
+	again, _ := s.History(id, "u1", "squad-a")
+	if again[0].Prompt != "revenue by month" {
+		t.Error("History must return a copy, store was mutated")
+	}
+}
+
+func TestConversationStore_UnknownID(t *testing.T) {
+	s := newTestConversationStore(time.Minute, 10)
+	if _, err := s.History("nope", "u1", "squad-a"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected ErrConversationNotFound, got %v", err)
+	}
+	if err := s.Append("nope", "u1", "squad-a", models.ConversationTurn{}); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("Append: expected ErrConversationNotFound, got %v", err)
+	}
+}
+
+func TestConversationStore_OwnerIsolation(t *testing.T) {
+	s := newTestConversationStore(time.Minute, 10)
+	id, _ := s.Create("u1", "squad-a")
+
+	cases := []struct{ user, squad string }{
+		{"u1", "squad-b"}, // same user, other squad
+		{"u2", "squad-a"}, // other user, same squad
+		{"", ""},          // anonymous
+	}
+	for _, c := range cases {
+		if _, err := s.History(id, c.user, c.squad); !errors.Is(err, ErrConversationNotFound) {
+			t.Errorf("History as %s/%s: expected ErrConversationNotFound, got %v", c.user, c.squad, err)
+		}
+		if err := s.Append(id, c.user, c.squad, models.ConversationTurn{}); !errors.Is(err, ErrConversationNotFound) {
+			t.Errorf("Append as %s/%s: expected ErrConversationNotFound, got %v", c.user, c.squad, err)
+		}
+	}
+}
+
+func TestConversationStore_TTLExpiry(t *testing.T) {
+	now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	s := newTestConversationStore(30*time.Minute, 10)
+	s.now = func() time.Time { return now }
+
+	id, _ := s.Create("u1", "squad-a")
+	now = now.Add(20 * time.Minute)
+	if err := s.Append(id, "u1", "squad-a", models.ConversationTurn{Prompt: "q1"}); err != nil {
+		t.Fatalf("Append within TTL: %v", err)
+	}
+
+	// Append refreshed the expiry, so 20 more minutes is still within TTL.
+	now = now.Add(20 * time.Minute)
+	if _, err := s.History(id, "u1", "squad-a"); err != nil {
+		t.Fatalf("History within refreshed TTL: %v", err)
+	}
+
+	now = now.Add(31 * time.Minute)
+	if _, err := s.History(id, "u1", "squad-a"); !errors.Is(err, ErrConversationNotFound) {
+		t.Errorf("expected expired conversation, got %v", err)
+	}
+}
+
+func TestConversationStore_SharedBetweenReplicas(t *testing.T) {
+	backend := cache.NewMemoryStore().Namespace("conversations")
+	a := NewConversationStore(backend, time.Minute, 10)
+	b := NewConversationStore(backend, time.Minute, 10)
+
+	id, err := a.Create("u1", "squad-a")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := a.Append(id, "u1", "squad-a", models.ConversationTurn{Prompt: "q1"}); err != nil {
+		t.Fatalf("Append on a: %v", err)
+	}
+	if err := b.Append(id, "u1", "squad-a", models.ConversationTurn{Prompt: "q2"}); err != nil {
+		t.Fatalf("Append on b: %v", err)
+	}
+	hist, err := a.History(id, "u1", "squad-a")
+	if err != nil || len(hist) != 2 || hist[1].Prompt != "q2" {
+		t.Errorf("a should see both turns, got %+v (err %v)", hist, err)
+	}
+}
+
+func TestConversationStore_MaxTurnsKeepsNewest(t *testing.T) {
+	s := newTestConversationStore(time.Minute, 3)
+	id, _ := s.Create("u1", "squad-a")
+	for i := 1; i <= 5; i++ {
+		if err := s.Append(id, "u1", "squad-a", models.ConversationTurn{Prompt: fmt.Sprintf("q%d", i)}); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	hist, _ := s.History(id, "u1", "squad-a")
+	if len(hist) != 3 {
+		t.Fatalf("expected 3 turns, got %d", len(hist))
+	}
+	if hist[0].Prompt != "q3" || hist[2].Prompt != "q5" {
+		t.Errorf("expected q3..q5, got %q..%q", hist[0].Prompt, hist[2].Prompt)
+	}
+}
+
+func TestNewConversationStore_Defaults(t *testing.T) {
+	s := newTestConversationStore(0, 0)
+	if s.ttl != 30*time.Minute {
+		t.Errorf("default ttl = %v, want 30m", s.ttl)
+	}
+	if s.maxTurns != 10 {
+		t.Errorf("default maxTurns = %d, want 10", s.maxTurns)
+	}
+}
+
+func newTestConversationStore(ttl time.Duration, maxTurns int) *ConversationStore {
+	return NewConversationStore(cache.NewMemoryStore().Namespace("conversations"), ttl, maxTurns)
+}
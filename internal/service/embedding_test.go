@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIEmbedder_Embed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/embeddings" {
+			t.Errorf("path = %q, want /v1/embeddings", r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var req embeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if req.Model != "text-embedding-3-small" || len(req.Input) != 1 || req.Input[0] != "top 10 drivers" {
+			t.Errorf("unexpected request %+v", req)
+		}
+		w.Write([]byte(`{"data":[{"embedding":[0.1,0.2,0.3]}]}`))
+	}))
+	defer srv.Close()
+
+	e := NewOpenAIEmbedder(srv.URL+"/v1/", "key", "text-embedding-3-small")
+	vec, err := e.Embed(context.Background(), "top 10 drivers")
+	if err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(vec) != 3 || vec[2] != 0.3 {
+		t.Errorf("vec = %v", vec)
+	}
+}
+
+func TestOpenAIEmbedder_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	if _, err := NewOpenAIEmbedder(srv.URL, "", "m").Embed(context.Background(), "x"); err == nil {
+		t.Fatal("expected error for non-200 status")
+	}
+}
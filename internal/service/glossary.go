@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// GlossaryTerm is a business term with its agreed meaning, so the agent uses
+// the definition analysts agreed on instead of inventing one.
+type GlossaryTerm struct {
+	Term       string   `json:"term"`
+	Synonyms   []string `json:"synonyms,omitempty"`   // other names, in Indonesian or English
+	Definition string   `json:"definition,omitempty"` // plain-language meaning
+	SQL        string   `json:"sql,omitempty"`        // canonical SQL expression of a metric
+	JoinHints  []string `json:"join_hints,omitempty"` // e.g. "orders.driver_id = drivers.id"
+	Datasets   []string `json:"datasets,omitempty"`   // BigQuery datasets / PostgreSQL databases it applies to; empty = all
+	SquadID    string   `json:"squad_id,omitempty"`   // empty for terms shared by every squad
+}
+
+// AppliesTo reports whether the term is defined for the dataset or database
+// scope. An empty scope matches every term.
+func (t GlossaryTerm) AppliesTo(scope string) bool {
+	if scope == "" || len(t.Datasets) == 0 {
+		return true
+	}
+	for _, d := range t.Datasets {
+		if d == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Glossary holds the business glossary: terms shared by every squad plus
+// each squad's own. It is read-only after construction.
+type Glossary struct {
+	shared  []GlossaryTerm
+	squads  map[string][]GlossaryTerm
+	version string
+}
+
+// NewGlossary creates a Glossary. The SquadID of the terms is set from the
+// map key; shared terms have none.
+func NewGlossary(shared []GlossaryTerm, squads map[string][]GlossaryTerm) *Glossary {
+	g := &Glossary{squads: make(map[string][]GlossaryTerm, len(squads))}
+	for _, t := range shared {
+		t.SquadID = ""
+		g.shared = append(g.shared, t)
+	}
+	for id, terms := range squads {
+		for _, t := range terms {
+			t.SquadID = id
+			g.squads[id] = append(g.squads[id], t)
+		}
+	}
+	b, _ := json.Marshal([]interface{}{g.shared, g.squads}) // map keys are sorted
+	g.version = fmt.Sprintf("%x", sha256.Sum256(b))
+	return g
+}
+
+// Version returns a digest of every term, which changes whenever the
+// glossary does. It is empty for a nil Glossary.
+func (g *Glossary) Version() string {
+	if g == nil {
+		return ""
+	}
+	return g.version
+}
+
+// Terms returns the terms visible to squadID in scope (a dataset or
+// database; "" = any). A squad's own term replaces a shared term of the
+// same name; squad terms come first.
+func (g *Glossary) Terms(squadID, scope string) []GlossaryTerm {
+	if g == nil {
+		return nil
+	}
+	var out []GlossaryTerm
+	own := make(map[string]bool)
+	for _, t := range g.squads[squadID] {
+		if t.AppliesTo(scope) {
+			out = append(out, t)
+			own[strings.ToLower(t.Term)] = true
+		}
+	}
+	for _, t := range g.shared {
+		if t.AppliesTo(scope) && !own[strings.ToLower(t.Term)] {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// Match returns the terms of Terms(squadID, scope) that text mentions by
+// name or synonym. Matching ignores case and punctuation and works on whole
+// words, so "GMV" matches "GMV-nya" but not "GMVX".
+func (g *Glossary) Match(squadID, scope, text string) []GlossaryTerm {
+	terms := g.Terms(squadID, scope)
+	if len(terms) == 0 {
+		return nil
+	}
+	padded := " " + glossaryWords(text) + " "
+	var out []GlossaryTerm
+	for _, t := range terms {
+		for _, name := range append([]string{t.Term}, t.Synonyms...) {
+			if w := glossaryWords(name); w != "" && strings.Contains(padded, " "+w+" ") {
+				out = append(out, t)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// glossaryWords lower-cases s and joins its words with single spaces.
+func glossaryWords(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func testGlossary() *Glossary {
+	return NewGlossary(
+		[]GlossaryTerm{
+			{Term: "GMV", Synonyms: []string{"gross merchandise value", "nilai transaksi"}, SQL: "SUM(orders.amount)"},
+			{Term: "Active driver", Synonyms: []string{"driver aktif"}, Definition: "Driver with a trip in the last 30 days", Datasets: []string{"ops"}},
+		},
+		map[string][]GlossaryTerm{
+			"squad-a": {{Term: "gmv", SQL: "SUM(orders.amount) - SUM(orders.discount)"}},
+		},
+	)
+}
+
+func termNames(terms []GlossaryTerm) []string {
+	names := make([]string, len(terms))
+	for i, t := range terms {
+		names[i] = t.Term
+	}
+	return names
+}
+
+func TestGlossaryTerms_SquadOverridesAndScope(t *testing.T) {
+	g := testGlossary()
+
+	got := g.Terms("squad-a", "")
+	if !reflect.DeepEqual(termNames(got), []string{"gmv", "Active driver"}) {
+		t.Fatalf("squad-a terms = %v", termNames(got))
+	}
+	if got[0].SquadID != "squad-a" || got[0].SQL != "SUM(orders.amount) - SUM(orders.discount)" {
+		t.Errorf("squad term = %+v, want the squad's own GMV", got[0])
+	}
+	if got[1].SquadID != "" {
+		t.Errorf("shared term has squad %q", got[1].SquadID)
+	}
+
+	if got := termNames(g.Terms("squad-b", "sales")); !reflect.DeepEqual(got, []string{"GMV"}) {
+		t.Errorf("squad-b terms for sales = %v, want [GMV]", got)
+	}
+	if got := termNames(g.Terms("squad-b", "ops")); !reflect.DeepEqual(got, []string{"GMV", "Active driver"}) {
+		t.Errorf("squad-b terms for ops = %v", got)
+	}
+
+	var none *Glossary
+	if got := none.Terms("squad-a", ""); got != nil {
+		t.Errorf("nil glossary terms = %v", got)
+	}
+}
+
+func TestGlossaryMatch_WholeWordsAndSynonyms(t *testing.T) {
+	g := testGlossary()
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"berapa GMV-nya bulan ini?", []string{"GMV"}},
+		{"total Nilai  Transaksi per kota", []string{"GMV"}},
+		{"jumlah driver aktif dan gmv minggu lalu", []string{"GMV", "Active driver"}},
+		{"GMVX per hari", nil},
+		{"jumlah driver per kota", nil},
+	}
+	for _, tt := range tests {
+		if got := g.Match("squad-b", "ops", tt.text); !slices.Equal(termNames(got), tt.want) {
+			t.Errorf("Match(%q) = %v, want %v", tt.text, termNames(got), tt.want)
+		}
+	}
+
+	if got := g.Match("squad-b", "sales", "driver aktif"); len(got) != 0 {
+		t.Errorf("term outside its datasets matched: %v", termNames(got))
+	}
+}
+
+func TestGlossaryVersion(t *testing.T) {
+	if testGlossary().Version() != testGlossary().Version() {
+		t.Error("equal glossaries must have equal versions")
+	}
+	changed := NewGlossary(nil, map[string][]GlossaryTerm{"squad-a": {{Term: "gmv", SQL: "SUM(orders.amount)"}}})
+	if changed.Version() == testGlossary().Version() {
+		t.Error("a changed glossary must change version")
+	}
+	var none *Glossary
+	if none.Version() != "" {
+		t.Errorf("nil glossary version = %q", none.Version())
+	}
+}
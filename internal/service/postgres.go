@@ -4,10 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog/log"
 )
@@ -25,6 +27,16 @@ type PGColumnInfo struct {
 	DataType   string  `json:"data_type"`
 	IsNullable string  `json:"is_nullable"`
 	Default    *string `json:"column_default,omitempty"`
+	Comment    string  `json:"comment,omitempty"`
+}
+
+// PGTableDetails holds the keys, indexes and comment of a PostgreSQL table,
+// as read from pg_catalog.
+type PGTableDetails struct {
+	Comment     string   `json:"comment,omitempty"`
+	PrimaryKey  string   `json:"primary_key,omitempty"`  // e.g. "(id)"
+	ForeignKeys []string `json:"foreign_keys,omitempty"` // e.g. "(customer_id) REFERENCES customers(id)"
+	Indexes     []string `json:"indexes,omitempty"`      // e.g. "orders_created_at_idx btree (created_at)"; the primary key's is omitted
 }
 
 // PGQueryResult holds the result of a PostgreSQL query.
@@ -159,7 +171,8 @@ func (s *PostgresService) GetTableSchema(ctx context.Context, dbName, schema, ta
 	}
 
 	rows, err := db.QueryContext(ctx, `
-		SELECT column_name, data_type, is_nullable, column_default
+		SELECT column_name, data_type, is_nullable, column_default,
+			COALESCE(col_description(format('%I.%I', table_schema, table_name)::regclass, ordinal_position::int), '')
 		FROM information_schema.columns
 		WHERE table_schema = $1 AND table_name = $2
 		ORDER BY ordinal_position`, schema, table)
@@ -171,7 +184,7 @@ func (s *PostgresService) GetTableSchema(ctx context.Context, dbName, schema, ta
 	var cols []PGColumnInfo
 	for rows.Next() {
 		var c PGColumnInfo
-		if err := rows.Scan(&c.Name, &c.DataType, &c.IsNullable, &c.Default); err != nil {
+		if err := rows.Scan(&c.Name, &c.DataType, &c.IsNullable, &c.Default, &c.Comment); err != nil {
 			return nil, fmt.Errorf("scan column: %w", err)
 		}
 		cols = append(cols, c)
@@ -179,6 +192,70 @@ func (s *PostgresService) GetTableSchema(ctx context.Context, dbName, schema, ta
 	return cols, rows.Err()
 }
 
+// GetTableDetails returns the comment, primary key, foreign keys and
+// indexes of a specific table.
+func (s *PostgresService) GetTableDetails(ctx context.Context, dbName, schema, table string) (*PGTableDetails, error) {
+	db, err := s.GetPool(dbName)
+	if err != nil {
+		return nil, err
+	}
+	rel := quoteIdent(schema) + "." + quoteIdent(table)
+
+	var d PGTableDetails
+	err = db.QueryRowContext(ctx,
+		`SELECT COALESCE(obj_description($1::regclass, 'pg_class'), '')`, rel).Scan(&d.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("get table comment: %w", err)
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT contype::text, pg_get_constraintdef(oid)
+		FROM pg_catalog.pg_constraint
+		WHERE conrelid = $1::regclass AND contype IN ('p', 'f')
+		ORDER BY contype DESC, conname`, rel)
+	if err != nil {
+		return nil, fmt.Errorf("get constraints: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, def string
+		if err := rows.Scan(&kind, &def); err != nil {
+			return nil, fmt.Errorf("scan constraint: %w", err)
+		}
+		if kind == "p" {
+			d.PrimaryKey = strings.TrimPrefix(def, "PRIMARY KEY ")
+		} else {
+			d.ForeignKeys = append(d.ForeignKeys, strings.TrimPrefix(def, "FOREIGN KEY "))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get constraints: %w", err)
+	}
+
+	idxRows, err := db.QueryContext(ctx, `
+		SELECT i.relname || CASE WHEN x.indisunique THEN ' unique ' ELSE ' ' END
+			|| substring(pg_get_indexdef(x.indexrelid) from ' USING (.*)$')
+		FROM pg_catalog.pg_index x
+		JOIN pg_catalog.pg_class i ON i.oid = x.indexrelid
+		WHERE x.indrelid = $1::regclass AND NOT x.indisprimary
+		ORDER BY i.relname`, rel)
+	if err != nil {
+		return nil, fmt.Errorf("get indexes: %w", err)
+	}
+	defer idxRows.Close()
+	for idxRows.Next() {
+		var idx string
+		if err := idxRows.Scan(&idx); err != nil {
+			return nil, fmt.Errorf("scan index: %w", err)
+		}
+		d.Indexes = append(d.Indexes, idx)
+	}
+	if err := idxRows.Err(); err != nil {
+		return nil, fmt.Errorf("get indexes: %w", err)
+	}
+	return &d, nil
+}
+
 // GetSampleData returns up to 3 sample rows from a table.
 func (s *PostgresService) GetSampleData(ctx context.Context, dbName, schema, table string) (*PGQueryResult, error) {
 	db, err := s.GetPool(dbName)
@@ -253,6 +330,24 @@ func (s *PostgresService) ExplainCost(ctx context.Context, dbName, sqlQuery stri
 	return cost, nil
 }
 
+// IsPGQueryError reports whether err from ExecuteQuery is PostgreSQL
+// rejecting the query itself (a syntax error, undefined table or column, or
+// a data exception such as an invalid cast) rather than a connection,
+// permission or timeout failure, i.e. whether rewriting the SQL can fix it.
+func IsPGQueryError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || len(pgErr.Code) != 5 {
+		return false
+	}
+	switch pgErr.Code[:2] {
+	case "42": // syntax error or access rule violation
+		return pgErr.Code != "42501" // insufficient_privilege
+	case "21", "22": // cardinality violation, data exception
+		return true
+	}
+	return false
+}
+
 // PGSchemaToString formats column metadata for LLM context injection.
 func PGSchemaToString(cols []PGColumnInfo) string {
 	var sb strings.Builder
@@ -261,7 +356,33 @@ func PGSchemaToString(cols []PGColumnInfo) string {
 		if c.IsNullable == "YES" {
 			nullable = " (nullable)"
 		}
-		sb.WriteString(fmt.Sprintf("  %s %s%s\n", c.Name, c.DataType, nullable))
+		comment := ""
+		if c.Comment != "" {
+			comment = " -- " + strings.Join(strings.Fields(c.Comment), " ")
+		}
+		sb.WriteString(fmt.Sprintf("  %s %s%s%s\n", c.Name, c.DataType, nullable, comment))
+	}
+	return sb.String()
+}
+
+// PGTableDetailsToString formats table keys, indexes and comment for LLM
+// context, one "Label: value" line each. It returns "" when there is none.
+func PGTableDetailsToString(d *PGTableDetails) string {
+	if d == nil {
+		return ""
+	}
+	var sb strings.Builder
+	if d.Comment != "" {
+		sb.WriteString("Comment: " + strings.Join(strings.Fields(d.Comment), " ") + "\n")
+	}
+	if d.PrimaryKey != "" {
+		sb.WriteString("Primary key: " + d.PrimaryKey + "\n")
+	}
+	if len(d.ForeignKeys) > 0 {
+		sb.WriteString("Foreign keys: " + strings.Join(d.ForeignKeys, "; ") + "\n")
+	}
+	if len(d.Indexes) > 0 {
+		sb.WriteString("Indexes: " + strings.Join(d.Indexes, "; ") + "\n")
 	}
 	return sb.String()
 }
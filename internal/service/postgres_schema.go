@@ -0,0 +1,170 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// PGTableSchema is the columns, keys and indexes of one table of a database.
+type PGTableSchema struct {
+	PGTableInfo
+	Columns []PGColumnInfo
+	Details PGTableDetails
+}
+
+// pgDatabaseSchemaQuery reads every table and view the current user can
+// select from, with its columns, comment, keys and indexes, from pg_catalog
+// in one round trip. Columns, foreign keys and indexes are aggregated into
+// JSON arrays; column types are as format_type renders them, so they carry
+// their modifiers ("character varying(255)").
+const pgDatabaseSchemaQuery = `
+SELECT
+	n.nspname,
+	c.relname,
+	CASE c.relkind WHEN 'v' THEN 'VIEW' WHEN 'f' THEN 'FOREIGN' ELSE 'BASE TABLE' END,
+	COALESCE((
+		SELECT json_agg(json_build_object(
+			'name', a.attname,
+			'data_type', format_type(a.atttypid, a.atttypmod),
+			'is_nullable', CASE WHEN a.attnotnull THEN 'NO' ELSE 'YES' END,
+			'column_default', pg_get_expr(d.adbin, d.adrelid),
+			'comment', col_description(c.oid, a.attnum)
+		) ORDER BY a.attnum)
+		FROM pg_catalog.pg_attribute a
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+	), '[]'),
+	COALESCE(obj_description(c.oid, 'pg_class'), ''),
+	COALESCE((
+		SELECT pg_get_constraintdef(k.oid)
+		FROM pg_catalog.pg_constraint k
+		WHERE k.conrelid = c.oid AND k.contype = 'p'
+	), ''),
+	COALESCE((
+		SELECT json_agg(pg_get_constraintdef(k.oid) ORDER BY k.conname)
+		FROM pg_catalog.pg_constraint k
+		WHERE k.conrelid = c.oid AND k.contype = 'f'
+	), '[]'),
+	COALESCE((
+		SELECT json_agg(i.relname || CASE WHEN x.indisunique THEN ' unique ' ELSE ' ' END
+			|| substring(pg_get_indexdef(x.indexrelid) from ' USING (.*)$') ORDER BY i.relname)
+		FROM pg_catalog.pg_index x
+		JOIN pg_catalog.pg_class i ON i.oid = x.indexrelid
+		WHERE x.indrelid = c.oid AND NOT x.indisprimary
+	), '[]')
+FROM pg_catalog.pg_class c
+JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'v', 'f')
+	AND n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%'
+	AND has_any_column_privilege(c.oid, 'SELECT')
+ORDER BY n.nspname, c.relname`
+
+// GetDatabaseSchema returns the columns, keys and indexes of every table and
+// view in the given database, ordered by schema and name, with a single
+// catalog query. When that query fails it falls back to ListTables plus
+// GetTableSchema and GetTableDetails per table, a few tables at a time.
+func (s *PostgresService) GetDatabaseSchema(ctx context.Context, dbName string) ([]PGTableSchema, error) {
+	tables, err := s.databaseSchemaFromCatalog(ctx, dbName)
+	if err == nil {
+		return tables, nil
+	}
+	log.Warn().Err(err).Str("database", dbName).Msg("pg catalog schema load failed, falling back to per-table queries")
+	return s.databaseSchemaPerTable(ctx, dbName)
+}
+
+func (s *PostgresService) databaseSchemaFromCatalog(ctx context.Context, dbName string) ([]PGTableSchema, error) {
+	db, err := s.GetPool(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, pgDatabaseSchemaQuery)
+	if err != nil {
+		return nil, fmt.Errorf("query catalog: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []PGTableSchema
+	for rows.Next() {
+		var t PGTableSchema
+		var cols, fks, idxs []byte
+		if err := rows.Scan(&t.Schema, &t.Name, &t.Type, &cols, &t.Details.Comment, &t.Details.PrimaryKey, &fks, &idxs); err != nil {
+			return nil, fmt.Errorf("scan table: %w", err)
+		}
+		if err := decodeCatalogTable(&t, cols, fks, idxs); err != nil {
+			return nil, fmt.Errorf("decode %s.%s: %w", t.Schema, t.Name, err)
+		}
+		tables = append(tables, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query catalog: %w", err)
+	}
+	return tables, nil
+}
+
+// decodeCatalogTable fills t from the JSON arrays of pgDatabaseSchemaQuery.
+func decodeCatalogTable(t *PGTableSchema, cols, fks, idxs []byte) error {
+	if err := json.Unmarshal(cols, &t.Columns); err != nil {
+		return fmt.Errorf("columns: %w", err)
+	}
+	if err := json.Unmarshal(fks, &t.Details.ForeignKeys); err != nil {
+		return fmt.Errorf("foreign keys: %w", err)
+	}
+	if err := json.Unmarshal(idxs, &t.Details.Indexes); err != nil {
+		return fmt.Errorf("indexes: %w", err)
+	}
+	t.Details.PrimaryKey = strings.TrimPrefix(t.Details.PrimaryKey, "PRIMARY KEY ")
+	for i, fk := range t.Details.ForeignKeys {
+		t.Details.ForeignKeys[i] = strings.TrimPrefix(fk, "FOREIGN KEY ")
+	}
+	return nil
+}
+
+// databaseSchemaPerTable loads the schema table by table, with at most
+// schemaFetchConcurrency tables (and no more than the pool's connections)
+// in flight. Tables that fail are logged and skipped.
+func (s *PostgresService) databaseSchemaPerTable(ctx context.Context, dbName string) ([]PGTableSchema, error) {
+	infos, err := s.ListTables(ctx, dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]PGTableSchema, len(infos))
+	ok := make([]bool, len(infos))
+	var g errgroup.Group
+	g.SetLimit(min(schemaFetchConcurrency, s.maxConns))
+	for i, info := range infos {
+		g.Go(func() error {
+			cols, err := s.GetTableSchema(ctx, dbName, info.Schema, info.Name)
+			if err != nil {
+				log.Warn().Err(err).Str("table", info.Schema+"."+info.Name).Msg("load database schema: get schema failed")
+				return nil
+			}
+			tables[i] = PGTableSchema{PGTableInfo: info, Columns: cols}
+			if details, err := s.GetTableDetails(ctx, dbName, info.Schema, info.Name); err == nil {
+				tables[i].Details = *details
+			} else {
+				log.Warn().Err(err).Str("table", info.Schema+"."+info.Name).Msg("load database schema: get keys and indexes failed")
+			}
+			ok[i] = true
+			return nil
+		})
+	}
+	g.Wait() // tables that fail are logged and skipped
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	loaded := tables[:0]
+	for i, t := range tables {
+		if ok[i] {
+			loaded = append(loaded, t)
+		}
+	}
+	return loaded, nil
+}
@@ -1,6 +1,12 @@
 package service
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
 
 func TestPGSchemaToString_Basic(t *testing.T) {
 	cols := []PGColumnInfo{
@@ -27,6 +33,68 @@ func TestPGSchemaToString_Empty(t *testing.T) {
 	}
 }
 
+func TestPGSchemaToString_ColumnComments(t *testing.T) {
+	cols := []PGColumnInfo{
+		{Name: "status", DataType: "text", IsNullable: "NO", Comment: "order state:\n  pending, paid"},
+	}
+	if got := PGSchemaToString(cols); got != "  status text -- order state: pending, paid\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestPGTableDetailsToString(t *testing.T) {
+	d := &PGTableDetails{
+		Comment:     "one row per order",
+		PrimaryKey:  "(id)",
+		ForeignKeys: []string{"(customer_id) REFERENCES customers(id)", "(driver_id) REFERENCES drivers(id)"},
+		Indexes:     []string{"orders_created_at_idx btree (created_at)"},
+	}
+	want := "Comment: one row per order\n" +
+		"Primary key: (id)\n" +
+		"Foreign keys: (customer_id) REFERENCES customers(id); (driver_id) REFERENCES drivers(id)\n" +
+		"Indexes: orders_created_at_idx btree (created_at)\n"
+	if got := PGTableDetailsToString(d); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := PGTableDetailsToString(&PGTableDetails{}); got != "" {
+		t.Errorf("empty details: got %q", got)
+	}
+	if got := PGTableDetailsToString(nil); got != "" {
+		t.Errorf("nil details: got %q", got)
+	}
+}
+
+func TestDecodeCatalogTable(t *testing.T) {
+	tbl := PGTableSchema{
+		PGTableInfo: PGTableInfo{Schema: "public", Name: "orders", Type: "BASE TABLE"},
+		Details:     PGTableDetails{PrimaryKey: "PRIMARY KEY (id)"},
+	}
+	cols := []byte(`[{"name":"id","data_type":"bigint","is_nullable":"NO","column_default":"nextval('orders_id_seq'::regclass)","comment":null},
+		{"name":"customer_id","data_type":"bigint","is_nullable":"YES","column_default":null,"comment":"buyer"}]`)
+	fks := []byte(`["FOREIGN KEY (customer_id) REFERENCES customers(id)"]`)
+	idxs := []byte(`["orders_customer_idx btree (customer_id)"]`)
+	if err := decodeCatalogTable(&tbl, cols, fks, idxs); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := PGSchemaToString(tbl.Columns); got != "  id bigint\n  customer_id bigint (nullable) -- buyer\n" {
+		t.Errorf("columns: got %q", got)
+	}
+	if tbl.Columns[0].Default == nil || *tbl.Columns[0].Default != "nextval('orders_id_seq'::regclass)" {
+		t.Errorf("default = %v", tbl.Columns[0].Default)
+	}
+	want := "Primary key: (id)\n" +
+		"Foreign keys: (customer_id) REFERENCES customers(id)\n" +
+		"Indexes: orders_customer_idx btree (customer_id)\n"
+	if got := PGTableDetailsToString(&tbl.Details); got != want {
+		t.Errorf("details:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := decodeCatalogTable(&tbl, []byte(`{`), fks, idxs); err == nil {
+		t.Error("expected an error for malformed columns")
+	}
+}
+
 func TestPGPoolRegistry_RegisterAndGet(t *testing.T) {
 	reg := NewPGPoolRegistry()
 	svc := NewPostgresService("localhost", 5432, "user", "pass", "disable", 5)
@@ -88,3 +156,21 @@ func containsStr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestIsPGQueryError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("execute query: %w", &pgconn.PgError{Code: "42703"}), true}, // undefined_column
+		{&pgconn.PgError{Code: "22P02"}, true},                                  // invalid_text_representation
+		{&pgconn.PgError{Code: "42501"}, false},                                 // insufficient_privilege
+		{&pgconn.PgError{Code: "57014"}, false},                                 // query_canceled
+		{errors.New("begin read-only tx: connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := IsPGQueryError(tt.err); got != tt.want {
+			t.Errorf("IsPGQueryError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
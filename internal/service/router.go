@@ -9,6 +9,10 @@ const (
 	DataSourceBigQuery      DataSource = "bigquery"
 	DataSourceElasticsearch DataSource = "elasticsearch"
 	DataSourcePostgres      DataSource = "postgres"
+
+	// DataSourceFederated combines BigQuery, PostgreSQL and Elasticsearch in one
+	// agent run. It is never chosen by IntentRouter; clients request it explicitly.
+	DataSourceFederated DataSource = "federated"
 )
 
 var elasticsearchKeywords = []string{
@@ -5,12 +5,13 @@ import "github.com/cortexai/cortexai/internal/models"
 // UserEntry is the raw input used to build a UserStore (mirrors config.UserConfig
 // but kept separate so service does not import config).
 type UserEntry struct {
-	ID      string
-	Name    string
-	Role    string // "admin" | "analyst" | "viewer"
-	APIKey  string
-	SquadID string
-	Persona string // references persona config key; empty = "default"
+	ID         string
+	Name       string
+	Role       string // "admin" | "analyst" | "viewer"
+	APIKey     string
+	SquadID    string
+	Persona    string // references persona config key; empty = "default"
+	RowFilters []models.RowFilter
 }
 
 // SquadEntry mirrors config.SquadConfig for the same reason.
@@ -20,6 +21,10 @@ type SquadEntry struct {
 	Datasets        []string
 	ESIndexPatterns []string
 	PGDatabases     []string
+	AllowedTables   []string
+	DeniedTables    []string
+	DeniedColumns   []string
+	RowFilters      []models.RowFilter
 }
 
 // UserStore maps API keys to User objects.
@@ -42,6 +47,10 @@ func NewUserStore(users []UserEntry, squads []SquadEntry, legacyKeys []string) *
 			Datasets:        se.Datasets,
 			ESIndexPatterns: se.ESIndexPatterns,
 			PGDatabases:     se.PGDatabases,
+			AllowedTables:   se.AllowedTables,
+			DeniedTables:    se.DeniedTables,
+			DeniedColumns:   se.DeniedColumns,
+			RowFilters:      se.RowFilters,
 		}
 	}
 
@@ -60,6 +69,8 @@ func NewUserStore(users []UserEntry, squads []SquadEntry, legacyKeys []string) *
 			APIKey:  ue.APIKey,
 			SquadID: ue.SquadID,
 			Persona: ue.Persona,
+
+			RowFilters: ue.RowFilters,
 		}
 		if ue.SquadID != "" {
 			u.Squad = squadMap[ue.SquadID] // nil if squad_id not found — treated as no restriction
@@ -8,8 +8,11 @@ import (
 	"github.com/cortexai/cortexai/internal/service"
 )
 
-// BQExecuteQueryTool executes a SQL query and returns results
-func BQExecuteQueryTool(bq *service.BigQueryService) Tool {
+// BQExecuteQueryTool executes a SQL query and returns results. Queries run
+// through policy (validation, table access with unqualified tables resolved
+// against datasetID, dry-run cost check, masking and audit); nil runs them
+// unchecked.
+func BQExecuteQueryTool(bq *service.BigQueryService, policy *QueryPolicy, datasetID string) Tool {
 	return Tool{
 		Name:        "execute_bigquery_sql",
 		Description: "Execute a SQL SELECT query on BigQuery and return the results. Only SELECT queries are allowed.",
@@ -29,9 +32,9 @@ func BQExecuteQueryTool(bq *service.BigQueryService) Tool {
 				return "", fmt.Errorf("sql is required")
 			}
 
-			result, err := bq.ExecuteQuery(ctx, sql, "", false, 60000, true, false)
+			result, err := policy.RunBigQuery(ctx, bq, "execute_bigquery_sql", sql, datasetID, 60000)
 			if err != nil {
-				return "", fmt.Errorf("execute query: %w", err)
+				return "", err
 			}
 
 			out := map[string]interface{}{
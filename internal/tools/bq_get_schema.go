@@ -3,15 +3,19 @@ package tools
 import (
 	"context"
 	"fmt"
+	"slices"
 
+	"cloud.google.com/go/bigquery"
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
-// BQGetSchemaTool returns the schema for a BigQuery table
-func BQGetSchemaTool(bq *service.BigQueryService) Tool {
+// BQGetSchemaTool returns the schema for a BigQuery table, leaving out the
+// columns policy denies; tables it denies are reported as inaccessible.
+func BQGetSchemaTool(bq *service.BigQueryService, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "get_bigquery_schema",
-		Description: "Get the schema (column names and types) for a specific BigQuery table. Use this before writing SQL to understand the table structure.",
+		Description: "Get the schema (column names, types and descriptions, partitioning and clustering) for a specific BigQuery table. Use this before writing SQL to understand the table structure.",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -33,20 +37,35 @@ func BQGetSchemaTool(bq *service.BigQueryService) Tool {
 				return "", fmt.Errorf("dataset_id and table_id are required")
 			}
 
+			if err := policy.checkTable(security.DialectBigQuery, datasetID, datasetID, tableID); err != nil {
+				return "", fmt.Errorf("get schema: %w", err)
+			}
 			schema, meta, err := bq.GetTableSchema(ctx, datasetID, tableID)
 			if err != nil {
 				return "", fmt.Errorf("get schema: %w", err)
 			}
+			if policy.hasDeniedColumns(security.DialectBigQuery, datasetID, datasetID, tableID) {
+				names := make([]string, len(schema))
+				for i, f := range schema {
+					names[i] = f.Name
+				}
+				allowed := policy.allowedColumns(security.DialectBigQuery, datasetID, names, datasetID, tableID)
+				schema = slices.DeleteFunc(slices.Clone(schema), func(f *bigquery.FieldSchema) bool { return !slices.Contains(allowed, f.Name) })
+			}
 
 			schemaStr := service.SchemaToString(schema)
-			return fmt.Sprintf("Table: %s.%s\nRows: %d\nSchema:\n%s",
-				datasetID, tableID, meta.NumRows, schemaStr), nil
+			result := fmt.Sprintf("Table: %s.%s\nRows: %d\n%sSchema:\n%s",
+				datasetID, tableID, meta.NumRows, service.TableDetailsToString(meta), schemaStr)
+			if col := service.PartitionColumn(meta); col != "" {
+				result += fmt.Sprintf("Always filter on the partition column %s in WHERE.\n", col)
+			}
+			return result, nil
 		},
 	}
 }
 
-// BQListTablesTool lists tables in a dataset
-func BQListTablesTool(bq *service.BigQueryService) Tool {
+// BQListTablesTool lists the tables of a dataset policy lets queries read.
+func BQListTablesTool(bq *service.BigQueryService, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "list_bigquery_tables",
 		Description: "List all tables in a BigQuery dataset.",
@@ -73,6 +92,9 @@ func BQListTablesTool(bq *service.BigQueryService) Tool {
 
 			result := fmt.Sprintf("Tables in dataset %q:\n", datasetID)
 			for _, t := range tables {
+				if policy.checkTable(security.DialectBigQuery, datasetID, datasetID, t.ID) != nil {
+					continue
+				}
 				result += fmt.Sprintf("  - %s (type: %s, rows: %d)\n", t.ID, t.Type, t.NumRows)
 			}
 			return result, nil
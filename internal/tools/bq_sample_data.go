@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
 // BQSampleDataTool fetches a few sample rows from a table so the agent
 // can understand actual data values, types, and join key relationships.
-func BQSampleDataTool(bq *service.BigQueryService) Tool {
+// The sample query runs through policy like any other tool query, selecting
+// only the columns the policy lets the squad read.
+func BQSampleDataTool(bq *service.BigQueryService, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "get_bigquery_sample_data",
 		Description: "Get 3 sample rows from a BigQuery table to understand actual data values, formats, and relationships. Use this before writing JOIN queries to verify foreign key values match across tables.",
@@ -35,8 +38,26 @@ func BQSampleDataTool(bq *service.BigQueryService) Tool {
 				return "", fmt.Errorf("dataset_id and table_id are required")
 			}
 
-			sql := fmt.Sprintf("SELECT * FROM `%s.%s` LIMIT 3", datasetID, tableID)
-			result, err := bq.ExecuteQuery(ctx, sql, "", false, 10000, true, false)
+			if err := policy.checkTable(security.DialectBigQuery, datasetID, datasetID, tableID); err != nil {
+				return "", fmt.Errorf("sample data: %w", err)
+			}
+			columns, err := policy.sampleColumns(security.DialectBigQuery, datasetID, []string{datasetID, tableID}, func() ([]string, error) {
+				schema, _, err := bq.GetTableSchema(ctx, datasetID, tableID)
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, len(schema))
+				for i, f := range schema {
+					names[i] = f.Name
+				}
+				return names, nil
+			}, bqQuoteIdent)
+			if err != nil {
+				return "", fmt.Errorf("sample data: %w", err)
+			}
+
+			sql := fmt.Sprintf("SELECT %s FROM `%s.%s` LIMIT 3", columns, datasetID, tableID)
+			result, err := policy.RunBigQuery(ctx, bq, "get_bigquery_sample_data", sql, datasetID, 10000)
 			if err != nil {
 				return "", fmt.Errorf("sample data: %w", err)
 			}
@@ -9,7 +9,9 @@ import (
 )
 
 // PGExecuteQueryTool runs a read-only SQL query against a PostgreSQL database.
-func PGExecuteQueryTool(pg *service.PostgresService, dbName string) Tool {
+// Queries run through policy (validation, table access, EXPLAIN cost check,
+// masking and audit); nil runs them unchecked.
+func PGExecuteQueryTool(pg *service.PostgresService, dbName string, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "execute_postgres_sql",
 		Description: "Execute a read-only SELECT query against the PostgreSQL database. Only SELECT statements are allowed.",
@@ -29,9 +31,9 @@ func PGExecuteQueryTool(pg *service.PostgresService, dbName string) Tool {
 				return "", fmt.Errorf("sql is required")
 			}
 
-			result, err := pg.ExecuteQuery(ctx, dbName, sqlQuery, 60000)
+			result, err := policy.RunPostgres(ctx, pg, "execute_postgres_sql", dbName, sqlQuery, 60000)
 			if err != nil {
-				return "", fmt.Errorf("execute query: %w", err)
+				return "", err
 			}
 
 			out := map[string]interface{}{
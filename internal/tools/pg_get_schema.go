@@ -3,15 +3,19 @@ package tools
 import (
 	"context"
 	"fmt"
+	"slices"
 
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
-// PGGetSchemaTool returns column details for a specific PostgreSQL table.
-func PGGetSchemaTool(pg *service.PostgresService, dbName string) Tool {
+// PGGetSchemaTool returns column details for a specific PostgreSQL table,
+// leaving out the columns policy denies; tables it denies are reported as
+// inaccessible.
+func PGGetSchemaTool(pg *service.PostgresService, dbName string, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "get_postgres_schema",
-		Description: "Get column details (name, data type, nullable) for a specific PostgreSQL table. Provide the schema and table name.",
+		Description: "Get column details (name, data type, nullable, comment), keys and indexes for a specific PostgreSQL table. Provide the schema and table name.",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -36,12 +40,27 @@ func PGGetSchemaTool(pg *service.PostgresService, dbName string) Tool {
 				return "", fmt.Errorf("table is required")
 			}
 
+			if err := policy.checkTable(security.DialectPostgres, dbName, schema, table); err != nil {
+				return "", fmt.Errorf("get schema: %w", err)
+			}
 			cols, err := pg.GetTableSchema(ctx, dbName, schema, table)
 			if err != nil {
 				return "", fmt.Errorf("get schema: %w", err)
 			}
+			if policy.hasDeniedColumns(security.DialectPostgres, dbName, schema, table) {
+				names := make([]string, len(cols))
+				for i, c := range cols {
+					names[i] = c.Name
+				}
+				allowed := policy.allowedColumns(security.DialectPostgres, dbName, names, schema, table)
+				cols = slices.DeleteFunc(cols, func(c service.PGColumnInfo) bool { return !slices.Contains(allowed, c.Name) })
+			}
+
+			// Keys and indexes are a bonus; the columns are enough to write SQL.
+			details, _ := pg.GetTableDetails(ctx, dbName, schema, table)
 
-			result := fmt.Sprintf("Table: %s.%s\nColumns:\n%s", schema, table, service.PGSchemaToString(cols))
+			result := fmt.Sprintf("Table: %s.%s\n%sColumns:\n%s", schema, table,
+				service.PGTableDetailsToString(details), service.PGSchemaToString(cols))
 			return result, nil
 		},
 	}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"slices"
 
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
-// PGListTablesTool lists tables in a PostgreSQL database.
-func PGListTablesTool(pg *service.PostgresService, dbName string) Tool {
+// PGListTablesTool lists the tables of a PostgreSQL database policy lets
+// queries read.
+func PGListTablesTool(pg *service.PostgresService, dbName string, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "list_postgres_tables",
 		Description: "List all tables and views in the PostgreSQL database. Returns schema, name, and type for each table.",
@@ -23,6 +26,9 @@ func PGListTablesTool(pg *service.PostgresService, dbName string) Tool {
 			if err != nil {
 				return "", fmt.Errorf("list tables: %w", err)
 			}
+			tables = slices.DeleteFunc(tables, func(t service.PGTableInfo) bool {
+				return policy.checkTable(security.DialectPostgres, dbName, t.Schema, t.Name) != nil
+			})
 			b, _ := json.Marshal(tables)
 			return string(b), nil
 		},
@@ -5,11 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/cortexai/cortexai/internal/security"
 	"github.com/cortexai/cortexai/internal/service"
 )
 
-// PGSampleDataTool fetches 3 sample rows from a PostgreSQL table.
-func PGSampleDataTool(pg *service.PostgresService, dbName string) Tool {
+// PGSampleDataTool fetches 3 sample rows from a PostgreSQL table. The sample
+// query runs through policy like any other tool query, selecting only the
+// columns the policy lets the squad read.
+func PGSampleDataTool(pg *service.PostgresService, dbName string, policy *QueryPolicy) Tool {
 	return Tool{
 		Name:        "get_postgres_sample_data",
 		Description: "Fetch 3 sample rows from a PostgreSQL table. Useful for verifying join key values before writing complex queries.",
@@ -37,7 +40,26 @@ func PGSampleDataTool(pg *service.PostgresService, dbName string) Tool {
 				return "", fmt.Errorf("table is required")
 			}
 
-			result, err := pg.GetSampleData(ctx, dbName, schema, table)
+			if err := policy.checkTable(security.DialectPostgres, dbName, schema, table); err != nil {
+				return "", fmt.Errorf("sample data: %w", err)
+			}
+			columns, err := policy.sampleColumns(security.DialectPostgres, dbName, []string{schema, table}, func() ([]string, error) {
+				cols, err := pg.GetTableSchema(ctx, dbName, schema, table)
+				if err != nil {
+					return nil, err
+				}
+				names := make([]string, len(cols))
+				for i, c := range cols {
+					names[i] = c.Name
+				}
+				return names, nil
+			}, pgQuoteIdent)
+			if err != nil {
+				return "", fmt.Errorf("sample data: %w", err)
+			}
+
+			sql := fmt.Sprintf("SELECT %s FROM %s.%s LIMIT 3", columns, pgQuoteIdent(schema), pgQuoteIdent(table))
+			result, err := policy.RunPostgres(ctx, pg, "get_postgres_sample_data", dbName, sql, 10000)
 			if err != nil {
 				return "", fmt.Errorf("sample data: %w", err)
 			}
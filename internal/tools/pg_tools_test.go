@@ -3,6 +3,8 @@ package tools
 import (
 	"context"
 	"testing"
+
+	"github.com/cortexai/cortexai/internal/security"
 )
 
 func TestPGListDatabasesTool_Name(t *testing.T) {
@@ -42,14 +44,14 @@ func TestPGListDatabasesTool_EmptyList(t *testing.T) {
 }
 
 func TestPGGetSchemaTool_Name(t *testing.T) {
-	tool := PGGetSchemaTool(nil, "testdb")
+	tool := PGGetSchemaTool(nil, "testdb", nil)
 	if tool.Name != "get_postgres_schema" {
 		t.Errorf("expected name 'get_postgres_schema', got %q", tool.Name)
 	}
 }
 
 func TestPGGetSchemaTool_RequiresSchema(t *testing.T) {
-	tool := PGGetSchemaTool(nil, "testdb")
+	tool := PGGetSchemaTool(nil, "testdb", nil)
 	_, err := tool.Execute(context.Background(), map[string]interface{}{
 		"table": "users",
 	})
@@ -59,7 +61,7 @@ func TestPGGetSchemaTool_RequiresSchema(t *testing.T) {
 }
 
 func TestPGGetSchemaTool_RequiresTable(t *testing.T) {
-	tool := PGGetSchemaTool(nil, "testdb")
+	tool := PGGetSchemaTool(nil, "testdb", nil)
 	_, err := tool.Execute(context.Background(), map[string]interface{}{
 		"schema": "public",
 	})
@@ -69,14 +71,14 @@ func TestPGGetSchemaTool_RequiresTable(t *testing.T) {
 }
 
 func TestPGSampleDataTool_Name(t *testing.T) {
-	tool := PGSampleDataTool(nil, "testdb")
+	tool := PGSampleDataTool(nil, "testdb", nil)
 	if tool.Name != "get_postgres_sample_data" {
 		t.Errorf("expected name 'get_postgres_sample_data', got %q", tool.Name)
 	}
 }
 
 func TestPGSampleDataTool_RequiresSchema(t *testing.T) {
-	tool := PGSampleDataTool(nil, "testdb")
+	tool := PGSampleDataTool(nil, "testdb", nil)
 	_, err := tool.Execute(context.Background(), map[string]interface{}{
 		"table": "users",
 	})
@@ -86,22 +88,40 @@ func TestPGSampleDataTool_RequiresSchema(t *testing.T) {
 }
 
 func TestPGExecuteQueryTool_Name(t *testing.T) {
-	tool := PGExecuteQueryTool(nil, "testdb")
+	tool := PGExecuteQueryTool(nil, "testdb", nil)
 	if tool.Name != "execute_postgres_sql" {
 		t.Errorf("expected name 'execute_postgres_sql', got %q", tool.Name)
 	}
 }
 
 func TestPGExecuteQueryTool_RequiresSQL(t *testing.T) {
-	tool := PGExecuteQueryTool(nil, "testdb")
+	tool := PGExecuteQueryTool(nil, "testdb", nil)
 	_, err := tool.Execute(context.Background(), map[string]interface{}{})
 	if err == nil {
 		t.Error("expected error for missing sql")
 	}
 }
 
+func TestPGExecuteQueryTool_RejectsDeniedTable(t *testing.T) {
+	policy := &QueryPolicy{Access: &security.TableAccessPolicy{Databases: []string{"testdb"}, DeniedTables: []string{"public.api_tokens"}}}
+	tool := PGExecuteQueryTool(nil, "testdb", policy) // nil service: must fail before executing
+	_, err := tool.Execute(context.Background(), map[string]interface{}{"sql": "SELECT token FROM api_tokens"})
+	if err == nil || !containsStr(err.Error(), "access denied") {
+		t.Errorf("expected access denied error, got %v", err)
+	}
+}
+
+func TestBQExecuteQueryTool_RejectsOtherSquadDataset(t *testing.T) {
+	policy := &QueryPolicy{Access: &security.TableAccessPolicy{Datasets: []string{"payment_ds"}}}
+	tool := BQExecuteQueryTool(nil, policy, "payment_ds")
+	_, err := tool.Execute(context.Background(), map[string]interface{}{"sql": "SELECT * FROM user_ds.users"})
+	if err == nil || !containsStr(err.Error(), "dataset user_ds") {
+		t.Errorf("expected dataset access error, got %v", err)
+	}
+}
+
 func TestPGListTablesTool_Name(t *testing.T) {
-	tool := PGListTablesTool(nil, "testdb")
+	tool := PGListTablesTool(nil, "testdb", nil)
 	if tool.Name != "list_postgres_tables" {
 		t.Errorf("expected name 'list_postgres_tables', got %q", tool.Name)
 	}
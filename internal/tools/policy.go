@@ -0,0 +1,245 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+// QueryPolicy is the security pipeline applied to every query a tool runs on
+// the model's behalf, so that intermediate results seen during the agent
+// loop get the same treatment as the final answer. Queries are validated,
+// checked against Access and rewritten with its row filters, cost-checked
+// before they run (a BigQuery dry run against Cost, a PostgreSQL EXPLAIN
+// against PGCost), and their rows masked before being returned to the
+// model. Each call is written to Audit, and each successful query to Log.
+//
+// Nil fields skip their step; a nil policy runs queries unchecked.
+type QueryPolicy struct {
+	Validator *security.SQLValidator
+	Access    *security.TableAccessPolicy
+	Cost      *security.CostTracker
+	PGCost    *security.PGCostTracker
+	Masker    *security.DataMasker
+	Audit     *security.AuditLogger
+	Log       *QueryLog
+	APIKey    string // caller billed and audited
+	ProjectID string // BigQuery project queries run in; "" for the default
+}
+
+// check validates sql and the tables and columns it reads, and returns the
+// SQL to run: sql with Access's row filters applied. defaultScope is the
+// dataset unqualified BigQuery tables resolve to, or the PostgreSQL database
+// the query runs against.
+func (p *QueryPolicy) check(sql string, dialect security.SQLDialect, defaultScope string) (string, error) {
+	if p == nil {
+		return sql, nil
+	}
+	v := p.Validator
+	if v == nil {
+		v = security.NewSQLValidator()
+	}
+	analysis := v.Analyze(sql, dialect)
+	if !analysis.Valid() {
+		return "", fmt.Errorf("SQL validation failed: %s", analysis.Message())
+	}
+	if violations := p.Access.Check(analysis, defaultScope); len(violations) > 0 {
+		return "", fmt.Errorf("access denied: %s", violations[0].Message)
+	}
+	execSQL, _, err := p.Access.ApplyRowFilters(sql, analysis, defaultScope)
+	if err != nil {
+		return "", fmt.Errorf("row-level security: %w", err)
+	}
+	return execSQL, nil
+}
+
+// checkTable returns an error if Access does not let queries read the table
+// at path parts, so metadata tools hide what queries could not read.
+func (p *QueryPolicy) checkTable(dialect security.SQLDialect, defaultScope string, parts ...string) error {
+	if p == nil {
+		return nil
+	}
+	if msg := p.Access.TableViolation(dialect, parts, defaultScope); msg != "" {
+		return fmt.Errorf("access denied: %s", msg)
+	}
+	return nil
+}
+
+// allowedColumns returns the names of columns Access lets queries read from
+// the table at path parts, in order.
+func (p *QueryPolicy) allowedColumns(dialect security.SQLDialect, defaultScope string, columns []string, parts ...string) []string {
+	if p == nil {
+		return columns
+	}
+	denied := p.Access.DeniedColumnsFor(dialect, parts, defaultScope)
+	if len(denied) == 0 {
+		return columns
+	}
+	var out []string
+	for _, c := range columns {
+		if !slices.ContainsFunc(denied, func(d string) bool { return strings.EqualFold(d, c) }) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// hasDeniedColumns reports whether Access denies any column of the table at
+// path parts, in which case SELECT * on it is rejected.
+func (p *QueryPolicy) hasDeniedColumns(dialect security.SQLDialect, defaultScope string, parts ...string) bool {
+	return p != nil && len(p.Access.DeniedColumnsFor(dialect, parts, defaultScope)) > 0
+}
+
+func (p *QueryPolicy) projectID() string {
+	if p == nil {
+		return ""
+	}
+	return p.ProjectID
+}
+
+func (p *QueryPolicy) mask(rows []map[string]interface{}) []map[string]interface{} {
+	if p == nil || p.Masker == nil {
+		return rows
+	}
+	return p.Masker.MaskRows(rows)
+}
+
+// record adds a successful query to Log.
+func (p *QueryPolicy) record(q ExecutedQuery, dialect security.SQLDialect) {
+	if p == nil {
+		return
+	}
+	p.Log.Record(q, dialect)
+}
+
+// audit records one tool-issued query; tool is logged as the user context.
+func (p *QueryPolicy) audit(tool, sql string, start time.Time, rows int, bytes int64, err error) {
+	if p == nil || p.Audit == nil {
+		return
+	}
+	errMsg := ""
+	if err != nil {
+		errMsg = err.Error()
+	}
+	p.Audit.LogQuery(sql, p.APIKey, "agent_tool:"+tool, time.Since(start).Milliseconds(), rows, bytes, err == nil, errMsg)
+}
+
+// RunBigQuery runs sql for tool through the policy and returns the result
+// with masked rows. datasetID is the dataset unqualified tables resolve to.
+func (p *QueryPolicy) RunBigQuery(ctx context.Context, bq *service.BigQueryService, tool, sql, datasetID string, timeoutMs int) (result *service.QueryResult, err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			p.audit(tool, sql, start, 0, 0, err)
+		}
+	}()
+
+	execSQL, err := p.check(sql, security.DialectBigQuery, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	var reservation *security.BudgetReservation
+	if p != nil && p.Cost != nil {
+		dry, err := bq.ExecuteQuery(ctx, execSQL, p.ProjectID, true, timeoutMs, true, false)
+		if err != nil {
+			return nil, fmt.Errorf("dry run: %w", err)
+		}
+		var ok bool
+		var costErr string
+		if reservation, ok, costErr = p.Cost.CheckLimits(dry.TotalBytesProcessed, p.APIKey); !ok {
+			return nil, fmt.Errorf("query cost check failed: %s", costErr)
+		}
+		defer reservation.Release() // no-op once settled
+	}
+
+	result, err = bq.ExecuteQuery(ctx, execSQL, p.projectID(), false, timeoutMs, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %w", err)
+	}
+	if p != nil && p.Cost != nil {
+		reservation.Settle(result.TotalBytesProcessed)
+		p.Cost.LogQueryCost(execSQL, result.TotalBytesProcessed, p.APIKey, result.ExecutionTimeMs)
+	}
+	raw := *result
+	p.record(ExecutedQuery{Tool: tool, SQL: execSQL, Scope: datasetID, BigQuery: &raw, LatencyMs: result.ExecutionTimeMs}, security.DialectBigQuery)
+	result.Data = p.mask(result.Data)
+	p.audit(tool, sql, start, len(result.Data), result.TotalBytesProcessed, nil)
+	return result, nil
+}
+
+// RunPostgres runs sql for tool through the policy against dbName and
+// returns the result with masked rows.
+func (p *QueryPolicy) RunPostgres(ctx context.Context, pg *service.PostgresService, tool, dbName, sql string, timeoutMs int) (result *service.PGQueryResult, err error) {
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			p.audit(tool, sql, start, 0, 0, err)
+		}
+	}()
+
+	execSQL, err := p.check(sql, security.DialectPostgres, dbName)
+	if err != nil {
+		return nil, err
+	}
+	var explainCost *service.PGExplainCost
+	if p != nil && p.PGCost != nil {
+		if cost, explainErr := pg.ExplainCost(ctx, dbName, execSQL); explainErr == nil && cost != nil {
+			if ok, costErr := p.PGCost.CheckCost(cost.TotalCost); !ok {
+				return nil, fmt.Errorf("query cost check failed: %s", costErr)
+			}
+			explainCost = cost
+		}
+	}
+
+	queryStart := time.Now()
+	result, err = pg.ExecuteQuery(ctx, dbName, execSQL, timeoutMs)
+	if err != nil {
+		return nil, fmt.Errorf("execute query: %w", err)
+	}
+	queryMs := time.Since(queryStart).Milliseconds()
+	if explainCost != nil {
+		p.PGCost.LogQueryCost(execSQL, explainCost.TotalCost, p.APIKey, time.Since(start).Milliseconds())
+	}
+	raw := *result
+	p.record(ExecutedQuery{Tool: tool, SQL: execSQL, Scope: dbName, Postgres: &raw, PGCost: explainCost, LatencyMs: queryMs}, security.DialectPostgres)
+	result.Data = p.mask(result.Data)
+	p.audit(tool, sql, start, len(result.Data), 0, nil)
+	return result, nil
+}
+
+// sampleColumns returns the select list of a sample query: * unless Access
+// denies columns of the table, otherwise the allowed columns, quoted, as
+// listed by fetch.
+func (p *QueryPolicy) sampleColumns(dialect security.SQLDialect, defaultScope string, parts []string, fetch func() ([]string, error), quote func(string) string) (string, error) {
+	if !p.hasDeniedColumns(dialect, defaultScope, parts...) {
+		return "*", nil
+	}
+	columns, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	allowed := p.allowedColumns(dialect, defaultScope, columns, parts...)
+	if len(allowed) == 0 {
+		return "", fmt.Errorf("access denied: no column of %s is accessible for your squad", strings.Join(parts, "."))
+	}
+	quoted := make([]string, len(allowed))
+	for i, c := range allowed {
+		quoted[i] = quote(c)
+	}
+	return strings.Join(quoted, ", "), nil
+}
+
+// bqQuoteIdent quotes a BigQuery identifier.
+func bqQuoteIdent(s string) string {
+	return "`" + strings.ReplaceAll(s, "`", "\\`") + "`"
+}
+
+// pgQuoteIdent quotes a PostgreSQL identifier.
+func pgQuoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
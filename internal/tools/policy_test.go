@@ -0,0 +1,130 @@
+package tools
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+func TestQueryPolicy_RejectsWriteSQLBeforeExecuting(t *testing.T) {
+	policy := &QueryPolicy{Audit: security.NewAuditLogger(true)}
+	// nil services: the tools must fail before reaching them
+	for _, tool := range []Tool{
+		BQExecuteQueryTool(nil, policy, "ds"),
+		PGExecuteQueryTool(nil, "testdb", policy),
+	} {
+		_, err := tool.Execute(context.Background(), map[string]interface{}{"sql": "DELETE FROM orders"})
+		if err == nil || !containsStr(err.Error(), "SQL validation failed") {
+			t.Errorf("%s: expected validation error, got %v", tool.Name, err)
+		}
+	}
+}
+
+func TestQueryPolicy_SampleDataChecksTableAccess(t *testing.T) {
+	policy := &QueryPolicy{Access: &security.TableAccessPolicy{
+		Datasets:     []string{"payment_ds"},
+		DeniedTables: []string{"public.api_tokens"},
+	}}
+	_, err := BQSampleDataTool(nil, policy).Execute(context.Background(), map[string]interface{}{
+		"dataset_id": "user_ds", "table_id": "users",
+	})
+	if err == nil || !containsStr(err.Error(), "dataset user_ds") {
+		t.Errorf("BQ sample: expected dataset access error, got %v", err)
+	}
+	_, err = PGSampleDataTool(nil, "testdb", policy).Execute(context.Background(), map[string]interface{}{
+		"schema": "public", "table": "api_tokens",
+	})
+	if err == nil || !containsStr(err.Error(), "access denied") {
+		t.Errorf("PG sample: expected access denied error, got %v", err)
+	}
+}
+
+func TestQueryPolicy_MasksRows(t *testing.T) {
+	policy := &QueryPolicy{Masker: security.NewDataMasker([]string{"national_id"})}
+	rows := policy.mask([]map[string]interface{}{{"id": 1, "national_id": "3171234567890001"}})
+	if rows[0]["national_id"] == "3171234567890001" {
+		t.Error("expected national_id to be masked")
+	}
+	if rows[0]["id"] != 1 {
+		t.Errorf("id = %v, want 1", rows[0]["id"])
+	}
+
+	var none *QueryPolicy
+	if got := none.mask(rows); len(got) != 1 {
+		t.Errorf("nil policy changed rows: %v", got)
+	}
+}
+
+func TestQueryLog_FindsCanonicalMatchInScope(t *testing.T) {
+	log := NewQueryLog()
+	first := &service.QueryResult{JobID: "job-1"}
+	second := &service.QueryResult{JobID: "job-2"}
+	log.Record(ExecutedQuery{Tool: "execute_bigquery_sql", SQL: "SELECT id FROM ds.orders", Scope: "ds", BigQuery: first}, security.DialectBigQuery)
+	log.Record(ExecutedQuery{Tool: "execute_bigquery_sql", SQL: "SELECT id\nFROM ds.orders;", Scope: "ds", BigQuery: second}, security.DialectBigQuery)
+
+	q, ok := log.Find("SELECT  id FROM ds.orders -- final", security.DialectBigQuery, "ds")
+	if !ok || q.BigQuery.JobID != "job-2" {
+		t.Fatalf("Find = %+v, %v; want the most recent run", q, ok)
+	}
+	if _, ok := log.Find("SELECT id FROM ds.orders", security.DialectBigQuery, "other_ds"); ok {
+		t.Error("a query run against another scope must not match")
+	}
+	if _, ok := log.Find("SELECT id FROM ds.orders LIMIT 1", security.DialectBigQuery, "ds"); ok {
+		t.Error("a different query must not match")
+	}
+
+	var none *QueryLog
+	if _, ok := none.Find("SELECT 1", security.DialectBigQuery, "ds"); ok {
+		t.Error("nil log must find nothing")
+	}
+}
+
+func TestQueryPolicy_SampleColumnsLeaveOutDeniedColumns(t *testing.T) {
+	policy := &QueryPolicy{Access: &security.TableAccessPolicy{DeniedColumns: []string{"users.email", "national_id"}}}
+	fetch := func() ([]string, error) { return []string{"id", "email", "national_id", "name"}, nil }
+
+	got, err := policy.sampleColumns(security.DialectBigQuery, "ds", []string{"ds", "users"}, fetch, bqQuoteIdent)
+	if err != nil || got != "`id`, `name`" {
+		t.Errorf("users: got %q, %v; want the allowed columns", got, err)
+	}
+	sql := "SELECT " + got + " FROM `ds.users` LIMIT 3"
+	if _, err := policy.check(sql, security.DialectBigQuery, "ds"); err != nil {
+		t.Errorf("%s: %v", sql, err)
+	}
+
+	noneDenied := &QueryPolicy{Access: &security.TableAccessPolicy{DeniedColumns: []string{"users.email"}}}
+	got, err = noneDenied.sampleColumns(security.DialectPostgres, "db", []string{"public", "orders"}, func() ([]string, error) {
+		t.Fatal("columns fetched for a table without denied columns")
+		return nil, nil
+	}, pgQuoteIdent)
+	if err != nil || got != "*" {
+		t.Errorf("orders: got %q, %v; want *", got, err)
+	}
+
+	onlyDenied := func() ([]string, error) { return []string{"national_id"}, nil }
+	if _, err := policy.sampleColumns(security.DialectBigQuery, "ds", []string{"ds", "ids"}, onlyDenied, bqQuoteIdent); err == nil || !containsStr(err.Error(), "access denied") {
+		t.Errorf("expected access denied when every column is denied, got %v", err)
+	}
+}
+
+func TestQueryPolicy_SchemaToolsCheckTableAccess(t *testing.T) {
+	policy := &QueryPolicy{Access: &security.TableAccessPolicy{
+		Datasets:     []string{"payment_ds"},
+		DeniedTables: []string{"public.api_tokens"},
+	}}
+	// nil services: the tools must fail before reaching them
+	_, err := BQGetSchemaTool(nil, policy).Execute(context.Background(), map[string]interface{}{
+		"dataset_id": "user_ds", "table_id": "users",
+	})
+	if err == nil || !containsStr(err.Error(), "dataset user_ds") {
+		t.Errorf("BQ schema: expected dataset access error, got %v", err)
+	}
+	_, err = PGGetSchemaTool(nil, "testdb", policy).Execute(context.Background(), map[string]interface{}{
+		"schema": "public", "table": "api_tokens",
+	})
+	if err == nil || !containsStr(err.Error(), "access denied") {
+		t.Errorf("PG schema: expected access denied error, got %v", err)
+	}
+}
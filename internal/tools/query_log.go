@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"sync"
+
+	"github.com/cortexai/cortexai/internal/security"
+	"github.com/cortexai/cortexai/internal/service"
+)
+
+// ExecutedQuery is one query a tool ran through a QueryPolicy, with its
+// result before masking.
+type ExecutedQuery struct {
+	Tool      string
+	SQL       string // the SQL that ran, with row filters applied
+	Scope     string // dataset unqualified tables resolved to, or the PostgreSQL database
+	BigQuery  *service.QueryResult
+	Postgres  *service.PGQueryResult
+	PGCost    *service.PGExplainCost // EXPLAIN estimate checked before the query ran; nil if none
+	LatencyMs int64
+
+	canonical string
+}
+
+// QueryLog records the queries the tools of one request executed, so the
+// agent's final SQL can reuse a result fetched during the loop instead of
+// running the query again. It is safe for concurrent use: tool calls of an
+// iteration run in parallel.
+type QueryLog struct {
+	mu      sync.Mutex
+	queries []ExecutedQuery
+}
+
+// NewQueryLog creates an empty QueryLog.
+func NewQueryLog() *QueryLog {
+	return &QueryLog{}
+}
+
+// Record adds q, which ran in dialect, to the log.
+func (l *QueryLog) Record(q ExecutedQuery, dialect security.SQLDialect) {
+	if l == nil {
+		return
+	}
+	q.canonical = security.CanonicalSQL(q.SQL, dialect)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.queries = append(l.queries, q)
+}
+
+// Find returns the most recent query that ran sql against scope. Queries are
+// compared in canonical form (see security.CanonicalSQL), so whitespace,
+// comments and trailing semicolons do not matter.
+func (l *QueryLog) Find(sql string, dialect security.SQLDialect, scope string) (ExecutedQuery, bool) {
+	if l == nil {
+		return ExecutedQuery{}, false
+	}
+	canonical := security.CanonicalSQL(sql, dialect)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.queries) - 1; i >= 0; i-- {
+		if q := l.queries[i]; q.canonical == canonical && q.Scope == scope {
+			return q, true
+		}
+	}
+	return ExecutedQuery{}, false
+}
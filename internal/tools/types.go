@@ -2,7 +2,10 @@
 // the agent and individual tool implementations.
 package tools
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Tool represents a callable function the LLM can invoke
 type Tool struct {
@@ -10,4 +13,6 @@ type Tool struct {
 	Description string
 	InputSchema map[string]interface{}
 	Execute     func(ctx context.Context, input map[string]interface{}) (string, error)
+	// Timeout bounds one call; 0 uses the agent run's default tool timeout.
+	Timeout time.Duration
 }